@@ -259,6 +259,69 @@ type GatewayConfig struct {
 	StreamKeepaliveInterval int `mapstructure:"stream_keepalive_interval"`
 	// MaxLineSize: 上游 SSE 单行最大字节数（0使用默认值）
 	MaxLineSize int `mapstructure:"max_line_size"`
+	// MaxDeltaBytes: OpenAI 兼容上游流式响应中单个 text_delta 的最大字节数，超过时拆分为多个事件（0表示不拆分）
+	MaxDeltaBytes int `mapstructure:"max_delta_bytes"`
+	// TextDeltaBatchWindowMs: OpenAI 兼容上游流式文本增量的合并窗口（毫秒），窗口内的增量合并为一个 text_delta 事件（0表示不合并）
+	TextDeltaBatchWindowMs int `mapstructure:"text_delta_batch_window_ms"`
+	// MaxToolArgumentBytes: OpenAI 兼容上游单个 tool call arguments 的最大字节数（0表示不限制）
+	MaxToolArgumentBytes int `mapstructure:"max_tool_argument_bytes"`
+	// ToolArgumentOverflowMode: tool call arguments 超限时的处理方式：error（返回错误）/ truncate（截断并记录警告）
+	ToolArgumentOverflowMode string `mapstructure:"tool_argument_overflow_mode"`
+	// MaxToolCalls: OpenAI 兼容上游单个响应中 tool call 的最大数量（0表示不限制）
+	MaxToolCalls int `mapstructure:"max_tool_calls"`
+	// ToolCallOverflowMode: tool call 数量超限时的处理方式：truncate（丢弃超出部分并记录警告）/ error（返回错误）
+	ToolCallOverflowMode string `mapstructure:"tool_call_overflow_mode"`
+	// MaxTools: 发送给 OpenAI 兼容上游的工具数量上限（0表示不限制）
+	MaxTools int `mapstructure:"max_tools"`
+	// MaxToolSchemaBytes: 发送给 OpenAI 兼容上游的工具定义序列化后的总字节数上限（0表示不限制）
+	MaxToolSchemaBytes int `mapstructure:"max_tool_schema_bytes"`
+	// ToolOverflowMode: 工具超过 max_tools / max_tool_schema_bytes 时的处理方式：truncate（丢弃超出部分并记录工具名）/ error（返回 400）
+	ToolOverflowMode string `mapstructure:"tool_overflow_mode"`
+	// DuplicateToolCallIndexMode: OpenAI 兼容上游同一流式 chunk 内 tool_calls index 重复时的处理方式：reindex（重新分配 index）/ error（返回错误）
+	DuplicateToolCallIndexMode string `mapstructure:"duplicate_tool_call_index_mode"`
+	// MaxResponseBytes: OpenAI 兼容上游非流式响应体的最大字节数，超过时返回错误（0表示不限制）
+	MaxResponseBytes int `mapstructure:"max_response_bytes"`
+	// CancelUpstreamOnDisconnect: OpenAI 兼容上游流式响应中客户端断开时立即取消上游请求（默认继续读取上游以统计 usage）
+	CancelUpstreamOnDisconnect bool `mapstructure:"cancel_upstream_on_disconnect"`
+	// MaxRateLimitCooldown: OpenAI 兼容上游 429 按响应头（Retry-After / x-ratelimit-reset-*）限流账号的最长时间（秒），防止异常值长时间停用账号（0表示不限制）
+	MaxRateLimitCooldown int `mapstructure:"max_rate_limit_cooldown"`
+
+	// 辅助接口（模型列表 / count_tokens）超时配置，独立于主请求的 response_header_timeout
+	// AuxModelsTimeout: 上游模型列表请求超时（秒），不重试
+	AuxModelsTimeout int `mapstructure:"aux_models_timeout"`
+	// AuxModelsCacheTTL: 上游模型列表按账号缓存的时间（秒），0 表示不缓存
+	AuxModelsCacheTTL int `mapstructure:"aux_models_cache_ttl"`
+	// AuxCountTokensTimeout: 上游 count_tokens 单次请求超时（秒）
+	AuxCountTokensTimeout int `mapstructure:"aux_count_tokens_timeout"`
+	// AuxCountTokensMaxRetries: 上游 count_tokens 失败（网络错误或 5xx）后的最大重试次数
+	AuxCountTokensMaxRetries int `mapstructure:"aux_count_tokens_max_retries"`
+
+	// EmptyAssistantMode: OpenAI 兼容上游中仅含 thinking 的 assistant 历史消息处理方式
+	// keep（原样保留）/ space（content 填充空格）/ thinking（thinking 写入 content）/ drop（丢弃消息）
+	EmptyAssistantMode string `mapstructure:"empty_assistant_mode"`
+	// OrphanToolResultMode: OpenAI 兼容上游中 tool_use_id 找不到对应 tool_call 的 tool_result 处理方式
+	// keep（原样保留并记录警告）/ drop（丢弃）/ stub（补充占位 assistant tool_call）
+	OrphanToolResultMode string `mapstructure:"orphan_tool_result_mode"`
+	// ToolResultJSONMode: 结构化 JSON 的 tool_result（对象或非内容块数组）以紧凑 JSON 发送给 OpenAI 兼容上游（默认提取为文本）
+	ToolResultJSONMode bool `mapstructure:"tool_result_json_mode"`
+	// MaxImageDimension: 发送给 OpenAI 兼容上游前将 base64 图片等比缩放至最长边不超过该像素值（0表示不缩放）
+	// 仅支持 PNG / JPEG / GIF，WebP 等其他格式及总像素超过 8192x4096 的图片原样转发
+	MaxImageDimension int `mapstructure:"max_image_dimension"`
+	// EstimateMissingUsage: OpenAI 兼容上游非流式响应缺少 usage 时按请求与输出文本在本地估算用量（默认按 0 计费）
+	EstimateMissingUsage bool `mapstructure:"estimate_missing_usage"`
+	// HideThinkingFromClient: OpenAI 兼容上游的响应中不向客户端返回 thinking 块（reasoning tokens 仍计费），
+	// 账号 extra.hide_thinking_from_client（true/false）可覆盖
+	HideThinkingFromClient bool `mapstructure:"hide_thinking_from_client"`
+	// AdaptiveReasoningEffort: adaptive thinking（未指定 budget）映射的 reasoning effort：auto（按输入大小）/ low / medium / high
+	AdaptiveReasoningEffort string `mapstructure:"adaptive_reasoning_effort"`
+	// ForwardResponseHeaders: OpenAI 兼容上游响应中透传给客户端的响应头，
+	// 每项为 "上游头名" 或 "上游头名:客户端头名"（重命名为 Claude 风格头名）；hop-by-hop 头与网关自行设置的头不会透传
+	ForwardResponseHeaders []string `mapstructure:"forward_response_headers"`
+
+	// DebugLog: 记录 OpenAI 兼容上游转换后的请求体与原始响应体（脱敏 Authorization 与 base64 图片），仅用于排障
+	DebugLog bool `mapstructure:"debug_log"`
+	// DebugLogMaxBytes: 调试日志中请求/响应体记录的最大字节数（超过会截断）
+	DebugLogMaxBytes int `mapstructure:"debug_log_max_bytes"`
 
 	// 是否记录上游错误响应体摘要（避免输出请求内容）
 	LogUpstreamErrorBody bool `mapstructure:"log_upstream_error_body"`
@@ -896,6 +959,40 @@ func setDefaults() {
 	viper.SetDefault("gateway.stream_data_interval_timeout", 180)
 	viper.SetDefault("gateway.stream_keepalive_interval", 10)
 	viper.SetDefault("gateway.max_line_size", 40*1024*1024)
+	viper.SetDefault("gateway.max_delta_bytes", 32*1024)
+	viper.SetDefault("gateway.text_delta_batch_window_ms", 0)
+	viper.SetDefault("gateway.max_tool_argument_bytes", 1024*1024)
+	viper.SetDefault("gateway.tool_argument_overflow_mode", "error")
+	viper.SetDefault("gateway.max_tool_calls", 128)
+	viper.SetDefault("gateway.tool_call_overflow_mode", "truncate")
+	viper.SetDefault("gateway.max_tools", 0)
+	viper.SetDefault("gateway.max_tool_schema_bytes", 0)
+	viper.SetDefault("gateway.tool_overflow_mode", "truncate")
+	viper.SetDefault("gateway.duplicate_tool_call_index_mode", "reindex")
+	viper.SetDefault("gateway.max_response_bytes", 32*1024*1024)
+	viper.SetDefault("gateway.cancel_upstream_on_disconnect", false)
+	viper.SetDefault("gateway.max_rate_limit_cooldown", 3600)
+	viper.SetDefault("gateway.aux_models_timeout", 10)
+	viper.SetDefault("gateway.aux_models_cache_ttl", 300)
+	viper.SetDefault("gateway.aux_count_tokens_timeout", 10)
+	viper.SetDefault("gateway.aux_count_tokens_max_retries", 1)
+	viper.SetDefault("gateway.empty_assistant_mode", "keep")
+	viper.SetDefault("gateway.orphan_tool_result_mode", "keep")
+	viper.SetDefault("gateway.tool_result_json_mode", false)
+	viper.SetDefault("gateway.max_image_dimension", 0)
+	viper.SetDefault("gateway.estimate_missing_usage", false)
+	viper.SetDefault("gateway.hide_thinking_from_client", false)
+	viper.SetDefault("gateway.adaptive_reasoning_effort", "auto")
+	viper.SetDefault("gateway.forward_response_headers", []string{
+		"x-request-id:request-id",
+		"openai-processing-ms",
+		"x-ratelimit-limit-requests:anthropic-ratelimit-requests-limit",
+		"x-ratelimit-remaining-requests:anthropic-ratelimit-requests-remaining",
+		"x-ratelimit-limit-tokens:anthropic-ratelimit-tokens-limit",
+		"x-ratelimit-remaining-tokens:anthropic-ratelimit-tokens-remaining",
+	})
+	viper.SetDefault("gateway.debug_log", false)
+	viper.SetDefault("gateway.debug_log_max_bytes", 8192)
 	viper.SetDefault("gateway.scheduling.sticky_session_max_waiting", 3)
 	viper.SetDefault("gateway.scheduling.sticky_session_wait_timeout", 120*time.Second)
 	viper.SetDefault("gateway.scheduling.fallback_wait_timeout", 30*time.Second)
@@ -1214,6 +1311,90 @@ func (c *Config) Validate() error {
 	if c.Gateway.MaxLineSize != 0 && c.Gateway.MaxLineSize < 1024*1024 {
 		return fmt.Errorf("gateway.max_line_size must be at least 1MB")
 	}
+	if c.Gateway.MaxDeltaBytes < 0 {
+		return fmt.Errorf("gateway.max_delta_bytes must be non-negative")
+	}
+	if c.Gateway.TextDeltaBatchWindowMs < 0 {
+		return fmt.Errorf("gateway.text_delta_batch_window_ms must be non-negative")
+	}
+	if c.Gateway.MaxToolArgumentBytes < 0 {
+		return fmt.Errorf("gateway.max_tool_argument_bytes must be non-negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.ToolArgumentOverflowMode)) {
+	case "", "error", "truncate":
+	default:
+		return fmt.Errorf("gateway.tool_argument_overflow_mode must be one of: error, truncate")
+	}
+	if c.Gateway.MaxToolCalls < 0 {
+		return fmt.Errorf("gateway.max_tool_calls must be non-negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.ToolCallOverflowMode)) {
+	case "", "truncate", "error":
+	default:
+		return fmt.Errorf("gateway.tool_call_overflow_mode must be one of: truncate, error")
+	}
+	if c.Gateway.MaxTools < 0 {
+		return fmt.Errorf("gateway.max_tools must be non-negative")
+	}
+	if c.Gateway.MaxToolSchemaBytes < 0 {
+		return fmt.Errorf("gateway.max_tool_schema_bytes must be non-negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.ToolOverflowMode)) {
+	case "", "truncate", "error":
+	default:
+		return fmt.Errorf("gateway.tool_overflow_mode must be one of: truncate, error")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.DuplicateToolCallIndexMode)) {
+	case "", "reindex", "error":
+	default:
+		return fmt.Errorf("gateway.duplicate_tool_call_index_mode must be one of: reindex, error")
+	}
+	if c.Gateway.MaxResponseBytes < 0 {
+		return fmt.Errorf("gateway.max_response_bytes must be non-negative")
+	}
+	if c.Gateway.MaxRateLimitCooldown < 0 {
+		return fmt.Errorf("gateway.max_rate_limit_cooldown must be non-negative")
+	}
+	if c.Gateway.MaxImageDimension < 0 {
+		return fmt.Errorf("gateway.max_image_dimension must be non-negative")
+	}
+	if c.Gateway.AuxModelsTimeout < 0 {
+		return fmt.Errorf("gateway.aux_models_timeout must be non-negative")
+	}
+	if c.Gateway.AuxModelsCacheTTL < 0 {
+		return fmt.Errorf("gateway.aux_models_cache_ttl must be non-negative")
+	}
+	if c.Gateway.AuxCountTokensTimeout < 0 {
+		return fmt.Errorf("gateway.aux_count_tokens_timeout must be non-negative")
+	}
+	if c.Gateway.AuxCountTokensMaxRetries < 0 {
+		return fmt.Errorf("gateway.aux_count_tokens_max_retries must be non-negative")
+	}
+	if c.Gateway.DebugLogMaxBytes < 0 {
+		return fmt.Errorf("gateway.debug_log_max_bytes must be non-negative")
+	}
+	for _, rule := range c.Gateway.ForwardResponseHeaders {
+		from, to, _ := strings.Cut(rule, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if from == "" || strings.ContainsAny(from+to, " \t") {
+			return fmt.Errorf("gateway.forward_response_headers entry %q must be \"header\" or \"upstream-header:client-header\"", rule)
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.EmptyAssistantMode)) {
+	case "", "keep", "space", "thinking", "drop":
+	default:
+		return fmt.Errorf("gateway.empty_assistant_mode must be one of: keep, space, thinking, drop")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.OrphanToolResultMode)) {
+	case "", "keep", "drop", "stub":
+	default:
+		return fmt.Errorf("gateway.orphan_tool_result_mode must be one of: keep, drop, stub")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Gateway.AdaptiveReasoningEffort)) {
+	case "", "auto", "low", "medium", "high":
+	default:
+		return fmt.Errorf("gateway.adaptive_reasoning_effort must be one of: auto, low, medium, high")
+	}
 	if c.Gateway.Scheduling.StickySessionMaxWaiting <= 0 {
 		return fmt.Errorf("gateway.scheduling.sticky_session_max_waiting must be positive")
 	}
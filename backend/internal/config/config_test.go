@@ -850,3 +850,259 @@ func TestValidateConfigErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateGatewayEmptyAssistantMode(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.EmptyAssistantMode != "keep" {
+		t.Fatalf("Gateway.EmptyAssistantMode = %q, want keep", cfg.Gateway.EmptyAssistantMode)
+	}
+
+	cfg.Gateway.EmptyAssistantMode = "invalid"
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatalf("Validate() expected error for invalid empty_assistant_mode, got nil")
+	}
+	if !strings.Contains(err.Error(), "gateway.empty_assistant_mode") {
+		t.Fatalf("Validate() expected empty_assistant_mode error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayOrphanToolResultMode(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.OrphanToolResultMode != "keep" {
+		t.Fatalf("Gateway.OrphanToolResultMode = %q, want keep", cfg.Gateway.OrphanToolResultMode)
+	}
+
+	cfg.Gateway.OrphanToolResultMode = "repair"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.orphan_tool_result_mode") {
+		t.Fatalf("Validate() expected orphan_tool_result_mode error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayToolArgumentOverflow(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.MaxToolArgumentBytes != 1024*1024 {
+		t.Fatalf("Gateway.MaxToolArgumentBytes = %d, want %d", cfg.Gateway.MaxToolArgumentBytes, 1024*1024)
+	}
+	if cfg.Gateway.ToolArgumentOverflowMode != "error" {
+		t.Fatalf("Gateway.ToolArgumentOverflowMode = %q, want error", cfg.Gateway.ToolArgumentOverflowMode)
+	}
+
+	cfg.Gateway.MaxToolArgumentBytes = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_tool_argument_bytes") {
+		t.Fatalf("Validate() expected max_tool_argument_bytes error, got: %v", err)
+	}
+
+	cfg.Gateway.MaxToolArgumentBytes = 0
+	cfg.Gateway.ToolArgumentOverflowMode = "drop"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.tool_argument_overflow_mode") {
+		t.Fatalf("Validate() expected tool_argument_overflow_mode error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayToolCallOverflow(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.MaxToolCalls != 128 {
+		t.Fatalf("Gateway.MaxToolCalls = %d, want 128", cfg.Gateway.MaxToolCalls)
+	}
+	if cfg.Gateway.ToolCallOverflowMode != "truncate" {
+		t.Fatalf("Gateway.ToolCallOverflowMode = %q, want truncate", cfg.Gateway.ToolCallOverflowMode)
+	}
+
+	cfg.Gateway.MaxToolCalls = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_tool_calls") {
+		t.Fatalf("Validate() expected max_tool_calls error, got: %v", err)
+	}
+
+	cfg.Gateway.MaxToolCalls = 0
+	cfg.Gateway.ToolCallOverflowMode = "drop"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.tool_call_overflow_mode") {
+		t.Fatalf("Validate() expected tool_call_overflow_mode error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayToolLimits(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.MaxTools != 0 || cfg.Gateway.MaxToolSchemaBytes != 0 {
+		t.Fatalf("Gateway.MaxTools = %d, MaxToolSchemaBytes = %d, want 0", cfg.Gateway.MaxTools, cfg.Gateway.MaxToolSchemaBytes)
+	}
+	if cfg.Gateway.ToolOverflowMode != "truncate" {
+		t.Fatalf("Gateway.ToolOverflowMode = %q, want truncate", cfg.Gateway.ToolOverflowMode)
+	}
+
+	cfg.Gateway.MaxTools = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_tools") {
+		t.Fatalf("Validate() expected max_tools error, got: %v", err)
+	}
+
+	cfg.Gateway.MaxTools = 0
+	cfg.Gateway.MaxToolSchemaBytes = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_tool_schema_bytes") {
+		t.Fatalf("Validate() expected max_tool_schema_bytes error, got: %v", err)
+	}
+
+	cfg.Gateway.MaxToolSchemaBytes = 0
+	cfg.Gateway.ToolOverflowMode = "drop"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.tool_overflow_mode") {
+		t.Fatalf("Validate() expected tool_overflow_mode error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayDuplicateToolCallIndexMode(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.DuplicateToolCallIndexMode != "reindex" {
+		t.Fatalf("Gateway.DuplicateToolCallIndexMode = %q, want reindex", cfg.Gateway.DuplicateToolCallIndexMode)
+	}
+
+	cfg.Gateway.DuplicateToolCallIndexMode = "merge"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.duplicate_tool_call_index_mode") {
+		t.Fatalf("Validate() expected duplicate_tool_call_index_mode error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayAuxModelsCacheTTL(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.AuxModelsCacheTTL != 300 {
+		t.Fatalf("Gateway.AuxModelsCacheTTL = %d, want 300", cfg.Gateway.AuxModelsCacheTTL)
+	}
+
+	cfg.Gateway.AuxModelsCacheTTL = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.aux_models_cache_ttl") {
+		t.Fatalf("Validate() expected aux_models_cache_ttl error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayMaxResponseBytes(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.MaxResponseBytes != 32*1024*1024 {
+		t.Fatalf("Gateway.MaxResponseBytes = %d, want %d", cfg.Gateway.MaxResponseBytes, 32*1024*1024)
+	}
+
+	cfg.Gateway.MaxResponseBytes = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_response_bytes") {
+		t.Fatalf("Validate() expected max_response_bytes error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayMaxRateLimitCooldown(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.MaxRateLimitCooldown != 3600 {
+		t.Fatalf("Gateway.MaxRateLimitCooldown = %d, want 3600", cfg.Gateway.MaxRateLimitCooldown)
+	}
+
+	cfg.Gateway.MaxRateLimitCooldown = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_rate_limit_cooldown") {
+		t.Fatalf("Validate() expected max_rate_limit_cooldown error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayMaxImageDimension(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.MaxImageDimension != 0 {
+		t.Fatalf("Gateway.MaxImageDimension = %d, want 0", cfg.Gateway.MaxImageDimension)
+	}
+
+	cfg.Gateway.MaxImageDimension = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.max_image_dimension") {
+		t.Fatalf("Validate() expected max_image_dimension error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayForwardResponseHeaders(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.Gateway.ForwardResponseHeaders) == 0 || cfg.Gateway.ForwardResponseHeaders[0] != "x-request-id:request-id" {
+		t.Fatalf("Gateway.ForwardResponseHeaders = %v, want x-request-id:request-id first", cfg.Gateway.ForwardResponseHeaders)
+	}
+
+	cfg.Gateway.ForwardResponseHeaders = []string{":request-id"}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.forward_response_headers") {
+		t.Fatalf("Validate() expected forward_response_headers error, got: %v", err)
+	}
+}
+
+func TestValidateGatewayAdaptiveReasoningEffort(t *testing.T) {
+	viper.Reset()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Gateway.AdaptiveReasoningEffort != "auto" {
+		t.Fatalf("Gateway.AdaptiveReasoningEffort = %q, want auto", cfg.Gateway.AdaptiveReasoningEffort)
+	}
+
+	cfg.Gateway.AdaptiveReasoningEffort = "max"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "gateway.adaptive_reasoning_effort") {
+		t.Fatalf("Validate() expected adaptive_reasoning_effort error, got: %v", err)
+	}
+}
@@ -376,6 +376,10 @@ func usageLogFromServiceUser(l *service.UsageLog) UsageLog {
 		RequestID:             l.RequestID,
 		Model:                 l.Model,
 		ReasoningEffort:       l.ReasoningEffort,
+		ReasoningTokens:       l.ReasoningTokens,
+		UsageEstimated:        l.UsageEstimated,
+		ServiceTier:           l.ServiceTier,
+		WebSearchRequests:     l.WebSearchRequests,
 		GroupID:               l.GroupID,
 		SubscriptionID:        l.SubscriptionID,
 		InputTokens:           l.InputTokens,
@@ -241,6 +241,14 @@ type UsageLog struct {
 	// ReasoningEffort is the request's reasoning effort level (OpenAI Responses API).
 	// nil means not provided / not applicable.
 	ReasoningEffort *string `json:"reasoning_effort,omitempty"`
+	// ReasoningTokens is the number of reasoning tokens included in output_tokens.
+	ReasoningTokens int `json:"reasoning_tokens,omitempty"`
+	// UsageEstimated means the token counts were estimated locally because the upstream returned no usage.
+	UsageEstimated bool `json:"usage_estimated,omitempty"`
+	// ServiceTier is the service tier actually used by the upstream (e.g. flex / priority).
+	ServiceTier *string `json:"service_tier,omitempty"`
+	// WebSearchRequests is the number of server-side web searches reported by the upstream.
+	WebSearchRequests int `json:"web_search_requests,omitempty"`
 
 	GroupID        *int64 `json:"group_id"`
 	SubscriptionID *int64 `json:"subscription_id"`
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -386,11 +387,12 @@ func (h *GatewayHandler) Messages(c *gin.Context) {
 
 					failedAccountIDs[account.ID] = struct{}{}
 					if switchCount >= maxAccountSwitches {
+						logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, true)
 						h.handleFailoverExhausted(c, failoverErr, service.PlatformGemini, streamStarted)
 						return
 					}
 					switchCount++
-					log.Printf("Account %d: upstream error %d, switching account %d/%d", account.ID, failoverErr.StatusCode, switchCount, maxAccountSwitches)
+					logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, false)
 					if account.Platform == service.PlatformAntigravity {
 						if !sleepFailoverDelay(c.Request.Context(), switchCount) {
 							return
@@ -502,6 +504,7 @@ func (h *GatewayHandler) Messages(c *gin.Context) {
 
 			// 3. 获取账号并发槽位
 			accountReleaseFunc := selection.ReleaseFunc
+			var accountSlotWait time.Duration
 			if !selection.Acquired {
 				if selection.WaitPlan == nil {
 					h.handleStreamingAwareError(c, http.StatusServiceUnavailable, "api_error", "No available accounts", streamStarted)
@@ -525,6 +528,7 @@ func (h *GatewayHandler) Messages(c *gin.Context) {
 					}
 				}()
 
+				slotWaitStart := time.Now()
 				accountReleaseFunc, err = h.concurrencyHelper.AcquireAccountSlotWithWaitTimeout(
 					c,
 					account.ID,
@@ -538,6 +542,7 @@ func (h *GatewayHandler) Messages(c *gin.Context) {
 					h.handleConcurrencyError(c, err, "account", streamStarted)
 					return
 				}
+				accountSlotWait = time.Since(slotWaitStart)
 				if accountWaitCounted {
 					h.concurrencyHelper.DecrementAccountWaitCount(c.Request.Context(), account.ID)
 					accountWaitCounted = false
@@ -558,6 +563,9 @@ func (h *GatewayHandler) Messages(c *gin.Context) {
 			if account.Platform == service.PlatformAntigravity && account.Type != service.AccountTypeAPIKey {
 				result, err = h.antigravityGatewayService.Forward(requestCtx, c, account, body, hasBoundSession)
 			} else if account.Platform == service.PlatformOpenAICompat || account.Platform == service.PlatformOpenRouter {
+				if accountSlotWait > 0 {
+					requestCtx = context.WithValue(requestCtx, ctxkey.AccountSlotWait, accountSlotWait)
+				}
 				result, err = h.openAICompatGatewayService.Forward(requestCtx, c, account, body)
 			} else {
 				result, err = h.gatewayService.Forward(requestCtx, c, account, parsedReq)
@@ -623,14 +631,23 @@ func (h *GatewayHandler) Messages(c *gin.Context) {
 					if failoverErr.RetryableOnSameAccount {
 						h.gatewayService.TempUnscheduleRetryableError(c.Request.Context(), account.ID, failoverErr)
 					}
+					// 上游返回了限流重置时间，冷却该账号直到重置
+					if failoverErr.RateLimitResetAt != nil {
+						h.gatewayService.CooldownRateLimitedAccount(c.Request.Context(), account.ID, failoverErr)
+					}
+					// 上游 401 已分类：密钥无效停用账号，令牌过期临时停止调度
+					if failoverErr.AuthError != "" {
+						h.gatewayService.HandleUnauthorizedAccount(c.Request.Context(), account.ID, failoverErr)
+					}
 
 					failedAccountIDs[account.ID] = struct{}{}
 					if switchCount >= maxAccountSwitches {
+						logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, true)
 						h.handleFailoverExhausted(c, failoverErr, account.Platform, streamStarted)
 						return
 					}
 					switchCount++
-					log.Printf("Account %d: upstream error %d, switching account %d/%d", account.ID, failoverErr.StatusCode, switchCount, maxAccountSwitches)
+					logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, false)
 					if account.Platform == service.PlatformAntigravity {
 						if !sleepFailoverDelay(c.Request.Context(), switchCount) {
 							return
@@ -898,6 +915,29 @@ func (h *GatewayHandler) handleConcurrencyError(c *gin.Context, err error, slotT
 		fmt.Sprintf("Concurrency limit exceeded for %s, please retry later", slotType), streamStarted)
 }
 
+// logFailoverDecision 记录 failover 决策：被放弃的账号、原因分类、状态码及截断后的上游响应摘要
+// exhausted 为 true 表示切换次数已用尽，不再切换账号
+func logFailoverDecision(account *service.Account, failoverErr *service.UpstreamFailoverError, switchCount, maxSwitches int, exhausted bool) {
+	attrs := []any{
+		"account_id", account.ID,
+		"account_name", account.Name,
+		"platform", account.Platform,
+		"status_code", failoverErr.StatusCode,
+		"classification", failoverErr.Classification(),
+		"reason", failoverErr.Reason(),
+		"switch_count", switchCount,
+		"max_switches", maxSwitches,
+	}
+	if failoverErr.RateLimitResetAt != nil {
+		attrs = append(attrs, "rate_limit_reset_at", failoverErr.RateLimitResetAt.Format(time.RFC3339))
+	}
+	if exhausted {
+		slog.Warn("upstream_failover_exhausted", attrs...)
+		return
+	}
+	slog.Warn("upstream_failover_switch", attrs...)
+}
+
 // needForceCacheBilling 判断 failover 时是否需要强制缓存计费
 // 粘性会话切换账号、或上游明确标记时，将 input_tokens 转为 cache_read 计费
 func needForceCacheBilling(hasBoundSession bool, failoverErr *service.UpstreamFailoverError) bool {
@@ -985,7 +1025,10 @@ func (h *GatewayHandler) handleFailoverExhausted(c *gin.Context, failoverErr *se
 		}
 	}
 
-	// 使用默认的错误映射
+	// 使用默认的错误映射（过载错误统一按 529 映射为 overloaded_error）
+	if failoverErr.Overloaded {
+		statusCode = 529
+	}
 	status, errType, errMsg := h.mapUpstreamError(statusCode)
 	h.handleStreamingAwareError(c, status, errType, errMsg, streamStarted)
 }
@@ -1137,6 +1180,12 @@ func (h *GatewayHandler) CountTokens(c *gin.Context) {
 	}
 	setOpsSelectedAccount(c, account.ID)
 
+	// OpenAI 兼容账号走独立的 count_tokens 逻辑（使用辅助接口超时）
+	if account.Platform == service.PlatformOpenAICompat || account.Platform == service.PlatformOpenRouter {
+		_ = h.openAICompatGatewayService.ForwardCountTokens(c.Request.Context(), c, account, parsedReq.Body)
+		return
+	}
+
 	// 转发请求（不记录使用量）
 	if err := h.gatewayService.ForwardCountTokens(c.Request.Context(), c, account, parsedReq); err != nil {
 		log.Printf("Forward count_tokens request failed: %v", err)
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wei-Shaw/sub2api/internal/service"
+	"github.com/stretchr/testify/require"
+)
+
+// captureSlog 将默认 slog 输出重定向为 JSON，返回捕获缓冲区
+func captureSlog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestLogFailoverDecision_Fields(t *testing.T) {
+	buf := captureSlog(t)
+	resetAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	account := &service.Account{ID: 42, Name: "acc-42", Platform: service.PlatformOpenAICompat}
+	failoverErr := &service.UpstreamFailoverError{
+		StatusCode:       http.StatusTooManyRequests,
+		ResponseBody:     []byte("{\n  \"error\": \"" + strings.Repeat("x", 400) + "\"\n}"),
+		RateLimitResetAt: &resetAt,
+	}
+
+	logFailoverDecision(account, failoverErr, 1, 3, false)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "WARN", entry["level"])
+	require.Equal(t, "upstream_failover_switch", entry["msg"])
+	require.EqualValues(t, 42, entry["account_id"])
+	require.Equal(t, "acc-42", entry["account_name"])
+	require.Equal(t, service.PlatformOpenAICompat, entry["platform"])
+	require.EqualValues(t, http.StatusTooManyRequests, entry["status_code"])
+	require.Equal(t, "rate_limited", entry["classification"])
+	require.EqualValues(t, 1, entry["switch_count"])
+	require.EqualValues(t, 3, entry["max_switches"])
+	require.Equal(t, "2026-01-02T03:04:05Z", entry["rate_limit_reset_at"])
+
+	reason, _ := entry["reason"].(string)
+	require.True(t, strings.HasPrefix(reason, `{ "error": "xxx`))
+	require.True(t, strings.HasSuffix(reason, "..."))
+	require.LessOrEqual(t, len(reason), 256+len("..."))
+}
+
+func TestLogFailoverDecision_Exhausted(t *testing.T) {
+	buf := captureSlog(t)
+	account := &service.Account{ID: 7, Platform: service.PlatformAnthropic}
+	failoverErr := &service.UpstreamFailoverError{StatusCode: 529, Overloaded: true}
+
+	logFailoverDecision(account, failoverErr, 3, 3, true)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "upstream_failover_exhausted", entry["msg"])
+	require.Equal(t, "overloaded", entry["classification"])
+	require.Equal(t, "", entry["reason"])
+	require.NotContains(t, entry, "rate_limit_reset_at")
+}
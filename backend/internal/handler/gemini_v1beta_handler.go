@@ -449,12 +449,13 @@ func (h *GatewayHandler) GeminiV1BetaModels(c *gin.Context) {
 				}
 				if switchCount >= maxAccountSwitches {
 					lastFailoverErr = failoverErr
+					logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, true)
 					h.handleGeminiFailoverExhausted(c, lastFailoverErr)
 					return
 				}
 				lastFailoverErr = failoverErr
 				switchCount++
-				log.Printf("Gemini account %d: upstream error %d, switching account %d/%d", account.ID, failoverErr.StatusCode, switchCount, maxAccountSwitches)
+				logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, false)
 				if account.Platform == service.PlatformAntigravity {
 					if !sleepFailoverDelay(c.Request.Context(), switchCount) {
 						return
@@ -292,11 +292,12 @@ func (h *OpenAIGatewayHandler) Responses(c *gin.Context) {
 				failedAccountIDs[account.ID] = struct{}{}
 				lastFailoverErr = failoverErr
 				if switchCount >= maxAccountSwitches {
+					logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, true)
 					h.handleFailoverExhausted(c, failoverErr, streamStarted)
 					return
 				}
 				switchCount++
-				log.Printf("Account %d: upstream error %d, switching account %d/%d", account.ID, failoverErr.StatusCode, switchCount, maxAccountSwitches)
+				logFailoverDecision(account, failoverErr, switchCount, maxAccountSwitches, false)
 				continue
 			}
 			// Error response already handled in Forward, just log
@@ -18,6 +18,12 @@ type ClaudeRequest struct {
 	ToolChoice  json.RawMessage `json:"tool_choice,omitempty"` // {"type":"auto"} / {"type":"tool","name":"xxx"} / {"type":"any"}
 	Thinking    *ThinkingConfig `json:"thinking,omitempty"`
 	Metadata    *ClaudeMetadata `json:"metadata,omitempty"`
+	ServiceTier string          `json:"service_tier,omitempty"` // "auto" / "standard_only"（OpenAI 兼容上游另支持 "flex" / "priority" 等）
+	// Logprobs / TopLogprobs OpenAI 兼容扩展：请求上游返回 token logprobs（Claude 无对应字段）
+	Logprobs    *bool `json:"logprobs,omitempty"`
+	TopLogprobs *int  `json:"top_logprobs,omitempty"`
+	// Prediction OpenAI 兼容扩展：predicted outputs（{"type":"content","content":...} 或字符串简写），加速代码编辑类请求
+	Prediction json.RawMessage `json:"prediction,omitempty"`
 }
 
 // ClaudeMessage Claude 消息
@@ -35,6 +41,10 @@ type ThinkingConfig struct {
 // ClaudeMetadata 请求元数据
 type ClaudeMetadata struct {
 	UserID string `json:"user_id,omitempty"`
+	// SystemDirectives 客户端附加的上游专用系统指令，OpenAI 兼容转换时合并进 system message
+	SystemDirectives []string `json:"system_directives,omitempty"`
+	// Seed 客户端指定的采样随机种子，OpenAI 兼容转换时透传为 seed
+	Seed *int64 `json:"seed,omitempty"`
 }
 
 // ClaudeTool Claude 工具定义
@@ -101,6 +111,12 @@ type ClaudeResponse struct {
 	StopReason   string              `json:"stop_reason,omitempty"`   // end_turn, tool_use, max_tokens
 	StopSequence *string             `json:"stop_sequence,omitempty"` // null 或具体值
 	Usage        ClaudeUsage         `json:"usage"`
+	// SystemFingerprint OpenAI 兼容上游返回的后端配置指纹，用于校验 seed 可复现性
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+	// OpenAILogprobs OpenAI 兼容上游返回的 logprobs 原始对象（厂商扩展字段，Claude 无原生 logprobs）
+	OpenAILogprobs json.RawMessage `json:"openai_logprobs,omitempty"`
+	// EffectiveParams 网关实际发送给 OpenAI 兼容上游的请求参数（调试扩展字段，仅在账号开启时返回）
+	EffectiveParams json.RawMessage `json:"effective_params,omitempty"`
 }
 
 // ClaudeContentItem Claude 响应内容项
@@ -114,10 +130,18 @@ type ClaudeContentItem struct {
 	Thinking  string `json:"thinking,omitempty"`
 	Signature string `json:"signature,omitempty"`
 
-	// tool_use
+	// tool_use / server_tool_use
 	ID    string `json:"id,omitempty"`
 	Name  string `json:"name,omitempty"`
 	Input any    `json:"input,omitempty"`
+
+	// web_search_tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   any    `json:"content,omitempty"`
+
+	// openai_audio（厂商扩展块，OpenAI 兼容上游返回的音频输出）
+	Source     *ImageSource `json:"source,omitempty"`
+	Transcript string       `json:"transcript,omitempty"`
 }
 
 // ClaudeUsage Claude 用量统计
@@ -126,6 +150,21 @@ type ClaudeUsage struct {
 	OutputTokens             int `json:"output_tokens"`
 	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
 	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+	// ServerToolUse 服务端工具（如 web 搜索）调用次数，单独计费
+	ServerToolUse *ServerToolUsage `json:"server_tool_use,omitempty"`
+	// ServiceTier 上游实际使用的服务等级（如 "default" / "flex" / "priority"），用于核对不同等级的计费
+	ServiceTier string `json:"service_tier,omitempty"`
+	// ReasoningTokens output_tokens 中的推理 token 数（OpenAI 兼容上游的 reasoning_tokens），仅用于计费，不返回给客户端
+	ReasoningTokens int `json:"-"`
+	// AcceptedPredictionTokens / RejectedPredictionTokens 上游 predicted outputs 中被采纳 / 未被采纳的 token 数（OpenAI 兼容扩展，
+	// 未被采纳的部分已计入 output_tokens）
+	AcceptedPredictionTokens int `json:"accepted_prediction_tokens,omitempty"`
+	RejectedPredictionTokens int `json:"rejected_prediction_tokens,omitempty"`
+}
+
+// ServerToolUsage Claude 服务端工具用量
+type ServerToolUsage struct {
+	WebSearchRequests int `json:"web_search_requests"`
 }
 
 // ClaudeError Claude 错误响应
@@ -32,4 +32,7 @@ const (
 	// SingleAccountRetry 标识当前请求处于单账号 503 退避重试模式。
 	// 在此模式下，Service 层的模型限流预检查将等待限流过期而非直接切换账号。
 	SingleAccountRetry Key = "ctx_single_account_retry"
+
+	// AccountSlotWait 请求排队等待账号并发槽位的时间（time.Duration），由 handler 获取槽位后设置，用于上报指标
+	AccountSlotWait Key = "ctx_account_slot_wait"
 )
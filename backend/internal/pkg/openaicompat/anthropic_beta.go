@@ -0,0 +1,60 @@
+package openaicompat
+
+import "strings"
+
+// 已知的 anthropic-beta 标志
+const (
+	AnthropicBetaInterleavedThinking = "interleaved-thinking-2025-05-14"
+	AnthropicBetaContext1M           = "context-1m-2025-08-07"
+)
+
+// ParseAnthropicBetas 解析 anthropic-beta 请求头（可多次出现，每个值为逗号分隔列表），
+// 去除空白与重复项并保持原有顺序，无标志时返回 nil
+func ParseAnthropicBetas(values ...string) []string {
+	var betas []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, flag := range strings.Split(value, ",") {
+			flag = strings.TrimSpace(flag)
+			if flag == "" || seen[flag] {
+				continue
+			}
+			seen[flag] = true
+			betas = append(betas, flag)
+		}
+	}
+	return betas
+}
+
+// FilterAnthropicBetas 按白名单过滤 beta 标志；allowlist 为 nil 时原样返回，为空数组时全部过滤
+func FilterAnthropicBetas(betas, allowlist []string) []string {
+	if allowlist == nil {
+		return betas
+	}
+	var kept []string
+	for _, flag := range betas {
+		if hasAnthropicBeta(allowlist, flag) {
+			kept = append(kept, flag)
+		}
+	}
+	return kept
+}
+
+// HasAnthropicBeta 客户端是否启用了指定的 anthropic-beta 标志
+func (o RequestOptions) HasAnthropicBeta(flag string) bool {
+	return hasAnthropicBeta(o.AnthropicBetas, flag)
+}
+
+// HasAnthropicBeta 客户端是否启用了指定的 anthropic-beta 标志
+func (o ResponseOptions) HasAnthropicBeta(flag string) bool {
+	return hasAnthropicBeta(o.AnthropicBetas, flag)
+}
+
+func hasAnthropicBeta(betas []string, flag string) bool {
+	for _, b := range betas {
+		if strings.EqualFold(b, flag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package openaicompat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnthropicBetas(t *testing.T) {
+	require.Nil(t, ParseAnthropicBetas())
+	require.Nil(t, ParseAnthropicBetas("", " , "))
+	require.Equal(t,
+		[]string{AnthropicBetaInterleavedThinking, "tools-2024-04-04", AnthropicBetaContext1M},
+		ParseAnthropicBetas(" interleaved-thinking-2025-05-14 ,tools-2024-04-04", "context-1m-2025-08-07,tools-2024-04-04"))
+}
+
+func TestFilterAnthropicBetas(t *testing.T) {
+	betas := []string{AnthropicBetaInterleavedThinking, "tools-2024-04-04"}
+	require.Equal(t, betas, FilterAnthropicBetas(betas, nil))
+	require.Empty(t, FilterAnthropicBetas(betas, []string{}))
+	require.Equal(t, []string{"tools-2024-04-04"}, FilterAnthropicBetas(betas, []string{"tools-2024-04-04"}))
+}
+
+func TestOptionsHasAnthropicBeta(t *testing.T) {
+	betas := ParseAnthropicBetas("Interleaved-Thinking-2025-05-14")
+	require.True(t, RequestOptions{AnthropicBetas: betas}.HasAnthropicBeta(AnthropicBetaInterleavedThinking))
+	require.True(t, ResponseOptions{AnthropicBetas: betas}.HasAnthropicBeta(AnthropicBetaInterleavedThinking))
+	require.False(t, RequestOptions{}.HasAnthropicBeta(AnthropicBetaContext1M))
+}
@@ -0,0 +1,104 @@
+package openaicompat
+
+import (
+	"encoding/base64"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// AudioBlockType 上游返回的音频在 Claude 响应中的内容块类型（厂商扩展，Claude 无原生音频输出）
+const AudioBlockType = "openai_audio"
+
+// 音频输出的默认参数（账号开启 audio 模态但未指定时使用）
+const (
+	DefaultAudioVoice  = "alloy"
+	DefaultAudioFormat = "wav"
+)
+
+// applyModalities 按账号配置设置输出模态；未配置时不发送，纯文本请求保持不变
+// modalities 含 audio 时补齐 audio 参数（上游要求同时指定 voice 与 format）
+func applyModalities(req *ChatRequest, opts RequestOptions) {
+	if len(opts.Modalities) == 0 {
+		return
+	}
+	req.Modalities = opts.Modalities
+	if !slices.Contains(opts.Modalities, "audio") {
+		return
+	}
+	audio := AudioConfig{Voice: DefaultAudioVoice, Format: DefaultAudioFormat}
+	if opts.Audio != nil {
+		if voice := strings.TrimSpace(opts.Audio.Voice); voice != "" {
+			audio.Voice = voice
+		}
+		if format := strings.TrimSpace(opts.Audio.Format); format != "" {
+			audio.Format = format
+		}
+	}
+	req.Audio = &audio
+}
+
+// audioMediaType 返回音频格式对应的 MIME 类型
+func audioMediaType(format string) string {
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case "":
+		return "audio/wav"
+	case "mp3":
+		return "audio/mpeg"
+	case "pcm16":
+		return "audio/pcm"
+	default:
+		return "audio/" + format
+	}
+}
+
+// buildAudioBlock 将上游音频输出转换为 openai_audio 内容块，无音频数据与转写文本时返回 false
+func buildAudioBlock(audio *ChatAudio, format string) (antigravity.ClaudeContentItem, bool) {
+	if audio == nil || (audio.Data == "" && audio.Transcript == "") {
+		return antigravity.ClaudeContentItem{}, false
+	}
+	block := antigravity.ClaudeContentItem{
+		Type:       AudioBlockType,
+		ID:         audio.ID,
+		Transcript: audio.Transcript,
+	}
+	if audio.Data != "" {
+		block.Source = &antigravity.ImageSource{Type: "base64", MediaType: audioMediaType(format), Data: audio.Data}
+	}
+	return block, true
+}
+
+// audioStreamState 流式音频输出的累积状态（上游按 chunk 分段返回 base64 数据与转写文本）
+// 每段 base64 单独编码（可能带填充），需解码后拼接再整体编码
+type audioStreamState struct {
+	id         string
+	data       []byte
+	transcript strings.Builder
+}
+
+// add 累积一个音频增量，无法解码的数据段丢弃并记录日志
+func (s *audioStreamState) add(delta *ChatAudio) {
+	if delta.ID != "" {
+		s.id = delta.ID
+	}
+	if delta.Data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(delta.Data)
+		if err != nil {
+			log.Printf("[OpenAICompat] dropped undecodable audio chunk: %v", err)
+		} else {
+			s.data = append(s.data, decoded...)
+		}
+	}
+	s.transcript.WriteString(delta.Transcript)
+}
+
+// audio 返回累积的完整音频
+func (s *audioStreamState) audio() *ChatAudio {
+	audio := &ChatAudio{ID: s.id, Transcript: s.transcript.String()}
+	if len(s.data) > 0 {
+		audio.Data = base64.StdEncoding.EncodeToString(s.data)
+	}
+	return audio
+}
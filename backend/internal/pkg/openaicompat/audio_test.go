@@ -0,0 +1,102 @@
+package openaicompat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyModalities(t *testing.T) {
+	claudeReq := &antigravity.ClaudeRequest{
+		Model:     "gpt-4o-audio-preview",
+		MaxTokens: 100,
+		Messages:  []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
+	}
+	build := func(opts RequestOptions) map[string]any {
+		body, _, err := TransformClaudeToOpenAIWithOptions(claudeReq, opts)
+		require.NoError(t, err)
+		var req map[string]any
+		require.NoError(t, json.Unmarshal(body, &req))
+		return req
+	}
+
+	// 未配置时不发送 modalities / audio
+	req := build(DefaultRequestOptions())
+	require.NotContains(t, req, "modalities")
+	require.NotContains(t, req, "audio")
+
+	// 仅文本模态时不发送 audio 参数
+	req = build(RequestOptions{Modalities: []string{"text"}})
+	require.Equal(t, []any{"text"}, req["modalities"])
+	require.NotContains(t, req, "audio")
+
+	// audio 模态补齐默认 voice / format，账号配置优先
+	req = build(RequestOptions{Modalities: []string{"text", "audio"}})
+	require.Equal(t, map[string]any{"voice": DefaultAudioVoice, "format": DefaultAudioFormat}, req["audio"])
+	req = build(RequestOptions{Modalities: []string{"text", "audio"}, Audio: &AudioConfig{Format: "mp3"}})
+	require.Equal(t, map[string]any{"voice": DefaultAudioVoice, "format": "mp3"}, req["audio"])
+}
+
+func TestAssistantAudioHistory(t *testing.T) {
+	chatReq := transformRequest(t, `{"model":"gpt-4o-audio-preview","max_tokens":100,"messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","content":[{"type":"openai_audio","id":"audio_1","transcript":"hello","source":{"type":"base64","media_type":"audio/wav","data":"AAAA"}}]},
+		{"role":"user","content":"again"}
+	]}`)
+	require.Len(t, chatReq.Messages, 3)
+	require.Equal(t, &ChatAudio{ID: "audio_1"}, chatReq.Messages[1].Audio)
+}
+
+func TestTransformOpenAIToClaude_Audio(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-audio-preview","choices":[{"index":0,
+		"message":{"role":"assistant","content":null,"audio":{"id":"audio_1","data":"UklGRg==","expires_at":1700000000,"transcript":"hello there"}},
+		"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`)
+
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{AudioFormat: "mp3"})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	block := resp.Content[0]
+	require.Equal(t, AudioBlockType, block.Type)
+	require.Equal(t, "audio_1", block.ID)
+	require.Equal(t, "hello there", block.Transcript)
+	require.Equal(t, &antigravity.ImageSource{Type: "base64", MediaType: "audio/mpeg", Data: "UklGRg=="}, block.Source)
+	require.Equal(t, "end_turn", resp.StopReason)
+
+	// 纯文本响应不受影响
+	out, _, err = TransformOpenAIToClaudeWithOptions([]byte(`{"id":"chatcmpl-2","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`), "claude-model", ResponseOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, string(out), AudioBlockType)
+}
+
+func TestStreamingProcessor_Audio(t *testing.T) {
+	part1 := base64.StdEncoding.EncodeToString([]byte("ab"))
+	part2 := base64.StdEncoding.EncodeToString([]byte("cde"))
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{AudioFormat: "pcm16"})
+	events := runStream(t, p,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","audio":{"id":"audio_1","transcript":"hel"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"audio":{"data":"`+part1+`","transcript":"lo"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"audio":{"data":"`+part2+`"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+
+	require.Equal(t, []string{AudioBlockType}, blockStartTypes(events))
+	for _, ev := range events {
+		if ev.Event != "content_block_start" {
+			continue
+		}
+		block, _ := ev.Data["content_block"].(map[string]any)
+		require.Equal(t, "audio_1", block["id"])
+		require.Equal(t, "hello", block["transcript"])
+		source, _ := block["source"].(map[string]any)
+		require.Equal(t, "audio/pcm", source["media_type"])
+		require.Equal(t, base64.StdEncoding.EncodeToString([]byte("abcde")), source["data"])
+	}
+	require.True(t, p.ContentSeen())
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+}
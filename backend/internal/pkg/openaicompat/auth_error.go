@@ -0,0 +1,131 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 上游 401 的分类（ClassifyUnauthorized）
+const (
+	AuthErrorUnknown    = ""            // 无法判断原因
+	AuthErrorInvalidKey = "invalid_key" // 密钥错误、已吊销或不存在（永久性，需人工更换）
+	AuthErrorExpired    = "expired"     // 令牌过期（可通过刷新或轮换凭证恢复）
+)
+
+// authErrorCodes 各上游 401 响应体中的错误码（error.code / error.type / error.status / details[].reason）
+var authErrorCodes = map[string]string{
+	// OpenAI / DeepSeek / 多数兼容上游
+	"invalid_api_key":     AuthErrorInvalidKey,
+	"incorrect_api_key":   AuthErrorInvalidKey,
+	"account_deactivated": AuthErrorInvalidKey,
+	// Azure OpenAI
+	"expiredauthenticationtoken": AuthErrorExpired,
+	"invalidauthenticationtoken": AuthErrorInvalidKey,
+	// Google（Gemini OpenAI 兼容接口 / Vertex）
+	"access_token_expired": AuthErrorExpired,
+	"api_key_invalid":      AuthErrorInvalidKey,
+	// 其他 JWT / OAuth 网关
+	"token_expired": AuthErrorExpired,
+	"invalid_token": AuthErrorInvalidKey,
+}
+
+// authExpiredPhrases / authInvalidPhrases 错误码无法判断时按错误信息匹配的关键短语（小写）
+var (
+	authExpiredPhrases = []string{"expired", "token is expired", "jwt expired", "refresh the token"}
+	authInvalidPhrases = []string{
+		"invalid api key", "incorrect api key", "invalid x-api-key", "invalid_api_key", "api key not valid",
+		"invalid authentication", "invalid subscription key", "no auth credentials", "user not found",
+		"revoked", "disabled", "deactivated", "does not exist",
+	}
+)
+
+// ClassifyUnauthorized 按上游 401 响应体判断是密钥无效（AuthErrorInvalidKey）还是令牌过期（AuthErrorExpired），
+// 依次识别结构化错误码与错误信息，无法判断时返回 AuthErrorUnknown
+func ClassifyUnauthorized(body []byte) string {
+	codes, message := authErrorFields(body)
+	for _, code := range codes {
+		if kind, ok := authErrorCodes[strings.ToLower(code)]; ok && kind != AuthErrorUnknown {
+			return kind
+		}
+	}
+
+	message = strings.ToLower(message)
+	if message == "" {
+		return AuthErrorUnknown
+	}
+	for _, phrase := range authExpiredPhrases {
+		if strings.Contains(message, phrase) {
+			return AuthErrorExpired
+		}
+	}
+	for _, phrase := range authInvalidPhrases {
+		if strings.Contains(message, phrase) {
+			return AuthErrorInvalidKey
+		}
+	}
+	return AuthErrorUnknown
+}
+
+// authErrorFields 提取 401 响应体中的错误码与错误信息，兼容：
+// OpenAI {"error":{"code","type","message"}}、Anthropic {"type":"error","error":{"type","message"}}、
+// Google {"error":{"status","message","details":[{"reason"}]}}、顶层 {"code","message"} / {"detail"} 及纯文本
+func authErrorFields(body []byte) (codes []string, message string) {
+	var resp struct {
+		Error json.RawMessage `json:"error"`
+		Code  any             `json:"code"`
+		Msg   string          `json:"message"`
+		// FastAPI 等框架的错误格式
+		Detail any `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, strings.TrimSpace(string(body))
+	}
+
+	var detail struct {
+		Code    any    `json:"code"`
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			Reason string `json:"reason"`
+		} `json:"details"`
+	}
+	if len(resp.Error) > 0 && json.Unmarshal(resp.Error, &detail) != nil {
+		// error 为字符串（部分中转直接返回 {"error":"..."}）
+		var text string
+		_ = json.Unmarshal(resp.Error, &text)
+		detail.Message = text
+	}
+	for _, code := range []any{detail.Code, detail.Type, detail.Status, resp.Code} {
+		if s := authCodeString(code); s != "" {
+			codes = append(codes, s)
+		}
+	}
+	for _, d := range detail.Details {
+		if d.Reason != "" {
+			codes = append(codes, d.Reason)
+		}
+	}
+
+	message = detail.Message
+	if message == "" {
+		message = resp.Msg
+	}
+	if message == "" && resp.Detail != nil {
+		message = authCodeString(resp.Detail)
+	}
+	return codes, message
+}
+
+// authCodeString 将错误码（字符串或数字）转为字符串，数字错误码（如 401）不携带分类信息时仍原样返回
+func authCodeString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val)
+	case float64:
+		return fmt.Sprintf("%g", val)
+	default:
+		return ""
+	}
+}
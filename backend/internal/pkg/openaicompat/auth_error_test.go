@@ -0,0 +1,35 @@
+package openaicompat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyUnauthorized(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"openai invalid key", `{"error":{"message":"Incorrect API key provided: sk-abc***. You can find your API key at https://platform.openai.com/account/api-keys.","type":"invalid_request_error","param":null,"code":"invalid_api_key"}}`, AuthErrorInvalidKey},
+		{"anthropic style", `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, AuthErrorInvalidKey},
+		{"openrouter no credentials", `{"error":{"message":"No auth credentials found","code":401}}`, AuthErrorInvalidKey},
+		{"openrouter user not found", `{"error":{"message":"User not found.","code":401}}`, AuthErrorInvalidKey},
+		{"azure expired token", `{"error":{"code":"ExpiredAuthenticationToken","message":"The access token expiry UTC time is earlier than current UTC time."}}`, AuthErrorExpired},
+		{"azure invalid subscription key", `{"error":{"code":"401","message":"Access denied due to invalid subscription key or wrong API endpoint."}}`, AuthErrorInvalidKey},
+		{"google token expired", `{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"ACCESS_TOKEN_EXPIRED"}]}}`, AuthErrorExpired},
+		{"google invalid key", `{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT","details":[{"reason":"API_KEY_INVALID"}]}}`, AuthErrorInvalidKey},
+		{"top-level jwt expired", `{"code":"token_expired","message":"jwt expired"}`, AuthErrorExpired},
+		{"fastapi detail", `{"detail":"Token has expired"}`, AuthErrorExpired},
+		{"string error", `{"error":"API key revoked"}`, AuthErrorInvalidKey},
+		{"plain text", `Unauthorized: token is expired`, AuthErrorExpired},
+		{"bare unauthorized", `{"error":{"message":"Unauthorized","code":401}}`, AuthErrorUnknown},
+		{"empty", ``, AuthErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ClassifyUnauthorized([]byte(tt.body)))
+		})
+	}
+}
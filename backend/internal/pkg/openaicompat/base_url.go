@@ -0,0 +1,59 @@
+package openaicompat
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// defaultAPIVersionPath base_url 只有主机（无路径）时补全的版本段
+const defaultAPIVersionPath = "/v1"
+
+// endpointSuffixes 误将完整接口地址配置为 base_url 时需要去掉的接口路径
+var endpointSuffixes = []string{"/chat/completions", "/completions", "/responses"}
+
+// apiVersionSegment 形如 v1 / v4 / v1beta 的版本路径段
+var apiVersionSegment = regexp.MustCompile(`^v\d+[a-z0-9]*$`)
+
+// NormalizeBaseURL 校验并规范化 OpenAI 兼容上游的 base_url，返回可直接拼接 "/chat/completions" 等接口路径的地址：
+//   - 必须是 http(s) 绝对地址，否则返回错误
+//   - 去掉末尾的 "/" 及误填的接口路径（如 https://host/v1/chat/completions → https://host/v1）
+//   - 合并重复的版本段（如 https://host/v1/v1 → https://host/v1）
+//   - 只有主机时补全 /v1（如 https://host → https://host/v1）；已有其他路径（如 /api/v3、/openai）时保持不变
+func NormalizeBaseURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid base_url %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid base_url %q: must be an absolute http(s) URL", raw)
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	for _, suffix := range endpointSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			path = strings.TrimRight(strings.TrimSuffix(path, suffix), "/")
+			break
+		}
+	}
+
+	var segments []string
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		if n := len(segments); n > 0 && seg == segments[n-1] && apiVersionSegment.MatchString(seg) {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		u.Path = defaultAPIVersionPath
+	} else {
+		u.Path = "/" + strings.Join(segments, "/")
+	}
+	u.RawPath = ""
+	return u.String(), nil
+}
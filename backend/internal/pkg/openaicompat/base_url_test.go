@@ -0,0 +1,41 @@
+package openaicompat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeBaseURL(t *testing.T) {
+	tests := map[string]string{
+		"https://api.example.com":                                 "https://api.example.com/v1",
+		"https://api.example.com/":                                "https://api.example.com/v1",
+		"  https://api.example.com/v1  ":                          "https://api.example.com/v1",
+		"https://api.example.com/v1/":                             "https://api.example.com/v1",
+		"https://api.example.com/v1/v1":                           "https://api.example.com/v1",
+		"https://api.example.com/v1/chat/completions":             "https://api.example.com/v1",
+		"https://api.example.com/v1/v1/chat/completions/":         "https://api.example.com/v1",
+		"https://api.example.com/chat/completions":                "https://api.example.com/v1",
+		"https://api.example.com/v1/responses":                    "https://api.example.com/v1",
+		"https://openrouter.ai/api/v1":                            "https://openrouter.ai/api/v1",
+		"https://open.bigmodel.cn/api/paas/v4":                    "https://open.bigmodel.cn/api/paas/v4",
+		"https://ark.cn-beijing.volces.com/api/v3/":               "https://ark.cn-beijing.volces.com/api/v3",
+		"https://api.groq.com/openai":                             "https://api.groq.com/openai",
+		"https://generativelanguage.googleapis.com/v1beta/openai": "https://generativelanguage.googleapis.com/v1beta/openai",
+		"http://localhost:8000":                                   "http://localhost:8000/v1",
+	}
+	for raw, want := range tests {
+		t.Run(raw, func(t *testing.T) {
+			got, err := NormalizeBaseURL(raw)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestNormalizeBaseURL_Invalid(t *testing.T) {
+	for _, raw := range []string{"", "api.example.com/v1", "ftp://api.example.com", "https://", "://bad"} {
+		_, err := NormalizeBaseURL(raw)
+		require.ErrorContains(t, err, "invalid base_url", "raw=%q", raw)
+	}
+}
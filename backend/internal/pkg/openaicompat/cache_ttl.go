@@ -0,0 +1,65 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// Claude cache_control.ttl 取值
+const (
+	CacheTTL5m = "5m"
+	CacheTTL1h = "1h"
+)
+
+// cacheTTLRank 比较多个 ttl 提示时取最长的一个
+var cacheTTLRank = map[string]int{CacheTTL5m: 1, CacheTTL1h: 2}
+
+// providerCacheRetention 支持缓存时长的上游：Claude ttl → prompt_cache_retention 取值
+// 未列出的上游（自动缓存或不支持缓存时长）忽略 ttl 提示
+var providerCacheRetention = map[string]map[string]string{
+	// OpenAI 默认内存缓存（数分钟），extended 保留最长 24 小时
+	ProviderOpenAI: {CacheTTL5m: "in_memory", CacheTTL1h: "24h"},
+}
+
+// applyCacheTTL 将请求中 cache_control 的 ttl 提示映射为上游的缓存时长字段，上游不支持时不设置
+func applyCacheTTL(req *ChatRequest, claudeReq *antigravity.ClaudeRequest, provider string) {
+	retention, ok := providerCacheRetention[strings.ToLower(strings.TrimSpace(provider))]
+	if !ok {
+		return
+	}
+	if ttl := cacheControlTTL(claudeReq); ttl != "" {
+		req.PromptCacheRetention = retention[ttl]
+	}
+}
+
+// cacheControlTTL 返回 system 与消息内容块 cache_control 中显式指定的最长 ttl，均未指定时返回空字符串
+// （工具定义的 cache_control 在解析时已丢弃，不参与判断）
+func cacheControlTTL(claudeReq *antigravity.ClaudeRequest) string {
+	var best string
+	consider := func(raw json.RawMessage) {
+		var blocks []struct {
+			CacheControl *struct {
+				TTL string `json:"ttl"`
+			} `json:"cache_control"`
+		}
+		if len(raw) == 0 || json.Unmarshal(raw, &blocks) != nil {
+			return
+		}
+		for _, block := range blocks {
+			if block.CacheControl == nil {
+				continue
+			}
+			ttl := strings.ToLower(strings.TrimSpace(block.CacheControl.TTL))
+			if cacheTTLRank[ttl] > cacheTTLRank[best] {
+				best = ttl
+			}
+		}
+	}
+	consider(claudeReq.System)
+	for _, msg := range claudeReq.Messages {
+		consider(msg.Content)
+	}
+	return best
+}
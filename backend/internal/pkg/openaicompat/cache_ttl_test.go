@@ -0,0 +1,61 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_CacheControlTTL(t *testing.T) {
+	claudeJSON := `{
+		"model": "gpt-4o",
+		"system": [{"type": "text", "text": "long shared prefix", "cache_control": {"type": "ephemeral", "ttl": "1h"}}],
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "hi", "cache_control": {"type": "ephemeral", "ttl": "5m"}}]}]
+	}`
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{ProviderOpenAI, "24h"}, // 取最长的 ttl 提示
+		{ProviderGeneric, ""},   // 不支持缓存时长的上游忽略 ttl
+		{ProviderOpenRouter, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: tt.provider})
+			require.NoError(t, err)
+			var req map[string]any
+			require.NoError(t, json.Unmarshal(body, &req))
+			if tt.want == "" {
+				require.NotContains(t, req, "prompt_cache_retention")
+				return
+			}
+			require.Equal(t, tt.want, req["prompt_cache_retention"])
+		})
+	}
+
+	// Responses API 同样下发
+	body, _, err := TransformClaudeToResponsesWithOptions(&claudeReq, RequestOptions{Provider: ProviderOpenAI})
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"prompt_cache_retention":"24h"`)
+}
+
+func TestCacheControlTTL(t *testing.T) {
+	tests := map[string]string{
+		`{"messages":[{"role":"user","content":"hi"}]}`:                                                                                       "",
+		`{"messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral"}}]}]}`:                         "",
+		`{"messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral","ttl":"5m"}}]}]}`:              CacheTTL5m,
+		`{"system":[{"type":"text","text":"s","cache_control":{"type":"ephemeral","ttl":"1h"}}],"messages":[{"role":"user","content":"hi"}]}`: CacheTTL1h,
+		`{"messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral","ttl":"2d"}}]}]}`:              "",
+	}
+	for raw, want := range tests {
+		var claudeReq antigravity.ClaudeRequest
+		require.NoError(t, json.Unmarshal([]byte(raw), &claudeReq))
+		require.Equal(t, want, cacheControlTTL(&claudeReq), raw)
+	}
+}
@@ -0,0 +1,172 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// completionsTurnStop 旧版 Completions 请求的停止序列：模型开始续写下一轮用户发言时停止
+const completionsTurnStop = "\nUser:"
+
+// CompletionRequest 旧版 Completions API（/completions）请求
+// 由 Chat Completions 请求改写而来：消息按角色拼接为单个 prompt；
+// 上游不支持工具调用与推理，tools / tool_choice / reasoning 不下发（开启 TextToolProtocol 时工具说明已在 system 中）
+type CompletionRequest struct {
+	Model         string      `json:"model"`
+	Prompt        string      `json:"prompt"`
+	MaxTokens     int         `json:"max_tokens,omitempty"`
+	Temperature   *float64    `json:"temperature,omitempty"`
+	TopP          *float64    `json:"top_p,omitempty"`
+	TopK          *int        `json:"top_k,omitempty"`
+	Seed          *int64      `json:"seed,omitempty"`
+	Stop          []string    `json:"stop,omitempty"`
+	Stream        bool        `json:"stream,omitempty"`
+	StreamOptions *StreamOpts `json:"stream_options,omitempty"`
+}
+
+// CompletionResponse 旧版 Completions API 响应（流式 chunk 结构相同，choices[].text 为增量）
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+	Error   *ErrorDetail       `json:"error,omitempty"`
+}
+
+// CompletionChoice 旧版 Completions 选择项
+type CompletionChoice struct {
+	Index        int     `json:"index"`
+	Text         string  `json:"text"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// TransformClaudeToCompletionsWithOptions 将 Claude Messages API 请求转换为旧版 Completions API 格式
+// 先按 Chat Completions 规则转换（system 合并、tool_result、采样参数等行为保持一致），再将消息拼接为 prompt
+func TransformClaudeToCompletionsWithOptions(claudeReq *antigravity.ClaudeRequest, opts RequestOptions) ([]byte, []TransformWarning, error) {
+	chatReq, warnings, err := buildChatRequest(claudeReq, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(chatReq.Tools) > 0 {
+		w := transformWarnings(warnings)
+		w.add(WarningToolsDropped, "%d tool definition(s) not sent: the completions api does not support tool calling", len(chatReq.Tools))
+		warnings = w
+	}
+	body, err := json.Marshal(chatToCompletionRequest(chatReq))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, warnings, nil
+}
+
+// chatToCompletionRequest 将 Chat Completions 请求改写为旧版 Completions 请求
+func chatToCompletionRequest(req *ChatRequest) *CompletionRequest {
+	return &CompletionRequest{
+		Model:         req.Model,
+		Prompt:        completionPrompt(req.Messages),
+		MaxTokens:     req.MaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		TopK:          req.TopK,
+		Seed:          req.Seed,
+		Stop:          []string{completionsTurnStop},
+		Stream:        req.Stream,
+		StreamOptions: req.StreamOptions,
+	}
+}
+
+// completionPrompt 将消息按 "角色: 内容" 拼接为对话记录，末尾留出 "Assistant:" 供模型续写；
+// 图片等非文本内容丢弃，历史工具调用以文本形式保留
+func completionPrompt(messages []ChatMessage) string {
+	var turns []string
+	for _, msg := range messages {
+		text := responsesContentText(msg.Content)
+		var label string
+		switch msg.Role {
+		case "system", "developer":
+			label = "System"
+		case "assistant":
+			label = "Assistant"
+			for _, tc := range msg.ToolCalls {
+				call := fmt.Sprintf("[tool call %s: %s]", tc.Function.Name, tc.Function.Arguments)
+				if text == "" {
+					text = call
+				} else {
+					text += "\n" + call
+				}
+			}
+		case "tool":
+			label = "Tool"
+		default:
+			label = "User"
+		}
+		if text == "" {
+			continue
+		}
+		turns = append(turns, label+": "+text)
+	}
+	turns = append(turns, "Assistant:")
+	return strings.Join(turns, "\n\n")
+}
+
+// CompletionsToChatResponse 将旧版 Completions 非流式响应改写为 Chat Completions 响应，
+// 之后可按 Chat Completions 响应统一处理；携带 error 对象的响应原样返回
+func CompletionsToChatResponse(body []byte) ([]byte, error) {
+	var resp CompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse completions api response: %w", err)
+	}
+	if resp.Error != nil {
+		return body, nil
+	}
+	out := ChatResponse{ID: resp.ID, Object: "chat.completion", Model: resp.Model, Choices: []ChatChoice{}, Usage: resp.Usage}
+	for _, choice := range resp.Choices {
+		content, _ := json.Marshal(strings.TrimPrefix(choice.Text, " "))
+		finishReason := "stop"
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			finishReason = *choice.FinishReason
+		}
+		out.Choices = append(out.Choices, ChatChoice{
+			Index:        choice.Index,
+			Message:      ChatMessage{Role: "assistant", Content: content},
+			FinishReason: finishReason,
+		})
+	}
+	return json.Marshal(out)
+}
+
+// processCompletionsLine 将一行旧版 Completions SSE 数据改写为 Chat Completions chunk 后交给 processChatLine 处理
+func (p *StreamingProcessor) processCompletionsLine(line string) []byte {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return p.processChatLine(line)
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return p.processChatLine("data: [DONE]")
+	}
+	var chunk CompletionResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || chunk.Error != nil {
+		// 无法识别或携带 error 对象的数据交给 Chat Completions 流程处理（错误事件转换）
+		return p.processChatLine(line)
+	}
+
+	out := StreamChunk{ID: chunk.ID, Object: "chat.completion.chunk", Model: chunk.Model, Choices: []StreamChunkChoice{}, Usage: chunk.Usage}
+	for _, choice := range chunk.Choices {
+		text := choice.Text
+		if !p.contentSeen {
+			// 续写 "Assistant:" 时模型通常先输出一个空格
+			text = strings.TrimPrefix(text, " ")
+		}
+		out.Choices = append(out.Choices, StreamChunkChoice{
+			Index:        choice.Index,
+			Delta:        StreamChunkDelta{Content: text},
+			FinishReason: choice.FinishReason,
+		})
+	}
+	return p.processChatLine(chatDataLine(out))
+}
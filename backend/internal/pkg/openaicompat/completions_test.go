@@ -0,0 +1,73 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToCompletions(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"llama","max_tokens":128,"stream":true,"system":"be brief","temperature":0.2,
+		"tools":[{"name":"ls","input_schema":{"type":"object"}}],
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"list files"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}}]},
+			{"role":"assistant","content":[{"type":"text","text":"sure"},{"type":"tool_use","id":"call_1","name":"ls","input":{"dir":"."}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"a.txt"}]}
+		]}`), &claudeReq))
+	body, _, err := TransformClaudeToCompletionsWithOptions(&claudeReq, DefaultRequestOptions())
+	require.NoError(t, err)
+
+	var req CompletionRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Equal(t, "llama", req.Model)
+	require.Equal(t, 128, req.MaxTokens)
+	require.True(t, req.Stream)
+	require.NotNil(t, req.Temperature)
+	require.NotNil(t, req.StreamOptions)
+	require.Equal(t, []string{"\nUser:"}, req.Stop)
+	require.Equal(t, "System: be brief\n\nUser: list files\n\nAssistant: sure\n[tool call ls: {\"dir\":\".\"}]\n\nTool: a.txt\n\nAssistant:", req.Prompt)
+	require.NotContains(t, string(body), `"messages"`)
+	require.NotContains(t, string(body), `"tools"`)
+}
+
+func TestCompletionsToChatResponse(t *testing.T) {
+	body := []byte(`{"id":"cmpl-1","object":"text_completion","model":"llama","choices":[{"index":0,"text":" Hello there","finish_reason":"length"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`)
+	chatBody, err := CompletionsToChatResponse(body)
+	require.NoError(t, err)
+
+	out, usage, err := TransformOpenAIToClaude(chatBody, "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "Hello there", resp.Content[0].Text)
+	require.Equal(t, "max_tokens", resp.StopReason)
+	require.Equal(t, 20, usage.InputTokens)
+	require.Equal(t, 5, usage.OutputTokens)
+
+	// 错误响应原样返回
+	errBody := []byte(`{"error":{"message":"model not found","type":"invalid_request_error"}}`)
+	converted, err := CompletionsToChatResponse(errBody)
+	require.NoError(t, err)
+	require.Equal(t, errBody, converted)
+}
+
+func TestStreamingProcessor_CompletionsAPI(t *testing.T) {
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{CompletionsAPI: true})
+	events := runStream(t, p,
+		`data: {"id":"cmpl-1","object":"text_completion","model":"llama","choices":[{"index":0,"text":" Hello","finish_reason":null}]}`,
+		`data: {"id":"cmpl-1","object":"text_completion","model":"llama","choices":[{"index":0,"text":" world","finish_reason":null}]}`,
+		`data: {"id":"cmpl-1","object":"text_completion","model":"llama","choices":[{"index":0,"text":"","finish_reason":"stop"}]}`,
+		`data: {"id":"cmpl-1","object":"text_completion","model":"llama","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":2,"total_tokens":22}}`,
+		`data: [DONE]`,
+	)
+
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+	require.Equal(t, "Hello world", textDeltas(events))
+	require.Equal(t, 2, messageDeltaOutputTokens(t, events))
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+	require.Equal(t, 20, p.Usage().InputTokens)
+}
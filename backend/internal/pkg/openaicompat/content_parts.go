@@ -0,0 +1,80 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ProviderOpenAIResponses 使用 Responses API 风格内容块命名（input_text / input_image）的上游
+const ProviderOpenAIResponses = "openai_responses"
+
+// 内部统一使用的内容块类型（Chat Completions 命名）
+const (
+	contentPartText  = "text"
+	contentPartImage = "image_url"
+)
+
+// ContentPartNames 上游期望的多模态内容块类型名
+type ContentPartNames struct {
+	Text  string
+	Image string
+	// FlatImageURL 为 true 时 image_url 输出为字符串（Responses API 风格），否则为 {"url": ...} 对象
+	FlatImageURL bool
+}
+
+// defaultContentPartNames 各上游的内容块命名，未列出的上游使用 Chat Completions 命名
+var defaultContentPartNames = map[string]ContentPartNames{
+	ProviderGeneric:         {Text: contentPartText, Image: contentPartImage},
+	ProviderOpenAIResponses: {Text: "input_text", Image: "input_image", FlatImageURL: true},
+}
+
+// ContentPartNamesFor 返回上游提供方的内容块命名，未知提供方使用 Chat Completions 命名
+func ContentPartNamesFor(provider string) ContentPartNames {
+	if names, ok := defaultContentPartNames[strings.ToLower(strings.TrimSpace(provider))]; ok {
+		return names
+	}
+	return defaultContentPartNames[ProviderGeneric]
+}
+
+// flatImageContentPart Responses API 风格的内容块，image_url 为字符串
+type flatImageContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// marshalContentParts 按上游命名序列化内容块（parts 使用内部 Chat Completions 命名）
+func marshalContentParts(parts []ContentPart, names ContentPartNames) json.RawMessage {
+	rename := func(partType string) string {
+		switch {
+		case partType == contentPartText && names.Text != "":
+			return names.Text
+		case partType == contentPartImage && names.Image != "":
+			return names.Image
+		}
+		return partType
+	}
+
+	if names.FlatImageURL {
+		flat := make([]flatImageContentPart, 0, len(parts))
+		for _, part := range parts {
+			p := flatImageContentPart{Type: rename(part.Type), Text: part.Text}
+			if part.ImageURL != nil {
+				p.ImageURL = part.ImageURL.URL
+				p.Detail = part.ImageURL.Detail
+			}
+			flat = append(flat, p)
+		}
+		data, _ := json.Marshal(flat)
+		return data
+	}
+
+	renamed := make([]ContentPart, 0, len(parts))
+	for _, part := range parts {
+		part.Type = rename(part.Type)
+		renamed = append(renamed, part)
+	}
+	data, _ := json.Marshal(renamed)
+	return data
+}
@@ -0,0 +1,36 @@
+package openaicompat
+
+import "encoding/json"
+
+// effectiveParamKeys 调试时回显的上游请求参数（Chat Completions 与 Responses API 字段名）
+// max_tokens 按上游实际使用的字段名回显（max_completion_tokens / max_output_tokens）
+var effectiveParamKeys = []string{
+	"model",
+	"temperature",
+	"max_tokens",
+	"max_completion_tokens",
+	"max_output_tokens",
+	"reasoning",
+	"reasoning_effort",
+	"tool_choice",
+}
+
+// EffectiveRequestParams 从最终发送给上游的请求体中提取生效的请求参数（模型映射、默认值、clamp 等均已应用），
+// 用于调试时在响应中回显；请求体无法解析时返回 nil。未发送的参数（如被省略的 tool_choice）不出现在结果中
+func EffectiveRequestParams(upstreamBody []byte) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(upstreamBody, &fields); err != nil {
+		return nil
+	}
+	params := make(map[string]json.RawMessage, len(effectiveParamKeys))
+	for _, key := range effectiveParamKeys {
+		if v, ok := fields[key]; ok && string(v) != "null" {
+			params[key] = v
+		}
+	}
+	out, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	return out
+}
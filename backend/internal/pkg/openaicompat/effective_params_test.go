@@ -0,0 +1,17 @@
+package openaicompat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveRequestParams(t *testing.T) {
+	chat := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"max_tokens":4096,"temperature":0.2,"top_p":0.9,"reasoning":{"effort":"low"},"tool_choice":null,"stream":true}`)
+	require.JSONEq(t, `{"model":"m","max_tokens":4096,"temperature":0.2,"reasoning":{"effort":"low"}}`, string(EffectiveRequestParams(chat)))
+
+	responses := []byte(`{"model":"m","input":[],"max_output_tokens":1024,"tool_choice":"required","store":false}`)
+	require.JSONEq(t, `{"model":"m","max_output_tokens":1024,"tool_choice":"required"}`, string(EffectiveRequestParams(responses)))
+
+	require.Nil(t, EffectiveRequestParams([]byte(`not json`)))
+}
@@ -0,0 +1,43 @@
+package openaicompat
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// 假签名格式（ResponseOptions.FakeSignatureFormat），不同下游 Claude 客户端对 signature 的形态要求不同
+const (
+	FakeSignatureTimestamp = "timestamp" // 毫秒时间戳 + 随机十六进制后缀（默认）
+	FakeSignatureBase64    = "base64"    // FakeSignatureBytes 个随机字节的标准 base64 编码（与 Anthropic 签名形态一致）
+	FakeSignatureHex       = "hex"       // FakeSignatureBytes 个随机字节的十六进制编码
+)
+
+// defaultFakeSignatureBytes base64 / hex 格式未配置长度时使用的随机字节数
+const defaultFakeSignatureBytes = 64
+
+// maxFakeSignatureBytes 随机字节数上限，避免误配置产生过大的签名
+const maxFakeSignatureBytes = 4096
+
+// fakeSignature 按配置的格式生成假签名，未知格式按默认格式处理
+func (o ResponseOptions) fakeSignature() string {
+	switch strings.ToLower(strings.TrimSpace(o.FakeSignatureFormat)) {
+	case FakeSignatureBase64:
+		return base64.StdEncoding.EncodeToString(randomSignatureBytes(o.FakeSignatureBytes))
+	case FakeSignatureHex:
+		return hex.EncodeToString(randomSignatureBytes(o.FakeSignatureBytes))
+	default:
+		return generateFakeSignature()
+	}
+}
+
+// randomSignatureBytes 生成 n 个随机字节，n 非正数时使用默认长度，超过上限时取上限
+func randomSignatureBytes(n int) []byte {
+	if n <= 0 {
+		n = defaultFakeSignatureBytes
+	}
+	buf := make([]byte, min(n, maxFakeSignatureBytes))
+	_, _ = cryptorand.Read(buf)
+	return buf
+}
@@ -0,0 +1,62 @@
+package openaicompat
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeSignatureFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   ResponseOptions
+		verify func(t *testing.T, sig string)
+	}{
+		{"default", ResponseOptions{}, func(t *testing.T, sig string) {
+			require.Regexp(t, regexp.MustCompile(`^\d+_[0-9a-f]{16}$`), sig)
+		}},
+		{"base64 default length", ResponseOptions{FakeSignatureFormat: FakeSignatureBase64}, func(t *testing.T, sig string) {
+			raw, err := base64.StdEncoding.DecodeString(sig)
+			require.NoError(t, err)
+			require.Len(t, raw, defaultFakeSignatureBytes)
+		}},
+		{"base64 configured length", ResponseOptions{FakeSignatureFormat: "BASE64", FakeSignatureBytes: 300}, func(t *testing.T, sig string) {
+			raw, err := base64.StdEncoding.DecodeString(sig)
+			require.NoError(t, err)
+			require.Len(t, raw, 300)
+		}},
+		{"hex", ResponseOptions{FakeSignatureFormat: FakeSignatureHex, FakeSignatureBytes: 24}, func(t *testing.T, sig string) {
+			raw, err := hex.DecodeString(sig)
+			require.NoError(t, err)
+			require.Len(t, raw, 24)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				sig := tt.opts.fakeSignature()
+				tt.verify(t, sig)
+				require.False(t, seen[sig], "duplicate signature %q", sig)
+				seen[sig] = true
+			}
+		})
+	}
+}
+
+func TestStreamingProcessor_FakeSignatureFormat(t *testing.T) {
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{FakeSignatureFormat: FakeSignatureBase64, FakeSignatureBytes: 32}),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"thinking"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"answer"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	sigs := blockSignatures(events)[0]
+	require.Len(t, sigs, 1)
+	raw, err := base64.StdEncoding.DecodeString(sigs[0])
+	require.NoError(t, err)
+	require.Len(t, raw, 32)
+}
@@ -0,0 +1,99 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	_ "image/gif" // 注册 GIF 解码器
+	"image/jpeg"
+	"image/png"
+	"log"
+)
+
+const (
+	// resizedJPEGQuality 缩放后重新编码 JPEG 使用的质量
+	resizedJPEGQuality = 90
+	// maxDownscaleSourcePixels 允许解码缩放的源图片最大像素数（约 128MB NRGBA），
+	// 超过时原样转发，避免少量压缩数据声明超大尺寸导致解码耗尽内存
+	maxDownscaleSourcePixels = 8192 * 4096
+)
+
+// downscaleBase64Image 将 base64 图片等比缩放至最长边不超过 maxDimension，返回新的 media type 与 base64 数据
+// maxDimension <= 0、图片未超过上限、像素数超过 maxDownscaleSourcePixels 或无法解码时原样返回；
+// 仅支持标准库解码器（PNG、JPEG、GIF），WebP 等其他格式不缩放直接转发；
+// JPEG 重新编码为 JPEG，其余格式（PNG、GIF 首帧）编码为 PNG
+func downscaleBase64Image(mediaType, data string, maxDimension int) (string, string) {
+	if maxDimension <= 0 || data == "" {
+		return mediaType, data
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return mediaType, data
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil || (cfg.Width <= maxDimension && cfg.Height <= maxDimension) {
+		return mediaType, data
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxDownscaleSourcePixels {
+		log.Printf("[OpenAICompat] %s image %dx%d exceeds %d pixels, forwarding without downscaling", format, cfg.Width, cfg.Height, maxDownscaleSourcePixels)
+		return mediaType, data
+	}
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return mediaType, data
+	}
+
+	width, height := scaledImageSize(cfg.Width, cfg.Height, maxDimension)
+	dst := downscaleImage(src, width, height)
+
+	var buf bytes.Buffer
+	outType := "image/png"
+	if format == "jpeg" {
+		outType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: resizedJPEGQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return mediaType, data
+	}
+	log.Printf("[OpenAICompat] downscaled %s image from %dx%d to %dx%d", format, cfg.Width, cfg.Height, width, height)
+	return outType, base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// scaledImageSize 计算等比缩放后的尺寸，最长边为 maxDimension，每边至少 1 像素
+func scaledImageSize(width, height, maxDimension int) (int, int) {
+	if width >= height {
+		return maxDimension, max(1, height*maxDimension/width)
+	}
+	return max(1, width*maxDimension/height), maxDimension
+}
+
+// downscaleImage 按区域平均（box filter）将图片缩小到指定尺寸
+func downscaleImage(src image.Image, width, height int) *image.NRGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// 预乘 alpha 的平均值转换为非预乘颜色
+			avg := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+			dst.Set(x, y, avg)
+		}
+	}
+	return dst
+}
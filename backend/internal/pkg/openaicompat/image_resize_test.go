@@ -0,0 +1,129 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTestImage 生成指定尺寸的纯色图片并按 format（png / jpeg）编码为 base64
+func encodeTestImage(t *testing.T, format string, width, height int) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	} else {
+		require.NoError(t, png.Encode(&buf, img))
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// decodedImageSize 返回 base64 图片的格式与尺寸
+func decodedImageSize(t *testing.T, data string) (string, int, int) {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(data)
+	require.NoError(t, err)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	require.NoError(t, err)
+	return format, cfg.Width, cfg.Height
+}
+
+func TestDownscaleBase64Image(t *testing.T) {
+	t.Run("png keeps aspect ratio", func(t *testing.T) {
+		mediaType, data := downscaleBase64Image("image/png", encodeTestImage(t, "png", 400, 100), 200)
+		require.Equal(t, "image/png", mediaType)
+		format, w, h := decodedImageSize(t, data)
+		require.Equal(t, "png", format)
+		require.Equal(t, 200, w)
+		require.Equal(t, 50, h)
+	})
+
+	t.Run("jpeg stays jpeg", func(t *testing.T) {
+		mediaType, data := downscaleBase64Image("image/jpeg", encodeTestImage(t, "jpeg", 120, 360), 90)
+		require.Equal(t, "image/jpeg", mediaType)
+		format, w, h := decodedImageSize(t, data)
+		require.Equal(t, "jpeg", format)
+		require.Equal(t, 30, w)
+		require.Equal(t, 90, h)
+	})
+
+	t.Run("within limit unchanged", func(t *testing.T) {
+		original := encodeTestImage(t, "png", 64, 64)
+		mediaType, data := downscaleBase64Image("image/png", original, 64)
+		require.Equal(t, "image/png", mediaType)
+		require.Equal(t, original, data)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		original := encodeTestImage(t, "png", 400, 400)
+		_, data := downscaleBase64Image("image/png", original, 0)
+		require.Equal(t, original, data)
+	})
+
+	t.Run("oversized pixel count passes through", func(t *testing.T) {
+		// 篡改 IHDR 声明 20000x20000，解码前即按像素上限拒绝
+		raw, err := base64.StdEncoding.DecodeString(encodeTestImage(t, "png", 1, 1))
+		require.NoError(t, err)
+		binary.BigEndian.PutUint32(raw[16:20], 20000)
+		binary.BigEndian.PutUint32(raw[20:24], 20000)
+		binary.BigEndian.PutUint32(raw[29:33], crc32.ChecksumIEEE(raw[12:29]))
+		original := base64.StdEncoding.EncodeToString(raw)
+		_, w, h := decodedImageSize(t, original)
+		require.Equal(t, 20000, w)
+		require.Equal(t, 20000, h)
+
+		mediaType, data := downscaleBase64Image("image/png", original, 1024)
+		require.Equal(t, "image/png", mediaType)
+		require.Equal(t, original, data)
+	})
+
+	t.Run("undecodable passes through", func(t *testing.T) {
+		webp := base64.StdEncoding.EncodeToString([]byte("RIFF\x00\x00\x00\x00WEBPVP8 not really an image"))
+		mediaType, data := downscaleBase64Image("image/webp", webp, 16)
+		require.Equal(t, "image/webp", mediaType)
+		require.Equal(t, webp, data)
+
+		mediaType, data = downscaleBase64Image("image/png", "%%% not base64 %%%", 16)
+		require.Equal(t, "image/png", mediaType)
+		require.Equal(t, "%%% not base64 %%%", data)
+	})
+}
+
+func TestTransformClaudeToOpenAI_MaxImageDimension(t *testing.T) {
+	claudeJSON := `{"model":"m","messages":[{"role":"user","content":[
+		{"type":"text","text":"describe"},
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + encodeTestImage(t, "png", 1000, 500) + `"}}
+	]}]}`
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{MaxImageDimension: 100})
+	require.NoError(t, err)
+
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	var parts []ContentPart
+	require.NoError(t, json.Unmarshal(req.Messages[0].Content, &parts))
+	require.Len(t, parts, 2)
+	url := parts[1].ImageURL.URL
+	require.True(t, strings.HasPrefix(url, "data:image/png;base64,"))
+	_, w, h := decodedImageSize(t, strings.TrimPrefix(url, "data:image/png;base64,"))
+	require.Equal(t, 100, w)
+	require.Equal(t, 50, h)
+}
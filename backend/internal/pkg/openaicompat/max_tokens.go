@@ -0,0 +1,36 @@
+package openaicompat
+
+import "strings"
+
+// defaultMaxTokensByProvider 客户端未指定（或指定为 0）max_tokens 时各上游使用的默认值
+// 0 表示不发送 max_tokens，由上游自行决定（OpenRouter、vLLM 未指定时按模型上限 / 剩余上下文输出）；
+// 未指定时会按 0 处理或默认值过小的上游显式发送一个合理的上限，避免请求零输出
+var defaultMaxTokensByProvider = map[string]int{
+	ProviderGeneric:         4096,
+	ProviderOpenAI:          4096,
+	ProviderOpenAIResponses: 4096,
+	ProviderDeepSeek:        4096,
+	ProviderAnthropicAuth:   4096, // Anthropic 风格鉴权的中转多转发至要求必须指定 max_tokens 的后端
+	ProviderOpenRouter:      0,
+	ProviderVLLM:            0,
+}
+
+// DefaultMaxTokens 返回上游提供方在客户端未指定 max_tokens 时的默认值，0 表示不发送；未知提供方使用通用默认值
+func DefaultMaxTokens(provider string) int {
+	if n, ok := defaultMaxTokensByProvider[strings.ToLower(strings.TrimSpace(provider))]; ok {
+		return n
+	}
+	return defaultMaxTokensByProvider[ProviderGeneric]
+}
+
+// resolveMaxTokens 计算发送给上游的 max_tokens：客户端指定正数时原样使用，
+// 否则使用 opts.DefaultMaxTokens（账号配置），再否则使用上游提供方的默认值；返回 0 表示不发送
+func resolveMaxTokens(requested int, opts RequestOptions) int {
+	if requested > 0 {
+		return requested
+	}
+	if opts.DefaultMaxTokens > 0 {
+		return opts.DefaultMaxTokens
+	}
+	return DefaultMaxTokens(opts.Provider)
+}
@@ -0,0 +1,73 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// transformMaxTokens 转换请求并返回上游请求体中的 max_tokens 字段（未发送时 present 为 false）
+func transformMaxTokens(t *testing.T, claudeJSON string, opts RequestOptions) (value int, present bool) {
+	t.Helper()
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, opts)
+	require.NoError(t, err)
+	var raw map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &raw))
+	field, ok := raw["max_tokens"]
+	if !ok {
+		return 0, false
+	}
+	require.NoError(t, json.Unmarshal(field, &value))
+	return value, true
+}
+
+func TestTransformClaudeToOpenAI_MaxTokensDefaultPerProvider(t *testing.T) {
+	requests := map[string]string{
+		"unset": `{"model":"m","messages":[{"role":"user","content":"hi"}]}`,
+		"zero":  `{"model":"m","max_tokens":0,"messages":[{"role":"user","content":"hi"}]}`,
+	}
+	tests := []struct {
+		provider string
+		want     int // 0 表示不发送 max_tokens
+	}{
+		{ProviderGeneric, 4096},
+		{ProviderOpenAI, 4096},
+		{ProviderOpenAIResponses, 4096},
+		{ProviderDeepSeek, 4096},
+		{ProviderAnthropicAuth, 4096},
+		{ProviderOpenRouter, 0},
+		{ProviderVLLM, 0},
+		{"unknown-provider", 4096},
+	}
+	for name, claudeJSON := range requests {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.provider, func(t *testing.T) {
+				value, present := transformMaxTokens(t, claudeJSON, RequestOptions{Provider: tt.provider})
+				if tt.want == 0 {
+					require.False(t, present, "max_tokens should be omitted")
+					return
+				}
+				require.True(t, present)
+				require.Equal(t, tt.want, value)
+			})
+		}
+	}
+}
+
+func TestTransformClaudeToOpenAI_MaxTokensExplicitAndOverride(t *testing.T) {
+	explicit := `{"model":"m","max_tokens":256,"messages":[{"role":"user","content":"hi"}]}`
+	value, present := transformMaxTokens(t, explicit, RequestOptions{Provider: ProviderOpenRouter, DefaultMaxTokens: 8192})
+	require.True(t, present)
+	require.Equal(t, 256, value, "client value wins over defaults")
+
+	unset := `{"model":"m","messages":[{"role":"user","content":"hi"}]}`
+	for _, provider := range []string{ProviderOpenAI, ProviderVLLM} {
+		value, present = transformMaxTokens(t, unset, RequestOptions{Provider: provider, DefaultMaxTokens: 8192})
+		require.True(t, present)
+		require.Equal(t, 8192, value, "account default overrides provider default for %s", provider)
+	}
+}
@@ -0,0 +1,408 @@
+// Package openaicompattest 提供验证 Claude ↔ OpenAI 转换往返一致性的测试工具，
+// 扩展 openaicompat 转换器时可用于确认消息、工具、工具结果、system 等语义字段未在转换中丢失
+package openaicompattest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/openaicompat"
+	"github.com/stretchr/testify/require"
+)
+
+// Conversation 请求的语义视图，Claude 与 OpenAI 两侧各自归一化后进行比较
+// 相邻的同角色消息会合并为一个 Turn（OpenAI 侧 tool 消息归入 user）
+type Conversation struct {
+	System           string
+	ReasoningEnabled bool
+	Turns            []Turn
+	Tools            []ToolDef
+}
+
+// Turn 单轮消息：文本按顺序拼接，图片以 data URL 表示
+type Turn struct {
+	Role        string
+	Text        string
+	Reasoning   string
+	Images      []string
+	ToolCalls   []ToolCall
+	ToolResults []ToolResult
+}
+
+// ToolCall assistant 发起的工具调用
+type ToolCall struct {
+	ID    string
+	Name  string
+	Input any
+}
+
+// ToolResult user 返回的工具结果
+type ToolResult struct {
+	ToolUseID string
+	Content   string
+}
+
+// ToolDef 工具定义
+type ToolDef struct {
+	Name        string
+	Description string
+	Schema      any
+}
+
+// RoundTrip 往返转换的中间产物，供调用方做额外断言
+type RoundTrip struct {
+	Request  *openaicompat.ChatRequest
+	Response *antigravity.ClaudeResponse
+}
+
+// AssertRoundTrip 使用默认转换选项将 Claude 请求转换为 OpenAI 请求，经模拟的 identity 上游
+// （回显最后一条 assistant 消息）生成响应后再转换回 Claude 响应，断言语义字段在两个方向上均未丢失
+func AssertRoundTrip(t testing.TB, claudeJSON string) *RoundTrip {
+	t.Helper()
+
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq), "parse claude request")
+
+	body, _, err := openaicompat.TransformClaudeToOpenAI(&claudeReq)
+	require.NoError(t, err, "transform claude request")
+	var chatReq openaicompat.ChatRequest
+	require.NoError(t, json.Unmarshal(body, &chatReq), "parse openai request")
+
+	want, err := FromClaude(&claudeReq)
+	require.NoError(t, err, "normalize claude request")
+	got := FromOpenAI(&chatReq)
+	// 空的 tool_result 会被替换为占位文本，此时不比较内容
+	for i := range want.Turns {
+		for j, result := range want.Turns[i].ToolResults {
+			if result.Content == "" && i < len(got.Turns) && j < len(got.Turns[i].ToolResults) {
+				got.Turns[i].ToolResults[j].Content = ""
+			}
+		}
+	}
+	require.Equal(t, want, got, "claude → openai request lost semantic fields")
+
+	respBody, err := json.Marshal(IdentityResponse(&chatReq))
+	require.NoError(t, err)
+	claudeRespBody, _, err := openaicompat.TransformOpenAIToClaude(respBody, claudeReq.Model)
+	require.NoError(t, err, "transform openai response")
+	var claudeResp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(claudeRespBody, &claudeResp), "parse claude response")
+
+	require.Equal(t, echoedTurn(want), responseTurn(&claudeResp), "openai → claude response lost semantic fields")
+
+	return &RoundTrip{Request: &chatReq, Response: &claudeResp}
+}
+
+// IdentityResponse 模拟 identity 上游：以最后一条 assistant 消息（文本、reasoning、tool_calls）作为回复；
+// 没有 assistant 消息时回显最后一条 user 消息的文本
+func IdentityResponse(req *openaicompat.ChatRequest) *openaicompat.ChatResponse {
+	msg := openaicompat.ChatMessage{Role: "assistant"}
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		m := req.Messages[i]
+		if m.Role == "assistant" {
+			msg.Content = m.Content
+			msg.ToolCalls = m.ToolCalls
+			if m.ThinkingField != nil {
+				msg.ReasoningContent = m.ThinkingField.Content
+			}
+			break
+		}
+		if m.Role == "user" {
+			text, _ := messageText(m.Content)
+			msg.Content, _ = json.Marshal(text)
+			break
+		}
+	}
+
+	finishReason := "stop"
+	if len(msg.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+	return &openaicompat.ChatResponse{
+		ID:      "chatcmpl-identity",
+		Object:  "chat.completion",
+		Model:   req.Model,
+		Choices: []openaicompat.ChatChoice{{Message: msg, FinishReason: finishReason}},
+	}
+}
+
+// FromClaude 将 Claude 请求归一化为语义视图
+// 仅 base64 图片参与比较；thinking 块只在 assistant 消息中保留
+func FromClaude(req *antigravity.ClaudeRequest) (*Conversation, error) {
+	conv := &Conversation{
+		ReasoningEnabled: req.Thinking != nil && (req.Thinking.Type == "enabled" || req.Thinking.Type == "adaptive"),
+	}
+
+	system, err := claudeSystemText(req.System)
+	if err != nil {
+		return nil, err
+	}
+	var systemParts []string
+	if system != "" {
+		systemParts = append(systemParts, system)
+	}
+	if req.Metadata != nil {
+		for _, directive := range req.Metadata.SystemDirectives {
+			if strings.TrimSpace(directive) != "" {
+				systemParts = append(systemParts, directive)
+			}
+		}
+	}
+	conv.System = strings.Join(systemParts, "\n\n")
+
+	for i, msg := range req.Messages {
+		turn, err := claudeTurn(msg)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		conv.Turns = appendTurn(conv.Turns, turn)
+	}
+
+	for _, tool := range req.Tools {
+		if strings.HasPrefix(tool.Type, "web_search") || strings.TrimSpace(tool.Name) == "" {
+			continue // web_search 工具不作为 function 下发
+		}
+		def := ToolDef{Name: tool.Name, Description: tool.Description, Schema: normalizeJSON(tool.InputSchema)}
+		if tool.Custom != nil {
+			def.Description = tool.Custom.Description
+			def.Schema = normalizeJSON(tool.Custom.InputSchema)
+		}
+		if def.Schema == nil {
+			def.Schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		conv.Tools = append(conv.Tools, def)
+	}
+	return conv, nil
+}
+
+// FromOpenAI 将 OpenAI 请求归一化为语义视图
+func FromOpenAI(req *openaicompat.ChatRequest) *Conversation {
+	conv := &Conversation{ReasoningEnabled: req.Reasoning != nil}
+	for _, msg := range req.Messages {
+		text, images := messageText(msg.Content)
+		switch msg.Role {
+		case "system":
+			conv.System = text
+		case "tool":
+			conv.Turns = appendTurn(conv.Turns, Turn{
+				Role:        "user",
+				ToolResults: []ToolResult{{ToolUseID: msg.ToolCallID, Content: text}},
+			})
+		default:
+			turn := Turn{Role: msg.Role, Text: text, Images: images}
+			if msg.ThinkingField != nil {
+				turn.Reasoning = msg.ThinkingField.Content
+			}
+			for _, tc := range msg.ToolCalls {
+				turn.ToolCalls = append(turn.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Input: decodeArguments(tc.Function.Arguments)})
+			}
+			conv.Turns = appendTurn(conv.Turns, turn)
+		}
+	}
+	for _, tool := range req.Tools {
+		conv.Tools = append(conv.Tools, ToolDef{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Schema:      normalizeJSON(tool.Function.Parameters),
+		})
+	}
+	return conv
+}
+
+// claudeTurn 归一化单条 Claude 消息
+func claudeTurn(msg antigravity.ClaudeMessage) (Turn, error) {
+	turn := Turn{Role: msg.Role}
+
+	var text string
+	if err := json.Unmarshal(msg.Content, &text); err == nil {
+		turn.Text = text
+		return turn, nil
+	}
+
+	var blocks []antigravity.ContentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return turn, fmt.Errorf("parse content: %w", err)
+	}
+
+	var reasoning []string
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			turn.Text += block.Text
+		case "image":
+			if block.Source != nil && block.Source.Type == "base64" {
+				turn.Images = append(turn.Images, fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data))
+			}
+		case "thinking":
+			if msg.Role == "assistant" && block.Thinking != "" {
+				reasoning = append(reasoning, block.Thinking)
+			}
+		case "tool_use":
+			turn.ToolCalls = append(turn.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Input: normalizeJSON(block.Input)})
+		case "tool_result":
+			turn.ToolResults = append(turn.ToolResults, ToolResult{ToolUseID: block.ToolUseID, Content: toolResultText(block.Content)})
+		}
+	}
+	turn.Reasoning = strings.Join(reasoning, "\n")
+	return turn, nil
+}
+
+// echoedTurn identity 上游回显的内容：最后一个 assistant 轮次，没有时为最后一个 user 轮次的文本
+func echoedTurn(conv *Conversation) Turn {
+	for i := len(conv.Turns) - 1; i >= 0; i-- {
+		turn := conv.Turns[i]
+		if turn.Role == "assistant" {
+			return Turn{Role: "assistant", Text: turn.Text, Reasoning: turn.Reasoning, ToolCalls: turn.ToolCalls}
+		}
+		if turn.Role == "user" {
+			return Turn{Role: "assistant", Text: turn.Text}
+		}
+	}
+	return Turn{Role: "assistant"}
+}
+
+// responseTurn 归一化 Claude 响应内容块
+func responseTurn(resp *antigravity.ClaudeResponse) Turn {
+	turn := Turn{Role: resp.Role}
+	for _, item := range resp.Content {
+		switch item.Type {
+		case "text":
+			turn.Text += item.Text
+		case "thinking":
+			turn.Reasoning = item.Thinking
+		case "tool_use":
+			turn.ToolCalls = append(turn.ToolCalls, ToolCall{ID: item.ID, Name: item.Name, Input: normalizeJSON(item.Input)})
+		}
+	}
+	return turn
+}
+
+// appendTurn 追加轮次，与上一轮同角色时合并
+func appendTurn(turns []Turn, turn Turn) []Turn {
+	if n := len(turns); n > 0 && turns[n-1].Role == turn.Role {
+		last := &turns[n-1]
+		last.Text += turn.Text
+		last.Images = append(last.Images, turn.Images...)
+		last.ToolCalls = append(last.ToolCalls, turn.ToolCalls...)
+		last.ToolResults = append(last.ToolResults, turn.ToolResults...)
+		if turn.Reasoning != "" {
+			if last.Reasoning != "" {
+				last.Reasoning += "\n"
+			}
+			last.Reasoning += turn.Reasoning
+		}
+		return turns
+	}
+	return append(turns, turn)
+}
+
+// messageText 提取 OpenAI 消息内容中的文本与图片 URL（content 可为字符串或 content parts）
+func messageText(content json.RawMessage) (string, []string) {
+	if len(content) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil
+	}
+	var parts []openaicompat.ContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return string(content), nil
+	}
+	var images []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			text += part.Text
+		case "image_url":
+			if part.ImageURL != nil {
+				images = append(images, part.ImageURL.URL)
+			}
+		}
+	}
+	return text, images
+}
+
+// claudeSystemText 提取 Claude system prompt 文本，多个 text 块以空行连接
+func claudeSystemText(system json.RawMessage) (string, error) {
+	if len(system) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(system, &text); err == nil {
+		if strings.TrimSpace(text) == "" {
+			return "", nil
+		}
+		return text, nil
+	}
+	var blocks []antigravity.SystemBlock
+	if err := json.Unmarshal(system, &blocks); err != nil {
+		return "", fmt.Errorf("parse system: %w", err)
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" && strings.TrimSpace(block.Text) != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+// toolResultText 提取 tool_result 内容文本（字符串或 text 块数组，多个块以换行连接）
+func toolResultText(content json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if strings.TrimSpace(text) == "" {
+			return ""
+		}
+		return text
+	}
+	var items []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &items); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, item := range items {
+		if item.Type == "text" {
+			texts = append(texts, item.Text)
+		}
+	}
+	if result := strings.Join(texts, "\n"); strings.TrimSpace(result) != "" {
+		return result
+	}
+	return ""
+}
+
+// decodeArguments 解析 tool call arguments，空字符串视为空对象
+func decodeArguments(args string) any {
+	if strings.TrimSpace(args) == "" {
+		return map[string]any{}
+	}
+	var v any
+	if err := json.Unmarshal([]byte(args), &v); err != nil {
+		return args
+	}
+	return v
+}
+
+// normalizeJSON 经 JSON 编解码统一数值与 map 类型，便于比较
+func normalizeJSON(v any) any {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package openaicompattest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/openaicompat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertRoundTrip_Text(t *testing.T) {
+	rt := AssertRoundTrip(t, `{
+		"model": "claude-sonnet-4-5",
+		"system": [{"type": "text", "text": "You are terse."}, {"type": "text", "text": "Answer in English."}],
+		"messages": [
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": [{"type": "text", "text": "Hello"}, {"type": "text", "text": " there"}]},
+			{"role": "user", "content": [{"type": "text", "text": "how are you?"}]}
+		]
+	}`)
+	require.Equal(t, "how are you?", rt.Response.Content[0].Text)
+}
+
+func TestAssertRoundTrip_Tools(t *testing.T) {
+	rt := AssertRoundTrip(t, `{
+		"model": "claude-sonnet-4-5",
+		"tools": [
+			{"name": "get_weather", "description": "Get weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}},
+			{"type": "custom", "name": "mcp_search", "custom": {"description": "Search", "input_schema": {"type": "object"}}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"messages": [
+			{"role": "user", "content": "weather in Paris and Tokyo?"},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Checking."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}},
+				{"type": "tool_use", "id": "toolu_2", "name": "get_weather", "input": {"city": "Tokyo"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "18C"},
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": [{"type": "text", "text": "22C"}, {"type": "text", "text": "sunny"}]},
+				{"type": "tool_result", "tool_use_id": "toolu_3", "content": ""},
+				{"type": "text", "text": "Summarize."}
+			]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_4", "name": "mcp_search", "input": {"q": "forecast", "limit": 3}}]}
+		]
+	}`)
+	require.Len(t, rt.Request.Tools, 2)
+	require.Equal(t, "tool_use", rt.Response.StopReason)
+}
+
+func TestAssertRoundTrip_Images(t *testing.T) {
+	AssertRoundTrip(t, `{
+		"model": "claude-sonnet-4-5",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in these images?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/jpeg", "data": "/9j/4AAQ"}}
+			]}
+		]
+	}`)
+}
+
+func TestAssertRoundTrip_Reasoning(t *testing.T) {
+	rt := AssertRoundTrip(t, `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 16000,
+		"thinking": {"type": "enabled", "budget_tokens": 8000},
+		"messages": [
+			{"role": "user", "content": "2+2?"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "Simple arithmetic.", "signature": "sig"},
+				{"type": "text", "text": "4"}
+			]}
+		]
+	}`)
+	require.NotNil(t, rt.Request.Reasoning)
+	require.Equal(t, "thinking", rt.Response.Content[0].Type)
+	require.Equal(t, "Simple arithmetic.", rt.Response.Content[0].Thinking)
+}
+
+func TestFromOpenAI_DetectsDroppedToolResult(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "m",
+		"messages": [
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "ls", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "a.txt"}]}
+		]
+	}`), &claudeReq))
+	body, _, err := openaicompat.TransformClaudeToOpenAI(&claudeReq)
+	require.NoError(t, err)
+	var chatReq openaicompat.ChatRequest
+	require.NoError(t, json.Unmarshal(body, &chatReq))
+
+	want, err := FromClaude(&claudeReq)
+	require.NoError(t, err)
+	require.Equal(t, want, FromOpenAI(&chatReq))
+
+	// 模拟转换器丢失 tool 消息
+	chatReq.Messages = chatReq.Messages[:len(chatReq.Messages)-1]
+	require.NotEqual(t, want, FromOpenAI(&chatReq))
+}
@@ -0,0 +1,66 @@
+package openaicompat
+
+import (
+	"log"
+	"strings"
+)
+
+// tool_use_id 在已转换历史中找不到对应 assistant tool_call 的 tool 消息（孤立 tool_result）处理方式
+// 严格校验的上游（如 OpenAI 官方）会以 400 拒绝此类消息
+const (
+	OrphanToolResultKeep = "keep" // 原样保留并记录警告日志（默认）
+	OrphanToolResultDrop = "drop" // 丢弃孤立的 tool 消息
+	OrphanToolResultStub = "stub" // 在其之前补一个调用同 id 的 assistant tool_call 占位，使对话保持合法
+)
+
+// orphanToolStubName 补全的占位 tool_call 使用的函数名（tool_result 不携带工具名）
+const orphanToolStubName = "unknown_tool"
+
+// repairOrphanToolResults 检查 tool 消息的 tool_call_id 是否对应之前 assistant 消息中的 tool_call，
+// 并按 mode（OrphanToolResult* 常量）处理孤立的 tool 消息
+func repairOrphanToolResults(messages []ChatMessage, mode string) []ChatMessage {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	known := make(map[string]struct{})
+	out := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case "assistant":
+			for _, tc := range msg.ToolCalls {
+				known[tc.ID] = struct{}{}
+			}
+		case "tool":
+			if _, ok := known[msg.ToolCallID]; ok {
+				break
+			}
+			log.Printf("[OpenAICompat] tool result %q has no matching prior tool call (mode=%s)", msg.ToolCallID, mode)
+			switch mode {
+			case OrphanToolResultDrop:
+				continue
+			case OrphanToolResultStub:
+				out = appendOrphanToolStub(out, msg.ToolCallID)
+				known[msg.ToolCallID] = struct{}{}
+			}
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// appendOrphanToolStub 为孤立的 tool 消息补充占位 tool_call：
+// 紧邻的连续 tool 消息之前是 assistant 消息时追加到该消息，否则插入一条新的 assistant 消息
+func appendOrphanToolStub(messages []ChatMessage, toolCallID string) []ChatMessage {
+	stub := ToolCall{
+		ID:       toolCallID,
+		Type:     "function",
+		Function: FunctionCall{Name: orphanToolStubName, Arguments: "{}"},
+	}
+	i := len(messages) - 1
+	for i >= 0 && messages[i].Role == "tool" {
+		i--
+	}
+	if i >= 0 && messages[i].Role == "assistant" && (len(messages[i].ToolCalls) > 0 || i == len(messages)-1) {
+		messages[i].ToolCalls = append(messages[i].ToolCalls, stub)
+		return messages
+	}
+	return append(messages, ChatMessage{Role: "assistant", ToolCalls: []ToolCall{stub}})
+}
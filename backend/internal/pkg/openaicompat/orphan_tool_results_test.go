@@ -0,0 +1,83 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// orphanToolResultRequest 历史被截断：首条 user 消息的 tool_result 没有对应的 assistant tool_use
+const orphanToolResultRequest = `{
+	"model": "m",
+	"messages": [
+		{"role": "user", "content": [
+			{"type": "tool_result", "tool_use_id": "toolu_lost", "content": "stale result"},
+			{"type": "text", "text": "continue"}
+		]},
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "read", "input": {}}]},
+		{"role": "user", "content": [
+			{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"},
+			{"type": "tool_result", "tool_use_id": "toolu_2", "content": "orphan"}
+		]}
+	]
+}`
+
+func transformWithOrphanMode(t *testing.T, mode string) []ChatMessage {
+	t.Helper()
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(orphanToolResultRequest), &claudeReq))
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{OrphanToolResultMode: mode})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	return req.Messages
+}
+
+func messageRoles(messages []ChatMessage) []string {
+	roles := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		roles = append(roles, msg.Role)
+	}
+	return roles
+}
+
+func TestRepairOrphanToolResults_Keep(t *testing.T) {
+	messages := transformWithOrphanMode(t, "")
+	require.Equal(t, []string{"tool", "user", "assistant", "tool", "tool"}, messageRoles(messages))
+	require.Equal(t, "toolu_lost", messages[0].ToolCallID)
+}
+
+func TestRepairOrphanToolResults_Drop(t *testing.T) {
+	messages := transformWithOrphanMode(t, OrphanToolResultDrop)
+	require.Equal(t, []string{"user", "assistant", "tool"}, messageRoles(messages))
+	require.Equal(t, "toolu_1", messages[2].ToolCallID)
+}
+
+func TestRepairOrphanToolResults_Stub(t *testing.T) {
+	messages := transformWithOrphanMode(t, OrphanToolResultStub)
+	require.Equal(t, []string{"assistant", "tool", "user", "assistant", "tool", "tool"}, messageRoles(messages))
+
+	// 没有前置 assistant：插入占位 assistant 消息
+	require.Len(t, messages[0].ToolCalls, 1)
+	require.Equal(t, "toolu_lost", messages[0].ToolCalls[0].ID)
+	require.Equal(t, orphanToolStubName, messages[0].ToolCalls[0].Function.Name)
+	require.Equal(t, "{}", messages[0].ToolCalls[0].Function.Arguments)
+
+	// 紧跟在 assistant 的 tool 消息之后：占位 tool_call 追加到该 assistant 消息
+	require.Len(t, messages[3].ToolCalls, 2)
+	require.Equal(t, "toolu_1", messages[3].ToolCalls[0].ID)
+	require.Equal(t, "toolu_2", messages[3].ToolCalls[1].ID)
+
+	// 每个 tool 消息都有对应的 tool_call
+	known := map[string]bool{}
+	for _, msg := range messages {
+		for _, tc := range msg.ToolCalls {
+			known[tc.ID] = true
+		}
+		if msg.Role == "tool" {
+			require.True(t, known[msg.ToolCallID], msg.ToolCallID)
+		}
+	}
+}
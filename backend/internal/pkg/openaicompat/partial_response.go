@@ -0,0 +1,47 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// SalvagePartialChatResponse 尝试将读取中断（如上游中途停滞直至超时）的非流式响应体修复为完整的 Chat Completions 响应：
+// 补齐被截断 JSON 的闭合（见 jsonCompletionSuffix），仍至少含一个 choice 时返回修复后的响应体。
+// 修复后未带 finish_reason 的 choice 标记为 "length"，转换为 Claude 响应时 stop_reason 为 max_tokens，提示客户端内容不完整
+func SalvagePartialChatResponse(body []byte) ([]byte, bool) {
+	repaired, ok := repairTruncatedJSON(body)
+	if !ok {
+		return nil, false
+	}
+	var resp ChatResponse
+	if err := json.Unmarshal(repaired, &resp); err != nil || len(resp.Choices) == 0 {
+		return nil, false
+	}
+	for i := range resp.Choices {
+		if resp.Choices[i].FinishReason == "" {
+			resp.Choices[i].FinishReason = "length"
+		}
+	}
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// repairTruncatedJSON 为被截断的 JSON 补齐闭合；截断在逗号之后时先去掉悬空的逗号
+func repairTruncatedJSON(body []byte) ([]byte, bool) {
+	prefix := string(bytes.TrimSpace(body))
+	if prefix == "" {
+		return nil, false
+	}
+	if json.Valid([]byte(prefix)) {
+		return []byte(prefix), true
+	}
+	for _, candidate := range []string{prefix, string(bytes.TrimRight([]byte(prefix), ", \t\r\n"))} {
+		if repaired := candidate + jsonCompletionSuffix(candidate); json.Valid([]byte(repaired)) {
+			return []byte(repaired), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,39 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalvagePartialChatResponse(t *testing.T) {
+	full := `{"id":"chatcmpl-1","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"Hello wor`
+
+	salvaged, ok := SalvagePartialChatResponse([]byte(full))
+	require.True(t, ok)
+	out, _, err := TransformOpenAIToClaudeWithOptions(salvaged, "claude-model", ResponseOptions{})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "Hello wor", resp.Content[0].Text)
+	require.Equal(t, "max_tokens", resp.StopReason)
+
+	// 截断在逗号之后
+	salvaged, ok = SalvagePartialChatResponse([]byte(`{"id":"c","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},`))
+	require.True(t, ok)
+	require.Contains(t, string(salvaged), `"finish_reason":"length"`)
+
+	// 已有 finish_reason 时保留
+	salvaged, ok = SalvagePartialChatResponse([]byte(`{"id":"c","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tok`))
+	require.True(t, ok)
+	require.Contains(t, string(salvaged), `"finish_reason":"stop"`)
+
+	// 尚未收到任何 choice 或无法修复时放弃
+	for _, body := range []string{``, `{"id":"chatcmpl-1","obj`, `{"id":"c","choices":[`, `<html>`} {
+		_, ok := SalvagePartialChatResponse([]byte(body))
+		require.False(t, ok, body)
+	}
+}
@@ -0,0 +1,30 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// predictionSupported 默认支持 predicted outputs（prediction 字段）的上游，其他上游需账号开启 PredictionPassthrough
+var predictionSupported = map[string]bool{
+	ProviderOpenAI: true,
+}
+
+// applyPrediction 透传客户端的 predicted outputs：上游支持（账号开启或提供方默认支持）时下发 prediction，否则丢弃。
+// 简写的字符串形式改写为 {"type":"content","content":"..."}，其他形式原样下发
+func applyPrediction(req *ChatRequest, claudeReq *antigravity.ClaudeRequest, opts RequestOptions) {
+	if len(claudeReq.Prediction) == 0 || string(claudeReq.Prediction) == "null" {
+		return
+	}
+	if !opts.PredictionPassthrough && !predictionSupported[strings.ToLower(strings.TrimSpace(opts.Provider))] {
+		return
+	}
+	var content string
+	if err := json.Unmarshal(claudeReq.Prediction, &content); err == nil {
+		req.Prediction = map[string]any{"type": "content", "content": content}
+		return
+	}
+	req.Prediction = claudeReq.Prediction
+}
@@ -0,0 +1,76 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_Prediction(t *testing.T) {
+	const object = `{"type":"content","content":"func main() {}"}`
+	tests := []struct {
+		name       string
+		prediction string
+		opts       RequestOptions
+		want       string // 期望下发的 prediction，空表示不下发
+	}{
+		{"openai passthrough", object, RequestOptions{Provider: ProviderOpenAI}, object},
+		{"account enabled", object, RequestOptions{PredictionPassthrough: true}, object},
+		{"string shorthand", `"func main() {}"`, RequestOptions{PredictionPassthrough: true}, object},
+		{"unsupported upstream", object, RequestOptions{Provider: ProviderVLLM}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"fix it"}],"prediction":`+tt.prediction+`}`), &claudeReq))
+			body, warnings, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+			var req map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(body, &req))
+			if tt.want == "" {
+				require.NotContains(t, req, "prediction")
+				require.Equal(t, []string{WarningPredictionDropped}, WarningCodes(warnings))
+				return
+			}
+			require.JSONEq(t, tt.want, string(req["prediction"]))
+			require.Empty(t, warnings)
+		})
+	}
+}
+
+func TestTransformOpenAIToClaude_PredictionTokens(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":40,"total_tokens":140,"completion_tokens_details":{"accepted_prediction_tokens":30,"rejected_prediction_tokens":8}}}`)
+	out, usage, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.Equal(t, 30, usage.AcceptedPredictionTokens)
+	require.Equal(t, 8, usage.RejectedPredictionTokens)
+	require.Contains(t, string(out), `"accepted_prediction_tokens":30`)
+	require.Contains(t, string(out), `"rejected_prediction_tokens":8`)
+
+	// 未使用 prediction 时不输出这两个字段
+	body = []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":4,"total_tokens":104}}`)
+	out, _, err = TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "prediction_tokens")
+}
+
+func TestStreamingProcessor_PredictionTokens(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":100,"completion_tokens":40,"total_tokens":140,"completion_tokens_details":{"accepted_prediction_tokens":30,"rejected_prediction_tokens":8}}}`,
+		`data: [DONE]`,
+	)
+	for _, ev := range events {
+		if ev.Event != "message_delta" {
+			continue
+		}
+		usage, _ := ev.Data["usage"].(map[string]any)
+		require.Equal(t, float64(30), usage["accepted_prediction_tokens"])
+		require.Equal(t, float64(8), usage["rejected_prediction_tokens"])
+		return
+	}
+	t.Fatal("message_delta not found")
+}
@@ -0,0 +1,178 @@
+package openaicompat
+
+import (
+	"log"
+	"strings"
+)
+
+// thinking budget → reasoning 的转换方式
+const (
+	ReasoningModeAuto   = ""       // 上游支持 reasoning.max_tokens 时传递预算，否则映射为 effort（默认）
+	ReasoningModeEffort = "effort" // 始终按阈值映射为 reasoning.effort
+	ReasoningModeBudget = "budget" // 始终以 reasoning.max_tokens 直接传递 budget_tokens
+)
+
+// 默认的 budget → effort 映射阈值
+const (
+	defaultReasoningEffortMinimalMax = 1024
+	defaultReasoningEffortLowMax     = 4096
+	defaultReasoningEffortMediumMax  = 16384
+)
+
+// ReasoningEffortThresholds budget → effort 映射阈值：budget ≤ LowMax 为 low，≤ MediumMax 为 medium，否则为 high；
+// 模型支持 minimal 时 budget ≤ MinimalMax 为 minimal。字段为 0 时使用默认值（1024 / 4096 / 16384）
+type ReasoningEffortThresholds struct {
+	MinimalMax int
+	LowMax     int
+	MediumMax  int
+}
+
+// supportsMinimalReasoningEffort 判断模型是否支持 reasoning.effort=minimal（OpenAI gpt-5 系列），
+// 其他上游只接受 low/medium/high，发送 minimal 会被拒绝
+func supportsMinimalReasoningEffort(model string) bool {
+	name := strings.ToLower(strings.TrimSpace(model))
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:] // 去掉 "openai/" 等提供方前缀
+	}
+	return strings.HasPrefix(name, "gpt-5")
+}
+
+// isReasoningOnly 判断目标模型是否只能以推理模式运行，override 非 nil 时以其为准
+func isReasoningOnly(model string, override *bool) bool {
+	if override != nil {
+		return *override
+	}
+	return isReasoningOnlyModel(model)
+}
+
+// isReasoningOnlyModel 按模型名判断是否为无法关闭推理的模型（OpenAI o 系列与 gpt-5 推理模型）
+func isReasoningOnlyModel(model string) bool {
+	name := strings.ToLower(strings.TrimSpace(model))
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if strings.HasPrefix(name, "gpt-5") {
+		return !strings.Contains(name, "-chat")
+	}
+	for _, prefix := range []string{"o1", "o3", "o4"} {
+		if name == prefix || strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// lowestReasoningEffort 返回模型允许的最低 reasoning effort
+func lowestReasoningEffort(model string) string {
+	if supportsMinimalReasoningEffort(model) {
+		return "minimal"
+	}
+	return "low"
+}
+
+// AdaptiveReasoningEffortAuto adaptive thinking 按输入大小选择 effort
+const AdaptiveReasoningEffortAuto = "auto"
+
+// adaptive thinking 自动选择 effort 的输入大小阈值（消息内容字节数）
+const (
+	adaptiveLowMaxBytes    = 2000  // 约 500 tokens 以内的短问题使用 low
+	adaptiveMediumMaxBytes = 40000 // 约 10k tokens 以内使用 medium，更长的上下文使用 high
+)
+
+// adaptiveReasoningEffort 返回 adaptive thinking 使用的 effort：configured 为 low/medium/high 时直接使用，
+// 否则按转换后消息内容的总字节数选择（≤2000 为 low，≤40000 为 medium，其余为 high）
+func adaptiveReasoningEffort(messages []ChatMessage, configured string) string {
+	switch effort := strings.ToLower(strings.TrimSpace(configured)); effort {
+	case "low", "medium", "high":
+		return effort
+	}
+	size := 0
+	for _, msg := range messages {
+		size += len(msg.Content)
+		for _, tc := range msg.ToolCalls {
+			size += len(tc.Function.Arguments)
+		}
+	}
+	switch {
+	case size <= adaptiveLowMaxBytes:
+		return "low"
+	case size <= adaptiveMediumMaxBytes:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// reasoningBudgetLimit 上游接受的 reasoning.max_tokens 取值范围
+type reasoningBudgetLimit struct {
+	Min int
+	Max int
+}
+
+// reasoningBudgetLimits 支持 reasoning.max_tokens 的上游及其预算范围
+// 未列出的上游不支持按 token 指定推理预算，仍按 budget_tokens 映射为 effort
+var reasoningBudgetLimits = map[string]reasoningBudgetLimit{
+	ProviderOpenRouter: {Min: 1024, Max: 32000},
+}
+
+// buildReasoningConfig 将 Claude thinking budget 转换为 OpenAI reasoning 配置
+// 上游支持 reasoning.max_tokens 时按其范围钳制预算（并保证小于请求的 max_tokens），否则映射为 effort；
+// opts.ReasoningMode 可强制使用 effort 或 budget 方式
+func buildReasoningConfig(model string, budgetTokens, maxTokens int, opts RequestOptions) *ReasoningConfig {
+	provider := opts.Provider
+	mode := strings.ToLower(strings.TrimSpace(opts.ReasoningMode))
+	limit, ok := reasoningBudgetLimits[strings.ToLower(strings.TrimSpace(provider))]
+	if mode == ReasoningModeEffort || budgetTokens <= 0 || (!ok && mode != ReasoningModeBudget) {
+		return &ReasoningConfig{Effort: reasoningEffortForBudget(budgetTokens, opts.ReasoningEffortThresholds, supportsMinimalReasoningEffort(model))}
+	}
+	if !ok {
+		// 未知范围的上游按原值透传
+		return &ReasoningConfig{MaxTokens: budgetTokens}
+	}
+
+	budget := clampReasoningBudget(budgetTokens, maxTokens, limit)
+	if budget != budgetTokens {
+		log.Printf("[OpenAICompat] Clamped reasoning.max_tokens from %d to %d for provider %s (allowed %d-%d, max_tokens=%d)",
+			budgetTokens, budget, provider, limit.Min, limit.Max, maxTokens)
+	}
+	return &ReasoningConfig{MaxTokens: budget}
+}
+
+// clampReasoningBudget 将推理预算限制在上游范围内；请求指定了 max_tokens 时预算不超过 max_tokens-1
+func clampReasoningBudget(budgetTokens, maxTokens int, limit reasoningBudgetLimit) int {
+	budget := budgetTokens
+	if budget > limit.Max {
+		budget = limit.Max
+	}
+	if maxTokens > 0 && budget >= maxTokens {
+		budget = maxTokens - 1
+	}
+	if budget < limit.Min {
+		budget = limit.Min
+	}
+	return budget
+}
+
+// reasoningEffortForBudget 按 thinking budget 及阈值映射 reasoning effort，allowMinimal 为 false 时最低为 low
+func reasoningEffortForBudget(budgetTokens int, thresholds ReasoningEffortThresholds, allowMinimal bool) string {
+	minimalMax, lowMax, mediumMax := thresholds.MinimalMax, thresholds.LowMax, thresholds.MediumMax
+	if minimalMax <= 0 {
+		minimalMax = defaultReasoningEffortMinimalMax
+	}
+	if lowMax <= 0 {
+		lowMax = defaultReasoningEffortLowMax
+	}
+	if mediumMax <= 0 {
+		mediumMax = defaultReasoningEffortMediumMax
+	}
+	switch {
+	case allowMinimal && budgetTokens > 0 && budgetTokens <= minimalMax:
+		return "minimal"
+	case budgetTokens > 0 && budgetTokens <= lowMax:
+		return "low"
+	case budgetTokens > lowMax && budgetTokens <= mediumMax:
+		return "medium"
+	default:
+		return "high"
+	}
+}
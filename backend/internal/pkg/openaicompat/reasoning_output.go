@@ -0,0 +1,46 @@
+package openaicompat
+
+import "strings"
+
+// 上游 reasoning 输出给客户端的方式（ResponseOptions.ReasoningOutput）
+// 部分上游只返回推理摘要（OpenAI Responses API、OpenRouter 的 reasoning.summary），摘要价值较低时可只计费不展示；
+// 完全不返回 thinking 块使用 HideThinking
+const (
+	ReasoningOutputFull            = "full"             // 摘要与完整推理均作为 thinking 块返回（默认）
+	ReasoningOutputSuppressSummary = "suppress_summary" // 丢弃推理摘要，仅返回完整推理
+)
+
+// reasoning_details 中的推理类型
+const (
+	reasoningDetailText    = "reasoning.text"
+	reasoningDetailSummary = "reasoning.summary"
+)
+
+// visibleReasoning 按 ReasoningOutput 返回应作为 thinking 输出的推理文本：
+// suppress_summary 模式下 reasoning_details 含摘要时，仅保留其中的完整推理（reasoning.text）；
+// 其余情况（含未返回 reasoning_details、无法区分摘要的上游）原样返回 reasoning
+func (o ResponseOptions) visibleReasoning(reasoning string, details []ReasoningDetail) string {
+	if o.HideThinking {
+		return ""
+	}
+	if o.ReasoningOutput != ReasoningOutputSuppressSummary || !hasReasoningSummary(details) {
+		return reasoning
+	}
+	var full strings.Builder
+	for _, d := range details {
+		if d.Type == reasoningDetailText {
+			full.WriteString(d.Text)
+		}
+	}
+	return full.String()
+}
+
+// hasReasoningSummary 判断 reasoning_details 是否包含推理摘要
+func hasReasoningSummary(details []ReasoningDetail) bool {
+	for _, d := range details {
+		if d.Type == reasoningDetailSummary {
+			return true
+		}
+	}
+	return false
+}
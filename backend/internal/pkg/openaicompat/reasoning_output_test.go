@@ -0,0 +1,95 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformOpenAIToClaude_ReasoningOutput(t *testing.T) {
+	// OpenRouter 风格：reasoning 为摘要与完整推理拼接，reasoning_details 区分两者
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","reasoning":"short summary\n\nfull chain","reasoning_details":[{"type":"reasoning.summary","summary":"short summary"},{"type":"reasoning.text","text":"full chain"}],"content":"answer"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":50,"total_tokens":60}}`)
+	summaryOnly := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","reasoning":"short summary","reasoning_details":[{"type":"reasoning.summary","summary":"short summary"}],"content":"answer"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":50,"total_tokens":60}}`)
+
+	transform := func(t *testing.T, body []byte, mode string) antigravity.ClaudeResponse {
+		t.Helper()
+		out, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{ReasoningOutput: mode})
+		require.NoError(t, err)
+		// 无论是否输出 thinking，reasoning tokens 都计入 output_tokens
+		require.Equal(t, 50, usage.OutputTokens)
+		var resp antigravity.ClaudeResponse
+		require.NoError(t, json.Unmarshal(out, &resp))
+		return resp
+	}
+
+	t.Run("full", func(t *testing.T) {
+		for _, mode := range []string{"", ReasoningOutputFull} {
+			resp := transform(t, body, mode)
+			require.Len(t, resp.Content, 2)
+			require.Equal(t, "thinking", resp.Content[0].Type)
+			require.Equal(t, "short summary\n\nfull chain", resp.Content[0].Thinking)
+		}
+	})
+
+	t.Run("suppress_summary", func(t *testing.T) {
+		resp := transform(t, body, ReasoningOutputSuppressSummary)
+		require.Len(t, resp.Content, 2)
+		require.Equal(t, "thinking", resp.Content[0].Type)
+		require.Equal(t, "full chain", resp.Content[0].Thinking)
+
+		resp = transform(t, summaryOnly, ReasoningOutputSuppressSummary)
+		require.Len(t, resp.Content, 1)
+		require.Equal(t, "text", resp.Content[0].Type)
+		require.Equal(t, "answer", resp.Content[0].Text)
+	})
+}
+
+func TestResponsesToChatResponse_SuppressSummary(t *testing.T) {
+	body := []byte(`{"id":"resp_1","object":"response","status":"completed","model":"m","output":[{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"brief"}]},{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"answer"}]}],"usage":{"input_tokens":10,"output_tokens":20,"total_tokens":30}}`)
+
+	chatBody, err := ResponsesToChatResponse(body)
+	require.NoError(t, err)
+
+	out, _, err := TransformOpenAIToClaudeWithOptions(chatBody, "claude-model", ResponseOptions{ReasoningOutput: ReasoningOutputFull})
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"thinking":"brief"`)
+
+	out, _, err = TransformOpenAIToClaudeWithOptions(chatBody, "claude-model", ResponseOptions{ReasoningOutput: ReasoningOutputSuppressSummary})
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "brief")
+	require.Contains(t, string(out), "answer")
+}
+
+func TestStreamingProcessor_ReasoningOutput(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","reasoning":"summary","reasoning_details":[{"type":"reasoning.summary","summary":"summary"}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"reasoning":"full","reasoning_details":[{"type":"reasoning.text","text":"full"}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"answer"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}
+
+	thinkingText := func(events []sseEvent) string {
+		var text string
+		for _, ev := range events {
+			if ev.Event != "content_block_delta" {
+				continue
+			}
+			delta, _ := ev.Data["delta"].(map[string]any)
+			if delta["type"] == "thinking_delta" {
+				text += delta["thinking"].(string)
+			}
+		}
+		return text
+	}
+
+	full := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{ReasoningOutput: ReasoningOutputFull}), lines...)
+	require.Equal(t, []string{"thinking", "text"}, blockStartTypes(full))
+	require.Equal(t, "summaryfull", thinkingText(full))
+
+	summarySuppressed := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{ReasoningOutput: ReasoningOutputSuppressSummary}), lines...)
+	require.Equal(t, []string{"thinking", "text"}, blockStartTypes(summarySuppressed))
+	require.Equal(t, "full", thinkingText(summarySuppressed))
+}
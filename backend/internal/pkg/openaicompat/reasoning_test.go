@@ -0,0 +1,198 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_ReasoningBudgetClamping(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  string
+		budget    int
+		maxTokens int
+		want      ReasoningConfig
+	}{
+		{name: "generic maps to effort", provider: ProviderGeneric, budget: 8000, maxTokens: 16000, want: ReasoningConfig{Effort: "medium"}},
+		{name: "openai maps to effort", provider: ProviderOpenAI, budget: 64000, maxTokens: 80000, want: ReasoningConfig{Effort: "high"}},
+		{name: "openrouter within range", provider: ProviderOpenRouter, budget: 8000, maxTokens: 16000, want: ReasoningConfig{MaxTokens: 8000}},
+		{name: "openrouter clamps to max", provider: ProviderOpenRouter, budget: 64000, maxTokens: 80000, want: ReasoningConfig{MaxTokens: 32000}},
+		{name: "openrouter clamps to min", provider: ProviderOpenRouter, budget: 500, maxTokens: 4096, want: ReasoningConfig{MaxTokens: 1024}},
+		{name: "openrouter stays below max_tokens", provider: ProviderOpenRouter, budget: 10000, maxTokens: 8192, want: ReasoningConfig{MaxTokens: 8191}},
+		{name: "openrouter without budget", provider: ProviderOpenRouter, budget: 0, maxTokens: 8192, want: ReasoningConfig{Effort: "high"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claudeReq := antigravity.ClaudeRequest{
+				Model:     "m",
+				MaxTokens: tt.maxTokens,
+				Messages:  []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
+				Thinking:  &antigravity.ThinkingConfig{Type: "enabled", BudgetTokens: tt.budget},
+			}
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: tt.provider})
+			require.NoError(t, err)
+
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.NotNil(t, req.Reasoning)
+			require.Equal(t, tt.want, *req.Reasoning)
+		})
+	}
+}
+
+func TestTransformClaudeToOpenAI_ReasoningModeAndThresholds(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   RequestOptions
+		budget int
+		want   ReasoningConfig
+	}{
+		{name: "default thresholds low", budget: 4096, want: ReasoningConfig{Effort: "low"}},
+		{name: "default thresholds medium", budget: 16384, want: ReasoningConfig{Effort: "medium"}},
+		{name: "default thresholds high", budget: 16385, want: ReasoningConfig{Effort: "high"}},
+		{name: "custom thresholds low", opts: RequestOptions{ReasoningEffortThresholds: ReasoningEffortThresholds{LowMax: 8000, MediumMax: 12000}}, budget: 8000, want: ReasoningConfig{Effort: "low"}},
+		{name: "custom thresholds high", opts: RequestOptions{ReasoningEffortThresholds: ReasoningEffortThresholds{LowMax: 8000, MediumMax: 12000}}, budget: 12001, want: ReasoningConfig{Effort: "high"}},
+		{name: "partial thresholds keep default", opts: RequestOptions{ReasoningEffortThresholds: ReasoningEffortThresholds{LowMax: 1000}}, budget: 16000, want: ReasoningConfig{Effort: "medium"}},
+		{name: "budget mode generic passes through", opts: RequestOptions{ReasoningMode: ReasoningModeBudget}, budget: 50000, want: ReasoningConfig{MaxTokens: 50000}},
+		{name: "budget mode openrouter clamps", opts: RequestOptions{ReasoningMode: ReasoningModeBudget, Provider: ProviderOpenRouter}, budget: 50000, want: ReasoningConfig{MaxTokens: 32000}},
+		{name: "effort mode overrides openrouter", opts: RequestOptions{ReasoningMode: ReasoningModeEffort, Provider: ProviderOpenRouter}, budget: 2000, want: ReasoningConfig{Effort: "low"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claudeReq := antigravity.ClaudeRequest{
+				Model:     "m",
+				MaxTokens: 100000,
+				Messages:  []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
+				Thinking:  &antigravity.ThinkingConfig{Type: "enabled", BudgetTokens: tt.budget},
+			}
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.NotNil(t, req.Reasoning)
+			require.Equal(t, tt.want, *req.Reasoning)
+		})
+	}
+}
+
+func TestTransformClaudeToOpenAI_MinimalReasoningEffort(t *testing.T) {
+	tests := []struct {
+		model  string
+		budget int
+		opts   RequestOptions
+		want   string
+	}{
+		{model: "gpt-5", budget: 1024, want: "minimal"},
+		{model: "openai/gpt-5-mini", budget: 512, want: "minimal"},
+		{model: "gpt-5", budget: 1025, want: "low"},
+		{model: "gpt-5", budget: 2000, opts: RequestOptions{ReasoningEffortThresholds: ReasoningEffortThresholds{MinimalMax: 2048}}, want: "minimal"},
+		// 不支持 minimal 的模型最低为 low
+		{model: "o3-mini", budget: 1024, want: "low"},
+		{model: "deepseek-reasoner", budget: 512, want: "low"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			claudeReq := antigravity.ClaudeRequest{
+				Model:     tt.model,
+				MaxTokens: 8192,
+				Messages:  []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
+				Thinking:  &antigravity.ThinkingConfig{Type: "enabled", BudgetTokens: tt.budget},
+			}
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.NotNil(t, req.Reasoning)
+			require.Equal(t, tt.want, req.Reasoning.Effort)
+		})
+	}
+}
+
+func TestTransformClaudeToOpenAI_AdaptiveReasoningEffort(t *testing.T) {
+	newReq := func(prompt string) *antigravity.ClaudeRequest {
+		content, _ := json.Marshal(prompt)
+		return &antigravity.ClaudeRequest{
+			Model:    "m",
+			Messages: []antigravity.ClaudeMessage{{Role: "user", Content: content}},
+			Thinking: &antigravity.ThinkingConfig{Type: "adaptive"},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		prompt     string
+		configured string
+		want       string
+	}{
+		{name: "auto short prompt", prompt: "hi", want: "low"},
+		{name: "auto medium prompt", prompt: strings.Repeat("x", 10000), want: "medium"},
+		{name: "auto long prompt", prompt: strings.Repeat("x", 50000), configured: AdaptiveReasoningEffortAuto, want: "high"},
+		{name: "configured default", prompt: "hi", configured: "high", want: "high"},
+		{name: "configured medium", prompt: strings.Repeat("x", 50000), configured: "Medium", want: "medium"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _, err := TransformClaudeToOpenAIWithOptions(newReq(tt.prompt), RequestOptions{AdaptiveReasoningEffort: tt.configured})
+			require.NoError(t, err)
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.Equal(t, &ReasoningConfig{Effort: tt.want}, req.Reasoning)
+		})
+	}
+
+	// adaptive 指定了 budget 时仍按 budget 处理
+	claudeReq := newReq("hi")
+	claudeReq.Thinking.BudgetTokens = 20000
+	body, _, err := TransformClaudeToOpenAIWithOptions(claudeReq, RequestOptions{})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Equal(t, &ReasoningConfig{Effort: "high"}, req.Reasoning)
+}
+
+func TestTransformClaudeToOpenAI_ThinkingDisabled(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name     string
+		model    string
+		thinking *antigravity.ThinkingConfig
+		override *bool
+		want     *ReasoningConfig
+	}{
+		{name: "disabled regular model", model: "gpt-4o", thinking: &antigravity.ThinkingConfig{Type: "disabled"}},
+		{name: "omitted regular model", model: "deepseek-chat"},
+		{name: "disabled gpt-5", model: "gpt-5", thinking: &antigravity.ThinkingConfig{Type: "disabled"}, want: &ReasoningConfig{Effort: "minimal"}},
+		{name: "omitted o3", model: "openai/o3-mini", want: &ReasoningConfig{Effort: "low"}},
+		{name: "gpt-5 chat is not reasoning only", model: "gpt-5-chat-latest", thinking: &antigravity.ThinkingConfig{Type: "disabled"}},
+		{name: "override forces reasoning", model: "qwq-32b", thinking: &antigravity.ThinkingConfig{Type: "disabled"}, override: &enabled, want: &ReasoningConfig{Effort: "low"}},
+		{name: "override suppresses reasoning", model: "o1", override: &disabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claudeReq := antigravity.ClaudeRequest{
+				Model:    tt.model,
+				Messages: []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
+				Thinking: tt.thinking,
+			}
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{ReasoningOnly: tt.override})
+			require.NoError(t, err)
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.Equal(t, tt.want, req.Reasoning)
+			if tt.want == nil {
+				require.NotContains(t, string(body), `"reasoning"`)
+			}
+		})
+	}
+}
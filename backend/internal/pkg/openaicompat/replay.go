@@ -0,0 +1,35 @@
+package openaicompat
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// ReplayStream 将一段完整的 OpenAI SSE 流逐行送入 StreamingProcessor，返回转换后的 Claude SSE 输出与用量
+// 不涉及网络与客户端写入，便于对流式转换做离线回放、基准测试与 golden 输出比对
+func ReplayStream(r io.Reader, originalModel string, opts ResponseOptions) ([]byte, antigravity.ClaudeUsage, error) {
+	processor := NewStreamingProcessorWithOptions(originalModel, opts)
+	reader := bufio.NewReaderSize(r, 64*1024)
+
+	var out bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			out.Write(processor.ProcessLine(strings.TrimRight(line, "\r\n")))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, antigravity.ClaudeUsage{}, err
+		}
+	}
+
+	final, usage := processor.Finish()
+	out.Write(final)
+	return out.Bytes(), *usage, nil
+}
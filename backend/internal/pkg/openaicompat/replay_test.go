@@ -0,0 +1,80 @@
+package openaicompat
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata/streams")
+
+// replayFixtures 返回 testdata/streams 下录制的全部 OpenAI SSE 流
+func replayFixtures(t testing.TB) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "streams", "*.sse"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	return files
+}
+
+func TestReplayStream_Golden(t *testing.T) {
+	// 假签名固定为 fake，保证 golden 输出稳定
+	restore := fakeSignatureGenerator
+	fakeSignatureGenerator = func() string { return "fake" }
+	defer func() { fakeSignatureGenerator = restore }()
+
+	for _, path := range replayFixtures(t) {
+		name := strings.TrimSuffix(filepath.Base(path), ".sse")
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			out, _, err := ReplayStream(bytes.NewReader(input), "claude-sonnet-4-5", DefaultResponseOptions())
+			require.NoError(t, err)
+
+			goldenPath := strings.TrimSuffix(path, ".sse") + ".golden"
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(goldenPath, out, 0o644))
+			}
+			want, err := os.ReadFile(goldenPath)
+			require.NoError(t, err, "missing golden file, run: go test ./internal/pkg/openaicompat -run TestReplayStream_Golden -update")
+			require.Equal(t, string(want), string(out))
+
+			// 输出必须是合法的 Claude SSE：message_start 开头、message_stop 结尾
+			events := parseSSEEvents(t, string(out))
+			require.Equal(t, "message_start", events[0].Event)
+			require.Equal(t, "message_stop", events[len(events)-1].Event)
+		})
+	}
+}
+
+func TestReplayStream_Usage(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "streams", "multi_block.sse"))
+	require.NoError(t, err)
+
+	_, usage, err := ReplayStream(bytes.NewReader(input), "claude-sonnet-4-5", DefaultResponseOptions())
+	require.NoError(t, err)
+	require.Equal(t, 60, usage.OutputTokens)
+	require.Equal(t, 1000, usage.CacheReadInputTokens)
+}
+
+func BenchmarkReplayStream(b *testing.B) {
+	for _, path := range replayFixtures(b) {
+		input, err := os.ReadFile(path)
+		require.NoError(b, err)
+		b.Run(strings.TrimSuffix(filepath.Base(path), ".sse"), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := ReplayStream(bytes.NewReader(input), "claude-sonnet-4-5", DefaultResponseOptions()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
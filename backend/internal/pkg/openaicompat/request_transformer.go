@@ -1,6 +1,7 @@
 package openaicompat
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -8,80 +9,310 @@ import (
 	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
 )
 
-// TransformClaudeToOpenAI 将 Claude Messages API 请求转换为 OpenAI Chat Completions 格式
-func TransformClaudeToOpenAI(claudeReq *antigravity.ClaudeRequest) ([]byte, error) {
+// RequestOptions 控制 Claude → OpenAI 请求转换的可选行为
+type RequestOptions struct {
+	// TextToolProtocol 为 true 时不下发原生 tools，改为在 system prompt 中描述文本工具调用协议，
+	// 用于不支持 function calling 的上游（需配合 ResponseOptions.TextToolProtocol 解析响应）
+	TextToolProtocol bool
+	// WebSearchMode web_search 工具的处理方式（WebSearchMode* 常量），为空时丢弃该工具
+	WebSearchMode string
+	// Provider 上游提供方（Provider* 常量），用于选择默认的采样参数白名单、多模态内容块命名与默认 max_tokens
+	Provider string
+	// DefaultMaxTokens 客户端未指定（或指定为 0）max_tokens 时使用的值，0 表示使用 Provider 的默认值
+	DefaultMaxTokens int
+	// SamplingAllowlist 允许透传的采样参数（SamplingParam* 常量），为 nil 时使用 Provider 的默认白名单
+	SamplingAllowlist []string
+	// ReasoningMode thinking budget 的转换方式（ReasoningMode* 常量），默认按上游能力自动选择
+	ReasoningMode string
+	// ReasoningEffortThresholds budget 映射为 reasoning.effort 时使用的阈值，零值使用默认阈值
+	ReasoningEffortThresholds ReasoningEffortThresholds
+	// ReasoningOnly 显式声明目标模型是否只能以推理模式运行：true 时 thinking 关闭（或未指定）也发送最低 effort，
+	// false 时从不发送 reasoning，nil 时按模型名判断（见 isReasoningOnlyModel）
+	ReasoningOnly *bool
+	// AdaptiveReasoningEffort adaptive thinking（无 budget）使用的 effort：low/medium/high 为固定值，
+	// 为空或 AdaptiveReasoningEffortAuto 时按输入大小选择（见 adaptiveReasoningEffort）
+	AdaptiveReasoningEffort string
+	// DisableStreamUsage 为 true 时流式请求不发送 stream_options.include_usage（用于不支持 stream_options 的上游）
+	DisableStreamUsage bool
+	// SupportsTopK 显式声明上游是否接受 top_k：true 时始终透传，false 时始终丢弃，nil 时按采样参数白名单处理
+	SupportsTopK *bool
+	// TopKPlacement 透传的 top_k 在请求体中的位置（TopKPlacement* 常量），默认置于顶层
+	TopKPlacement string
+	// Logprobs / TopLogprobs 默认的 logprobs 请求参数，请求中显式指定时以请求为准
+	Logprobs    *bool
+	TopLogprobs *int
+	// PredictionPassthrough 为 true 时透传客户端的 prediction（predicted outputs）；
+	// 未开启时仅对默认支持的上游提供方（如 OpenAI）透传，其他上游丢弃
+	PredictionPassthrough bool
+	// OrphanToolResultMode tool_use_id 找不到对应 tool_call 的 tool_result 的处理方式（OrphanToolResult* 常量），默认保留并记录警告
+	OrphanToolResultMode string
+	// ToolResultJSON 为 true 时结构化 JSON 的 tool_result（对象或非内容块数组）以紧凑 JSON 作为 tool 消息内容，
+	// 否则（默认）提取为文本
+	ToolResultJSON bool
+	// OmitToolChoiceNone 为 true 时 tool_choice "none" 改为同时省略 tools 与 tool_choice（用于不接受 "none" 的上游），
+	// 未开启时按 Provider 的默认行为处理
+	OmitToolChoiceNone bool
+	// MaxTools 发送给上游的工具数量上限，0 表示不限制
+	MaxTools int
+	// MaxToolSchemaBytes 发送给上游的工具定义序列化后的总字节数上限，0 表示不限制
+	MaxToolSchemaBytes int
+	// ToolOverflowMode 工具超过 MaxTools / MaxToolSchemaBytes 时的处理方式（ToolOverflow* 常量），默认丢弃超出部分
+	ToolOverflowMode string
+	// MaxImageDimension 大于 0 时将 base64 图片等比缩放至最长边不超过该像素值后再发送，无法解码的图片原样发送
+	MaxImageDimension int
+	// EmptyAssistantMode 仅含 thinking（无文本、无工具调用）的 assistant 消息的处理方式（EmptyAssistantMode* 常量）
+	EmptyAssistantMode string
+	// PreserveUserThinking 为 true 时将 user 消息中的 thinking 块作为 reasoning_content
+	// 附加到之前最近的 assistant 消息上，否则丢弃
+	PreserveUserThinking bool
+	// SystemDirectiveOrder metadata.system_directives 相对基础 system prompt 的位置（SystemDirective* 常量），默认追加在后
+	SystemDirectiveOrder string
+	// SystemPrefix / SystemSuffix 账号配置的固定 system 文本，分别置于合并后 system prompt 的最前与最后；
+	// 仅含空白时忽略，基础 system prompt 为空时仍可单独构成 system message
+	SystemPrefix string
+	SystemSuffix string
+	// AnthropicBetas 客户端请求头 anthropic-beta 中的标志（ParseAnthropicBetas 解析），供按 beta 特性调整转换行为
+	AnthropicBetas []string
+	// Modalities 账号配置的输出模态（如 ["text","audio"]），为空时不发送 modalities，保持纯文本请求不变
+	Modalities []string
+	// Audio modalities 含 audio 时的音频输出参数，字段为空时使用 DefaultAudioVoice / DefaultAudioFormat
+	Audio *AudioConfig
+}
+
+// metadata.system_directives 合并顺序
+const (
+	SystemDirectiveAppend  = "append"  // 追加在基础 system prompt 之后（默认）
+	SystemDirectivePrepend = "prepend" // 置于基础 system prompt 之前
+)
+
+// 仅含 thinking 的 assistant 消息处理方式
+// 部分上游（如 OpenAI 官方、DeepSeek）会以 400 拒绝 content 为空且无 tool_calls 的 assistant 消息
+const (
+	EmptyAssistantModeKeep     = "keep"     // 原样保留（content 为空），默认行为，适用于 OpenRouter 等会透传 thinking 的上游
+	EmptyAssistantModeSpace    = "space"    // content 填充单个空格，适用于要求 content 非空的严格上游
+	EmptyAssistantModeThinking = "thinking" // 将 thinking 内容写入 content，适用于会丢弃 thinking 字段的上游（如 vLLM）
+	EmptyAssistantModeDrop     = "drop"     // 丢弃整条消息
+)
+
+// mapServiceTier 将 Claude service_tier 映射为 OpenAI service_tier：
+// "standard_only" 对应 "default"，其余取值（auto / flex / priority 等）原样透传
+func mapServiceTier(tier string) string {
+	tier = strings.ToLower(strings.TrimSpace(tier))
+	if tier == "standard_only" {
+		return "default"
+	}
+	return tier
+}
+
+// applyLogprobs 设置 logprobs / top_logprobs：请求中显式指定优先于账号默认值；
+// top_logprobs 要求 logprobs 为 true，未指定 logprobs 时自动开启，logprobs 为 false 时丢弃 top_logprobs
+func applyLogprobs(req *ChatRequest, claudeReq *antigravity.ClaudeRequest, opts RequestOptions) {
+	req.Logprobs, req.TopLogprobs = opts.Logprobs, opts.TopLogprobs
+	if claudeReq.Logprobs != nil {
+		req.Logprobs = claudeReq.Logprobs
+	}
+	if claudeReq.TopLogprobs != nil {
+		req.TopLogprobs = claudeReq.TopLogprobs
+	}
+	if req.TopLogprobs != nil && req.Logprobs == nil {
+		enabled := true
+		req.Logprobs = &enabled
+	}
+	if req.Logprobs != nil && !*req.Logprobs {
+		req.TopLogprobs = nil
+	}
+}
+
+// DefaultRequestOptions 返回默认的请求转换选项
+func DefaultRequestOptions() RequestOptions {
+	return RequestOptions{}
+}
+
+// TransformClaudeToOpenAI 将 Claude Messages API 请求转换为 OpenAI Chat Completions 格式，
+// 同时返回转换中对请求的静默调整（见 TransformWarning）
+func TransformClaudeToOpenAI(claudeReq *antigravity.ClaudeRequest) ([]byte, []TransformWarning, error) {
+	return TransformClaudeToOpenAIWithOptions(claudeReq, DefaultRequestOptions())
+}
+
+// TransformClaudeToOpenAIWithOptions 将 Claude Messages API 请求转换为 OpenAI Chat Completions 格式（可配置转换行为）
+func TransformClaudeToOpenAIWithOptions(claudeReq *antigravity.ClaudeRequest, opts RequestOptions) ([]byte, []TransformWarning, error) {
+	req, warnings, err := buildChatRequest(claudeReq, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, warnings, nil
+}
+
+// buildChatRequest 构建 Chat Completions 请求结构，供 Chat Completions 与 Responses API 转换共用
+func buildChatRequest(claudeReq *antigravity.ClaudeRequest, opts RequestOptions) (*ChatRequest, []TransformWarning, error) {
+	// max_tokens 未指定或为 0 时按上游选择默认值，避免请求零输出
+	maxTokens := resolveMaxTokens(claudeReq.MaxTokens, opts)
 	req := ChatRequest{
-		Model:       claudeReq.Model,
-		MaxTokens:   claudeReq.MaxTokens,
-		Temperature: claudeReq.Temperature,
-		TopP:        claudeReq.TopP,
-		Stream:      claudeReq.Stream,
+		Model:     claudeReq.Model,
+		MaxTokens: maxTokens,
+		Stream:    claudeReq.Stream,
+	}
+
+	// 采样参数按上游白名单透传，避免严格校验的上游因未知字段拒绝请求
+	applySamplingParams(&req, claudeReq, opts.Provider, samplingAllowlist(opts))
+	placeTopK(&req, opts.TopKPlacement)
+	if claudeReq.Metadata != nil {
+		req.Seed = claudeReq.Metadata.Seed
 	}
+	req.ServiceTier = mapServiceTier(claudeReq.ServiceTier)
+	applyCacheTTL(&req, claudeReq, opts.Provider)
+	applyLogprobs(&req, claudeReq, opts)
+	applyPrediction(&req, claudeReq, opts)
+	applyModalities(&req, opts)
 
 	// 流式请求需要 include_usage 来获取 token 用量
-	if claudeReq.Stream {
+	if claudeReq.Stream && !opts.DisableStreamUsage {
 		req.StreamOptions = &StreamOpts{IncludeUsage: true}
 	}
 
 	// 转换 thinking → reasoning
+	// thinking 关闭或未指定时不发送 reasoning；推理专用模型无法关闭推理，发送其允许的最低 effort 以减少推理消耗
 	if claudeReq.Thinking != nil && (claudeReq.Thinking.Type == "enabled" || claudeReq.Thinking.Type == "adaptive") {
-		effort := "high"
-		if claudeReq.Thinking.BudgetTokens > 0 && claudeReq.Thinking.BudgetTokens <= 4096 {
-			effort = "low"
-		} else if claudeReq.Thinking.BudgetTokens > 4096 && claudeReq.Thinking.BudgetTokens <= 16384 {
-			effort = "medium"
-		}
-		req.Reasoning = &ReasoningConfig{Effort: effort}
+		req.Reasoning = buildReasoningConfig(claudeReq.Model, claudeReq.Thinking.BudgetTokens, maxTokens, opts)
+	} else if isReasoningOnly(claudeReq.Model, opts.ReasoningOnly) {
+		req.Reasoning = &ReasoningConfig{Effort: lowestReasoningEffort(claudeReq.Model)}
 	}
 
 	// 转换 system prompt
 	var messages []ChatMessage
-	systemMsg, err := buildSystemMessage(claudeReq.System)
+	var directives []string
+	if claudeReq.Metadata != nil {
+		directives = claudeReq.Metadata.SystemDirectives
+	}
+	systemMsg, err := buildSystemMessage(claudeReq.System, directives, opts)
 	if err != nil {
-		return nil, fmt.Errorf("build system message: %w", err)
+		return nil, nil, fmt.Errorf("build system message: %w", err)
+	}
+	// 文本工具协议：工具定义写入 system prompt，tool_choice 为 none 时视为不使用工具
+	useTextTools := opts.TextToolProtocol && len(claudeReq.Tools) > 0 && !isToolChoiceNone(claudeReq.ToolChoice)
+	if useTextTools {
+		systemMsg = appendSystemText(systemMsg, buildTextToolProtocolPrompt(claudeReq.Tools, claudeReq.ToolChoice))
 	}
 	if systemMsg != nil {
 		messages = append(messages, *systemMsg)
 	}
 
 	// 转换 messages
+	userOpts := userBlockOptions{
+		partNames:         ContentPartNamesFor(opts.Provider),
+		toolResultJSON:    opts.ToolResultJSON,
+		maxImageDimension: opts.MaxImageDimension,
+	}
 	for i, msg := range claudeReq.Messages {
-		converted, err := convertMessage(msg)
+		if opts.TextToolProtocol {
+			msg = rewriteToolBlocksAsText(msg)
+		}
+		if opts.PreserveUserThinking && msg.Role == "user" {
+			if thinking := extractUserThinking(msg); thinking != "" {
+				attachReasoningToPrecedingAssistant(messages, thinking)
+			}
+		}
+		converted, err := convertMessage(msg, opts.EmptyAssistantMode, userOpts)
 		if err != nil {
-			return nil, fmt.Errorf("convert message %d: %w", i, err)
+			return nil, nil, fmt.Errorf("convert message %d: %w", i, err)
 		}
 		messages = append(messages, converted...)
 	}
+	// 文本工具协议下 tool_result 已改写为文本，无需校验
+	if !opts.TextToolProtocol {
+		messages = repairOrphanToolResults(messages, opts.OrphanToolResultMode)
+	}
 
 	req.Messages = messages
 
-	// 转换 tools
-	if len(claudeReq.Tools) > 0 {
-		req.Tools = convertTools(claudeReq.Tools)
+	// adaptive thinking 未指定 budget：按配置或输入大小选择 effort，避免简单请求也使用 high
+	if claudeReq.Thinking != nil && claudeReq.Thinking.Type == "adaptive" && claudeReq.Thinking.BudgetTokens <= 0 {
+		req.Reasoning = &ReasoningConfig{Effort: adaptiveReasoningEffort(messages, opts.AdaptiveReasoningEffort)}
 	}
 
-	// 转换 tool_choice
-	if len(claudeReq.ToolChoice) > 0 {
-		req.ToolChoice = convertToolChoice(claudeReq.ToolChoice)
+	// web_search 工具：按配置开启上游原生搜索
+	if opts.WebSearchMode != WebSearchModeDrop && hasWebSearchTool(claudeReq.Tools) {
+		applyWebSearchMode(&req, opts.WebSearchMode)
 	}
 
-	return json.Marshal(req)
+	// 转换 tools（文本工具协议下不下发原生 tools）
+	if len(claudeReq.Tools) > 0 && !opts.TextToolProtocol {
+		tools, err := limitTools(convertTools(claudeReq.Tools), opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Tools = tools
+	}
+
+	// 转换 tool_choice（含 disable_parallel_tool_use → parallel_tool_calls）
+	if len(claudeReq.ToolChoice) > 0 && !opts.TextToolProtocol {
+		req.ToolChoice, req.ParallelToolCalls = convertToolChoice(claudeReq.ToolChoice)
+	}
+	// 不接受 tool_choice "none" 的上游：同时省略 tools 与 tool_choice，效果等同于禁止调用工具
+	if req.ToolChoice == "none" && omitToolChoiceNone(opts) {
+		req.Tools = nil
+		req.ToolChoice = nil
+		req.ParallelToolCalls = nil
+	}
+
+	// 工具名改为符合 OpenAI 规则的名称（响应中由 ResponseOptions.ToolNames 还原），文本工具协议下不受此限制
+	if !opts.TextToolProtocol {
+		toUpstream, _ := toolNameMapping(claudeReq.Tools)
+		applyUpstreamToolNames(&req, toUpstream)
+	}
+
+	return &req, collectRequestWarnings(claudeReq, &req, opts), nil
 }
 
-// buildSystemMessage 将 Claude system prompt 转换为 OpenAI system message
-func buildSystemMessage(system json.RawMessage) (*ChatMessage, error) {
-	if len(system) == 0 {
+// buildSystemMessage 将 Claude system prompt、附加系统指令与账号配置的前后缀合并为 OpenAI system message
+// opts.SystemDirectiveOrder 为 SystemDirectivePrepend 时指令置于基础 prompt 之前，否则追加在后；
+// 基础 prompt 与各片段均在去除空白后判断是否为空，全部为空时返回 nil
+func buildSystemMessage(system json.RawMessage, directives []string, opts RequestOptions) (*ChatMessage, error) {
+	var parts []string
+	if base := extractSystemText(system); base != "" {
+		parts = append(parts, base)
+	}
+	var extra []string
+	for _, directive := range directives {
+		if strings.TrimSpace(directive) != "" {
+			extra = append(extra, directive)
+		}
+	}
+	if opts.SystemDirectiveOrder == SystemDirectivePrepend {
+		parts = append(extra, parts...)
+	} else {
+		parts = append(parts, extra...)
+	}
+	if strings.TrimSpace(opts.SystemPrefix) != "" {
+		parts = append([]string{opts.SystemPrefix}, parts...)
+	}
+	if strings.TrimSpace(opts.SystemSuffix) != "" {
+		parts = append(parts, opts.SystemSuffix)
+	}
+	if len(parts) == 0 {
 		return nil, nil
 	}
+	content, _ := json.Marshal(strings.Join(parts, "\n\n"))
+	return &ChatMessage{Role: "system", Content: content}, nil
+}
+
+// extractSystemText 提取 Claude system prompt 文本（字符串或 text 块数组），无有效内容时返回空字符串
+func extractSystemText(system json.RawMessage) string {
+	if len(system) == 0 {
+		return ""
+	}
 
 	// 尝试解析为字符串
 	var sysStr string
 	if err := json.Unmarshal(system, &sysStr); err == nil {
 		if strings.TrimSpace(sysStr) == "" {
-			return nil, nil
+			return ""
 		}
-		content, _ := json.Marshal(sysStr)
-		return &ChatMessage{Role: "system", Content: content}, nil
+		return sysStr
 	}
 
 	// 尝试解析为 SystemBlock 数组
@@ -93,19 +324,68 @@ func buildSystemMessage(system json.RawMessage) (*ChatMessage, error) {
 				texts = append(texts, block.Text)
 			}
 		}
-		if len(texts) == 0 {
-			return nil, nil
+		return strings.Join(texts, "\n\n")
+	}
+
+	return ""
+}
+
+// appendSystemText 在 system message 末尾追加文本，system message 为空时新建
+func appendSystemText(systemMsg *ChatMessage, text string) *ChatMessage {
+	combined := text
+	if systemMsg != nil {
+		var existing string
+		if err := json.Unmarshal(systemMsg.Content, &existing); err == nil && existing != "" {
+			combined = existing + "\n\n" + text
 		}
-		combined := strings.Join(texts, "\n\n")
-		content, _ := json.Marshal(combined)
-		return &ChatMessage{Role: "system", Content: content}, nil
 	}
+	content, _ := json.Marshal(combined)
+	return &ChatMessage{Role: "system", Content: content}
+}
 
-	return nil, nil
+// isToolChoiceNone 判断 Claude tool_choice 是否为 {"type": "none"}
+func isToolChoiceNone(raw json.RawMessage) bool {
+	if len(raw) == 0 {
+		return false
+	}
+	var tc struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(raw, &tc) == nil && tc.Type == "none"
+}
+
+// extractUserThinking 提取 user 消息中 thinking 块的文本
+func extractUserThinking(msg antigravity.ClaudeMessage) string {
+	var blocks []antigravity.ContentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return ""
+	}
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "thinking" && strings.TrimSpace(block.Thinking) != "" {
+			parts = append(parts, block.Thinking)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// attachReasoningToPrecedingAssistant 将 reasoning 文本追加到最近一条 assistant 消息的 reasoning_content
+// 之前没有 assistant 消息时无处承载，直接丢弃
+func attachReasoningToPrecedingAssistant(messages []ChatMessage, reasoning string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "assistant" {
+			continue
+		}
+		if messages[i].ReasoningContent != "" {
+			messages[i].ReasoningContent += "\n"
+		}
+		messages[i].ReasoningContent += reasoning
+		return
+	}
 }
 
 // convertMessage 将单条 Claude 消息转换为 OpenAI 消息（可能拆分为多条）
-func convertMessage(msg antigravity.ClaudeMessage) ([]ChatMessage, error) {
+func convertMessage(msg antigravity.ClaudeMessage, emptyAssistantMode string, userOpts userBlockOptions) ([]ChatMessage, error) {
 	// 尝试解析 content 为字符串
 	var textContent string
 	if err := json.Unmarshal(msg.Content, &textContent); err == nil {
@@ -121,45 +401,70 @@ func convertMessage(msg antigravity.ClaudeMessage) ([]ChatMessage, error) {
 	}
 
 	if msg.Role == "assistant" {
-		return convertAssistantBlocks(blocks)
+		return convertAssistantBlocks(blocks, emptyAssistantMode)
 	}
 
-	return convertUserBlocks(msg.Role, blocks)
+	return convertUserBlocks(msg.Role, blocks, userOpts)
+}
+
+// userBlockOptions 转换 user 消息内容块时使用的选项
+type userBlockOptions struct {
+	partNames         ContentPartNames
+	toolResultJSON    bool
+	maxImageDimension int
 }
 
 // convertUserBlocks 转换 user 角色的内容块
-func convertUserBlocks(role string, blocks []antigravity.ContentBlock) ([]ChatMessage, error) {
+// 按块出现顺序依次输出消息：连续的 text/image 合并为一条 user 消息，
+// 每个 tool_result 输出为独立的 tool 消息，保持与原始交错顺序一致；
+// 多模态内容块按 userOpts.partNames 输出为上游期望的类型名
+func convertUserBlocks(role string, blocks []antigravity.ContentBlock, userOpts userBlockOptions) ([]ChatMessage, error) {
 	var messages []ChatMessage
 	var contentParts []ContentPart
 
+	// flushParts 将已积累的 content parts 输出为一条消息
+	flushParts := func() {
+		if len(contentParts) == 0 {
+			return
+		}
+		if len(contentParts) == 1 && contentParts[0].Type == contentPartText {
+			// 单个文本，简化为字符串
+			content, _ := json.Marshal(contentParts[0].Text)
+			messages = append(messages, ChatMessage{Role: role, Content: content})
+		} else {
+			messages = append(messages, ChatMessage{Role: role, Content: marshalContentParts(contentParts, userOpts.partNames)})
+		}
+		contentParts = nil
+	}
+
 	for _, block := range blocks {
 		switch block.Type {
 		case "text":
 			contentParts = append(contentParts, ContentPart{
-				Type: "text",
+				Type: contentPartText,
 				Text: block.Text,
 			})
 
 		case "image":
 			if block.Source != nil && block.Source.Type == "base64" {
-				dataURL := fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+				mediaType, data := downscaleBase64Image(block.Source.MediaType, block.Source.Data, userOpts.maxImageDimension)
+				dataURL := fmt.Sprintf("data:%s;base64,%s", mediaType, data)
 				contentParts = append(contentParts, ContentPart{
-					Type:     "image_url",
+					Type:     contentPartImage,
 					ImageURL: &ImageURL{URL: dataURL},
 				})
 			}
 
 		case "tool_result":
-			// tool_result 需要作为独立的 tool message
-			// 先把之前积累的 content 输出
-			if len(contentParts) > 0 {
-				partsJSON, _ := json.Marshal(contentParts)
-				messages = append(messages, ChatMessage{Role: role, Content: partsJSON})
-				contentParts = nil
-			}
+			// tool_result 需要作为独立的 tool message，先输出之前积累的 content
+			flushParts()
 
-			// 提取 tool result 内容
 			resultText := extractToolResultText(block)
+			if userOpts.toolResultJSON {
+				if structured, ok := structuredToolResultJSON(block.Content); ok {
+					resultText = structured
+				}
+			}
 			content, _ := json.Marshal(resultText)
 			messages = append(messages, ChatMessage{
 				Role:       "tool",
@@ -174,26 +479,18 @@ func convertUserBlocks(role string, blocks []antigravity.ContentBlock) ([]ChatMe
 	}
 
 	// 输出剩余的 content parts
-	if len(contentParts) > 0 {
-		if len(contentParts) == 1 && contentParts[0].Type == "text" {
-			// 单个文本，简化为字符串
-			content, _ := json.Marshal(contentParts[0].Text)
-			messages = append(messages, ChatMessage{Role: role, Content: content})
-		} else {
-			partsJSON, _ := json.Marshal(contentParts)
-			messages = append(messages, ChatMessage{Role: role, Content: partsJSON})
-		}
-	}
+	flushParts()
 
 	return messages, nil
 }
 
 // convertAssistantBlocks 转换 assistant 角色的内容块
-func convertAssistantBlocks(blocks []antigravity.ContentBlock) ([]ChatMessage, error) {
+func convertAssistantBlocks(blocks []antigravity.ContentBlock, emptyAssistantMode string) ([]ChatMessage, error) {
 	var textParts []string
 	var toolCalls []ToolCall
 	var thinkingParts []string
 	var lastSignature string
+	var audioID string
 
 	for _, block := range blocks {
 		switch block.Type {
@@ -222,10 +519,19 @@ func convertAssistantBlocks(blocks []antigravity.ContentBlock) ([]ChatMessage, e
 			if block.Signature != "" {
 				lastSignature = block.Signature
 			}
+
+		case AudioBlockType:
+			// 之前的音频回复：按 id 引用（上游在有效期内可关联该音频）
+			if block.ID != "" {
+				audioID = block.ID
+			}
 		}
 	}
 
 	msg := ChatMessage{Role: "assistant"}
+	if audioID != "" {
+		msg.Audio = &ChatAudio{ID: audioID}
+	}
 
 	// 将历史消息中的 thinking 内容和 signature 一起传递
 	if len(thinkingParts) > 0 {
@@ -245,6 +551,19 @@ func convertAssistantBlocks(blocks []antigravity.ContentBlock) ([]ChatMessage, e
 		msg.ToolCalls = toolCalls
 	}
 
+	// 仅含 thinking 的 assistant 消息按配置兜底，避免严格上游拒绝空消息
+	if msg.ThinkingField != nil && len(msg.Content) == 0 && len(msg.ToolCalls) == 0 {
+		switch emptyAssistantMode {
+		case EmptyAssistantModeSpace:
+			msg.Content, _ = json.Marshal(" ")
+		case EmptyAssistantModeThinking:
+			msg.Content, _ = json.Marshal(msg.ThinkingField.Content)
+			msg.ThinkingField = nil
+		case EmptyAssistantModeDrop:
+			return nil, nil
+		}
+	}
+
 	return []ChatMessage{msg}, nil
 }
 
@@ -269,16 +588,10 @@ func extractToolResultText(block antigravity.ContentBlock) string {
 		return str
 	}
 
-	// 尝试解析为数组
-	var arr []map[string]any
-	if err := json.Unmarshal(block.Content, &arr); err == nil {
-		var texts []string
-		for _, item := range arr {
-			if text, ok := item["text"].(string); ok {
-				texts = append(texts, text)
-			}
-		}
-		result := strings.Join(texts, "\n")
+	// 尝试解析为数组或对象，递归提取嵌套内容中的文本
+	var content any
+	if err := json.Unmarshal(block.Content, &content); err == nil {
+		result := flattenToolResultContent(content, 0)
 		if strings.TrimSpace(result) != "" {
 			return result
 		}
@@ -288,6 +601,94 @@ func extractToolResultText(block antigravity.ContentBlock) string {
 	return string(block.Content)
 }
 
+// structuredToolResultJSON 判断 tool_result.content 是否为结构化 JSON 数据（对象，或不是 Claude 内容块的数组），
+// 是则返回紧凑 JSON；字符串及内容块数组（text / image / tool_result 等）返回 false，仍按文本提取
+func structuredToolResultJSON(content json.RawMessage) (string, bool) {
+	var value any
+	if err := json.Unmarshal(content, &value); err != nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		if isClaudeContentBlock(v) {
+			return "", false
+		}
+	case []any:
+		if len(v) == 0 {
+			return "", false
+		}
+		allBlocks := true
+		for _, item := range v {
+			if obj, ok := item.(map[string]any); !ok || !isClaudeContentBlock(obj) {
+				allBlocks = false
+				break
+			}
+		}
+		if allBlocks {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, content); err != nil {
+		return "", false
+	}
+	return compact.String(), true
+}
+
+// isClaudeContentBlock 判断对象是否为 Claude 内容块（而非工具返回的业务数据）
+func isClaudeContentBlock(obj map[string]any) bool {
+	switch obj["type"] {
+	case "text", "image", "document", "tool_result", "search_result":
+		return true
+	}
+	return false
+}
+
+// maxToolResultDepth tool_result 嵌套内容的最大递归深度，超过后以紧凑 JSON 输出剩余部分
+const maxToolResultDepth = 8
+
+// flattenToolResultContent 递归展开 tool_result 内容：
+// 文本块取 text，带 content 的块（如嵌套的 tool_result）继续展开，图片块跳过，
+// 其他结构化对象输出为紧凑 JSON；多个片段以换行连接
+func flattenToolResultContent(content any, depth int) string {
+	if depth >= maxToolResultDepth {
+		raw, _ := json.Marshal(content)
+		return string(raw)
+	}
+
+	switch v := content.(type) {
+	case string:
+		return v
+	case []any:
+		var texts []string
+		for _, item := range v {
+			if text := flattenToolResultContent(item, depth+1); strings.TrimSpace(text) != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	case map[string]any:
+		if text, ok := v["text"].(string); ok {
+			return text
+		}
+		if nested, ok := v["content"]; ok && nested != nil {
+			return flattenToolResultContent(nested, depth+1)
+		}
+		if v["type"] == "image" {
+			return ""
+		}
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	case nil:
+		return ""
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
+
 // convertTools 将 Claude 工具定义转换为 OpenAI function 格式
 func convertTools(claudeTools []antigravity.ClaudeTool) []Tool {
 	var tools []Tool
@@ -308,8 +709,8 @@ func convertTools(claudeTools []antigravity.ClaudeTool) []Tool {
 			parameters = ct.InputSchema
 		}
 
-		// web_search 等特殊工具类型跳过
-		if ct.Type == "web_search_20250305" || ct.Type == "web_search" {
+		// web_search 等特殊工具类型跳过（需要时由 WebSearchMode 开启上游原生搜索）
+		if isWebSearchTool(ct) {
 			continue
 		}
 
@@ -332,32 +733,52 @@ func convertTools(claudeTools []antigravity.ClaudeTool) []Tool {
 	return tools
 }
 
-// convertToolChoice 将 Claude tool_choice 转换为 OpenAI tool_choice
-// Claude 格式: {"type": "auto"} / {"type": "any"} / {"type": "tool", "name": "xxx"}
+// toolChoiceNoneUnsupported 不接受 tool_choice "none" 的上游（旧版 vLLM 仅支持 auto 与指定函数）
+var toolChoiceNoneUnsupported = map[string]bool{
+	ProviderVLLM: true,
+}
+
+// omitToolChoiceNone tool_choice 为 "none" 时是否改为省略 tools 与 tool_choice：账号开启 OmitToolChoiceNone 或上游提供方不支持
+func omitToolChoiceNone(opts RequestOptions) bool {
+	return opts.OmitToolChoiceNone || toolChoiceNoneUnsupported[strings.ToLower(strings.TrimSpace(opts.Provider))]
+}
+
+// convertToolChoice 将 Claude tool_choice 转换为 OpenAI tool_choice 和 parallel_tool_calls
+// Claude 格式: {"type": "auto"} / {"type": "any"} / {"type": "tool", "name": "xxx"} / {"type": "none"}，
+// 除 none 外均可携带 "disable_parallel_tool_use": true
 // OpenAI 格式: "auto" / "required" / "none" / {"type": "function", "function": {"name": "xxx"}}
-func convertToolChoice(raw json.RawMessage) any {
+func convertToolChoice(raw json.RawMessage) (any, *bool) {
 	var tc struct {
-		Type string `json:"type"`
-		Name string `json:"name,omitempty"`
+		Type                   string `json:"type"`
+		Name                   string `json:"name,omitempty"`
+		DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
 	}
 	if err := json.Unmarshal(raw, &tc); err != nil {
-		return nil
+		return nil, nil
 	}
+
+	// disable_parallel_tool_use 与 type 相互独立，仅在显式禁用时下发 parallel_tool_calls=false
+	var parallelToolCalls *bool
+	if tc.DisableParallelToolUse && tc.Type != "none" {
+		disabled := false
+		parallelToolCalls = &disabled
+	}
+
 	switch tc.Type {
 	case "auto":
-		return "auto"
+		return "auto", parallelToolCalls
 	case "any":
 		// Claude "any" = 必须调用某个工具 = OpenAI "required"
-		return "required"
+		return "required", parallelToolCalls
 	case "none":
-		return "none"
+		return "none", nil
 	case "tool":
 		// 指定调用某个具体工具
 		return map[string]any{
 			"type":     "function",
 			"function": map[string]string{"name": tc.Name},
-		}
+		}, parallelToolCalls
 	default:
-		return nil
+		return nil, parallelToolCalls
 	}
 }
@@ -0,0 +1,617 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// transformRequest 解析 Claude 请求 JSON 并转换为 OpenAI ChatRequest，便于断言
+func transformRequest(t *testing.T, claudeJSON string) ChatRequest {
+	t.Helper()
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+	body, _, err := TransformClaudeToOpenAI(&claudeReq)
+	require.NoError(t, err)
+
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	return req
+}
+
+// TestTransformClaudeToOpenAI_TwoRoundToolLoop 验证两轮 agentic 工具循环的消息顺序与关联关系
+func TestTransformClaudeToOpenAI_TwoRoundToolLoop(t *testing.T) {
+	req := transformRequest(t, `{
+		"model": "m",
+		"max_tokens": 1024,
+		"system": "you are helpful",
+		"messages": [
+			{"role": "user", "content": "what is the weather in Paris and Tokyo?"},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Checking Paris."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_2", "name": "get_weather", "input": {"city": "Tokyo"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": [{"type": "text", "text": "rainy"}]}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Paris is sunny, Tokyo is rainy."}
+			]}
+		]
+	}`)
+
+	type wantMessage struct {
+		role       string
+		content    string
+		toolCallID string
+		toolCalls  []FunctionCall
+		callIDs    []string
+	}
+	want := []wantMessage{
+		{role: "system", content: "you are helpful"},
+		{role: "user", content: "what is the weather in Paris and Tokyo?"},
+		{role: "assistant", content: "Checking Paris.", callIDs: []string{"toolu_1"}, toolCalls: []FunctionCall{{Name: "get_weather", Arguments: `{"city":"Paris"}`}}},
+		{role: "tool", content: "sunny", toolCallID: "toolu_1"},
+		{role: "assistant", callIDs: []string{"toolu_2"}, toolCalls: []FunctionCall{{Name: "get_weather", Arguments: `{"city":"Tokyo"}`}}},
+		{role: "tool", content: "rainy", toolCallID: "toolu_2"},
+		{role: "assistant", content: "Paris is sunny, Tokyo is rainy."},
+	}
+
+	require.Len(t, req.Messages, len(want))
+	for i, w := range want {
+		got := req.Messages[i]
+		require.Equal(t, w.role, got.Role, "message %d role", i)
+		require.Equal(t, w.toolCallID, got.ToolCallID, "message %d tool_call_id", i)
+
+		var content string
+		if len(got.Content) > 0 {
+			require.NoError(t, json.Unmarshal(got.Content, &content), "message %d content", i)
+		}
+		require.Equal(t, w.content, content, "message %d content", i)
+
+		require.Len(t, got.ToolCalls, len(w.toolCalls), "message %d tool_calls", i)
+		for j, tc := range got.ToolCalls {
+			require.Equal(t, w.callIDs[j], tc.ID)
+			require.Equal(t, "function", tc.Type)
+			require.Equal(t, w.toolCalls[j].Name, tc.Function.Name)
+			require.JSONEq(t, w.toolCalls[j].Arguments, tc.Function.Arguments)
+		}
+	}
+}
+
+func TestTransformClaudeToOpenAI_ToolChoice(t *testing.T) {
+	tests := []struct {
+		name         string
+		toolChoice   string
+		wantChoice   any
+		wantParallel *bool
+	}{
+		{name: "auto", toolChoice: `{"type":"auto"}`, wantChoice: "auto"},
+		{name: "any", toolChoice: `{"type":"any"}`, wantChoice: "required"},
+		{name: "none", toolChoice: `{"type":"none"}`, wantChoice: "none"},
+		{
+			name:       "tool",
+			toolChoice: `{"type":"tool","name":"get_weather"}`,
+			wantChoice: map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}},
+		},
+		{name: "auto without parallel", toolChoice: `{"type":"auto","disable_parallel_tool_use":true}`, wantChoice: "auto", wantParallel: new(bool)},
+		{name: "any without parallel", toolChoice: `{"type":"any","disable_parallel_tool_use":true}`, wantChoice: "required", wantParallel: new(bool)},
+		{
+			name:         "tool without parallel",
+			toolChoice:   `{"type":"tool","name":"get_weather","disable_parallel_tool_use":true}`,
+			wantChoice:   map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}},
+			wantParallel: new(bool),
+		},
+		{name: "parallel explicitly allowed", toolChoice: `{"type":"auto","disable_parallel_tool_use":false}`, wantChoice: "auto"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := transformRequest(t, `{
+				"model": "m",
+				"messages": [{"role": "user", "content": "hi"}],
+				"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+				"tool_choice": `+tt.toolChoice+`
+			}`)
+			require.Equal(t, tt.wantChoice, req.ToolChoice)
+			require.Equal(t, tt.wantParallel, req.ParallelToolCalls)
+		})
+	}
+}
+
+func TestTransformClaudeToOpenAI_ToolChoiceNonePerProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     RequestOptions
+		wantOmit bool
+	}{
+		{name: "generic", opts: RequestOptions{Provider: ProviderGeneric}},
+		{name: "openai", opts: RequestOptions{Provider: ProviderOpenAI}},
+		{name: "openrouter", opts: RequestOptions{Provider: ProviderOpenRouter}},
+		{name: "deepseek", opts: RequestOptions{Provider: ProviderDeepSeek}},
+		{name: "vllm", opts: RequestOptions{Provider: ProviderVLLM}, wantOmit: true},
+		{name: "account override", opts: RequestOptions{Provider: ProviderGeneric, OmitToolChoiceNone: true}, wantOmit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(`{
+				"model": "m",
+				"messages": [{"role": "user", "content": "hi"}],
+				"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+				"tool_choice": {"type": "none"}
+			}`), &claudeReq))
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+
+			var raw map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(body, &raw))
+			if tt.wantOmit {
+				require.NotContains(t, raw, "tools")
+				require.NotContains(t, raw, "tool_choice")
+				return
+			}
+			require.Contains(t, raw, "tools")
+			require.JSONEq(t, `"none"`, string(raw["tool_choice"]))
+		})
+	}
+
+	// 其他 tool_choice 不受影响
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"tools":[{"name":"f"}],"tool_choice":{"type":"auto"}}`), &claudeReq))
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderVLLM})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Equal(t, "auto", req.ToolChoice)
+	require.Len(t, req.Tools, 1)
+}
+
+// TestTransformClaudeToOpenAI_InterleavedToolResults 验证 user 消息中 text 与 tool_result 交错时保持原始顺序
+func TestTransformClaudeToOpenAI_InterleavedToolResults(t *testing.T) {
+	req := transformRequest(t, `{
+		"model": "m",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "first"},
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "result 1"},
+				{"type": "text", "text": "second"},
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": "result 2"}
+			]}
+		]
+	}`)
+
+	want := []struct {
+		role       string
+		content    string
+		toolCallID string
+	}{
+		{role: "user", content: "first"},
+		{role: "tool", content: "result 1", toolCallID: "toolu_1"},
+		{role: "user", content: "second"},
+		{role: "tool", content: "result 2", toolCallID: "toolu_2"},
+	}
+
+	require.Len(t, req.Messages, len(want))
+	for i, w := range want {
+		var content string
+		require.NoError(t, json.Unmarshal(req.Messages[i].Content, &content), "message %d", i)
+		require.Equal(t, w.role, req.Messages[i].Role, "message %d", i)
+		require.Equal(t, w.content, content, "message %d", i)
+		require.Equal(t, w.toolCallID, req.Messages[i].ToolCallID, "message %d", i)
+	}
+}
+
+// TestTransformClaudeToOpenAI_ThinkingOnlyAssistant 验证仅含 thinking 的 assistant 消息按 EmptyAssistantMode 兜底
+func TestTransformClaudeToOpenAI_ThinkingOnlyAssistant(t *testing.T) {
+	claudeJSON := `{
+		"model": "m",
+		"messages": [
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "let me think", "signature": "sig"}
+			]},
+			{"role": "user", "content": "continue"}
+		]
+	}`
+
+	tests := []struct {
+		mode         string
+		wantMessages int
+		wantContent  string
+		wantThinking bool
+	}{
+		{mode: "", wantMessages: 3, wantThinking: true},
+		{mode: EmptyAssistantModeKeep, wantMessages: 3, wantThinking: true},
+		{mode: EmptyAssistantModeSpace, wantMessages: 3, wantContent: " ", wantThinking: true},
+		{mode: EmptyAssistantModeThinking, wantMessages: 3, wantContent: "let me think"},
+		{mode: EmptyAssistantModeDrop, wantMessages: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{EmptyAssistantMode: tt.mode})
+			require.NoError(t, err)
+
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.Len(t, req.Messages, tt.wantMessages)
+			if tt.mode == EmptyAssistantModeDrop {
+				require.Equal(t, "user", req.Messages[1].Role)
+				return
+			}
+
+			msg := req.Messages[1]
+			require.Equal(t, "assistant", msg.Role)
+			if tt.wantContent == "" {
+				require.Empty(t, msg.Content)
+			} else {
+				var content string
+				require.NoError(t, json.Unmarshal(msg.Content, &content))
+				require.Equal(t, tt.wantContent, content)
+			}
+			require.Equal(t, tt.wantThinking, msg.ThinkingField != nil)
+		})
+	}
+}
+
+// TestTransformClaudeToOpenAI_ThinkingWithTextUnaffected 验证含文本的 assistant 消息不受 EmptyAssistantMode 影响
+func TestTransformClaudeToOpenAI_ThinkingWithTextUnaffected(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "m",
+		"messages": [
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "hmm", "signature": "sig"},
+				{"type": "text", "text": "hello"}
+			]}
+		]
+	}`), &claudeReq))
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{EmptyAssistantMode: EmptyAssistantModeDrop})
+	require.NoError(t, err)
+
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Len(t, req.Messages, 2)
+	require.JSONEq(t, `"hello"`, string(req.Messages[1].Content))
+	require.NotNil(t, req.Messages[1].ThinkingField)
+}
+
+// TestTransformClaudeToOpenAI_UserThinking 验证 user 消息中的 thinking 块按 PreserveUserThinking 保留或丢弃
+func TestTransformClaudeToOpenAI_UserThinking(t *testing.T) {
+	claudeJSON := `{
+		"model": "m",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "thinking", "thinking": "orphan thought"},
+				{"type": "text", "text": "hi"}
+			]},
+			{"role": "assistant", "content": "hello"},
+			{"role": "user", "content": [
+				{"type": "thinking", "thinking": "carried thought", "signature": "sig"},
+				{"type": "text", "text": "next"}
+			]}
+		]
+	}`
+
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{})
+	require.NoError(t, err)
+	var dropped ChatRequest
+	require.NoError(t, json.Unmarshal(body, &dropped))
+	require.Len(t, dropped.Messages, 3)
+	require.Empty(t, dropped.Messages[1].ReasoningContent)
+	require.NotContains(t, string(body), "thought")
+
+	body, _, err = TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{PreserveUserThinking: true})
+	require.NoError(t, err)
+	var preserved ChatRequest
+	require.NoError(t, json.Unmarshal(body, &preserved))
+	require.Len(t, preserved.Messages, 3)
+	require.Equal(t, "assistant", preserved.Messages[1].Role)
+	require.Equal(t, "carried thought", preserved.Messages[1].ReasoningContent)
+	require.JSONEq(t, `"next"`, string(preserved.Messages[2].Content))
+	// 第一条 user 消息之前没有 assistant 消息，thinking 无处承载
+	require.NotContains(t, string(body), "orphan thought")
+}
+
+// TestTransformClaudeToOpenAI_SystemDirectives 验证 metadata.system_directives 按配置顺序合并进 system message
+func TestTransformClaudeToOpenAI_SystemDirectives(t *testing.T) {
+	claudeJSON := `{
+		"model": "m",
+		"system": "base prompt",
+		"metadata": {"user_id": "u1", "system_directives": ["directive one", "  ", "directive two"]},
+		"messages": [{"role": "user", "content": "hi"}]
+	}`
+
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{order: "", want: "base prompt\n\ndirective one\n\ndirective two"},
+		{order: SystemDirectiveAppend, want: "base prompt\n\ndirective one\n\ndirective two"},
+		{order: SystemDirectivePrepend, want: "directive one\n\ndirective two\n\nbase prompt"},
+	}
+
+	for _, tt := range tests {
+		t.Run("order="+tt.order, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{SystemDirectiveOrder: tt.order})
+			require.NoError(t, err)
+
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.Equal(t, "system", req.Messages[0].Role)
+			var system string
+			require.NoError(t, json.Unmarshal(req.Messages[0].Content, &system))
+			require.Equal(t, tt.want, system)
+		})
+	}
+}
+
+// TestTransformClaudeToOpenAI_SystemDirectivesWithoutBase 验证没有基础 system prompt 时仅由指令构成 system message
+func TestTransformClaudeToOpenAI_SystemDirectivesWithoutBase(t *testing.T) {
+	req := transformRequest(t, `{
+		"model": "m",
+		"metadata": {"system_directives": ["only directive"]},
+		"messages": [{"role": "user", "content": "hi"}]
+	}`)
+	require.Len(t, req.Messages, 2)
+	require.JSONEq(t, `"only directive"`, string(req.Messages[0].Content))
+}
+
+// TestTransformClaudeToOpenAI_SystemPrefixSuffix 验证账号配置的前后缀与基础 system prompt、指令的合并及空白处理
+func TestTransformClaudeToOpenAI_SystemPrefixSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		system string
+		opts   RequestOptions
+		want   string // 空表示不生成 system message
+	}{
+		{name: "whitespace base with prefix", system: `"   \n\t "`, opts: RequestOptions{SystemPrefix: "prefix"}, want: "prefix"},
+		{name: "whitespace blocks with suffix", system: `[{"type":"text","text":"  "}]`, opts: RequestOptions{SystemSuffix: "suffix"}, want: "suffix"},
+		{name: "empty base with both", opts: RequestOptions{SystemPrefix: "prefix", SystemSuffix: "suffix"}, want: "prefix\n\nsuffix"},
+		{name: "base with both", system: `"base"`, opts: RequestOptions{SystemPrefix: "prefix", SystemSuffix: "suffix"}, want: "prefix\n\nbase\n\nsuffix"},
+		{name: "whitespace prefix ignored", system: `"base"`, opts: RequestOptions{SystemPrefix: "  \n"}, want: "base"},
+		{name: "all whitespace", system: `"  "`, opts: RequestOptions{SystemPrefix: " ", SystemSuffix: "\t"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claudeJSON := `{"model":"m","messages":[{"role":"user","content":"hi"}]}`
+			if tt.system != "" {
+				claudeJSON = `{"model":"m","system":` + tt.system + `,"messages":[{"role":"user","content":"hi"}]}`
+			}
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+
+			if tt.want == "" {
+				require.Len(t, req.Messages, 1)
+				require.Equal(t, "user", req.Messages[0].Role)
+				return
+			}
+			require.Len(t, req.Messages, 2)
+			require.Equal(t, "system", req.Messages[0].Role)
+			var content string
+			require.NoError(t, json.Unmarshal(req.Messages[0].Content, &content))
+			require.Equal(t, tt.want, content)
+		})
+	}
+}
+
+// TestTransformClaudeToOpenAI_SystemPrefixWithDirectives 验证前后缀始终位于指令之外
+func TestTransformClaudeToOpenAI_SystemPrefixWithDirectives(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"m","system":"base","metadata":{"system_directives":["directive"]},"messages":[{"role":"user","content":"hi"}]}`), &claudeReq))
+
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{
+		SystemDirectiveOrder: SystemDirectivePrepend,
+		SystemPrefix:         "prefix",
+		SystemSuffix:         "suffix",
+	})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.JSONEq(t, `"prefix\n\ndirective\n\nbase\n\nsuffix"`, string(req.Messages[0].Content))
+}
+
+func TestTransformClaudeToOpenAI_DisableStreamUsage(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model": "m", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`), &claudeReq))
+
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Equal(t, &StreamOpts{IncludeUsage: true}, req.StreamOptions)
+
+	body, _, err = TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{DisableStreamUsage: true})
+	require.NoError(t, err)
+	require.NotContains(t, string(body), "stream_options")
+}
+
+func TestExtractToolResultText_Nested(t *testing.T) {
+	block := antigravity.ContentBlock{
+		Type:      "tool_result",
+		ToolUseID: "toolu_1",
+		Content: json.RawMessage(`[
+			{"type": "text", "text": "summary"},
+			{"type": "tool_result", "tool_use_id": "toolu_inner", "content": [
+				{"type": "text", "text": "inner result"},
+				{"type": "tool_result", "content": [{"type": "text", "text": "deepest"}]}
+			]},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}},
+			{"type": "json", "value": {"rows": 2}}
+		]`),
+	}
+	require.Equal(t, "summary\ninner result\ndeepest\n{\"type\":\"json\",\"value\":{\"rows\":2}}", extractToolResultText(block))
+
+	// 结构化对象（非数组）同样展开
+	block.Content = json.RawMessage(`{"type": "tool_result", "content": "object result"}`)
+	require.Equal(t, "object result", extractToolResultText(block))
+}
+
+func TestExtractToolResultText_DepthLimit(t *testing.T) {
+	nested := `"leaf"`
+	for i := 0; i < 20; i++ {
+		nested = `[{"type": "tool_result", "content": ` + nested + `}]`
+	}
+	text := extractToolResultText(antigravity.ContentBlock{Type: "tool_result", Content: json.RawMessage(nested)})
+
+	// 超过深度上限的部分以 JSON 输出，不会无限递归
+	require.Contains(t, text, "leaf")
+	require.True(t, strings.HasPrefix(text, "["), text)
+	require.True(t, json.Valid([]byte(text)), text)
+}
+
+func TestTransformClaudeToOpenAI_ServiceTier(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"auto":          "auto",
+		"standard_only": "default",
+		"flex":          "flex",
+		"Priority":      "priority",
+	}
+	for tier, want := range tests {
+		req := transformRequest(t, `{"model":"gpt-4o","service_tier":"`+tier+`","messages":[{"role":"user","content":"hi"}]}`)
+		require.Equal(t, want, req.ServiceTier, "service_tier %q", tier)
+	}
+}
+
+func TestTransformClaudeToOpenAI_Logprobs(t *testing.T) {
+	enabled, disabled, three, five := true, false, 3, 5
+	tests := []struct {
+		name     string
+		body     string
+		opts     RequestOptions
+		wantLP   *bool
+		wantTopN *int
+	}{
+		{name: "not requested", body: `{}`},
+		{name: "request", body: `{"logprobs":true,"top_logprobs":3}`, wantLP: &enabled, wantTopN: &three},
+		{name: "top implies logprobs", body: `{"top_logprobs":3}`, wantLP: &enabled, wantTopN: &three},
+		{name: "account default", body: `{}`, opts: RequestOptions{Logprobs: &enabled, TopLogprobs: &five}, wantLP: &enabled, wantTopN: &five},
+		{name: "request overrides account", body: `{"top_logprobs":3}`, opts: RequestOptions{Logprobs: &enabled, TopLogprobs: &five}, wantLP: &enabled, wantTopN: &three},
+		{name: "disabled drops top", body: `{"logprobs":false}`, opts: RequestOptions{TopLogprobs: &five}, wantLP: &disabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(tt.body), &claudeReq))
+			claudeReq.Model = "gpt-4o"
+			claudeReq.Messages = []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}}
+			out, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(out, &req))
+			require.Equal(t, tt.wantLP, req.Logprobs)
+			require.Equal(t, tt.wantTopN, req.TopLogprobs)
+		})
+	}
+}
+
+func TestTransformClaudeToOpenAI_ContentPartNames(t *testing.T) {
+	claudeJSON := `{"model":"gpt-4o","messages":[{"role":"user","content":[
+		{"type":"text","text":"describe"},
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}}
+	]}]}`
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+	// 默认使用 Chat Completions 命名
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.JSONEq(t, `[
+		{"type":"text","text":"describe"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}
+	]`, string(req.Messages[0].Content))
+
+	// Responses API 风格：input_text / input_image，image_url 为字符串
+	body, _, err = TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderOpenAIResponses})
+	require.NoError(t, err)
+	req = ChatRequest{}
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.JSONEq(t, `[
+		{"type":"input_text","text":"describe"},
+		{"type":"input_image","image_url":"data:image/png;base64,AAAA"}
+	]`, string(req.Messages[0].Content))
+}
+
+func TestTransformClaudeToOpenAI_ContentPartNamesSingleText(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`), &claudeReq))
+
+	// 单个文本块仍简化为字符串，与命名无关
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderOpenAIResponses})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Equal(t, `"hi"`, string(req.Messages[0].Content))
+}
+
+func TestContentPartNamesFor(t *testing.T) {
+	require.Equal(t, ContentPartNames{Text: "text", Image: "image_url"}, ContentPartNamesFor(""))
+	require.Equal(t, ContentPartNames{Text: "text", Image: "image_url"}, ContentPartNamesFor(ProviderVLLM))
+	require.Equal(t, ContentPartNames{Text: "input_text", Image: "input_image", FlatImageURL: true}, ContentPartNamesFor(" OpenAI_Responses "))
+}
+
+func TestTransformClaudeToOpenAI_ToolResultJSON(t *testing.T) {
+	claudeJSON := `{"model":"m","messages":[
+		{"role":"assistant","content":[
+			{"type":"tool_use","id":"t1","name":"query","input":{}},
+			{"type":"tool_use","id":"t2","name":"query","input":{}},
+			{"type":"tool_use","id":"t3","name":"query","input":{}}
+		]},
+		{"role":"user","content":[
+			{"type":"tool_result","tool_use_id":"t1","content":{"total": 1, "rows": [ {"id": 1, "text": "a"} ]}},
+			{"type":"tool_result","tool_use_id":"t2","content":[{"id": 1}, {"id": 2}]},
+			{"type":"tool_result","tool_use_id":"t3","content":[{"type":"text","text":"plain result"}]}
+		]}
+	]}`
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+	toolContents := func(opts RequestOptions) []string {
+		body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, opts)
+		require.NoError(t, err)
+		var req ChatRequest
+		require.NoError(t, json.Unmarshal(body, &req))
+		var contents []string
+		for _, msg := range req.Messages {
+			if msg.Role == "tool" {
+				var text string
+				require.NoError(t, json.Unmarshal(msg.Content, &text))
+				contents = append(contents, text)
+			}
+		}
+		return contents
+	}
+
+	// 默认提取文本
+	require.Equal(t, []string{`{"rows":[{"id":1,"text":"a"}],"total":1}`, "{\"id\":1}\n{\"id\":2}", "plain result"}, toolContents(RequestOptions{}))
+
+	// JSON 模式：结构化数据以紧凑 JSON 发送（保留原始字段顺序），内容块数组仍提取文本
+	require.Equal(t, []string{`{"total":1,"rows":[{"id":1,"text":"a"}]}`, `[{"id":1},{"id":2}]`, "plain result"}, toolContents(RequestOptions{ToolResultJSON: true}))
+}
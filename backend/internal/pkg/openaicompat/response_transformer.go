@@ -1,9 +1,14 @@
 package openaicompat
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -11,19 +16,187 @@ import (
 	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
 )
 
+// ResponseOptions 控制 OpenAI → Claude 响应转换的可选行为（流式与非流式共用）
+type ResponseOptions struct {
+	// HideThinking 为 true 时不向客户端返回 thinking 块，reasoning tokens 仍计入 usage
+	HideThinking bool
+	// TextToolProtocol 为 true 时从文本中解析 <tool_call> 片段并还原为 tool_use 块
+	TextToolProtocol bool
+	// MaxDeltaBytes 流式单个 text_delta 的最大字节数，超过时按 UTF-8 字符边界拆分为多个事件，0 表示不限制
+	MaxDeltaBytes int
+	// TextDeltaBatchWindow 流式文本增量的合并窗口：窗口内连续到达的文本增量合并为一个 text_delta 事件输出，
+	// 减少逐 token 输出的上游产生的 SSE 事件数；0 表示不合并（默认）。合并不改变文本内容，
+	// 窗口内暂存的文本在开启其他块、流结束或调用方 FlushTextBatch 时输出
+	TextDeltaBatchWindow time.Duration
+	// FakeSignatureFormat 上游未返回 signature 时为 thinking 块生成的假签名格式（FakeSignature* 常量），默认时间戳 + 随机后缀
+	FakeSignatureFormat string
+	// FakeSignatureBytes base64 / hex 格式假签名的随机字节数，0 表示使用默认长度
+	FakeSignatureBytes int
+	// ReasoningOutput 上游 reasoning 的输出方式（ReasoningOutput* 常量），默认摘要与完整推理均返回
+	ReasoningOutput string
+	// EffectiveParams 非空时作为 effective_params 写入响应（非流式为 message 顶层，流式为 message_start.message），
+	// 用于调试时回显网关实际发送的请求参数（见 EffectiveRequestParams）
+	EffectiveParams json.RawMessage
+	// EmptyChoicesMode 非流式响应 choices 为空（仅含 usage）时的处理方式（EmptyChoicesMode* 常量），默认返回空文本块
+	EmptyChoicesMode string
+	// EmptyStopMode 非流式响应 finish_reason 为 stop 但无文本、工具调用与 reasoning 时的处理方式（EmptyStopMode* 常量），默认返回空文本块
+	EmptyStopMode string
+	// RefusalMode 上游仅返回 refusal（无内容、无工具调用）时的处理方式（RefusalMode* 常量），默认以文本块 + end_turn 返回
+	RefusalMode string
+	// ToolNames 上游函数名 → Claude 原工具名（请求中不符合 OpenAI 规则的工具名会被改写，见 ToolNameMapping），
+	// 响应中的 tool call 名称按此还原
+	ToolNames map[string]string
+	// FinishReasonMap 自定义 finish_reason → stop_reason 映射（用于非标准上游，如以 "complete" 表示 "stop"），
+	// 优先于默认映射；仍以实际出现 tool_use 为准
+	FinishReasonMap map[string]string
+	// ResponseModelAliases 响应中 model 字段的别名映射（请求模型名 → 返回给客户端的模型名），
+	// 仅影响响应展示，不影响计费模型
+	ResponseModelAliases map[string]string
+	// RecordOutputText 为 true 时流式处理器记录上游输出的全部文本（含 thinking 与工具参数），
+	// 供上游未返回 usage 时估算输出 token
+	RecordOutputText bool
+	// MaxToolArgumentBytes 单个 tool call 累积 arguments 的最大字节数，0 表示不限制
+	MaxToolArgumentBytes int
+	// ToolArgumentOverflowMode arguments 超过 MaxToolArgumentBytes 时的处理方式（ToolArgumentOverflow* 常量），默认返回错误
+	ToolArgumentOverflowMode string
+	// MaxToolCalls 单个响应中 tool_use 块的最大数量，0 表示不限制
+	MaxToolCalls int
+	// ToolCallOverflowMode tool call 数量超过 MaxToolCalls 时的处理方式（ToolCallOverflow* 常量），默认丢弃超出部分
+	ToolCallOverflowMode string
+	// DuplicateToolIndexMode 同一流式 chunk 内 tool_calls index 重复时的处理方式（DuplicateToolIndex* 常量），默认重新分配 index
+	DuplicateToolIndexMode string
+	// SnapshotDeltas 为 true 时按快照解析流式 delta：上游每个 chunk 携带累计的完整文本（非标准实现），
+	// 仅输出相对已收到内容新增的部分，避免文本重复
+	SnapshotDeltas bool
+	// ResponsesAPI 为 true 时流式响应为 Responses API 事件流（非流式响应由调用方先经 ResponsesToChatResponse 改写）
+	ResponsesAPI bool
+	// CompletionsAPI 为 true 时流式响应为旧版 Completions 流（非流式响应由调用方先经 CompletionsToChatResponse 改写）
+	CompletionsAPI bool
+	// AnthropicBetas 客户端请求头 anthropic-beta 中的标志（ParseAnthropicBetas 解析），供按 beta 特性调整响应转换
+	AnthropicBetas []string
+	// AudioFormat 请求的音频输出格式，用于确定 openai_audio 块的 media_type（为空时按 wav 处理）
+	AudioFormat string
+}
+
+// tool call arguments 超限时的处理方式，避免向客户端下发异常巨大的工具输入
+const (
+	ToolArgumentOverflowError    = "error"    // 返回 ToolArgumentsTooLargeError（流式为 error 事件）（默认）
+	ToolArgumentOverflowTruncate = "truncate" // 截断至上限并补齐 JSON 闭合，记录警告日志
+)
+
+// ToolArgumentsTooLargeError tool call arguments 超过上限（ToolArgumentOverflowError 模式下返回）
+type ToolArgumentsTooLargeError struct {
+	ToolName string
+	Size     int
+	Limit    int
+}
+
+func (e *ToolArgumentsTooLargeError) Error() string {
+	return fmt.Sprintf("tool call %s arguments too large: %d bytes exceeds limit %d", e.ToolName, e.Size, e.Limit)
+}
+
+// tool call 数量超限时的处理方式，避免向客户端下发异常多的工具调用
+const (
+	ToolCallOverflowTruncate = "truncate" // 仅保留前 MaxToolCalls 个 tool call，丢弃其余并记录警告日志（默认）
+	ToolCallOverflowError    = "error"    // 返回 TooManyToolCallsError（流式为 error 事件）
+)
+
+// TooManyToolCallsError tool call 数量超过上限（ToolCallOverflowError 模式下返回）
+type TooManyToolCallsError struct {
+	Limit int
+}
+
+func (e *TooManyToolCallsError) Error() string {
+	return fmt.Sprintf("too many tool calls: exceeds limit %d", e.Limit)
+}
+
+// 同一流式 chunk 内多个 tool_calls 使用相同 index（上游实现错误）时的处理方式
+const (
+	DuplicateToolIndexReindex = "reindex" // 携带新 id 的重复项视为新的 tool call 并分配新的 index（默认）
+	DuplicateToolIndexError   = "error"   // 以 error 事件结束流
+)
+
+// DuplicateToolCallIndexError 同一 chunk 内出现重复的 tool_calls index（DuplicateToolIndexError 模式下返回）
+type DuplicateToolCallIndexError struct {
+	Index int
+}
+
+func (e *DuplicateToolCallIndexError) Error() string {
+	return fmt.Sprintf("duplicate tool call index %d in one stream chunk", e.Index)
+}
+
+// refusal 处理方式（Claude 没有与 OpenAI refusal 对应的 stop_reason）
+const (
+	RefusalModeEndTurn = "end_turn" // refusal 文本放入 text 块，stop_reason 为 end_turn（默认）
+	RefusalModeError   = "error"    // 返回 RefusalError（流式为 error 事件），由客户端按错误处理
+)
+
+// RefusalError 上游拒绝回答（RefusalModeError 模式下返回）
+type RefusalError struct {
+	Message string
+}
+
+func (e *RefusalError) Error() string {
+	return "upstream refused: " + e.Message
+}
+
+// choices 为空时的处理方式（常见于请求被完全缓存或被内容过滤）
+const (
+	EmptyChoicesModeText  = "text"  // 返回空文本块 + end_turn，并带上上游报告的 usage（默认）
+	EmptyChoicesModeError = "error" // 返回 ErrEmptyChoices，由调用方向客户端返回错误，usage 仍照常返回
+)
+
+// ErrEmptyChoices 上游响应 choices 为空（EmptyChoicesModeError 模式下返回）
+var ErrEmptyChoices = errors.New("upstream returned no choices")
+
+// ErrMissingChoices 上游响应缺少 choices 字段（或为 null），通常是错误或格式异常的响应，
+// 与 choices 为空数组不同，不受 EmptyChoicesMode 影响，始终返回该错误
+var ErrMissingChoices = errors.New("upstream response missing choices")
+
+// finish_reason 为 stop 但内容为空时的处理方式（空的 end_turn 通常意味着生成失败）
+const (
+	EmptyStopModeText  = "text"  // 返回空文本块 + end_turn（默认）
+	EmptyStopModeError = "error" // 返回 ErrEmptyCompletion，由调用方向客户端返回错误，usage 仍照常返回
+	EmptyStopModeRetry = "retry" // 返回 ErrEmptyCompletion，由调用方按临时性错误重试
+)
+
+// ErrEmptyCompletion 上游以 finish_reason stop 结束但未返回任何内容（EmptyStopModeError / EmptyStopModeRetry 模式下返回）
+var ErrEmptyCompletion = errors.New("upstream returned an empty completion")
+
+// DefaultResponseOptions 返回默认的响应转换选项
+func DefaultResponseOptions() ResponseOptions {
+	return ResponseOptions{}
+}
+
 // TransformOpenAIToClaude 将 OpenAI Chat Completions 响应转换为 Claude Messages API 格式
 func TransformOpenAIToClaude(body []byte, originalModel string) ([]byte, *antigravity.ClaudeUsage, error) {
+	return TransformOpenAIToClaudeWithOptions(body, originalModel, DefaultResponseOptions())
+}
+
+// TransformOpenAIToClaudeWithOptions 将 OpenAI Chat Completions 响应转换为 Claude Messages API 格式（可配置转换行为）
+func TransformOpenAIToClaudeWithOptions(body []byte, originalModel string, opts ResponseOptions) ([]byte, *antigravity.ClaudeUsage, error) {
 	var resp ChatResponse
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return nil, nil, fmt.Errorf("parse openai response: %w", err)
 	}
 
+	// 缺少 choices 字段：视为异常响应返回错误；choices 为空数组：按配置返回错误。usage 均按上游报告计费
+	if resp.Choices == nil {
+		return nil, extractUsage(resp.Usage), ErrMissingChoices
+	}
+	if len(resp.Choices) == 0 && opts.EmptyChoicesMode == EmptyChoicesModeError {
+		return nil, extractUsage(resp.Usage), ErrEmptyChoices
+	}
+
 	// 构建 Claude content blocks
 	var content []antigravity.ClaudeContentItem
 	var hasToolUse bool
 
 	if len(resp.Choices) > 0 {
 		msg := resp.Choices[0].Message
+		if len(msg.ToolCalls) == 0 && msg.FunctionCall != nil {
+			msg.ToolCalls = []ToolCall{legacyFunctionToolCall(*msg.FunctionCall)}
+		}
 
 		// Reasoning → Claude thinking block
 		// 支持 reasoning、reasoning_content 和 thinking 三种字段名
@@ -31,16 +204,17 @@ func TransformOpenAIToClaude(body []byte, originalModel string) ([]byte, *antigr
 		if reasoning == "" {
 			reasoning = msg.ReasoningContent
 		}
+		reasoning = opts.visibleReasoning(reasoning, msg.ReasoningDetails)
 		// 某些上游用 thinking 字段（带 signature）
 		var thinkingSignature string
 		if msg.ThinkingField != nil && msg.ThinkingField.Content != "" {
 			reasoning = msg.ThinkingField.Content
 			thinkingSignature = msg.ThinkingField.Signature
 		}
-		if reasoning != "" {
+		if reasoning != "" && !opts.HideThinking {
 			// 如果上游没返回 signature，生成一个假签名（Claude Code 多轮对话需要）
 			if thinkingSignature == "" {
-				thinkingSignature = generateFakeSignature()
+				thinkingSignature = opts.fakeSignature()
 			}
 			content = append(content, antigravity.ClaudeContentItem{
 				Type:      "thinking",
@@ -49,25 +223,95 @@ func TransformOpenAIToClaude(body []byte, originalModel string) ([]byte, *antigr
 			})
 		}
 
+		// 上游 web 搜索引用 → Claude web_search 结果块
+		content = append(content, buildWebSearchBlocks(msg.Annotations, make(map[string]struct{}))...)
+
 		// 文本内容
 		var textContent string
 		if len(msg.Content) > 0 {
 			_ = json.Unmarshal(msg.Content, &textContent)
 		}
-		if textContent != "" {
+		if textContent != "" && opts.TextToolProtocol && strings.Contains(textContent, textToolCallOpenTag) {
+			// 文本工具协议：拆分出工具调用
+			for _, seg := range splitTextToolCalls(textContent) {
+				if seg.Call == nil {
+					// 工具调用之间的纯空白文本不单独成块
+					if strings.TrimSpace(seg.Text) != "" {
+						content = append(content, antigravity.ClaudeContentItem{Type: "text", Text: seg.Text})
+					}
+					continue
+				}
+				hasToolUse = true
+				var input any
+				_ = json.Unmarshal(seg.Call.Arguments, &input)
+				if input == nil {
+					input = map[string]any{}
+				}
+				content = append(content, antigravity.ClaudeContentItem{
+					Type:  "tool_use",
+					ID:    "toolu_" + randomID(),
+					Name:  seg.Call.Name,
+					Input: input,
+				})
+			}
+		} else if textContent != "" && (strings.TrimSpace(textContent) != "" || len(msg.ToolCalls) == 0) {
+			// 伴随 tool_calls 的纯空白文本不单独成块
 			content = append(content, antigravity.ClaudeContentItem{
 				Type: "text",
 				Text: textContent,
 			})
 		}
 
+		// 仅有 refusal：按配置返回错误，或作为文本块让客户端看到拒绝原因
+		if msg.Refusal != "" && textContent == "" && len(msg.ToolCalls) == 0 {
+			if opts.RefusalMode == RefusalModeError {
+				return nil, extractUsage(resp.Usage), &RefusalError{Message: msg.Refusal}
+			}
+			content = append(content, antigravity.ClaudeContentItem{
+				Type: "text",
+				Text: msg.Refusal,
+			})
+		}
+
+		// 音频输出 → openai_audio 块（仅在请求了 audio 模态时上游才会返回）
+		if block, ok := buildAudioBlock(msg.Audio, opts.AudioFormat); ok {
+			content = append(content, block)
+		}
+
 		// Tool calls
-		for _, tc := range msg.ToolCalls {
+		toolCalls := msg.ToolCalls
+		if limit := opts.MaxToolCalls; limit > 0 && len(toolCalls) > limit {
+			if opts.ToolCallOverflowMode == ToolCallOverflowError {
+				return nil, extractUsage(resp.Usage), &TooManyToolCallsError{Limit: limit}
+			}
+			log.Printf("[OpenAICompat] dropped %d tool calls exceeding limit %d", len(toolCalls)-limit, limit)
+			toolCalls = toolCalls[:limit]
+		}
+		for i, tc := range toolCalls {
 			hasToolUse = true
 
+			// 部分上游返回的 tool call 缺少 id / 函数名：与流式处理一致，使用占位值
+			if tc.ID == "" {
+				tc.ID = placeholderToolCallID(i)
+			}
+			toolName := claudeToolName(tc.Function.Name, opts.ToolNames)
+			if toolName == "" {
+				toolName = placeholderToolName(i)
+				log.Printf("[OpenAICompat] tool call %s missing function name, using placeholder %s", tc.ID, toolName)
+			}
+
+			args := tc.Function.Arguments
+			if opts.MaxToolArgumentBytes > 0 && len(args) > opts.MaxToolArgumentBytes {
+				if opts.ToolArgumentOverflowMode != ToolArgumentOverflowTruncate {
+					return nil, extractUsage(resp.Usage), &ToolArgumentsTooLargeError{ToolName: toolName, Size: len(args), Limit: opts.MaxToolArgumentBytes}
+				}
+				log.Printf("[OpenAICompat] tool call %s (%s) arguments truncated: %d bytes exceeds limit %d", tc.ID, toolName, len(args), opts.MaxToolArgumentBytes)
+				args = truncateToolArguments(args, opts.MaxToolArgumentBytes)
+			}
+
 			var input any
-			if tc.Function.Arguments != "" {
-				_ = json.Unmarshal([]byte(tc.Function.Arguments), &input)
+			if args != "" {
+				_ = json.Unmarshal([]byte(args), &input)
 			}
 			if input == nil {
 				input = map[string]any{}
@@ -76,14 +320,18 @@ func TransformOpenAIToClaude(body []byte, originalModel string) ([]byte, *antigr
 			content = append(content, antigravity.ClaudeContentItem{
 				Type:  "tool_use",
 				ID:    tc.ID,
-				Name:  tc.Function.Name,
+				Name:  toolName,
 				Input: input,
 			})
 		}
 	}
 
-	// 如果没有任何内容，添加空文本块
+	// 如果没有任何内容，添加空文本块；finish_reason 为 stop 时可按配置视为失败的生成
 	if len(content) == 0 {
+		if len(resp.Choices) > 0 && resp.Choices[0].FinishReason == "stop" &&
+			(opts.EmptyStopMode == EmptyStopModeError || opts.EmptyStopMode == EmptyStopModeRetry) {
+			return nil, extractUsage(resp.Usage), ErrEmptyCompletion
+		}
 		content = append(content, antigravity.ClaudeContentItem{
 			Type: "text",
 			Text: "",
@@ -93,21 +341,29 @@ func TransformOpenAIToClaude(body []byte, originalModel string) ([]byte, *antigr
 	// 转换 finish_reason → stop_reason
 	stopReason := "end_turn"
 	if len(resp.Choices) > 0 {
-		stopReason = mapFinishReason(resp.Choices[0].FinishReason, hasToolUse)
+		stopReason = mapFinishReason(resp.Choices[0].FinishReason, hasToolUse, opts.FinishReasonMap)
 	}
 
 	// 提取 usage
 	usage := extractUsage(resp.Usage)
+	usage.ServiceTier = resp.ServiceTier
 
 	// 构建 Claude 响应
 	claudeResp := antigravity.ClaudeResponse{
-		ID:         convertID(resp.ID),
-		Type:       "message",
-		Role:       "assistant",
-		Model:      originalModel,
-		Content:    content,
-		StopReason: stopReason,
-		Usage:      *usage,
+		ID:                convertID(resp.ID),
+		Type:              "message",
+		Role:              "assistant",
+		Model:             responseModel(originalModel, opts.ResponseModelAliases),
+		Content:           content,
+		StopReason:        stopReason,
+		Usage:             *usage,
+		SystemFingerprint: resp.SystemFingerprint,
+		EffectiveParams:   opts.EffectiveParams,
+	}
+	if len(resp.Choices) > 0 {
+		if logprobs := resp.Choices[0].Logprobs; len(logprobs) > 0 && string(logprobs) != "null" {
+			claudeResp.OpenAILogprobs = logprobs
+		}
 	}
 
 	respBytes, err := json.Marshal(claudeResp)
@@ -118,15 +374,26 @@ func TransformOpenAIToClaude(body []byte, originalModel string) ([]byte, *antigr
 	return respBytes, usage, nil
 }
 
-// mapFinishReason 将 OpenAI finish_reason 映射为 Claude stop_reason
-func mapFinishReason(finishReason string, hasToolUse bool) string {
+// responseModel 返回响应中展示的模型名：命中别名映射时使用别名，否则为请求的模型名
+func responseModel(originalModel string, aliases map[string]string) string {
+	if alias, ok := aliases[originalModel]; ok && alias != "" {
+		return alias
+	}
+	return originalModel
+}
+
+// mapFinishReason 将 OpenAI finish_reason 映射为 Claude stop_reason，overrides 中的自定义映射优先于默认规则
+func mapFinishReason(finishReason string, hasToolUse bool, overrides map[string]string) string {
 	if hasToolUse {
 		return "tool_use"
 	}
+	if stopReason, ok := overrides[finishReason]; ok && stopReason != "" {
+		return stopReason
+	}
 	switch finishReason {
 	case "stop":
 		return "end_turn"
-	case "tool_calls":
+	case "tool_calls", "function_call":
 		return "tool_use"
 	case "length":
 		return "max_tokens"
@@ -137,23 +404,48 @@ func mapFinishReason(finishReason string, hasToolUse bool) string {
 	}
 }
 
+// legacyFunctionToolCall 将已废弃的 function_call 转换为 tool call（旧格式不携带 id，生成一个）
+func legacyFunctionToolCall(fc FunctionCall) ToolCall {
+	return ToolCall{ID: "call_" + randomID(), Type: "function", Function: fc}
+}
+
 // extractUsage 从 OpenAI usage 提取 Claude usage
-// input_tokens = prompt_tokens - cached_tokens，因为 Claude 的 input_tokens 不含 cached 部分
+// input_tokens = prompt_tokens - cached_tokens - 缓存写入 tokens，因为 Claude 的 input_tokens 不含缓存读写部分
+// 上游未返回缓存写入用量时 cache_creation_input_tokens 为 0
 func extractUsage(u *Usage) *antigravity.ClaudeUsage {
 	usage := &antigravity.ClaudeUsage{}
 	if u == nil {
 		return usage
 	}
 	cachedTokens := 0
+	cacheWriteTokens := u.CacheCreationInputTokens
 	if u.PromptTokensDetails != nil {
 		cachedTokens = u.PromptTokensDetails.CachedTokens
+		if u.PromptTokensDetails.CacheWriteTokens > cacheWriteTokens {
+			cacheWriteTokens = u.PromptTokensDetails.CacheWriteTokens
+		}
 	}
-	usage.InputTokens = u.PromptTokens - cachedTokens
+	usage.InputTokens = u.PromptTokens - cachedTokens - cacheWriteTokens
 	if usage.InputTokens < 0 {
 		usage.InputTokens = 0
 	}
 	usage.OutputTokens = u.CompletionTokens
 	usage.CacheReadInputTokens = cachedTokens
+	usage.CacheCreationInputTokens = cacheWriteTokens
+	if u.CompletionTokensDetails != nil {
+		usage.ReasoningTokens = u.CompletionTokensDetails.ReasoningTokens
+		usage.AcceptedPredictionTokens = u.CompletionTokensDetails.AcceptedPredictionTokens
+		usage.RejectedPredictionTokens = u.CompletionTokensDetails.RejectedPredictionTokens
+	}
+
+	// 服务端 web 搜索次数：OpenRouter 使用 server_tool_use.web_search_requests，Perplexity 使用 num_search_queries
+	webSearchRequests := u.NumSearchQueries
+	if u.ServerToolUse != nil && u.ServerToolUse.WebSearchRequests > webSearchRequests {
+		webSearchRequests = u.ServerToolUse.WebSearchRequests
+	}
+	if webSearchRequests > 0 {
+		usage.ServerToolUse = &antigravity.ServerToolUsage{WebSearchRequests: webSearchRequests}
+	}
 	return usage
 }
 
@@ -174,18 +466,50 @@ func randomID() string {
 	return string(b)
 }
 
+// fakeSignatureGenerator 默认格式的假签名生成器（流式与非流式共用），测试中可替换为固定值
+var fakeSignatureGenerator = randomFakeSignature
+
 // generateFakeSignature 为不返回 signature 的上游（DeepSeek、GLM 等）生成假签名
 // Claude Code 多轮对话时需要 thinking block 包含 signature
 func generateFakeSignature() string {
-	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return fakeSignatureGenerator()
 }
 
-// TransformOpenAIErrorToClaude 将 OpenAI 格式错误转换为 Claude 格式错误
+// randomFakeSignature 毫秒时间戳 + 随机后缀，保证并发请求及同一响应内多个 thinking block 的签名互不相同
+func randomFakeSignature() string {
+	buf := make([]byte, 8)
+	_, _ = cryptorand.Read(buf)
+	return strconv.FormatInt(time.Now().UnixMilli(), 10) + "_" + hex.EncodeToString(buf)
+}
+
+// 上游错误响应体不是 OpenAI 错误格式时的处理方式（部分异常代理出错时回显请求体，可能包含 system_prefix 等注入内容）
+const (
+	ErrorBodyModeStatusText = "status_text" // JSON 响应体以 HTTP 状态文本代替，不回显给客户端；纯文本 / HTML 错误页截断后返回（默认）
+	ErrorBodyModeRaw        = "raw"         // 任何非 OpenAI 错误格式的响应体均截断后作为错误信息返回
+)
+
+// TransformOpenAIErrorToClaude 将 OpenAI 格式错误转换为 Claude 格式错误（ErrorBodyModeStatusText 模式）
 func TransformOpenAIErrorToClaude(body []byte, statusCode int) []byte {
-	var openaiErr ErrorResponse
-	if err := json.Unmarshal(body, &openaiErr); err != nil || openaiErr.Error == nil {
-		// 无法解析，原样返回
-		return body
+	return TransformOpenAIErrorToClaudeWithMode(body, statusCode, ErrorBodyModeStatusText)
+}
+
+// TransformOpenAIErrorToClaudeWithMode 将 OpenAI 格式错误转换为 Claude 格式错误，
+// mode（ErrorBodyMode* 常量）决定非 OpenAI 错误格式响应体的处理方式，为空时按 ErrorBodyModeStatusText 处理
+func TransformOpenAIErrorToClaudeWithMode(body []byte, statusCode int, mode string) []byte {
+	var message string
+	if IsOpenAIErrorFormat(body) {
+		var openaiErr ErrorResponse
+		_ = json.Unmarshal(body, &openaiErr)
+		if openaiErr.Error != nil {
+			message = openaiErr.Error.Message
+		}
+	} else if mode == ErrorBodyModeRaw || !json.Valid(body) {
+		// 非 JSON 响应体（如 text/plain、HTML 网关错误页）或 raw 模式：以原始文本作为错误信息；
+		// 其余 JSON 响应体可能是回显的请求，默认以状态文本代替
+		message = truncateUTF8(strings.TrimSpace(string(body)), maxPlainErrorMessageBytes)
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
 	}
 
 	// 映射错误类型
@@ -195,7 +519,7 @@ func TransformOpenAIErrorToClaude(body []byte, statusCode int) []byte {
 		Type: "error",
 		Error: antigravity.ErrorDetail{
 			Type:    errType,
-			Message: openaiErr.Error.Message,
+			Message: message,
 		},
 	}
 
@@ -206,6 +530,9 @@ func TransformOpenAIErrorToClaude(body []byte, statusCode int) []byte {
 	return result
 }
 
+// maxPlainErrorMessageBytes 非 JSON 错误响应体作为错误信息时的最大字节数
+const maxPlainErrorMessageBytes = 2048
+
 // mapErrorType 根据 HTTP 状态码映射 Claude 错误类型
 func mapErrorType(statusCode int) string {
 	switch {
@@ -219,6 +546,8 @@ func mapErrorType(statusCode int) string {
 		return "not_found_error"
 	case statusCode == 429:
 		return "rate_limit_error"
+	case statusCode == 529 || statusCode == 503:
+		return "overloaded_error"
 	case statusCode >= 500:
 		return "api_error"
 	default:
@@ -235,7 +564,20 @@ func ExtractUsageFromBody(body []byte) *antigravity.ClaudeUsage {
 	return extractUsage(resp.Usage)
 }
 
-// IsOpenAIErrorFormat 检测响应体是否为 OpenAI 错误格式
+// IsOpenAIErrorFormat 检测响应体是否为 OpenAI 错误格式：顶层 error 为对象且带非空的字符串 message
+// 不做子串匹配，避免错误回显请求体的代理（内容中恰好含 error / message 字样）被误判为错误响应
 func IsOpenAIErrorFormat(body []byte) bool {
-	return strings.Contains(string(body), `"error"`) && strings.Contains(string(body), `"message"`)
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || len(payload.Error) == 0 {
+		return false
+	}
+	var detail struct {
+		Message *string `json:"message"`
+	}
+	if err := json.Unmarshal(payload.Error, &detail); err != nil || detail.Message == nil {
+		return false
+	}
+	return strings.TrimSpace(*detail.Message) != ""
 }
@@ -0,0 +1,607 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformOpenAIErrorToClaude_ErrorTypes(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       string
+	}{
+		{400, "invalid_request_error"},
+		{401, "authentication_error"},
+		{429, "rate_limit_error"},
+		{503, "overloaded_error"},
+		{529, "overloaded_error"},
+		{500, "api_error"},
+	}
+
+	body := []byte(`{"error":{"message":"boom","type":"server_error"}}`)
+	for _, tt := range tests {
+		var claudeErr antigravity.ClaudeError
+		require.NoError(t, json.Unmarshal(TransformOpenAIErrorToClaude(body, tt.statusCode), &claudeErr))
+		require.Equal(t, "error", claudeErr.Type)
+		require.Equal(t, tt.want, claudeErr.Error.Type, "status %d", tt.statusCode)
+		require.Equal(t, "boom", claudeErr.Error.Message)
+	}
+}
+
+func TestIsOpenAIErrorFormat(t *testing.T) {
+	require.True(t, IsOpenAIErrorFormat([]byte(`{"error":{"message":"Invalid API key","type":"invalid_request_error"}}`)))
+	require.True(t, IsOpenAIErrorFormat([]byte(` {"error":{"message":"boom","code":500}} `)))
+
+	notErrors := []string{
+		// 代理回显的请求体，内容中含 "error" / "message" 字样
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"\"error\" and \"message\""}],"metadata":{"message":"x","error":"y"}}`,
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"explain this error"}],"error":"x","message":"y"}`,
+		`{"error":"plain string error","message":"not an error object"}`,
+		`{"error":{"code":500}}`,
+		`{"error":{"message":""}}`,
+		`{"error":null}`,
+		`[{"error":{"message":"array"}}]`,
+		`not json "error" "message"`,
+	}
+	for _, body := range notErrors {
+		require.False(t, IsOpenAIErrorFormat([]byte(body)), body)
+	}
+}
+
+func TestTransformOpenAIErrorToClaude_PlainTextBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+		wantType   string
+		wantMsg    string
+	}{
+		{"plain text", "  Bad Gateway: upstream unavailable\n", 502, "api_error", "Bad Gateway: upstream unavailable"},
+		{"html page", "<html><body>503 Service Unavailable</body></html>", 503, "overloaded_error", "<html><body>503 Service Unavailable</body></html>"},
+		{"json without error", `{"detail":"bad key"}`, 401, "authentication_error", "Unauthorized"},
+		{"echoed request", `{"model":"m","messages":[{"role":"system","content":"secret"}],"error":"x","message":"y"}`, 400, "invalid_request_error", "Bad Request"},
+		{"empty body", "", 500, "api_error", "Internal Server Error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claudeErr antigravity.ClaudeError
+			require.NoError(t, json.Unmarshal(TransformOpenAIErrorToClaude([]byte(tt.body), tt.statusCode), &claudeErr))
+			require.Equal(t, "error", claudeErr.Type)
+			require.Equal(t, tt.wantType, claudeErr.Error.Type)
+			require.Equal(t, tt.wantMsg, claudeErr.Error.Message)
+		})
+	}
+
+	// raw 模式下 JSON 响应体同样原样返回
+	var rawErr antigravity.ClaudeError
+	require.NoError(t, json.Unmarshal(TransformOpenAIErrorToClaudeWithMode([]byte(`{"detail":"bad key"}`), 401, ErrorBodyModeRaw), &rawErr))
+	require.Equal(t, `{"detail":"bad key"}`, rawErr.Error.Message)
+
+	long := strings.Repeat("x", maxPlainErrorMessageBytes+100)
+	var claudeErr antigravity.ClaudeError
+	require.NoError(t, json.Unmarshal(TransformOpenAIErrorToClaude([]byte(long), 500), &claudeErr))
+	require.Len(t, claudeErr.Error.Message, maxPlainErrorMessageBytes)
+}
+
+func TestTransformOpenAIToClaude_HideThinking(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","reasoning_content":"secret plan","content":"answer"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":50,"total_tokens":60}}`)
+
+	visible, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var visibleResp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(visible, &visibleResp))
+	require.Len(t, visibleResp.Content, 2)
+	require.Equal(t, "thinking", visibleResp.Content[0].Type)
+
+	hidden, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{HideThinking: true})
+	require.NoError(t, err)
+	var hiddenResp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(hidden, &hiddenResp))
+	require.Len(t, hiddenResp.Content, 1)
+	require.Equal(t, "text", hiddenResp.Content[0].Type)
+	require.Equal(t, "answer", hiddenResp.Content[0].Text)
+	require.NotContains(t, string(hidden), "secret plan")
+
+	// reasoning tokens 仍计入 output_tokens
+	require.Equal(t, 50, usage.OutputTokens)
+	require.Equal(t, 50, hiddenResp.Usage.OutputTokens)
+}
+
+func TestTransformOpenAIToClaude_ObjectToolArguments(t *testing.T) {
+	for name, args := range map[string]string{
+		"string": `"{\"city\":\"Paris\",\"days\":2}"`,
+		"object": `{"city": "Paris", "days": 2}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":` + args + `}}]},"finish_reason":"tool_calls"}]}`)
+
+			out, _, err := TransformOpenAIToClaude(body, "claude-model")
+			require.NoError(t, err)
+			var resp antigravity.ClaudeResponse
+			require.NoError(t, json.Unmarshal(out, &resp))
+			require.Len(t, resp.Content, 1)
+			require.Equal(t, "tool_use", resp.Content[0].Type)
+			input, err := json.Marshal(resp.Content[0].Input)
+			require.NoError(t, err)
+			require.JSONEq(t, `{"city":"Paris","days":2}`, string(input))
+		})
+	}
+}
+
+func TestTransformOpenAIToClaude_LegacyFunctionCall(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"function_call":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},"finish_reason":"function_call"}]}`)
+
+	out, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "tool_use", resp.Content[0].Type)
+	require.Equal(t, "get_weather", resp.Content[0].Name)
+	require.NotEmpty(t, resp.Content[0].ID)
+	input, err := json.Marshal(resp.Content[0].Input)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"city":"Paris"}`, string(input))
+	require.Equal(t, "tool_use", resp.StopReason)
+
+	require.Equal(t, "tool_use", mapFinishReason("function_call", false, nil))
+}
+
+func TestTransformOpenAIToClaude_ToolCallMissingName(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+		{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{}"}},
+		{"type":"function","function":{"name":"","arguments":"{\"q\":1}"}}
+	]},"finish_reason":"tool_calls"}]}`)
+
+	out, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 2)
+	require.Equal(t, "get_weather", resp.Content[0].Name)
+	require.Equal(t, "tool_use", resp.Content[1].Type)
+	require.Equal(t, "tool_1", resp.Content[1].Name)
+	require.NotEmpty(t, resp.Content[1].ID)
+
+	// 与流式处理使用相同的占位名
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"","arguments":"{}"}}]}}]}`,
+		`data: [DONE]`,
+	)
+	for _, ev := range events {
+		if ev.Event == "content_block_start" {
+			block, _ := ev.Data["content_block"].(map[string]any)
+			require.Equal(t, "tool_1", block["name"])
+		}
+	}
+}
+
+func TestFunctionCall_UnmarshalArguments(t *testing.T) {
+	tests := map[string]string{
+		`{"name":"f","arguments":"{\"a\":1}"}`: `{"a":1}`,
+		`{"name":"f","arguments":{"a": 1}}`:    `{"a":1}`,
+		`{"name":"f","arguments":null}`:        ``,
+		`{"name":"f"}`:                         ``,
+		`{"name":"f","arguments":"{\"a\":"}`:   `{"a":`, // 流式分片原样保留
+	}
+	for raw, want := range tests {
+		var fc FunctionCall
+		require.NoError(t, json.Unmarshal([]byte(raw), &fc), raw)
+		require.Equal(t, "f", fc.Name)
+		require.Equal(t, want, fc.Arguments, raw)
+	}
+}
+
+func TestTransformOpenAIToClaude_EmptyChoicesWithUsage(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[],"usage":{"prompt_tokens":120,"completion_tokens":0,"total_tokens":120,"prompt_tokens_details":{"cached_tokens":100}}}`)
+
+	out, usage, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "text", resp.Content[0].Type)
+	require.Equal(t, "end_turn", resp.StopReason)
+	require.Equal(t, 20, resp.Usage.InputTokens)
+	require.Equal(t, 100, resp.Usage.CacheReadInputTokens)
+	require.Equal(t, 20, usage.InputTokens)
+
+	out, usage, err = TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{EmptyChoicesMode: EmptyChoicesModeError})
+	require.ErrorIs(t, err, ErrEmptyChoices)
+	require.Nil(t, out)
+	require.Equal(t, 20, usage.InputTokens)
+	require.Equal(t, 100, usage.CacheReadInputTokens)
+}
+
+func TestTransformOpenAIToClaude_CacheWriteTokens(t *testing.T) {
+	tests := map[string]string{
+		"openrouter": `{"prompt_tokens":1000,"completion_tokens":5,"total_tokens":1005,"prompt_tokens_details":{"cached_tokens":600,"cache_write_tokens":300}}`,
+		"litellm":    `{"prompt_tokens":1000,"completion_tokens":5,"total_tokens":1005,"prompt_tokens_details":{"cached_tokens":600},"cache_creation_input_tokens":300}`,
+	}
+	for name, rawUsage := range tests {
+		t.Run(name, func(t *testing.T) {
+			body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":` + rawUsage + `}`)
+			out, usage, err := TransformOpenAIToClaude(body, "claude-model")
+			require.NoError(t, err)
+			require.Equal(t, 100, usage.InputTokens)
+			require.Equal(t, 600, usage.CacheReadInputTokens)
+			require.Equal(t, 300, usage.CacheCreationInputTokens)
+			var resp antigravity.ClaudeResponse
+			require.NoError(t, json.Unmarshal(out, &resp))
+			require.Equal(t, 300, resp.Usage.CacheCreationInputTokens)
+		})
+	}
+
+	// 未返回缓存写入用量的上游：cache_creation_input_tokens 保持为 0
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1000,"completion_tokens":5,"total_tokens":1005,"prompt_tokens_details":{"cached_tokens":600}}}`)
+	_, usage, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.Equal(t, 400, usage.InputTokens)
+	require.Zero(t, usage.CacheCreationInputTokens)
+}
+
+func TestTransformOpenAIToClaude_ReasoningTokens(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"answer"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":50,"total_tokens":60,"completion_tokens_details":{"reasoning_tokens":42}}}`)
+
+	out, usage, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.Equal(t, 50, usage.OutputTokens)
+	require.Equal(t, 42, usage.ReasoningTokens)
+	// reasoning_tokens 仅用于计费，不出现在返回给客户端的 Claude usage 中
+	require.NotContains(t, string(out), "reasoning")
+
+	responsesBody := []byte(`{"id":"resp_1","object":"response","status":"completed","model":"m","output":[{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"answer"}]}],"usage":{"input_tokens":10,"output_tokens":50,"total_tokens":60,"output_tokens_details":{"reasoning_tokens":42}}}`)
+	chatBody, err := ResponsesToChatResponse(responsesBody)
+	require.NoError(t, err)
+	_, usage, err = TransformOpenAIToClaude(chatBody, "claude-model")
+	require.NoError(t, err)
+	require.Equal(t, 42, usage.ReasoningTokens)
+}
+
+func TestTransformOpenAIToClaude_EmptyStop(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":0,"total_tokens":12}}`)
+
+	t.Run("text", func(t *testing.T) {
+		out, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{EmptyStopMode: EmptyStopModeText})
+		require.NoError(t, err)
+		var resp antigravity.ClaudeResponse
+		require.NoError(t, json.Unmarshal(out, &resp))
+		require.Len(t, resp.Content, 1)
+		require.Equal(t, "text", resp.Content[0].Type)
+		require.Empty(t, resp.Content[0].Text)
+		require.Equal(t, "end_turn", resp.StopReason)
+		require.Equal(t, 12, usage.InputTokens)
+	})
+
+	for _, mode := range []string{EmptyStopModeError, EmptyStopModeRetry} {
+		t.Run(mode, func(t *testing.T) {
+			out, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{EmptyStopMode: mode})
+			require.ErrorIs(t, err, ErrEmptyCompletion)
+			require.Nil(t, out)
+			require.Equal(t, 12, usage.InputTokens)
+		})
+	}
+
+	t.Run("only stop is treated as empty", func(t *testing.T) {
+		length := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"length"}]}`)
+		_, _, err := TransformOpenAIToClaudeWithOptions(length, "claude-model", ResponseOptions{EmptyStopMode: EmptyStopModeError})
+		require.NoError(t, err)
+
+		reasoningOnly := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"","reasoning":"thought"},"finish_reason":"stop"}]}`)
+		_, _, err = TransformOpenAIToClaudeWithOptions(reasoningOnly, "claude-model", ResponseOptions{EmptyStopMode: EmptyStopModeError})
+		require.NoError(t, err)
+	})
+}
+
+func TestTransformOpenAIToClaude_RefusalOnly(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"refusal":"I can't help with that."},"finish_reason":"stop"}],"usage":{"prompt_tokens":15,"completion_tokens":6,"total_tokens":21}}`)
+
+	out, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "text", resp.Content[0].Type)
+	require.Equal(t, "I can't help with that.", resp.Content[0].Text)
+	require.Equal(t, "end_turn", resp.StopReason)
+
+	out, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{RefusalMode: RefusalModeError})
+	var refusalErr *RefusalError
+	require.ErrorAs(t, err, &refusalErr)
+	require.Equal(t, "I can't help with that.", refusalErr.Message)
+	require.Nil(t, out)
+	require.Equal(t, 6, usage.OutputTokens)
+}
+
+func TestTransformOpenAIToClaude_RefusalIgnoredWithContent(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"partial answer","refusal":"stopped"},"finish_reason":"stop"}]}`)
+
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{RefusalMode: RefusalModeError})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "partial answer", resp.Content[0].Text)
+}
+
+func TestTransformOpenAIToClaude_ToolArgumentsTooLarge(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"write","arguments":"{\"path\":\"/tmp/a\",\"data\":\"0123456789abcdef\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`)
+
+	// 未超限时不受影响
+	_, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolArgumentBytes: 1024})
+	require.NoError(t, err)
+
+	out, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolArgumentBytes: 26})
+	var tooLarge *ToolArgumentsTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, "write", tooLarge.ToolName)
+	require.Equal(t, 26, tooLarge.Limit)
+	require.Nil(t, out)
+	require.Equal(t, 20, usage.OutputTokens)
+
+	out, _, err = TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolArgumentBytes: 26, ToolArgumentOverflowMode: ToolArgumentOverflowTruncate})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "tool_use", resp.Content[0].Type)
+	require.Equal(t, map[string]any{"path": "/tmp/a", "data": "0"}, resp.Content[0].Input)
+}
+
+func TestTransformOpenAIToClaude_ToolArgumentsTooLargeUsesResolvedName(t *testing.T) {
+	// 缺少函数名时错误中使用占位名称，与流式处理一致
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"","arguments":"{\"path\":\"/tmp/a\",\"data\":\"0123456789abcdef\"}"}}]},"finish_reason":"tool_calls"}]}`)
+
+	_, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolArgumentBytes: 26})
+	var tooLarge *ToolArgumentsTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, placeholderToolName(0), tooLarge.ToolName)
+}
+
+func TestTransformOpenAIToClaude_MaxToolCalls(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[` +
+		`{"id":"call_1","type":"function","function":{"name":"a","arguments":"{}"}},` +
+		`{"id":"call_2","type":"function","function":{"name":"b","arguments":"{}"}},` +
+		`{"id":"call_3","type":"function","function":{"name":"c","arguments":"{}"}}` +
+		`]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`)
+
+	// 未超限时不受影响
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolCalls: 3})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 3)
+
+	// 默认丢弃超出部分
+	out, _, err = TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolCalls: 2})
+	require.NoError(t, err)
+	resp = antigravity.ClaudeResponse{}
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 2)
+	require.Equal(t, "call_1", resp.Content[0].ID)
+	require.Equal(t, "call_2", resp.Content[1].ID)
+	require.Equal(t, "tool_use", resp.StopReason)
+
+	out, usage, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{MaxToolCalls: 2, ToolCallOverflowMode: ToolCallOverflowError})
+	var tooMany *TooManyToolCallsError
+	require.ErrorAs(t, err, &tooMany)
+	require.Equal(t, 2, tooMany.Limit)
+	require.Nil(t, out)
+	require.Equal(t, 20, usage.OutputTokens)
+}
+
+func TestTruncateToolArguments(t *testing.T) {
+	require.Equal(t, `{"a":"你"}`, truncateToolArguments(`{"a":"你好"}`, 10))
+	require.Equal(t, `{"a":""}`, truncateToolArguments(`{"a":"你好"}`, 8))
+	require.Equal(t, `{}`, truncateToolArguments(`{"a":1}`, 0))
+}
+
+func TestTransformOpenAIToClaude_FinishReasonMap(t *testing.T) {
+	overrides := map[string]string{"complete": "end_turn", "max_output": "max_tokens"}
+	tests := []struct {
+		finishReason string
+		want         string
+	}{
+		{finishReason: "complete", want: "end_turn"},
+		{finishReason: "max_output", want: "max_tokens"},
+		{finishReason: "length", want: "max_tokens"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.finishReason, func(t *testing.T) {
+			body := []byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"` + tt.finishReason + `"}]}`)
+			out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{FinishReasonMap: overrides})
+			require.NoError(t, err)
+			var resp antigravity.ClaudeResponse
+			require.NoError(t, json.Unmarshal(out, &resp))
+			require.Equal(t, tt.want, resp.StopReason)
+
+			events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{FinishReasonMap: overrides}),
+				`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"}}]}`,
+				`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"`+tt.finishReason+`"}]}`,
+				`data: [DONE]`,
+			)
+			delta, _ := events[len(events)-2].Data["delta"].(map[string]any)
+			require.Equal(t, tt.want, delta["stop_reason"])
+		})
+	}
+
+	// 自定义映射不影响 tool_use 判定
+	body := []byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},"finish_reason":"complete"}]}`)
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{FinishReasonMap: overrides})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "tool_use", resp.StopReason)
+}
+
+func TestTransformOpenAIToClaude_ResponseModelAliases(t *testing.T) {
+	aliases := map[string]string{"gpt-4o": "claude-3-5-sonnet"}
+	body := []byte(`{"id":"c1","model":"gpt-4o-2024-08-06","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "gpt-4o", ResponseOptions{ResponseModelAliases: aliases})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "claude-3-5-sonnet", resp.Model)
+
+	// 未命中别名时保持请求的模型名
+	out, _, err = TransformOpenAIToClaudeWithOptions(body, "gpt-4o-mini", ResponseOptions{ResponseModelAliases: aliases})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "gpt-4o-mini", resp.Model)
+
+	events := runStream(t, NewStreamingProcessorWithOptions("gpt-4o", ResponseOptions{ResponseModelAliases: aliases}),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, "message_start", events[0].Event)
+	message, _ := events[0].Data["message"].(map[string]any)
+	require.Equal(t, "claude-3-5-sonnet", message["model"])
+}
+
+func TestTransformOpenAIToClaude_ServerToolUsage(t *testing.T) {
+	tests := map[string]string{
+		"openrouter": `{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15,"server_tool_use":{"web_search_requests":2}}`,
+		"perplexity": `{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15,"num_search_queries":2}`,
+	}
+	for name, rawUsage := range tests {
+		t.Run(name, func(t *testing.T) {
+			body := []byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":` + rawUsage + `}`)
+			out, usage, err := TransformOpenAIToClaude(body, "claude-model")
+			require.NoError(t, err)
+			require.NotNil(t, usage.ServerToolUse)
+			require.Equal(t, 2, usage.ServerToolUse.WebSearchRequests)
+
+			var resp map[string]any
+			require.NoError(t, json.Unmarshal(out, &resp))
+			respUsage, _ := resp["usage"].(map[string]any)
+			require.Equal(t, map[string]any{"web_search_requests": float64(2)}, respUsage["server_tool_use"])
+		})
+	}
+
+	// 未使用服务端工具时不输出 server_tool_use
+	body := []byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	out, usage, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.Nil(t, usage.ServerToolUse)
+	require.NotContains(t, string(out), "server_tool_use")
+
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15,"server_tool_use":{"web_search_requests":1}}}`,
+		`data: [DONE]`,
+	)
+	deltaUsage, _ := events[len(events)-2].Data["usage"].(map[string]any)
+	require.Equal(t, map[string]any{"web_search_requests": float64(1)}, deltaUsage["server_tool_use"])
+}
+
+func TestTransformOpenAIToClaude_NoStrayEmptyTextBlock(t *testing.T) {
+	for name, rawContent := range map[string]string{
+		"null":       `null`,
+		"empty":      `""`,
+		"whitespace": `"\n\n"`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := []byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":` + rawContent + `,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`)
+			out, _, err := TransformOpenAIToClaude(body, "claude-model")
+			require.NoError(t, err)
+			var resp antigravity.ClaudeResponse
+			require.NoError(t, json.Unmarshal(out, &resp))
+			require.Len(t, resp.Content, 1)
+			require.Equal(t, "tool_use", resp.Content[0].Type)
+		})
+	}
+
+	// 没有任何内容时仍保留必需的空文本块
+	body := []byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}]}`)
+	out, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "text", resp.Content[0].Type)
+	require.Empty(t, resp.Content[0].Text)
+}
+
+func TestTransformOpenAIToClaude_Logprobs(t *testing.T) {
+	logprobs := `{"content":[{"token":"ok","logprob":-0.01,"bytes":[111,107],"top_logprobs":[]}]}`
+	body := []byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"logprobs":` + logprobs + `,"finish_reason":"stop"}]}`)
+	out, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	var resp map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.JSONEq(t, logprobs, string(resp["openai_logprobs"]))
+
+	// 未请求 logprobs（null）时不输出扩展字段
+	body = []byte(`{"id":"c1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"logprobs":null,"finish_reason":"stop"}]}`)
+	out, _, err = TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "openai_logprobs")
+
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"},"logprobs":{"content":[{"token":"Hel","logprob":-0.1}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"lo"},"logprobs":{"content":[{"token":"lo","logprob":-0.2}]},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	var got []any
+	for _, ev := range events {
+		if ev.Event != "content_block_delta" {
+			continue
+		}
+		lp, _ := ev.Data["openai_logprobs"].(map[string]any)
+		require.NotNil(t, lp)
+		got = append(got, lp["content"].([]any)...)
+	}
+	require.Len(t, got, 2)
+	require.Equal(t, "Hel", got[0].(map[string]any)["token"])
+	require.Equal(t, "lo", got[1].(map[string]any)["token"])
+}
+
+func TestTransformOpenAIToClaude_MissingChoices(t *testing.T) {
+	for name, body := range map[string]string{
+		"absent": `{"id":"chatcmpl-1","model":"m","usage":{"prompt_tokens":12,"completion_tokens":0,"total_tokens":12}}`,
+		"null":   `{"id":"chatcmpl-1","model":"m","choices":null,"usage":{"prompt_tokens":12,"completion_tokens":0,"total_tokens":12}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// 缺少 choices 始终返回错误，不受 EmptyChoicesMode 影响
+			out, usage, err := TransformOpenAIToClaude([]byte(body), "claude-model")
+			require.ErrorIs(t, err, ErrMissingChoices)
+			require.Nil(t, out)
+			require.Equal(t, 12, usage.InputTokens)
+		})
+	}
+}
+
+func TestGenerateFakeSignature(t *testing.T) {
+	// 默认生成器带随机后缀，同一毫秒内生成的签名也互不相同
+	seen := make(map[string]struct{})
+	for range 100 {
+		sig := generateFakeSignature()
+		require.NotContains(t, seen, sig)
+		seen[sig] = struct{}{}
+	}
+
+	// 流式与非流式共用同一生成器
+	restore := fakeSignatureGenerator
+	fakeSignatureGenerator = func() string { return "sig-test" }
+	defer func() { fakeSignatureGenerator = restore }()
+
+	out, _, err := TransformOpenAIToClaude([]byte(`{"id":"c","choices":[{"index":0,"message":{"role":"assistant","reasoning":"hmm","content":"ok"},"finish_reason":"stop"}]}`), "claude-model")
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "sig-test", resp.Content[0].Signature)
+
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c","choices":[{"index":0,"delta":{"reasoning":"hmm"}}]}`,
+		`data: {"id":"c","choices":[{"index":0,"delta":{"content":"ok"},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, map[int][]string{0: {"sig-test"}}, blockSignatures(events))
+}
@@ -0,0 +1,371 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// 上游接口类型（账号 extra.api）
+const (
+	UpstreamAPIChatCompletions = "chat_completions" // /chat/completions（默认）
+	UpstreamAPIResponses       = "responses"        // /responses（OpenAI Responses API）
+	UpstreamAPICompletions     = "completions"      // /completions（旧版文本补全 API，仅支持纯文本）
+)
+
+// ResponsesRequest OpenAI Responses API 请求
+// 由 Chat Completions 请求改写而来：system 写入 instructions，消息、tool call 与 tool 结果转为 input 条目；
+// Responses API 不支持的 seed / top_k / logprobs / OpenRouter 插件不下发
+type ResponsesRequest struct {
+	Model             string               `json:"model"`
+	Instructions      string               `json:"instructions,omitempty"`
+	Input             []ResponsesInputItem `json:"input"`
+	MaxOutputTokens   int                  `json:"max_output_tokens,omitempty"`
+	Temperature       *float64             `json:"temperature,omitempty"`
+	TopP              *float64             `json:"top_p,omitempty"`
+	ServiceTier       string               `json:"service_tier,omitempty"`
+	Stream            bool                 `json:"stream,omitempty"`
+	Tools             []ResponsesTool      `json:"tools,omitempty"`
+	ToolChoice        any                  `json:"tool_choice,omitempty"` // "auto" / "none" / "required" 或 {"type":"function","name":...}
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	Reasoning         *ResponsesReasoning  `json:"reasoning,omitempty"`
+	Store             bool                 `json:"store"` // 始终为 false：网关每次发送完整上下文，不依赖上游保存的响应
+	// PromptCacheRetention 提示缓存保留时长，与 Chat Completions 字段一致
+	PromptCacheRetention string `json:"prompt_cache_retention,omitempty"`
+}
+
+// ResponsesInputItem Responses API input 条目：消息、函数调用或函数调用结果
+type ResponsesInputItem struct {
+	Type      string          `json:"type"` // "message" / "function_call" / "function_call_output"
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallID    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    *string         `json:"output,omitempty"` // function_call_output 必须携带（可为空字符串）
+}
+
+// ResponsesTool Responses API 函数工具定义（字段平铺，不嵌套 function）
+type ResponsesTool struct {
+	Type        string         `json:"type"` // "function"
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// ResponsesReasoning Responses API reasoning 配置，summary 为 auto 时上游返回推理摘要（转换为 Claude thinking）
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// ResponsesResponse OpenAI Responses API 响应（流式 response.completed 等事件中的 response 对象结构相同）
+type ResponsesResponse struct {
+	ID                string                      `json:"id"`
+	Model             string                      `json:"model"`
+	Status            string                      `json:"status"` // completed / incomplete / failed
+	ServiceTier       string                      `json:"service_tier,omitempty"`
+	Output            []ResponsesOutputItem       `json:"output"`
+	IncompleteDetails *ResponsesIncompleteDetails `json:"incomplete_details,omitempty"`
+	Error             *ResponsesError             `json:"error,omitempty"`
+	Usage             *ResponsesUsage             `json:"usage,omitempty"`
+}
+
+// ResponsesOutputItem Responses API 输出条目
+type ResponsesOutputItem struct {
+	Type      string                   `json:"type"` // "message" / "reasoning" / "function_call"
+	ID        string                   `json:"id,omitempty"`
+	Role      string                   `json:"role,omitempty"`
+	Content   []ResponsesOutputContent `json:"content,omitempty"` // message: output_text / refusal；reasoning: reasoning_text
+	Summary   []ResponsesOutputContent `json:"summary,omitempty"` // reasoning: summary_text
+	CallID    string                   `json:"call_id,omitempty"`
+	Name      string                   `json:"name,omitempty"`
+	Arguments string                   `json:"arguments,omitempty"`
+}
+
+// ResponsesOutputContent 输出条目中的内容块
+type ResponsesOutputContent struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Refusal string `json:"refusal,omitempty"`
+}
+
+// ResponsesIncompleteDetails 响应未完成的原因
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"` // "max_output_tokens" / "content_filter"
+}
+
+// ResponsesError 响应失败时的错误
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesUsage Responses API 用量（input_tokens 含缓存命中部分）
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	TotalTokens        int `json:"total_tokens"`
+	InputTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details,omitempty"`
+	OutputTokensDetails *struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details,omitempty"`
+}
+
+// TransformClaudeToResponsesWithOptions 将 Claude Messages API 请求转换为 OpenAI Responses API 格式
+// 先按 Chat Completions 规则转换（system 合并、tool_result、图片、工具名清洗等行为保持一致），再改写为 Responses 结构
+func TransformClaudeToResponsesWithOptions(claudeReq *antigravity.ClaudeRequest, opts RequestOptions) ([]byte, []TransformWarning, error) {
+	chatReq, warnings, err := buildChatRequest(claudeReq, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := json.Marshal(chatToResponsesRequest(chatReq, opts))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, warnings, nil
+}
+
+// chatToResponsesRequest 将 Chat Completions 请求改写为 Responses API 请求
+func chatToResponsesRequest(req *ChatRequest, opts RequestOptions) *ResponsesRequest {
+	out := &ResponsesRequest{
+		Model:             req.Model,
+		MaxOutputTokens:   req.MaxTokens,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		ServiceTier:       req.ServiceTier,
+		Stream:            req.Stream,
+		ParallelToolCalls: req.ParallelToolCalls,
+		Input:             []ResponsesInputItem{},
+	}
+	out.PromptCacheRetention = req.PromptCacheRetention
+
+	var instructions []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			if text := responsesContentText(msg.Content); text != "" {
+				instructions = append(instructions, text)
+			}
+		case "tool":
+			output := responsesContentText(msg.Content)
+			out.Input = append(out.Input, ResponsesInputItem{Type: "function_call_output", CallID: msg.ToolCallID, Output: &output})
+		case "assistant":
+			// 历史 reasoning 需要上游签发的加密内容才能回传，此处不下发
+			if parts := responsesContentParts(msg.Content, "output_text"); len(parts) > 0 {
+				out.Input = append(out.Input, ResponsesInputItem{Type: "message", Role: "assistant", Content: parts})
+			}
+			for _, tc := range msg.ToolCalls {
+				out.Input = append(out.Input, ResponsesInputItem{
+					Type:      "function_call",
+					CallID:    tc.ID,
+					Name:      tc.Function.Name,
+					Arguments: tc.Function.Arguments,
+				})
+			}
+		default:
+			if parts := responsesContentParts(msg.Content, "input_text"); len(parts) > 0 {
+				out.Input = append(out.Input, ResponsesInputItem{Type: "message", Role: msg.Role, Content: parts})
+			}
+		}
+	}
+	out.Instructions = strings.Join(instructions, "\n\n")
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, ResponsesTool{
+			Type:        "function",
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+	out.ToolChoice = responsesToolChoice(req.ToolChoice)
+
+	if req.Reasoning != nil {
+		effort := req.Reasoning.Effort
+		if effort == "" {
+			// Responses API 仅支持 effort，推理预算按阈值映射
+			effort = reasoningEffortForBudget(req.Reasoning.MaxTokens, opts.ReasoningEffortThresholds, supportsMinimalReasoningEffort(req.Model))
+		}
+		out.Reasoning = &ResponsesReasoning{Effort: effort, Summary: "auto"}
+	}
+	return out
+}
+
+// responsesToolChoice 将 Chat Completions tool_choice 改写为 Responses 格式（指定函数时 name 平铺）
+func responsesToolChoice(choice any) any {
+	obj, ok := choice.(map[string]any)
+	if !ok {
+		return choice
+	}
+	if fn, ok := obj["function"].(map[string]string); ok {
+		return map[string]any{"type": "function", "name": fn["name"]}
+	}
+	return choice
+}
+
+// responsesContentParts 将 Chat Completions content（字符串或内容块数组，兼容两种命名）转换为 Responses 内容块
+// textType 为文本块类型：用户消息为 input_text，assistant 消息为 output_text
+func responsesContentParts(content json.RawMessage, textType string) json.RawMessage {
+	var parts []flatImageContentPart
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if text != "" {
+			parts = append(parts, flatImageContentPart{Type: textType, Text: text})
+		}
+	} else {
+		var raw []map[string]json.RawMessage
+		_ = json.Unmarshal(content, &raw)
+		for _, part := range raw {
+			var partType string
+			_ = json.Unmarshal(part["type"], &partType)
+			switch partType {
+			case contentPartText, "input_text", "output_text":
+				var partText string
+				_ = json.Unmarshal(part["text"], &partText)
+				parts = append(parts, flatImageContentPart{Type: textType, Text: partText})
+			case contentPartImage, "input_image":
+				var image ImageURL
+				if json.Unmarshal(part["image_url"], &image) != nil {
+					_ = json.Unmarshal(part["image_url"], &image.URL)
+				}
+				parts = append(parts, flatImageContentPart{Type: "input_image", ImageURL: image.URL, Detail: image.Detail})
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(parts)
+	return data
+}
+
+// responsesContentText 提取 Chat Completions content 中的文本（内容块按换行拼接）
+func responsesContentText(content json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(content, &parts)
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ResponsesToChatResponse 将 Responses API 非流式响应改写为 Chat Completions 响应，
+// 之后可按 Chat Completions 响应统一处理（错误包装检测、结构校验、TransformOpenAIToClaudeWithOptions）；
+// 响应失败（status failed）时改写为 {"error": {...}} 错误响应
+func ResponsesToChatResponse(body []byte) ([]byte, error) {
+	var resp ResponsesResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse responses api response: %w", err)
+	}
+	if resp.Error != nil && resp.Error.Message != "" {
+		return json.Marshal(ErrorResponse{Error: &ErrorDetail{Message: resp.Error.Message, Type: resp.Error.Code, Code: resp.Error.Code}})
+	}
+	return json.Marshal(responsesToChatResponse(&resp))
+}
+
+// responsesToChatResponse 合并 Responses 输出条目为单个 Chat Completions choice
+func responsesToChatResponse(resp *ResponsesResponse) *ChatResponse {
+	var text, reasoning, refusal strings.Builder
+	msg := ChatMessage{Role: "assistant"}
+	for _, item := range resp.Output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				switch part.Type {
+				case "output_text":
+					text.WriteString(part.Text)
+				case "refusal":
+					refusal.WriteString(part.Refusal)
+				}
+			}
+		case "reasoning":
+			// 摘要与完整推理分别记录到 reasoning_details，供按 ReasoningOutput 过滤摘要
+			for _, part := range item.Summary {
+				if part.Text != "" {
+					appendReasoning(&reasoning, part.Text)
+					msg.ReasoningDetails = append(msg.ReasoningDetails, ReasoningDetail{Type: reasoningDetailSummary, Summary: part.Text})
+				}
+			}
+			for _, part := range item.Content {
+				if part.Text != "" {
+					appendReasoning(&reasoning, part.Text)
+					msg.ReasoningDetails = append(msg.ReasoningDetails, ReasoningDetail{Type: reasoningDetailText, Text: part.Text})
+				}
+			}
+		case "function_call":
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: FunctionCall{Name: item.Name, Arguments: item.Arguments},
+			})
+		}
+	}
+	msg.Content, _ = json.Marshal(text.String())
+	msg.Reasoning = reasoning.String()
+	msg.Refusal = refusal.String()
+
+	return &ChatResponse{
+		ID:          resp.ID,
+		Object:      "chat.completion",
+		Model:       resp.Model,
+		ServiceTier: resp.ServiceTier,
+		Choices: []ChatChoice{{
+			Message:      msg,
+			FinishReason: responsesFinishReason(resp, len(msg.ToolCalls) > 0),
+		}},
+		Usage: responsesChatUsage(resp.Usage),
+	}
+}
+
+// appendReasoning 追加一段推理文本，段与段之间空一行
+func appendReasoning(b *strings.Builder, text string) {
+	if b.Len() > 0 {
+		b.WriteString("\n\n")
+	}
+	b.WriteString(text)
+}
+
+// responsesFinishReason 按响应状态与输出推断 Chat Completions finish_reason
+func responsesFinishReason(resp *ResponsesResponse, hasToolCalls bool) string {
+	if resp.Status == "incomplete" && resp.IncompleteDetails != nil {
+		switch resp.IncompleteDetails.Reason {
+		case "max_output_tokens":
+			return "length"
+		case "content_filter":
+			return "content_filter"
+		}
+	}
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// responsesChatUsage 将 Responses 用量改写为 Chat Completions 用量
+func responsesChatUsage(u *ResponsesUsage) *Usage {
+	if u == nil {
+		return nil
+	}
+	usage := &Usage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+	if u.InputTokensDetails != nil && u.InputTokensDetails.CachedTokens > 0 {
+		usage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: u.InputTokensDetails.CachedTokens}
+	}
+	if u.OutputTokensDetails != nil && u.OutputTokensDetails.ReasoningTokens > 0 {
+		usage.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: u.OutputTokensDetails.ReasoningTokens}
+	}
+	return usage
+}
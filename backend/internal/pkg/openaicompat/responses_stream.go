@@ -0,0 +1,143 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// responsesStreamState Responses API 流式事件转换状态
+type responsesStreamState struct {
+	id           string
+	model        string
+	started      bool
+	toolIndex    map[int]int // 上游 output_index → tool_calls index
+	hasToolCalls bool
+}
+
+// responsesStreamEvent Responses API 流式事件（data 行），按 type 区分
+type responsesStreamEvent struct {
+	Type        string               `json:"type"`
+	OutputIndex int                  `json:"output_index"`
+	Delta       string               `json:"delta"`
+	Item        *ResponsesOutputItem `json:"item,omitempty"`
+	Response    *ResponsesResponse   `json:"response,omitempty"`
+	Code        string               `json:"code,omitempty"`    // type 为 error 时
+	Message     string               `json:"message,omitempty"` // type 为 error 时
+}
+
+// processResponsesLine 将一行 Responses API SSE 数据改写为 Chat Completions chunk 后交给 processChatLine 处理，
+// 复用 Chat Completions 流的全部转换逻辑（thinking、tool call、usage、finish_reason 等）
+func (p *StreamingProcessor) processResponsesLine(line string) []byte {
+	line = strings.TrimSpace(line)
+	// event: 行与 data 中的 type 重复，仅按 data 处理
+	if !strings.HasPrefix(line, "data:") {
+		return nil
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return p.processChatLine("data: [DONE]")
+	}
+	var ev responsesStreamEvent
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		return nil
+	}
+
+	var result bytes.Buffer
+	for _, chatLine := range p.responsesEventToChatLines(&ev) {
+		result.Write(p.processChatLine(chatLine))
+	}
+	return result.Bytes()
+}
+
+// responsesEventToChatLines 将单个 Responses 事件改写为等价的 Chat Completions data 行（未识别的事件忽略）
+func (p *StreamingProcessor) responsesEventToChatLines(ev *responsesStreamEvent) []string {
+	st := p.responses
+	if st == nil {
+		st = &responsesStreamState{toolIndex: make(map[int]int)}
+		p.responses = st
+	}
+	if ev.Response != nil {
+		if ev.Response.ID != "" {
+			st.id = ev.Response.ID
+		}
+		if ev.Response.Model != "" {
+			st.model = ev.Response.Model
+		}
+	}
+
+	var lines []string
+	delta := func(d StreamChunkDelta) {
+		chunk := StreamChunk{ID: st.id, Object: "chat.completion.chunk", Model: st.model, Choices: []StreamChunkChoice{{Delta: d}}}
+		lines = append(lines, chatDataLine(chunk))
+	}
+	if !st.started && ev.Type != "error" && ev.Type != "response.failed" {
+		st.started = true
+		delta(StreamChunkDelta{Role: "assistant"})
+	}
+
+	switch ev.Type {
+	case "response.output_text.delta":
+		delta(StreamChunkDelta{Content: ev.Delta})
+	case "response.reasoning_summary_text.delta":
+		delta(StreamChunkDelta{Reasoning: ev.Delta, ReasoningDetails: []ReasoningDetail{{Type: reasoningDetailSummary, Summary: ev.Delta}}})
+	case "response.reasoning_text.delta":
+		delta(StreamChunkDelta{Reasoning: ev.Delta, ReasoningDetails: []ReasoningDetail{{Type: reasoningDetailText, Text: ev.Delta}}})
+	case "response.refusal.delta":
+		delta(StreamChunkDelta{Refusal: ev.Delta})
+	case "response.output_item.added":
+		if ev.Item != nil && ev.Item.Type == "function_call" {
+			index := len(st.toolIndex)
+			st.toolIndex[ev.OutputIndex] = index
+			st.hasToolCalls = true
+			delta(StreamChunkDelta{ToolCalls: []ToolCall{{
+				Index:    index,
+				ID:       ev.Item.CallID,
+				Type:     "function",
+				Function: FunctionCall{Name: ev.Item.Name, Arguments: ev.Item.Arguments},
+			}}})
+		}
+	case "response.function_call_arguments.delta":
+		if index, ok := st.toolIndex[ev.OutputIndex]; ok {
+			delta(StreamChunkDelta{ToolCalls: []ToolCall{{Index: index, Function: FunctionCall{Arguments: ev.Delta}}}})
+		}
+	case "response.completed", "response.incomplete":
+		if ev.Response == nil {
+			break
+		}
+		finishReason := responsesFinishReason(ev.Response, st.hasToolCalls)
+		chunk := StreamChunk{
+			ID:          st.id,
+			Object:      "chat.completion.chunk",
+			Model:       st.model,
+			ServiceTier: ev.Response.ServiceTier,
+			Choices:     []StreamChunkChoice{{FinishReason: &finishReason}},
+			Usage:       responsesChatUsage(ev.Response.Usage),
+		}
+		lines = append(lines, chatDataLine(chunk), "data: [DONE]")
+	case "response.failed":
+		detail := ErrorDetail{Message: "upstream response failed"}
+		if ev.Response != nil && ev.Response.Error != nil {
+			detail = ErrorDetail{Message: ev.Response.Error.Message, Type: ev.Response.Error.Code, Code: ev.Response.Error.Code}
+		}
+		lines = append(lines, chatErrorLine(detail))
+	case "error":
+		lines = append(lines, chatErrorLine(ErrorDetail{Message: ev.Message, Type: ev.Code, Code: ev.Code}))
+	}
+	return lines
+}
+
+// chatDataLine 序列化 Chat Completions chunk 为 SSE data 行
+func chatDataLine(chunk StreamChunk) string {
+	data, _ := json.Marshal(chunk)
+	return "data: " + string(data)
+}
+
+// chatErrorLine 生成携带 error 对象的 SSE data 行（由 upstreamErrorEvent 转换为 Claude error 事件）
+func chatErrorLine(detail ErrorDetail) string {
+	if detail.Message == "" {
+		detail.Message = "upstream stream error"
+	}
+	data, _ := json.Marshal(ErrorResponse{Error: &detail})
+	return "data: " + string(data)
+}
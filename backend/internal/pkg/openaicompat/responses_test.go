@@ -0,0 +1,199 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// transformResponsesRequest 将 Claude 请求 JSON 转换为 Responses API 请求
+func transformResponsesRequest(t *testing.T, claudeJSON string) ResponsesRequest {
+	t.Helper()
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+	body, _, err := TransformClaudeToResponsesWithOptions(&claudeReq, DefaultRequestOptions())
+	require.NoError(t, err)
+
+	var req ResponsesRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.NotContains(t, string(body), `"messages"`)
+	return req
+}
+
+func TestTransformClaudeToResponses_Text(t *testing.T) {
+	req := transformResponsesRequest(t, `{"model":"gpt-5","max_tokens":512,"stream":true,"system":"be brief","temperature":0.2,
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"describe"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}}]},
+			{"role":"assistant","content":"a square"},
+			{"role":"user","content":"thanks"}
+		]}`)
+
+	require.Equal(t, "gpt-5", req.Model)
+	require.Equal(t, "be brief", req.Instructions)
+	require.Equal(t, 512, req.MaxOutputTokens)
+	require.True(t, req.Stream)
+	require.False(t, req.Store)
+	require.NotNil(t, req.Temperature)
+	require.Len(t, req.Input, 3)
+
+	var parts []flatImageContentPart
+	require.NoError(t, json.Unmarshal(req.Input[0].Content, &parts))
+	require.Equal(t, "message", req.Input[0].Type)
+	require.Equal(t, "user", req.Input[0].Role)
+	require.Equal(t, []flatImageContentPart{
+		{Type: "input_text", Text: "describe"},
+		{Type: "input_image", ImageURL: "data:image/png;base64,AAAA"},
+	}, parts)
+
+	require.NoError(t, json.Unmarshal(req.Input[1].Content, &parts))
+	require.Equal(t, "assistant", req.Input[1].Role)
+	require.Equal(t, []flatImageContentPart{{Type: "output_text", Text: "a square"}}, parts)
+}
+
+func TestTransformClaudeToResponses_Tools(t *testing.T) {
+	req := transformResponsesRequest(t, `{"model":"gpt-5","max_tokens":512,
+		"tools":[{"name":"get weather","description":"weather lookup","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}],
+		"tool_choice":{"type":"tool","name":"get weather","disable_parallel_tool_use":true},
+		"messages":[
+			{"role":"user","content":"weather in Paris?"},
+			{"role":"assistant","content":[{"type":"text","text":"checking"},{"type":"tool_use","id":"call_1","name":"get weather","input":{"city":"Paris"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"sunny"}]}
+		]}`)
+
+	require.Len(t, req.Tools, 1)
+	require.Equal(t, ResponsesTool{
+		Type:        "function",
+		Name:        "get_weather",
+		Description: "weather lookup",
+		Parameters:  req.Tools[0].Parameters,
+	}, req.Tools[0])
+	require.Equal(t, "object", req.Tools[0].Parameters["type"])
+	require.Equal(t, map[string]any{"type": "function", "name": "get_weather"}, req.ToolChoice)
+	require.NotNil(t, req.ParallelToolCalls)
+	require.False(t, *req.ParallelToolCalls)
+
+	require.Len(t, req.Input, 4)
+	require.Equal(t, "assistant", req.Input[1].Role)
+	call := req.Input[2]
+	require.Equal(t, "function_call", call.Type)
+	require.Equal(t, "call_1", call.CallID)
+	require.Equal(t, "get_weather", call.Name)
+	require.JSONEq(t, `{"city":"Paris"}`, call.Arguments)
+	output := req.Input[3]
+	require.Equal(t, "function_call_output", output.Type)
+	require.Equal(t, "call_1", output.CallID)
+	require.NotNil(t, output.Output)
+	require.Equal(t, "sunny", *output.Output)
+}
+
+func TestTransformClaudeToResponses_Reasoning(t *testing.T) {
+	req := transformResponsesRequest(t, `{"model":"gpt-5","max_tokens":32000,"thinking":{"type":"enabled","budget_tokens":20000},
+		"messages":[{"role":"user","content":"think"}]}`)
+	require.NotNil(t, req.Reasoning)
+	require.Equal(t, "high", req.Reasoning.Effort)
+	require.Equal(t, "auto", req.Reasoning.Summary)
+
+	// 仅有推理预算（reasoning_mode=budget）时按阈值映射为 effort
+	chatReq := &ChatRequest{Model: "gpt-5", Reasoning: &ReasoningConfig{MaxTokens: 1000}}
+	require.Equal(t, "minimal", chatToResponsesRequest(chatReq, RequestOptions{}).Reasoning.Effort)
+}
+
+func TestResponsesToChatResponse(t *testing.T) {
+	body := []byte(`{"id":"resp_1","model":"gpt-5","status":"completed","output":[
+		{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"thinking about weather"}]},
+		{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"Let me check."}]},
+		{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Paris\"}"}
+	],"usage":{"input_tokens":120,"output_tokens":30,"total_tokens":150,"input_tokens_details":{"cached_tokens":100}}}`)
+
+	converted, err := ResponsesToChatResponse(body)
+	require.NoError(t, err)
+	out, usage, err := TransformOpenAIToClaudeWithOptions(converted, "claude-model", ResponseOptions{})
+	require.NoError(t, err)
+
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 3)
+	require.Equal(t, "thinking", resp.Content[0].Type)
+	require.Equal(t, "thinking about weather", resp.Content[0].Thinking)
+	require.Equal(t, "text", resp.Content[1].Type)
+	require.Equal(t, "Let me check.", resp.Content[1].Text)
+	require.Equal(t, "tool_use", resp.Content[2].Type)
+	require.Equal(t, "call_1", resp.Content[2].ID)
+	require.Equal(t, "get_weather", resp.Content[2].Name)
+	require.Equal(t, "tool_use", resp.StopReason)
+	require.Equal(t, 20, usage.InputTokens)
+	require.Equal(t, 100, usage.CacheReadInputTokens)
+	require.Equal(t, 30, usage.OutputTokens)
+}
+
+func TestResponsesToChatResponse_IncompleteAndFailed(t *testing.T) {
+	converted, err := ResponsesToChatResponse([]byte(`{"id":"resp_1","model":"gpt-5","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},
+		"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"partial"}]}]}`))
+	require.NoError(t, err)
+	out, _, err := TransformOpenAIToClaudeWithOptions(converted, "claude-model", ResponseOptions{})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "max_tokens", resp.StopReason)
+
+	converted, err = ResponsesToChatResponse([]byte(`{"id":"resp_2","status":"failed","error":{"code":"server_error","message":"boom"},"output":[]}`))
+	require.NoError(t, err)
+	var errResp ErrorResponse
+	require.NoError(t, json.Unmarshal(converted, &errResp))
+	require.NotNil(t, errResp.Error)
+	require.Equal(t, "boom", errResp.Error.Message)
+
+	_, err = ResponsesToChatResponse([]byte(`not json`))
+	require.Error(t, err)
+}
+
+func TestStreamingProcessor_ResponsesAPI(t *testing.T) {
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{ResponsesAPI: true})
+	events := runStream(t, p,
+		`event: response.created`,
+		`data: {"type":"response.created","response":{"id":"resp_1","model":"gpt-5","status":"in_progress","output":[]}}`,
+		``,
+		`data: {"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[]}}`,
+		`data: {"type":"response.reasoning_summary_text.delta","output_index":0,"delta":"thinking"}`,
+		`data: {"type":"response.output_item.added","output_index":1,"item":{"type":"message","id":"msg_1","role":"assistant","content":[]}}`,
+		`data: {"type":"response.output_text.delta","output_index":1,"delta":"Hello"}`,
+		`data: {"type":"response.output_text.delta","output_index":1,"delta":" world"}`,
+		`data: {"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":""}}`,
+		`data: {"type":"response.function_call_arguments.delta","output_index":2,"delta":"{\"city\":"}`,
+		`data: {"type":"response.function_call_arguments.delta","output_index":2,"delta":"\"Paris\"}"}`,
+		`data: {"type":"response.completed","response":{"id":"resp_1","model":"gpt-5","status":"completed","output":[],"usage":{"input_tokens":50,"output_tokens":12,"total_tokens":62}}}`,
+	)
+
+	require.Equal(t, []string{"thinking", "text", "tool_use"}, blockStartTypes(events))
+	require.Equal(t, "Hello world", textDeltas(events))
+	require.JSONEq(t, `{"city":"Paris"}`, toolInputJSON(events, 2))
+	require.Equal(t, 12, messageDeltaOutputTokens(t, events))
+	var stopReason string
+	for _, ev := range events {
+		if ev.Event == "message_delta" {
+			delta, _ := ev.Data["delta"].(map[string]any)
+			stopReason, _ = delta["stop_reason"].(string)
+		}
+	}
+	require.Equal(t, "tool_use", stopReason)
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+
+	usage := p.Usage()
+	require.Equal(t, 50, usage.InputTokens)
+	require.Equal(t, 12, usage.OutputTokens)
+}
+
+func TestStreamingProcessor_ResponsesAPIFailed(t *testing.T) {
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{ResponsesAPI: true})
+	events := runStream(t, p,
+		`data: {"type":"response.created","response":{"id":"resp_1","model":"gpt-5","status":"in_progress","output":[]}}`,
+		`data: {"type":"response.output_text.delta","output_index":0,"delta":"Hi"}`,
+		`data: {"type":"response.failed","response":{"id":"resp_1","status":"failed","error":{"code":"server_error","message":"boom"},"output":[]}}`,
+	)
+	last := events[len(events)-1]
+	require.Equal(t, "error", last.Event)
+	errObj, _ := last.Data["error"].(map[string]any)
+	require.Equal(t, "boom", errObj["message"])
+}
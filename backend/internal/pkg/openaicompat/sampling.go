@@ -0,0 +1,133 @@
+package openaicompat
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// 已知上游提供方，用于选择默认的采样参数白名单
+const (
+	ProviderGeneric    = ""           // 未知 / 通用 OpenAI 兼容上游
+	ProviderOpenAI     = "openai"     // OpenAI 官方 API（严格校验字段，不支持 top_k）
+	ProviderOpenRouter = "openrouter" // OpenRouter
+	ProviderVLLM       = "vllm"       // vLLM
+	ProviderDeepSeek   = "deepseek"   // DeepSeek 官方 API
+	// ProviderAnthropicAuth 仍按 OpenAI Chat Completions 协议请求（/chat/completions + OpenAI 请求体），
+	// 但使用 Anthropic 风格鉴权头（x-api-key + anthropic-version）的中转 / 上游（如 GLM）；不是 /v1/messages 透传
+	ProviderAnthropicAuth = "anthropic-auth"
+)
+
+// 采样参数名（与 OpenAI Chat Completions 字段名一致）
+const (
+	SamplingParamTemperature = "temperature"
+	SamplingParamTopP        = "top_p"
+	SamplingParamTopK        = "top_k"
+)
+
+// top_k 在请求体中的位置（RequestOptions.TopKPlacement）
+// 部分上游（如经 OpenAI SDK 转发的推理服务、按 chat template 读取采样参数的自部署模型）只识别嵌套字段中的 top_k
+const (
+	TopKPlacementTopLevel           = "top_level"            // 请求体顶层 top_k（默认）
+	TopKPlacementExtraBody          = "extra_body"           // extra_body.top_k
+	TopKPlacementChatTemplateKwargs = "chat_template_kwargs" // chat_template_kwargs.top_k
+)
+
+// defaultSamplingAllowlists 各上游默认允许透传的采样参数
+var defaultSamplingAllowlists = map[string][]string{
+	ProviderGeneric:    {SamplingParamTemperature, SamplingParamTopP},
+	ProviderOpenAI:     {SamplingParamTemperature, SamplingParamTopP},
+	ProviderOpenRouter: {SamplingParamTemperature, SamplingParamTopP, SamplingParamTopK},
+	ProviderVLLM:       {SamplingParamTemperature, SamplingParamTopP, SamplingParamTopK},
+	ProviderDeepSeek:   {SamplingParamTemperature, SamplingParamTopP},
+}
+
+// DefaultSamplingAllowlist 返回上游提供方默认的采样参数白名单，未知提供方使用通用白名单
+func DefaultSamplingAllowlist(provider string) []string {
+	if list, ok := defaultSamplingAllowlists[strings.ToLower(strings.TrimSpace(provider))]; ok {
+		return list
+	}
+	return defaultSamplingAllowlists[ProviderGeneric]
+}
+
+// samplingAllowlist 返回应用 SupportsTopK 覆盖后的采样参数白名单
+func samplingAllowlist(opts RequestOptions) []string {
+	if opts.SupportsTopK == nil {
+		return opts.SamplingAllowlist
+	}
+	base := opts.SamplingAllowlist
+	if base == nil {
+		base = DefaultSamplingAllowlist(opts.Provider)
+	}
+	list := make([]string, 0, len(base)+1)
+	for _, name := range base {
+		if !strings.EqualFold(strings.TrimSpace(name), SamplingParamTopK) {
+			list = append(list, name)
+		}
+	}
+	if *opts.SupportsTopK {
+		list = append(list, SamplingParamTopK)
+	}
+	return list
+}
+
+// applySamplingParams 按白名单写入采样参数，不在白名单内的参数丢弃并记录 debug 日志
+// allowlist 为 nil 时使用 provider 的默认白名单
+func applySamplingParams(req *ChatRequest, claudeReq *antigravity.ClaudeRequest, provider string, allowlist []string) {
+	if allowlist == nil {
+		allowlist = DefaultSamplingAllowlist(provider)
+	}
+	allowed := make(map[string]bool, len(allowlist))
+	for _, name := range allowlist {
+		allowed[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	drop := func(name string) {
+		slog.Debug("openai_compat_sampling_param_dropped", "provider", provider, "param", name, "model", claudeReq.Model)
+	}
+
+	if claudeReq.Temperature != nil {
+		if allowed[SamplingParamTemperature] {
+			req.Temperature = claudeReq.Temperature
+		} else {
+			drop(SamplingParamTemperature)
+		}
+	}
+	if claudeReq.TopP != nil {
+		if allowed[SamplingParamTopP] {
+			req.TopP = claudeReq.TopP
+		} else {
+			drop(SamplingParamTopP)
+		}
+	}
+	if claudeReq.TopK != nil {
+		if allowed[SamplingParamTopK] {
+			req.TopK = claudeReq.TopK
+		} else {
+			drop(SamplingParamTopK)
+		}
+	}
+}
+
+// placeTopK 按 placement 将顶层 top_k 移入 extra_body 或 chat_template_kwargs，未知取值保持顶层
+func placeTopK(req *ChatRequest, placement string) {
+	if req.TopK == nil {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(placement)) {
+	case TopKPlacementExtraBody:
+		if req.ExtraBody == nil {
+			req.ExtraBody = make(map[string]any, 1)
+		}
+		req.ExtraBody[SamplingParamTopK] = *req.TopK
+	case TopKPlacementChatTemplateKwargs:
+		if req.ChatTemplateKwargs == nil {
+			req.ChatTemplateKwargs = make(map[string]any, 1)
+		}
+		req.ChatTemplateKwargs[SamplingParamTopK] = *req.TopK
+	default:
+		return
+	}
+	req.TopK = nil
+}
@@ -0,0 +1,106 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_SamplingAllowlist(t *testing.T) {
+	claudeJSON := `{
+		"model": "m",
+		"messages": [{"role": "user", "content": "hi"}],
+		"temperature": 0.7,
+		"top_p": 0.9,
+		"top_k": 40
+	}`
+	supported, unsupported := true, false
+
+	tests := []struct {
+		name        string
+		opts        RequestOptions
+		wantKeys    []string
+		wantDropped []string
+	}{
+		{name: "generic", opts: RequestOptions{}, wantKeys: []string{"temperature", "top_p"}, wantDropped: []string{"top_k"}},
+		{name: "openai", opts: RequestOptions{Provider: ProviderOpenAI}, wantKeys: []string{"temperature", "top_p"}, wantDropped: []string{"top_k"}},
+		{name: "vllm", opts: RequestOptions{Provider: ProviderVLLM}, wantKeys: []string{"temperature", "top_p", "top_k"}},
+		{name: "unknown provider", opts: RequestOptions{Provider: "acme"}, wantKeys: []string{"temperature", "top_p"}, wantDropped: []string{"top_k"}},
+		{name: "custom allowlist", opts: RequestOptions{Provider: ProviderVLLM, SamplingAllowlist: []string{"top_k"}}, wantKeys: []string{"top_k"}, wantDropped: []string{"temperature", "top_p"}},
+		{name: "empty allowlist", opts: RequestOptions{SamplingAllowlist: []string{}}, wantDropped: []string{"temperature", "top_p", "top_k"}},
+		{name: "supports_top_k true", opts: RequestOptions{SupportsTopK: &supported}, wantKeys: []string{"temperature", "top_p", "top_k"}},
+		{name: "supports_top_k false", opts: RequestOptions{Provider: ProviderVLLM, SupportsTopK: &unsupported}, wantKeys: []string{"temperature", "top_p"}, wantDropped: []string{"top_k"}},
+		{name: "supports_top_k with custom allowlist", opts: RequestOptions{SamplingAllowlist: []string{"temperature"}, SupportsTopK: &supported}, wantKeys: []string{"temperature", "top_k"}, wantDropped: []string{"top_p"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, tt.opts)
+			require.NoError(t, err)
+
+			var raw map[string]any
+			require.NoError(t, json.Unmarshal(body, &raw))
+			for _, key := range tt.wantKeys {
+				require.Contains(t, raw, key)
+			}
+			for _, key := range tt.wantDropped {
+				require.NotContains(t, raw, key)
+			}
+		})
+	}
+}
+
+func TestDefaultSamplingAllowlist(t *testing.T) {
+	require.Equal(t, []string{SamplingParamTemperature, SamplingParamTopP, SamplingParamTopK}, DefaultSamplingAllowlist("OpenRouter"))
+	require.Equal(t, DefaultSamplingAllowlist(ProviderGeneric), DefaultSamplingAllowlist("unknown"))
+}
+
+func TestTransformClaudeToOpenAI_TopKPlacement(t *testing.T) {
+	claudeJSON := `{"model": "m", "messages": [{"role": "user", "content": "hi"}], "temperature": 0.7, "top_k": 40}`
+
+	tests := []struct {
+		placement string
+		wantPath  []string
+	}{
+		{placement: "", wantPath: []string{"top_k"}},
+		{placement: TopKPlacementTopLevel, wantPath: []string{"top_k"}},
+		{placement: TopKPlacementExtraBody, wantPath: []string{"extra_body", "top_k"}},
+		{placement: TopKPlacementChatTemplateKwargs, wantPath: []string{"chat_template_kwargs", "top_k"}},
+		{placement: "unknown", wantPath: []string{"top_k"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.placement, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderVLLM, TopKPlacement: tt.placement})
+			require.NoError(t, err)
+
+			var raw map[string]any
+			require.NoError(t, json.Unmarshal(body, &raw))
+			require.Contains(t, raw, "temperature")
+			if len(tt.wantPath) > 1 {
+				require.NotContains(t, raw, "top_k")
+			}
+			var node any = raw
+			for _, key := range tt.wantPath {
+				obj, ok := node.(map[string]any)
+				require.True(t, ok, "missing %v", tt.wantPath)
+				node = obj[key]
+			}
+			require.Equal(t, float64(40), node)
+		})
+	}
+
+	// top_k 不在白名单内时不会写入任何位置
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderOpenAI, TopKPlacement: TopKPlacementExtraBody})
+	require.NoError(t, err)
+	require.NotContains(t, string(body), "top_k")
+	require.NotContains(t, string(body), "extra_body")
+}
@@ -4,30 +4,94 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
 )
 
 // StreamingProcessor 将 OpenAI SSE 流转换为 Claude SSE 流
+//
+// 并发约定：ProcessLine 与 Finish 会修改转换状态，必须在同一个 goroutine 中按顺序调用；
+// Usage 是唯一可并发调用的方法，随时返回一致的累计 usage 快照
 type StreamingProcessor struct {
 	originalModel    string
+	opts             ResponseOptions
 	messageStartSent bool
 	messageStopSent  bool
 	blockIndex       int
 	blockOpen        bool // 当前是否有未关闭的 content block
 	blockType        string
 	usedTool         bool
-	thinkingStarted  bool // 是否已开始 thinking block
-	thinkingGotSig   bool // 是否收到过真实 signature
+	contentSeen      bool // 是否已收到过文本 / thinking / tool call 增量（仅含 role 的 chunk 不计）
+
+	// 当前打开的 thinking block 的签名状态，仅在 thinking block 打开期间非 nil。
+	// 推理与文本交替时每个 thinking block 各自记录，关闭时随 block 一起丢弃，避免状态跨 block 残留
+	thinking *thinkingBlockState
 
 	// 工具调用状态：追踪多个并发 tool_calls
 	activeToolCalls map[int]*toolCallState
+	toolCallCount   int            // 已输出的 tool_use 块数量（不含被丢弃的）
+	toolCallsCapped bool           // 已因超过 MaxToolCalls 丢弃过 tool call
+	toolIndexRemap  map[int]int    // 因重复 index 被重新分配的上游 index → 当前使用的内部 index
+	reindexedCount  int            // 已重新分配的 index 数量
+	openToolCall    *toolCallState // 当前打开的 tool_use block 对应的 tool call
+
+	// 文本工具协议状态：尚未输出的文本缓冲，以及是否处于 <tool_call> 标签内
+	textToolBuf    strings.Builder
+	textToolInCall bool
+
+	// RefusalModeError 下缓冲的 refusal 文本，结束时决定输出 error 事件还是文本
+	refusalBuf strings.Builder
+
+	// 已输出的 web 搜索引用 URL，避免重复输出
+	webSearchSeen map[string]struct{}
+
+	// 上游已返回的 finish_reason，待 [DONE] 或流结束时再发送 message_delta，
+	// 以便带上 finish_reason 之后才到达的 usage
+	pendingFinishReason string
+
+	// 尚未打开 text block 时到达的纯空白文本增量，待后续正文到达时一并输出
+	pendingWhitespace strings.Builder
+
+	// TextDeltaBatchWindow 开启时窗口内暂存、尚未输出的文本增量及窗口开始时间
+	textBatch      strings.Builder
+	textBatchStart time.Time
+
+	// RecordOutputText 开启时记录的上游输出文本
+	outputText strings.Builder
+
+	// 累计 usage（usageTotal 为已采纳 usage 的 prompt+completion 总量）及上游返回的服务等级（可能只在部分 chunk 中出现）
+	// 写入均持有 usageMu，以便 Usage 在其他 goroutine 中并发读取
+	usageMu     sync.Mutex
+	usage       antigravity.ClaudeUsage
+	usageTotal  int
+	serviceTier string
+
+	// 尚未随 text_delta 输出的 token logprobs（厂商扩展字段 openai_logprobs）
+	pendingLogprobs []json.RawMessage
+
+	// 当前 SSE 事件的 event 字段（作用于随后的 data 行，空行时重置）
+	sseEvent string
 
-	// 累计 usage
-	usage antigravity.ClaudeUsage
+	// SnapshotDeltas 模式下各文本字段已累计的完整内容（键为字段名）
+	snapshotText map[string]string
+
+	// ResponsesAPI 模式下的 Responses 事件转换状态
+	responses *responsesStreamState
+
+	// 上游返回的音频输出增量，结束时作为完整的 openai_audio 块输出
+	audio *audioStreamState
+}
+
+// thinkingBlockState 单个 thinking block 的签名状态
+type thinkingBlockState struct {
+	gotSig    bool            // 是否收到过上游真实 signature
+	signature strings.Builder // 已收到、尚未输出的 signature 片段（上游可能分多个 chunk 发送，关闭 block 时拼接后输出）
 }
 
 // toolCallState 追踪单个 tool call 的增量构建
@@ -36,20 +100,42 @@ type toolCallState struct {
 	Name      string
 	Arguments strings.Builder
 	Started   bool // 是否已发送 content_block_start
+	Truncated bool // arguments 已因超过 MaxToolArgumentBytes 被截断，后续增量直接丢弃
+	Dropped   bool // tool call 已因超过 MaxToolCalls 被丢弃，后续增量直接丢弃
 }
 
 // NewStreamingProcessor 创建流式处理器
 func NewStreamingProcessor(originalModel string) *StreamingProcessor {
+	return NewStreamingProcessorWithOptions(originalModel, DefaultResponseOptions())
+}
+
+// NewStreamingProcessorWithOptions 创建流式处理器（可配置转换行为）
+func NewStreamingProcessorWithOptions(originalModel string, opts ResponseOptions) *StreamingProcessor {
 	return &StreamingProcessor{
 		originalModel:   originalModel,
+		opts:            opts,
 		activeToolCalls: make(map[int]*toolCallState),
+		webSearchSeen:   make(map[string]struct{}),
 	}
 }
 
 // ProcessLine 处理一行 SSE 数据，返回转换后的 Claude SSE 事件
+// ResponsesAPI / CompletionsAPI 开启时上游为 Responses API 事件流 / 旧版 Completions 流，先改写为 Chat Completions chunk 再处理
 func (p *StreamingProcessor) ProcessLine(line string) []byte {
+	if p.opts.ResponsesAPI {
+		return p.processResponsesLine(line)
+	}
+	if p.opts.CompletionsAPI {
+		return p.processCompletionsLine(line)
+	}
+	return p.processChatLine(line)
+}
+
+// processChatLine 处理一行 Chat Completions SSE 数据
+func (p *StreamingProcessor) processChatLine(line string) []byte {
 	line = strings.TrimSpace(line)
 	if line == "" {
+		p.sseEvent = ""
 		return nil
 	}
 
@@ -58,16 +144,29 @@ func (p *StreamingProcessor) ProcessLine(line string) []byte {
 		return p.finishIfNeeded()
 	}
 
-	// 只处理 data: 行
+	// 记录 event 类型（如 event: error）；注释（": keepalive"）、id: 等其他行忽略
+	if strings.HasPrefix(line, "event:") {
+		p.sseEvent = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		return nil
+	}
 	if !strings.HasPrefix(line, "data:") {
 		return nil
 	}
 
+	eventType := p.sseEvent
+	p.sseEvent = ""
 	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 	if data == "" || data == "[DONE]" {
 		return p.finishIfNeeded()
 	}
 
+	// 上游错误（event: error 或 data 中直接携带 error 对象）：转换为 Claude error 事件并结束流，避免客户端一直等待
+	if eventType == "error" || strings.Contains(data, `"error"`) {
+		if errEvent, ok := p.upstreamErrorEvent(data, eventType == "error"); ok {
+			return errEvent
+		}
+	}
+
 	var chunk StreamChunk
 	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 		return nil
@@ -77,27 +176,33 @@ func (p *StreamingProcessor) ProcessLine(line string) []byte {
 
 	// 首次处理：发送 message_start
 	if !p.messageStartSent {
-		result.Write(p.emitMessageStart(chunk.ID))
+		result.Write(p.emitMessageStart(chunk.ID, chunk.SystemFingerprint))
 	}
 
-	// 更新 usage（在最后一个 chunk 中包含 usage）
-	// input_tokens = prompt_tokens - cached_tokens
-	if chunk.Usage != nil {
-		cachedTokens := 0
-		if chunk.Usage.PromptTokensDetails != nil {
-			cachedTokens = chunk.Usage.PromptTokensDetails.CachedTokens
-		}
-		p.usage.InputTokens = chunk.Usage.PromptTokens - cachedTokens
-		if p.usage.InputTokens < 0 {
-			p.usage.InputTokens = 0
-		}
-		p.usage.OutputTokens = chunk.Usage.CompletionTokens
-		p.usage.CacheReadInputTokens = cachedTokens
+	p.updateUsage(chunk)
+
+	// 已以 error 事件提前结束（如 tool arguments 超限）时仅继续收集 usage
+	if p.messageStopSent {
+		return nil
 	}
 
 	// 处理 choices
 	for _, choice := range chunk.Choices {
 		delta := choice.Delta
+		if p.opts.SnapshotDeltas {
+			delta = p.snapshotDelta(delta)
+		}
+		// 已废弃的 delta.function_call：作为 index 0 的 tool call 处理（旧格式每个响应只有一个函数调用）
+		if delta.FunctionCall != nil && len(delta.ToolCalls) == 0 {
+			delta.ToolCalls = []ToolCall{{Function: *delta.FunctionCall}}
+		}
+		p.recordOutput(delta)
+		if !p.contentSeen && deltaHasContent(delta) {
+			p.contentSeen = true
+		}
+		if choice.Logprobs != nil {
+			p.pendingLogprobs = append(p.pendingLogprobs, choice.Logprobs.Content...)
+		}
 
 		// 处理 thinking/reasoning 内容
 		if delta.Thinking != nil {
@@ -109,11 +214,13 @@ func (p *StreamingProcessor) ProcessLine(line string) []byte {
 			}
 		}
 
-		// 处理 reasoning_content / reasoning 字段 (不同提供商格式)
-		if delta.ReasoningContent != "" {
-			result.Write(p.processThinkingDelta(delta.ReasoningContent))
-		} else if delta.Reasoning != "" {
-			result.Write(p.processThinkingDelta(delta.Reasoning))
+		// 处理 reasoning_content / reasoning 字段 (不同提供商格式)，按 ReasoningOutput 过滤推理摘要
+		reasoning := delta.ReasoningContent
+		if reasoning == "" {
+			reasoning = delta.Reasoning
+		}
+		if reasoning = p.opts.visibleReasoning(reasoning, delta.ReasoningDetails); reasoning != "" {
+			result.Write(p.processThinkingDelta(reasoning))
 		}
 
 		// 处理文本内容
@@ -121,33 +228,166 @@ func (p *StreamingProcessor) ProcessLine(line string) []byte {
 			result.Write(p.processTextDelta(delta.Content))
 		}
 
-		// 处理 tool calls
+		// 处理 refusal：默认作为文本输出；error 模式下缓冲至结束时处理
+		if delta.Refusal != "" {
+			if p.opts.RefusalMode == RefusalModeError {
+				p.refusalBuf.WriteString(delta.Refusal)
+			} else {
+				result.Write(p.emitTextDelta(delta.Refusal))
+			}
+		}
+
+		// 处理 tool calls（先处理同一 chunk 内重复的 index）
 		if len(delta.ToolCalls) > 0 {
+			seen := make(map[int]bool, len(delta.ToolCalls))
 			for _, tc := range delta.ToolCalls {
+				tc, ok := p.resolveToolCallIndex(tc, seen)
+				if !ok {
+					result.Write(p.emitDuplicateToolCallIndex(tc.Index))
+					return result.Bytes()
+				}
 				result.Write(p.processToolCallDelta(tc))
 			}
 		}
 
-		// 处理 finish_reason
+		// 处理 web 搜索引用
+		if len(delta.Annotations) > 0 {
+			result.Write(p.processAnnotations(delta.Annotations))
+		}
+
+		// 累积音频输出
+		if delta.Audio != nil {
+			if p.audio == nil {
+				p.audio = &audioStreamState{}
+			}
+			p.audio.add(delta.Audio)
+		}
+
+		// 记录 finish_reason，结束事件延迟到 [DONE] / 流结束时发送
 		if choice.FinishReason != nil && *choice.FinishReason != "" {
-			result.Write(p.emitFinish(*choice.FinishReason))
+			p.pendingFinishReason = *choice.FinishReason
 		}
 	}
 
 	return result.Bytes()
 }
 
+// snapshotDelta 将快照式 delta（每个 chunk 携带累计的完整内容）还原为增量：
+// 新内容以已累计内容为前缀时只保留新增后缀，否则视为普通增量并追加到累计内容
+func (p *StreamingProcessor) snapshotDelta(delta StreamChunkDelta) StreamChunkDelta {
+	if p.snapshotText == nil {
+		p.snapshotText = make(map[string]string)
+	}
+	suffix := func(field, text string) string {
+		if text == "" {
+			return ""
+		}
+		prev := p.snapshotText[field]
+		if strings.HasPrefix(text, prev) {
+			p.snapshotText[field] = text
+			return text[len(prev):]
+		}
+		p.snapshotText[field] = prev + text
+		return text
+	}
+
+	delta.Content = suffix("content", delta.Content)
+	delta.ReasoningContent = suffix("reasoning_content", delta.ReasoningContent)
+	delta.Reasoning = suffix("reasoning", delta.Reasoning)
+	delta.Refusal = suffix("refusal", delta.Refusal)
+	if delta.Thinking != nil {
+		thinking := *delta.Thinking
+		thinking.Content = suffix("thinking", thinking.Content)
+		delta.Thinking = &thinking
+	}
+	return delta
+}
+
+// recordOutput 记录增量中的输出文本（RecordOutputText 开启时）
+func (p *StreamingProcessor) recordOutput(delta StreamChunkDelta) {
+	if !p.opts.RecordOutputText {
+		return
+	}
+	if delta.Thinking != nil {
+		p.outputText.WriteString(delta.Thinking.Content)
+	}
+	p.outputText.WriteString(delta.ReasoningContent)
+	p.outputText.WriteString(delta.Reasoning)
+	p.outputText.WriteString(delta.Content)
+	p.outputText.WriteString(delta.Refusal)
+	if delta.Audio != nil {
+		p.outputText.WriteString(delta.Audio.Transcript)
+	}
+	for _, tc := range delta.ToolCalls {
+		p.outputText.WriteString(tc.Function.Name)
+		p.outputText.WriteString(tc.Function.Arguments)
+	}
+}
+
+// deltaHasContent 判断增量是否携带实际输出（文本、refusal、thinking、tool call 或音频），仅含 role 或为空时返回 false
+func deltaHasContent(delta StreamChunkDelta) bool {
+	if delta.Content != "" || delta.Refusal != "" || delta.ReasoningContent != "" || delta.Reasoning != "" || len(delta.ToolCalls) > 0 {
+		return true
+	}
+	if delta.Audio != nil && (delta.Audio.Data != "" || delta.Audio.Transcript != "") {
+		return true
+	}
+	return delta.Thinking != nil && (delta.Thinking.Content != "" || delta.Thinking.Signature != "")
+}
+
+// updateUsage 根据 chunk 更新累计 usage 与服务等级（持有 usageMu，Usage 可在其他 goroutine 并发读取）
+// 上游可能每个 chunk 都带累计 usage、只在末尾带一次，或先带部分 usage，
+// 因此仅在新 usage 不小于已采纳的 usage 时覆盖，避免较小的部分值覆盖完整值
+func (p *StreamingProcessor) updateUsage(chunk StreamChunk) {
+	p.usageMu.Lock()
+	defer p.usageMu.Unlock()
+	if chunk.Usage != nil {
+		if total := chunk.Usage.PromptTokens + chunk.Usage.CompletionTokens; total >= p.usageTotal {
+			p.usageTotal = total
+			p.usage = *extractUsage(chunk.Usage)
+		}
+	}
+	if chunk.ServiceTier != "" {
+		p.serviceTier = chunk.ServiceTier
+	}
+	p.usage.ServiceTier = p.serviceTier
+}
+
+// Usage 返回当前累计 usage 的快照，可在 ProcessLine 执行期间从其他 goroutine 并发调用
+// （如 tee 到多个下游时实时读取用量）
+func (p *StreamingProcessor) Usage() antigravity.ClaudeUsage {
+	p.usageMu.Lock()
+	defer p.usageMu.Unlock()
+	usage := p.usage
+	if usage.ServerToolUse != nil {
+		serverToolUse := *usage.ServerToolUse
+		usage.ServerToolUse = &serverToolUse
+	}
+	return usage
+}
+
+// ContentSeen 返回是否已收到首个实际输出增量，用于记录首 token 时间
+func (p *StreamingProcessor) ContentSeen() bool {
+	return p.contentSeen
+}
+
+// OutputText 返回已记录的上游输出文本，仅在 RecordOutputText 开启时有内容
+func (p *StreamingProcessor) OutputText() string {
+	return p.outputText.String()
+}
+
 // Finish 结束处理，返回最终事件和用量
 func (p *StreamingProcessor) Finish() ([]byte, *antigravity.ClaudeUsage) {
 	var result bytes.Buffer
 	if !p.messageStopSent {
-		result.Write(p.emitFinish("stop"))
+		result.Write(p.emitFinish(p.finishReason()))
 	}
 	return result.Bytes(), &p.usage
 }
 
 // emitMessageStart 发送 message_start 事件
-func (p *StreamingProcessor) emitMessageStart(responseID string) []byte {
+// systemFingerprint 非空时写入 message.system_fingerprint，便于客户端校验 seed 可复现性
+func (p *StreamingProcessor) emitMessageStart(responseID, systemFingerprint string) []byte {
 	if p.messageStartSent {
 		return nil
 	}
@@ -161,7 +401,7 @@ func (p *StreamingProcessor) emitMessageStart(responseID string) []byte {
 		"type":          "message",
 		"role":          "assistant",
 		"content":       []any{},
-		"model":         p.originalModel,
+		"model":         responseModel(p.originalModel, p.opts.ResponseModelAliases),
 		"stop_reason":   nil,
 		"stop_sequence": nil,
 		"usage": antigravity.ClaudeUsage{
@@ -169,6 +409,12 @@ func (p *StreamingProcessor) emitMessageStart(responseID string) []byte {
 			OutputTokens: 0,
 		},
 	}
+	if systemFingerprint != "" {
+		message["system_fingerprint"] = systemFingerprint
+	}
+	if len(p.opts.EffectiveParams) > 0 {
+		message["effective_params"] = p.opts.EffectiveParams
+	}
 
 	event := map[string]any{
 		"type":    "message_start",
@@ -181,6 +427,125 @@ func (p *StreamingProcessor) emitMessageStart(responseID string) []byte {
 
 // processTextDelta 处理文本增量
 func (p *StreamingProcessor) processTextDelta(text string) []byte {
+	if p.opts.TextToolProtocol {
+		return p.processTextToolProtocolDelta(text)
+	}
+	return p.emitTextDelta(text)
+}
+
+// processTextToolProtocolDelta 在文本工具协议下处理文本增量
+// 普通文本照常输出；<tool_call> 标签内的内容缓冲至闭合标签后转换为 tool_use 块
+func (p *StreamingProcessor) processTextToolProtocolDelta(text string) []byte {
+	var result bytes.Buffer
+	p.textToolBuf.WriteString(text)
+	buf := p.textToolBuf.String()
+
+	for {
+		if !p.textToolInCall {
+			if idx := strings.Index(buf, textToolCallOpenTag); idx >= 0 {
+				if idx > 0 {
+					result.Write(p.emitTextDelta(buf[:idx]))
+				}
+				buf = buf[idx+len(textToolCallOpenTag):]
+				p.textToolInCall = true
+				continue
+			}
+			// 末尾可能是未完整到达的开始标签，暂缓输出
+			keep := partialTagSuffixLen(buf, textToolCallOpenTag)
+			if len(buf) > keep {
+				result.Write(p.emitTextDelta(buf[:len(buf)-keep]))
+				buf = buf[len(buf)-keep:]
+			}
+			break
+		}
+
+		idx := strings.Index(buf, textToolCallCloseTag)
+		if idx < 0 {
+			break
+		}
+		raw := buf[:idx]
+		buf = buf[idx+len(textToolCallCloseTag):]
+		p.textToolInCall = false
+		if call := parseTextToolCall(raw); call != nil {
+			result.Write(p.emitTextToolUse(call))
+		} else {
+			result.Write(p.emitTextDelta(textToolCallOpenTag + raw + textToolCallCloseTag))
+		}
+	}
+
+	p.textToolBuf.Reset()
+	p.textToolBuf.WriteString(buf)
+	return result.Bytes()
+}
+
+// flushTextToolBuffer 流结束时输出文本工具协议缓冲中剩余的内容（未闭合的标签按原文输出）
+func (p *StreamingProcessor) flushTextToolBuffer() []byte {
+	if p.textToolBuf.Len() == 0 && !p.textToolInCall {
+		return nil
+	}
+	rest := p.textToolBuf.String()
+	if p.textToolInCall {
+		rest = textToolCallOpenTag + rest
+	}
+	p.textToolBuf.Reset()
+	p.textToolInCall = false
+	if rest == "" {
+		return nil
+	}
+	return p.emitTextDelta(rest)
+}
+
+// emitTextToolUse 将文本协议解析出的工具调用输出为完整的 tool_use 块
+func (p *StreamingProcessor) emitTextToolUse(call *textToolCall) []byte {
+	var result bytes.Buffer
+	p.usedTool = true
+
+	if p.blockOpen && p.blockType == "thinking" {
+		result.Write(p.closeThinkingWithFakeSignature())
+	} else if p.blockOpen {
+		result.Write(p.closeBlock())
+	}
+
+	result.Write(p.openBlock("tool_use", map[string]any{
+		"type":  "tool_use",
+		"id":    "toolu_" + randomID(),
+		"name":  call.Name,
+		"input": map[string]any{},
+	}))
+	event := map[string]any{
+		"type":  "content_block_delta",
+		"index": p.blockIndex,
+		"delta": map[string]any{
+			"type":         "input_json_delta",
+			"partial_json": string(call.Arguments),
+		},
+	}
+	result.Write(formatSSE("content_block_delta", event))
+	result.Write(p.closeBlock())
+
+	return result.Bytes()
+}
+
+// emitTextDelta 输出文本增量
+func (p *StreamingProcessor) emitTextDelta(text string) []byte {
+	if text == "" {
+		return nil
+	}
+	// 纯空白增量不单独开启 text block：后续有正文时一并输出，
+	// 紧接着开启 tool_use 等其他块时丢弃，避免产生只含空白的 text 块
+	if !(p.blockOpen && p.blockType == "text") && strings.TrimSpace(text) == "" {
+		p.pendingWhitespace.WriteString(text)
+		return nil
+	}
+	if p.pendingWhitespace.Len() > 0 {
+		text = p.pendingWhitespace.String() + text
+		p.pendingWhitespace.Reset()
+	}
+	return p.writeTextDelta(text)
+}
+
+// writeTextDelta 在 text block 中输出文本增量，必要时关闭其他 block 并开启 text block
+func (p *StreamingProcessor) writeTextDelta(text string) []byte {
 	var result bytes.Buffer
 
 	// 如果当前有非 text 的 block，先关闭
@@ -201,23 +566,115 @@ func (p *StreamingProcessor) processTextDelta(text string) []byte {
 		}))
 	}
 
-	// 发送 text delta
-	delta := map[string]any{
-		"type": "text_delta",
-		"text": text,
+	// 合并窗口内的文本增量暂存，窗口结束后一并输出
+	if p.opts.TextDeltaBatchWindow > 0 {
+		if p.textBatch.Len() == 0 {
+			p.textBatchStart = time.Now()
+		}
+		p.textBatch.WriteString(text)
+		if time.Since(p.textBatchStart) >= p.opts.TextDeltaBatchWindow {
+			result.Write(p.FlushTextBatch())
+		}
+		return result.Bytes()
 	}
-	event := map[string]any{
-		"type":  "content_block_delta",
-		"index": p.blockIndex,
-		"delta": delta,
+
+	result.Write(p.textDeltaEvents(text))
+	return result.Bytes()
+}
+
+// FlushTextBatch 输出 TextDeltaBatchWindow 合并窗口内暂存的文本（无暂存文本时返回 nil）。
+// 关闭 text block 时自动调用；上游停顿时调用方可按窗口周期调用，避免文本滞留。
+// 与 ProcessLine 相同，必须在处理流的 goroutine 中调用
+func (p *StreamingProcessor) FlushTextBatch() []byte {
+	if p.textBatch.Len() == 0 || !(p.blockOpen && p.blockType == "text") {
+		return nil
+	}
+	text := p.textBatch.String()
+	p.textBatch.Reset()
+	return p.textDeltaEvents(text)
+}
+
+// textDeltaEvents 在当前 text block 中输出文本增量事件
+func (p *StreamingProcessor) textDeltaEvents(text string) []byte {
+	var result bytes.Buffer
+	// 发送 text delta（过大的增量拆分为多个事件，避免单个 SSE 帧过大）
+	for _, chunk := range splitUTF8Chunks(text, p.opts.MaxDeltaBytes) {
+		delta := map[string]any{
+			"type": "text_delta",
+			"text": chunk,
+		}
+		event := map[string]any{
+			"type":  "content_block_delta",
+			"index": p.blockIndex,
+			"delta": delta,
+		}
+		// 上游 logprobs 随首个 text_delta 以厂商扩展字段输出
+		if len(p.pendingLogprobs) > 0 {
+			event["openai_logprobs"] = map[string]any{"content": p.pendingLogprobs}
+			p.pendingLogprobs = nil
+		}
+		result.Write(formatSSE("content_block_delta", event))
 	}
-	result.Write(formatSSE("content_block_delta", event))
 
 	return result.Bytes()
 }
 
+// splitUTF8Chunks 将文本按最大字节数拆分，拆分点对齐 UTF-8 字符边界
+// maxBytes <= 0 或文本未超限时原样返回；单个字符超过 maxBytes 时该字符单独成块
+func splitUTF8Chunks(text string, maxBytes int) []string {
+	if maxBytes <= 0 || len(text) <= maxBytes {
+		return []string{text}
+	}
+	chunks := make([]string, 0, len(text)/maxBytes+1)
+	for len(text) > maxBytes {
+		end := maxBytes
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		if end == 0 {
+			_, size := utf8.DecodeRuneInString(text)
+			end = size
+		}
+		chunks = append(chunks, text[:end])
+		text = text[end:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// truncateUTF8 截取 text 前不超过 maxBytes 字节的部分，不在多字节字符中间截断
+func truncateUTF8(text string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	if len(text) <= maxBytes {
+		return text
+	}
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
+
+// truncateToolArguments 将 arguments 截断至 maxBytes 并补齐 JSON 闭合，无法补齐时返回空对象
+func truncateToolArguments(args string, maxBytes int) string {
+	truncated := truncateUTF8(args, maxBytes)
+	if repaired := truncated + jsonCompletionSuffix(truncated); json.Valid([]byte(repaired)) {
+		return repaired
+	}
+	return "{}"
+}
+
 // processThinkingDelta 处理 thinking/reasoning 增量
 func (p *StreamingProcessor) processThinkingDelta(text string) []byte {
+	// 隐藏 thinking 时直接丢弃，不向客户端开启 thinking block
+	if p.opts.HideThinking {
+		return nil
+	}
+
 	var result bytes.Buffer
 
 	// 如果当前有非 thinking 的 block，先关闭
@@ -225,13 +682,12 @@ func (p *StreamingProcessor) processThinkingDelta(text string) []byte {
 		result.Write(p.closeBlock())
 	}
 
-	// 如果没有打开的 thinking block，开一个
+	// 如果没有打开的 thinking block，开一个（推理与文本交替时每段推理各自一个 thinking block 与 signature）
 	if !p.blockOpen {
 		result.Write(p.openBlock("thinking", map[string]any{
 			"type":     "thinking",
 			"thinking": "",
 		}))
-		p.thinkingStarted = true
 	}
 
 	// 发送 thinking delta
@@ -249,32 +705,36 @@ func (p *StreamingProcessor) processThinkingDelta(text string) []byte {
 	return result.Bytes()
 }
 
-// processSignatureDelta 处理 thinking signature
+// processSignatureDelta 处理 thinking signature 片段
+// 上游可能将 signature 分多个 chunk 发送，或在 signature 之后继续输出 thinking 内容，
+// 因此只缓冲片段而不立即关闭 block：推理真正结束（首个非 thinking 增量或流结束）时由 closeBlock 拼接输出
 func (p *StreamingProcessor) processSignatureDelta(signature string) []byte {
-	var result bytes.Buffer
-
 	// signature 应该在 thinking block 内
 	if !p.blockOpen || p.blockType != "thinking" {
 		return nil
 	}
 
-	p.thinkingGotSig = true
+	p.thinking.gotSig = true
+	p.thinking.signature.WriteString(signature)
+	return nil
+}
 
+// flushThinkingSignature 输出当前 thinking block 缓冲的完整 signature（无缓冲时返回 nil）
+func (p *StreamingProcessor) flushThinkingSignature() []byte {
+	if p.thinking == nil || p.thinking.signature.Len() == 0 {
+		return nil
+	}
 	delta := map[string]any{
 		"type":      "signature_delta",
-		"signature": signature,
+		"signature": p.thinking.signature.String(),
 	}
+	p.thinking.signature.Reset()
 	event := map[string]any{
 		"type":  "content_block_delta",
 		"index": p.blockIndex,
 		"delta": delta,
 	}
-	result.Write(formatSSE("content_block_delta", event))
-
-	// signature 发送后关闭 thinking block
-	result.Write(p.closeBlock())
-
-	return result.Bytes()
+	return formatSSE("content_block_delta", event)
 }
 
 // closeThinkingWithFakeSignature 在 thinking block 未收到真实 signature 时注入假签名并关闭
@@ -283,15 +743,15 @@ func (p *StreamingProcessor) closeThinkingWithFakeSignature() []byte {
 	if !p.blockOpen || p.blockType != "thinking" {
 		return nil
 	}
-	if p.thinkingGotSig {
-		// 已有真实 signature，正常关闭
+	if p.thinking != nil && p.thinking.gotSig {
+		// 已有真实 signature：closeBlock 输出拼接后的完整 signature 并关闭
 		return p.closeBlock()
 	}
 
 	var result bytes.Buffer
 
-	// 注入假签名
-	fakeSig := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	// 注入假签名（与非流式共用生成器，同一消息内多个 thinking block 的签名互不相同）
+	fakeSig := p.opts.fakeSignature()
 	delta := map[string]any{
 		"type":      "signature_delta",
 		"signature": fakeSig,
@@ -316,6 +776,22 @@ func (p *StreamingProcessor) processToolCallDelta(tc ToolCall) []byte {
 	idx := tc.Index
 
 	state, exists := p.activeToolCalls[idx]
+	if exists && state.Dropped {
+		return nil
+	}
+
+	// tool call 数量超限：error 模式以 error 事件结束流，否则丢弃该 tool call 及其后续增量
+	if limit := p.opts.MaxToolCalls; !exists && limit > 0 && p.toolCallCount >= limit {
+		if p.opts.ToolCallOverflowMode == ToolCallOverflowError {
+			return p.emitTooManyToolCalls()
+		}
+		if !p.toolCallsCapped {
+			log.Printf("[OpenAICompat] tool calls exceed limit %d, dropping the rest", limit)
+			p.toolCallsCapped = true
+		}
+		p.activeToolCalls[idx] = &toolCallState{Dropped: true}
+		return nil
+	}
 
 	if tc.ID != "" && !exists {
 		// 新 tool call 开始
@@ -329,11 +805,11 @@ func (p *StreamingProcessor) processToolCallDelta(tc ToolCall) []byte {
 		// ID fallback: 某些上游可能不返回 ID
 		toolID := tc.ID
 		if toolID == "" {
-			toolID = fmt.Sprintf("call_%d_%d", time.Now().UnixMilli(), idx)
+			toolID = placeholderToolCallID(idx)
 		}
-		toolName := tc.Function.Name
+		toolName := claudeToolName(tc.Function.Name, p.opts.ToolNames)
 		if toolName == "" {
-			toolName = fmt.Sprintf("tool_%d", idx)
+			toolName = placeholderToolName(idx)
 		}
 
 		state = &toolCallState{
@@ -351,6 +827,8 @@ func (p *StreamingProcessor) processToolCallDelta(tc ToolCall) []byte {
 		}
 		result.Write(p.openBlock("tool_use", toolUseBlock))
 		state.Started = true
+		p.openToolCall = state
+		p.toolCallCount++
 	} else if !exists {
 		// arguments 数据但无 state，创建 fallback
 		if p.blockOpen && p.blockType == "thinking" {
@@ -359,10 +837,10 @@ func (p *StreamingProcessor) processToolCallDelta(tc ToolCall) []byte {
 			result.Write(p.closeBlock())
 		}
 
-		toolID := fmt.Sprintf("call_%d_%d", time.Now().UnixMilli(), idx)
-		toolName := tc.Function.Name
+		toolID := placeholderToolCallID(idx)
+		toolName := claudeToolName(tc.Function.Name, p.opts.ToolNames)
 		if toolName == "" {
-			toolName = fmt.Sprintf("tool_%d", idx)
+			toolName = placeholderToolName(idx)
 		}
 
 		state = &toolCallState{ID: toolID, Name: toolName}
@@ -376,16 +854,34 @@ func (p *StreamingProcessor) processToolCallDelta(tc ToolCall) []byte {
 		}
 		result.Write(p.openBlock("tool_use", toolUseBlock))
 		state.Started = true
+		p.openToolCall = state
+		p.toolCallCount++
 	}
 
 	// 累积 arguments
 	if tc.Function.Arguments != "" && state != nil {
-		state.Arguments.WriteString(tc.Function.Arguments)
+		fragment := tc.Function.Arguments
+		if limit := p.opts.MaxToolArgumentBytes; limit > 0 && state.Arguments.Len()+len(fragment) > limit {
+			if state.Truncated {
+				return result.Bytes()
+			}
+			if p.opts.ToolArgumentOverflowMode != ToolArgumentOverflowTruncate {
+				result.Write(p.emitToolArgumentsTooLarge(state, state.Arguments.Len()+len(fragment)))
+				return result.Bytes()
+			}
+			log.Printf("[OpenAICompat] tool call %s (%s) arguments truncated at %d bytes", state.ID, state.Name, limit)
+			state.Truncated = true
+			fragment = truncateUTF8(fragment, limit-state.Arguments.Len())
+			if fragment == "" {
+				return result.Bytes()
+			}
+		}
+		state.Arguments.WriteString(fragment)
 
 		// 发送 input_json_delta
 		delta := map[string]any{
 			"type":         "input_json_delta",
-			"partial_json": tc.Function.Arguments,
+			"partial_json": fragment,
 		}
 		event := map[string]any{
 			"type":  "content_block_delta",
@@ -398,6 +894,230 @@ func (p *StreamingProcessor) processToolCallDelta(tc ToolCall) []byte {
 	return result.Bytes()
 }
 
+// placeholderToolCallID 上游未返回 tool call id 时生成的占位 id
+func placeholderToolCallID(idx int) string {
+	return fmt.Sprintf("call_%d_%d", time.Now().UnixMilli(), idx)
+}
+
+// placeholderToolName 上游未返回函数名时使用的占位工具名，避免生成无 name 的 tool_use 块
+func placeholderToolName(idx int) string {
+	return fmt.Sprintf("tool_%d", idx)
+}
+
+// reindexedToolCallBase 重新分配的 tool call index 起始值，避免与上游后续使用的 index 冲突
+const reindexedToolCallBase = 1 << 20
+
+// resolveToolCallIndex 将上游 tool_calls index 映射为内部 index
+// 同一 chunk 内重复的 index 若携带与当前 tool call 不同的新 id，视为新的 tool call：
+// reindex 模式下分配新的 index（该上游 index 的后续增量归属新 tool call），error 模式下返回 false；
+// 不带 id 的重复项视为同一 tool call 的后续 arguments
+func (p *StreamingProcessor) resolveToolCallIndex(tc ToolCall, seen map[int]bool) (ToolCall, bool) {
+	upstream := tc.Index
+	if mapped, ok := p.toolIndexRemap[upstream]; ok {
+		tc.Index = mapped
+	}
+	if seen[upstream] && tc.ID != "" {
+		if state, ok := p.activeToolCalls[tc.Index]; !ok || state.ID != tc.ID {
+			if p.opts.DuplicateToolIndexMode == DuplicateToolIndexError {
+				tc.Index = upstream
+				return tc, false
+			}
+			if p.toolIndexRemap == nil {
+				p.toolIndexRemap = make(map[int]int)
+			}
+			tc.Index = reindexedToolCallBase + p.reindexedCount
+			p.reindexedCount++
+			p.toolIndexRemap[upstream] = tc.Index
+			log.Printf("[OpenAICompat] duplicate tool call index %d in one chunk, reindexed tool call %s", upstream, tc.ID)
+		}
+	}
+	seen[upstream] = true
+	return tc, true
+}
+
+// emitDuplicateToolCallIndex 同一 chunk 内 tool_calls index 重复时以 error 事件结束流
+func (p *StreamingProcessor) emitDuplicateToolCallIndex(index int) []byte {
+	err := &DuplicateToolCallIndexError{Index: index}
+	log.Printf("[OpenAICompat] %v", err)
+	p.messageStopSent = true
+	return formatSSE("error", map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "api_error",
+			"message": err.Error(),
+		},
+	})
+}
+
+// processAnnotations 将 web 搜索引用输出为 server_tool_use + web_search_tool_result 块
+func (p *StreamingProcessor) processAnnotations(annotations []Annotation) []byte {
+	blocks := buildWebSearchBlocks(annotations, p.webSearchSeen)
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	var result bytes.Buffer
+	if p.blockOpen && p.blockType == "thinking" {
+		result.Write(p.closeThinkingWithFakeSignature())
+	} else if p.blockOpen {
+		result.Write(p.closeBlock())
+	}
+
+	for _, block := range blocks {
+		var contentBlock map[string]any
+		raw, _ := json.Marshal(block)
+		if err := json.Unmarshal(raw, &contentBlock); err != nil {
+			continue
+		}
+		result.Write(p.openBlock(block.Type, contentBlock))
+		result.Write(p.closeBlock())
+	}
+	return result.Bytes()
+}
+
+// emitAudioBlock 将累积的音频输出作为单个完整的 openai_audio 块输出（块内容一次性给出，无增量事件）
+func (p *StreamingProcessor) emitAudioBlock() []byte {
+	if p.audio == nil {
+		return nil
+	}
+	block, ok := buildAudioBlock(p.audio.audio(), p.opts.AudioFormat)
+	p.audio = nil
+	if !ok {
+		return nil
+	}
+	var contentBlock map[string]any
+	raw, _ := json.Marshal(block)
+	if err := json.Unmarshal(raw, &contentBlock); err != nil {
+		return nil
+	}
+	var result bytes.Buffer
+	result.Write(p.openBlock(block.Type, contentBlock))
+	result.Write(p.closeBlock())
+	return result.Bytes()
+}
+
+// emitToolArgumentsTooLarge tool call arguments 超限时以 error 事件结束流（已发送的增量无法撤回）
+func (p *StreamingProcessor) emitToolArgumentsTooLarge(state *toolCallState, size int) []byte {
+	err := &ToolArgumentsTooLargeError{ToolName: state.Name, Size: size, Limit: p.opts.MaxToolArgumentBytes}
+	log.Printf("[OpenAICompat] tool call %s: %v", state.ID, err)
+	p.messageStopSent = true
+	return formatSSE("error", map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "api_error",
+			"message": err.Error(),
+		},
+	})
+}
+
+// upstreamErrorEvent 将上游流中的错误负载转换为 Claude error 事件并标记流结束
+// 负载可为 {"error":{...}}、{"error":"..."}、{"message":...} 或纯文本（仅 event: error 时）；非错误负载返回 ok=false
+func (p *StreamingProcessor) upstreamErrorEvent(data string, isErrorEvent bool) ([]byte, bool) {
+	var payload struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	jsonErr := json.Unmarshal([]byte(data), &payload)
+
+	var detail ErrorDetail
+	var errText string
+	hasError := jsonErr == nil && len(payload.Error) > 0 && string(payload.Error) != "null" &&
+		(json.Unmarshal(payload.Error, &detail) == nil || json.Unmarshal(payload.Error, &errText) == nil)
+
+	errType := "api_error"
+	var message string
+	switch {
+	case hasError:
+		message = detail.Message
+		if message == "" {
+			message = errText
+		}
+		errType = streamErrorType(detail)
+	case !isErrorEvent:
+		return nil, false
+	case jsonErr == nil && payload.Message != "":
+		message = payload.Message
+	default:
+		message = truncateUTF8(data, maxPlainErrorMessageBytes)
+	}
+	if message == "" {
+		message = "upstream stream error"
+	}
+
+	if p.messageStopSent {
+		return nil, true
+	}
+	log.Printf("[OpenAICompat] upstream stream error: %s", message)
+	p.messageStopSent = true
+	return formatSSE("error", map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errType,
+			"message": message,
+		},
+	}), true
+}
+
+// Abort 读取上游失败等情况下以 error 事件提前结束流；流已结束时返回 nil
+func (p *StreamingProcessor) Abort(message string) []byte {
+	if p.messageStopSent {
+		return nil
+	}
+	p.messageStopSent = true
+	return formatSSE("error", map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "api_error",
+			"message": message,
+		},
+	})
+}
+
+// streamErrorType 根据流内错误对象映射 Claude 错误类型：
+// 优先使用数字 code（HTTP 状态码，可为字符串形式），其次按 OpenAI 的 type / code 名称匹配
+func streamErrorType(detail ErrorDetail) string {
+	switch code := detail.Code.(type) {
+	case float64:
+		return mapErrorType(int(code))
+	case string:
+		if status, err := strconv.Atoi(strings.TrimSpace(code)); err == nil {
+			return mapErrorType(status)
+		}
+	}
+	codeName, _ := detail.Code.(string)
+	for _, name := range []string{detail.Type, codeName} {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "invalid_request_error", "context_length_exceeded", "invalid_prompt":
+			return "invalid_request_error"
+		case "authentication_error", "invalid_api_key":
+			return "authentication_error"
+		case "permission_error", "permission_denied":
+			return "permission_error"
+		case "not_found_error", "model_not_found":
+			return "not_found_error"
+		case "rate_limit_error", "rate_limit_exceeded", "insufficient_quota":
+			return "rate_limit_error"
+		case "overloaded_error", "server_overloaded", "engine_overloaded":
+			return "overloaded_error"
+		}
+	}
+	return "api_error"
+}
+
+// emitTooManyToolCalls tool call 数量超限时以 error 事件结束流（已发送的 tool_use 块无法撤回）
+func (p *StreamingProcessor) emitTooManyToolCalls() []byte {
+	err := &TooManyToolCallsError{Limit: p.opts.MaxToolCalls}
+	log.Printf("[OpenAICompat] %v", err)
+	p.messageStopSent = true
+	return formatSSE("error", map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "api_error",
+			"message": err.Error(),
+		},
+	})
+}
+
 // emitFinish 发送结束事件
 func (p *StreamingProcessor) emitFinish(finishReason string) []byte {
 	if p.messageStopSent {
@@ -406,6 +1126,36 @@ func (p *StreamingProcessor) emitFinish(finishReason string) []byte {
 
 	var result bytes.Buffer
 
+	// 输出文本工具协议缓冲中剩余的文本
+	result.Write(p.flushTextToolBuffer())
+
+	// error 模式下的 refusal：尚未输出任何内容块时以 error 事件结束，否则作为文本补发
+	if p.refusalBuf.Len() > 0 {
+		refusal := p.refusalBuf.String()
+		p.refusalBuf.Reset()
+		if p.blockIndex == 0 && !p.blockOpen {
+			result.Write(formatSSE("error", map[string]any{
+				"type": "error",
+				"error": map[string]any{
+					"type":    "invalid_request_error",
+					"message": refusal,
+				},
+			}))
+			p.messageStopSent = true
+			return result.Bytes()
+		}
+		result.Write(p.emitTextDelta(refusal))
+	}
+
+	// 整个响应只有空白文本时仍原样输出，否则丢弃缓冲的空白
+	if p.pendingWhitespace.Len() > 0 {
+		whitespace := p.pendingWhitespace.String()
+		p.pendingWhitespace.Reset()
+		if p.blockIndex == 0 && !p.blockOpen {
+			result.Write(p.writeTextDelta(whitespace))
+		}
+	}
+
 	// 关闭当前 block（thinking block 需要注入假签名）
 	if p.blockOpen {
 		if p.blockType == "thinking" {
@@ -415,31 +1165,39 @@ func (p *StreamingProcessor) emitFinish(finishReason string) []byte {
 		}
 	}
 
-	// 确定 stop_reason
-	stopReason := "end_turn"
-	if p.usedTool {
-		stopReason = "tool_use"
-	} else {
-		switch finishReason {
-		case "stop":
-			stopReason = "end_turn"
-		case "tool_calls":
-			stopReason = "tool_use"
-		case "length":
-			stopReason = "max_tokens"
-		}
+	// 累积的音频输出作为完整的 openai_audio 块输出
+	result.Write(p.emitAudioBlock())
+
+	// 没有输出任何内容块（如仅有推理且 thinking 被隐藏）时补一个空文本块，与非流式响应保持一致
+	if p.blockIndex == 0 {
+		result.Write(p.openBlock("text", map[string]any{
+			"type": "text",
+			"text": "",
+		}))
+		result.Write(p.closeBlock())
 	}
 
+	// 确定 stop_reason
+	stopReason := mapFinishReason(finishReason, p.usedTool, p.opts.FinishReasonMap)
+
 	// message_delta
+	deltaUsage := map[string]any{
+		"output_tokens": p.usage.OutputTokens,
+	}
+	if p.usage.ServerToolUse != nil {
+		deltaUsage["server_tool_use"] = p.usage.ServerToolUse
+	}
+	if p.usage.AcceptedPredictionTokens > 0 || p.usage.RejectedPredictionTokens > 0 {
+		deltaUsage["accepted_prediction_tokens"] = p.usage.AcceptedPredictionTokens
+		deltaUsage["rejected_prediction_tokens"] = p.usage.RejectedPredictionTokens
+	}
 	deltaEvent := map[string]any{
 		"type": "message_delta",
 		"delta": map[string]any{
 			"stop_reason":   stopReason,
 			"stop_sequence": nil,
 		},
-		"usage": map[string]any{
-			"output_tokens": p.usage.OutputTokens,
-		},
+		"usage": deltaUsage,
 	}
 	result.Write(formatSSE("message_delta", deltaEvent))
 
@@ -461,7 +1219,15 @@ func (p *StreamingProcessor) finishIfNeeded() []byte {
 	if !p.messageStartSent {
 		return nil
 	}
-	return p.emitFinish("stop")
+	return p.emitFinish(p.finishReason())
+}
+
+// finishReason 返回上游的 finish_reason，未返回时视为 stop
+func (p *StreamingProcessor) finishReason() string {
+	if p.pendingFinishReason != "" {
+		return p.pendingFinishReason
+	}
+	return "stop"
 }
 
 // openBlock 开始新的 content block
@@ -478,24 +1244,132 @@ func (p *StreamingProcessor) openBlock(blockType string, contentBlock map[string
 
 	p.blockOpen = true
 	p.blockType = blockType
+	if blockType != "text" {
+		p.pendingWhitespace.Reset()
+	}
+	if blockType == "thinking" {
+		p.thinking = &thinkingBlockState{}
+	}
 	return formatSSE("content_block_start", event)
 }
 
 // closeBlock 关闭当前 content block
+// tool_use block 关闭前校验累积的 arguments，必要时补发修复用的 input_json_delta
 func (p *StreamingProcessor) closeBlock() []byte {
 	if !p.blockOpen {
 		return nil
 	}
 
+	var result bytes.Buffer
+	switch p.blockType {
+	case "tool_use":
+		result.Write(p.repairToolArguments())
+	case "text":
+		result.Write(p.FlushTextBatch())
+	case "thinking":
+		result.Write(p.flushThinkingSignature())
+	}
+
 	event := map[string]any{
 		"type":  "content_block_stop",
 		"index": p.blockIndex,
 	}
+	result.Write(formatSSE("content_block_stop", event))
 
 	p.blockOpen = false
 	p.blockIndex++
 	p.blockType = ""
-	return formatSSE("content_block_stop", event)
+	p.openToolCall = nil
+	p.thinking = nil
+	return result.Bytes()
+}
+
+// repairToolArguments 校验当前 tool call 累积的 arguments 是否为合法 JSON
+// 上游截断时（如 {"city":"Par）补发闭合引号/括号使其合法；已发送的内容无法撤回，无法补全时仅记录日志
+func (p *StreamingProcessor) repairToolArguments() []byte {
+	state := p.openToolCall
+	if state == nil {
+		return nil
+	}
+	args := state.Arguments.String()
+	if strings.TrimSpace(args) == "" || json.Valid([]byte(args)) {
+		return nil
+	}
+
+	suffix := jsonCompletionSuffix(args)
+	if suffix == "" || !json.Valid([]byte(args+suffix)) {
+		log.Printf("[OpenAICompat] tool call %s (%s) has invalid JSON arguments that cannot be repaired", state.ID, state.Name)
+		return nil
+	}
+	state.Arguments.WriteString(suffix)
+
+	delta := map[string]any{
+		"type":         "input_json_delta",
+		"partial_json": suffix,
+	}
+	event := map[string]any{
+		"type":  "content_block_delta",
+		"index": p.blockIndex,
+		"delta": delta,
+	}
+	return formatSSE("content_block_delta", event)
+}
+
+// jsonCompletionSuffix 计算使被截断的 JSON 前缀闭合所需的后缀：
+// 闭合未结束的字符串、为悬空的 key 补 null，并按嵌套顺序补齐 } / ]
+func jsonCompletionSuffix(prefix string) string {
+	var stack []byte
+	inString, escaped := false, false
+	for i := 0; i < len(prefix); i++ {
+		ch := prefix[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	var head strings.Builder
+	if inString {
+		if escaped {
+			head.WriteByte('\\')
+		}
+		head.WriteByte('"')
+	}
+	if strings.HasSuffix(strings.TrimRight(prefix+head.String(), " \t\r\n"), ":") {
+		head.WriteString("null")
+	}
+	closers := make([]byte, 0, len(stack))
+	for i := len(stack) - 1; i >= 0; i-- {
+		closers = append(closers, stack[i])
+	}
+
+	suffix := head.String() + string(closers)
+	// 对象中截断在 key 之后（如 {"ci），补全为 {"ci":null}
+	if !json.Valid([]byte(prefix+suffix)) && len(stack) > 0 && stack[len(stack)-1] == '}' {
+		if withValue := head.String() + ":null" + string(closers); json.Valid([]byte(prefix + withValue)) {
+			return withValue
+		}
+	}
+	return suffix
 }
 
 // formatSSE 格式化 SSE 事件
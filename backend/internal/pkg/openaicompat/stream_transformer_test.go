@@ -0,0 +1,935 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent 解析后的 Claude SSE 事件
+type sseEvent struct {
+	Event string
+	Data  map[string]any
+}
+
+// runStream 依次将 OpenAI SSE 行送入处理器，返回解析后的全部 Claude 事件
+func runStream(t *testing.T, p *StreamingProcessor, lines ...string) []sseEvent {
+	t.Helper()
+	var raw strings.Builder
+	for _, line := range lines {
+		raw.Write(p.ProcessLine(line))
+	}
+	final, _ := p.Finish()
+	raw.Write(final)
+	return parseSSEEvents(t, raw.String())
+}
+
+// parseSSEEvents 将 "event: x\ndata: {...}\n\n" 格式的文本解析为事件列表
+func parseSSEEvents(t *testing.T, raw string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, frame := range strings.Split(raw, "\n\n") {
+		if strings.TrimSpace(frame) == "" {
+			continue
+		}
+		var ev sseEvent
+		for _, line := range strings.Split(frame, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.Data))
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// blockStartTypes 返回所有 content_block_start 事件的块类型
+func blockStartTypes(events []sseEvent) []string {
+	var types []string
+	for _, ev := range events {
+		if ev.Event != "content_block_start" {
+			continue
+		}
+		block, _ := ev.Data["content_block"].(map[string]any)
+		blockType, _ := block["type"].(string)
+		types = append(types, blockType)
+	}
+	return types
+}
+
+func TestStreamingProcessor_HideThinking(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"secret "}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"reasoning_content":"plan"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"answer"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":50,"total_tokens":60}}`,
+		`data: [DONE]`,
+	}
+
+	visible := runStream(t, NewStreamingProcessor("claude-model"), lines...)
+	require.Equal(t, []string{"thinking", "text"}, blockStartTypes(visible))
+
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{HideThinking: true})
+	var raw strings.Builder
+	for _, line := range lines {
+		raw.Write(p.ProcessLine(line))
+	}
+	final, usage := p.Finish()
+	raw.Write(final)
+
+	hidden := parseSSEEvents(t, raw.String())
+	require.Equal(t, []string{"text"}, blockStartTypes(hidden))
+	require.NotContains(t, raw.String(), "secret")
+	require.NotContains(t, raw.String(), "signature_delta")
+
+	// 文本块索引从 0 开始，reasoning tokens 仍计入 usage
+	for _, ev := range hidden {
+		if ev.Event == "content_block_start" {
+			require.EqualValues(t, 0, ev.Data["index"])
+		}
+	}
+	require.Equal(t, 50, usage.OutputTokens)
+}
+
+func TestStreamingProcessor_MaxDeltaBytes(t *testing.T) {
+	text := strings.Repeat("ab你好", 10) // 每组 8 字节，含 3 字节中文字符
+	content, _ := json.Marshal(text)
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":` + string(content) + `}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}
+
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{MaxDeltaBytes: 7}), lines...)
+	var deltas []string
+	for _, ev := range events {
+		if ev.Event != "content_block_delta" {
+			continue
+		}
+		delta, _ := ev.Data["delta"].(map[string]any)
+		chunk, _ := delta["text"].(string)
+		require.LessOrEqual(t, len(chunk), 7)
+		deltas = append(deltas, chunk)
+	}
+	require.Greater(t, len(deltas), 1)
+	require.Equal(t, text, strings.Join(deltas, ""))
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+}
+
+func TestStreamingProcessor_TextDeltaBatching(t *testing.T) {
+	pieces := []string{"Hel", "lo", ",", " ", "wor", "ld", " 你", "好", "!"}
+	lines := []string{`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant"}}]}`}
+	for _, piece := range pieces {
+		content, _ := json.Marshal(piece)
+		lines = append(lines, `data: {"id":"c1","choices":[{"index":0,"delta":{"content":`+string(content)+`}}]}`)
+	}
+	lines = append(lines, `data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`, `data: [DONE]`)
+
+	countDeltas := func(events []sseEvent) int {
+		n := 0
+		for _, ev := range events {
+			if ev.Event == "content_block_delta" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// 默认不合并：每个上游增量一个事件
+	events := runStream(t, NewStreamingProcessor("claude-model"), lines...)
+	require.Equal(t, len(pieces), countDeltas(events))
+
+	// 窗口内的增量合并为一个事件，文本与逐个输出时完全一致
+	events = runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{TextDeltaBatchWindow: time.Hour}), lines...)
+	require.Equal(t, 1, countDeltas(events))
+	require.Equal(t, strings.Join(pieces, ""), textDeltas(events))
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+}
+
+func TestStreamingProcessor_TextDeltaBatchFlush(t *testing.T) {
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{TextDeltaBatchWindow: time.Hour})
+	var raw strings.Builder
+	raw.Write(p.ProcessLine(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"partial"}}]}`))
+	require.Empty(t, textDeltas(parseSSEEvents(t, raw.String())))
+
+	// 上游停顿时由调用方主动输出暂存文本
+	raw.Write(p.FlushTextBatch())
+	require.Equal(t, "partial", textDeltas(parseSSEEvents(t, raw.String())))
+	require.Nil(t, p.FlushTextBatch())
+
+	// 开启其他块前先输出暂存文本
+	raw.Write(p.ProcessLine(`data: {"id":"c1","choices":[{"index":0,"delta":{"content":" answer"}}]}`))
+	raw.Write(p.ProcessLine(`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read","arguments":"{}"}}]}}]}`))
+	final, _ := p.Finish()
+	raw.Write(final)
+	events := parseSSEEvents(t, raw.String())
+	require.Equal(t, "partial answer", textDeltas(events))
+	require.Equal(t, []string{"text", "tool_use"}, blockStartTypes(events))
+}
+
+func TestSplitUTF8Chunks(t *testing.T) {
+	require.Equal(t, []string{"hello"}, splitUTF8Chunks("hello", 0))
+	require.Equal(t, []string{"hello"}, splitUTF8Chunks("hello", 5))
+	require.Equal(t, []string{"he", "ll", "o"}, splitUTF8Chunks("hello", 2))
+	// 不在多字节字符中间拆分
+	require.Equal(t, []string{"a", "你", "好"}, splitUTF8Chunks("a你好", 3))
+	// 单个字符超过上限时单独成块
+	require.Equal(t, []string{"你", "好"}, splitUTF8Chunks("你好", 2))
+}
+
+func TestStreamingProcessor_ObjectToolArguments(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":{"city":"Paris"}}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"tool_use"}, blockStartTypes(events))
+
+	var partial strings.Builder
+	for _, ev := range events {
+		if ev.Event != "content_block_delta" {
+			continue
+		}
+		delta, _ := ev.Data["delta"].(map[string]any)
+		chunk, _ := delta["partial_json"].(string)
+		partial.WriteString(chunk)
+	}
+	require.JSONEq(t, `{"city":"Paris"}`, partial.String())
+}
+
+// toolInputJSON 拼接指定 content block 的全部 partial_json
+func toolInputJSON(events []sseEvent, index int) string {
+	var partial strings.Builder
+	for _, ev := range events {
+		if ev.Event != "content_block_delta" {
+			continue
+		}
+		if idx, _ := ev.Data["index"].(float64); int(idx) != index {
+			continue
+		}
+		delta, _ := ev.Data["delta"].(map[string]any)
+		chunk, _ := delta["partial_json"].(string)
+		partial.WriteString(chunk)
+	}
+	return partial.String()
+}
+
+// textDeltas 拼接全部 text_delta 文本
+func textDeltas(events []sseEvent) string {
+	var text strings.Builder
+	for _, ev := range events {
+		if ev.Event != "content_block_delta" {
+			continue
+		}
+		delta, _ := ev.Data["delta"].(map[string]any)
+		chunk, _ := delta["text"].(string)
+		text.WriteString(chunk)
+	}
+	return text.String()
+}
+
+func TestStreamingProcessor_RepairsTruncatedToolArguments(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read","arguments":"{\"path\":\"/tmp/a"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"ls","arguments":"{\"dirs\":[\"x\","}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"tool_use", "tool_use"}, blockStartTypes(events))
+	require.JSONEq(t, `{"path":"/tmp/a"}`, toolInputJSON(events, 0))
+	// 数组末尾的逗号无法通过追加修复，保持原样（仅记录日志）
+	require.Equal(t, `{"dirs":["x",`, toolInputJSON(events, 1))
+}
+
+func TestStreamingProcessor_ValidToolArgumentsUntouched(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read","arguments":"{\"path\":"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"/tmp/a\"}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	)
+	deltas := 0
+	for _, ev := range events {
+		if ev.Event == "content_block_delta" {
+			deltas++
+		}
+	}
+	require.Equal(t, 2, deltas)
+	require.JSONEq(t, `{"path":"/tmp/a"}`, toolInputJSON(events, 0))
+}
+
+func TestJSONCompletionSuffix(t *testing.T) {
+	tests := map[string]string{
+		`{"a":1`:               `}`,
+		`{"a":"b`:              `"}`,
+		`{"a":`:                `null}`,
+		`{"a":{"b":[1,2`:       `]}}`,
+		`{"a":"x\`:             `\"}`,
+		`{"ke`:                 `":null}`,
+		`{"a":"}{"`:            `}`,
+		`{"a":[{"b":"c"},{"d"`: `:null}]}`,
+	}
+	for prefix, want := range tests {
+		got := jsonCompletionSuffix(prefix)
+		require.Equal(t, want, got, prefix)
+		require.True(t, json.Valid([]byte(prefix+got)), prefix+got)
+	}
+}
+
+func TestStreamingProcessor_Refusal(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","refusal":"I can't "}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"refusal":"help with that."}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}
+
+	events := runStream(t, NewStreamingProcessor("claude-model"), lines...)
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+
+	events = runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{RefusalMode: RefusalModeError}), lines...)
+	require.Empty(t, blockStartTypes(events))
+	last := events[len(events)-1]
+	require.Equal(t, "error", last.Event)
+	errObj, _ := last.Data["error"].(map[string]any)
+	require.Equal(t, "invalid_request_error", errObj["type"])
+	require.Equal(t, "I can't help with that.", errObj["message"])
+}
+
+// messageDeltaOutputTokens 返回 message_delta 事件中的 output_tokens
+func messageDeltaOutputTokens(t *testing.T, events []sseEvent) int {
+	t.Helper()
+	for _, ev := range events {
+		if ev.Event != "message_delta" {
+			continue
+		}
+		usage, _ := ev.Data["usage"].(map[string]any)
+		tokens, _ := usage["output_tokens"].(float64)
+		return int(tokens)
+	}
+	t.Fatal("message_delta not found")
+	return 0
+}
+
+func TestStreamingProcessor_UsageAfterFinishReason(t *testing.T) {
+	p := NewStreamingProcessor("claude-model")
+	events := runStream(t, p,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19}}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, 7, messageDeltaOutputTokens(t, events))
+	delta, _ := events[len(events)-2].Data["delta"].(map[string]any)
+	require.Equal(t, "max_tokens", delta["stop_reason"])
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+}
+
+func TestStreamingProcessor_ReasoningTokens(t *testing.T) {
+	p := NewStreamingProcessor("claude-model")
+	events := runStream(t, p,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":30,"total_tokens":42,"completion_tokens_details":{"reasoning_tokens":25}}}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, 30, messageDeltaOutputTokens(t, events))
+	usage := p.Usage()
+	require.Equal(t, 30, usage.OutputTokens)
+	require.Equal(t, 25, usage.ReasoningTokens)
+}
+
+func TestStreamingProcessor_LegacyFunctionCall(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":null,"function_call":{"name":"get_weather","arguments":""}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"function_call":{"arguments":"{\"city\":"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"function_call":{"arguments":"\"Paris\"}"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"tool_use"}, blockStartTypes(events))
+	for _, ev := range events {
+		if ev.Event == "content_block_start" {
+			block, _ := ev.Data["content_block"].(map[string]any)
+			require.Equal(t, "get_weather", block["name"])
+		}
+	}
+	require.Equal(t, `{"city":"Paris"}`, toolInputJSON(events, 0))
+	delta, _ := events[len(events)-2].Data["delta"].(map[string]any)
+	require.Equal(t, "tool_use", delta["stop_reason"])
+}
+
+func TestStreamingProcessor_ContentSeen(t *testing.T) {
+	tests := map[string]string{
+		"content":   `{"content":"hi"}`,
+		"reasoning": `{"reasoning_content":"hmm"}`,
+		"thinking":  `{"thinking":{"content":"hmm"}}`,
+		"tool_call": `{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]}`,
+	}
+	for name, delta := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := NewStreamingProcessor("claude-model")
+			p.ProcessLine(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`)
+			p.ProcessLine(`data: {"id":"c1","choices":[{"index":0,"delta":{}}]}`)
+			require.False(t, p.ContentSeen(), "role-only and empty chunks are not content")
+			p.ProcessLine(`data: {"id":"c1","choices":[{"index":0,"delta":` + delta + `}]}`)
+			require.True(t, p.ContentSeen())
+		})
+	}
+}
+
+func TestStreamingProcessor_HideThinkingInterleaved(t *testing.T) {
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{HideThinking: true}),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"first"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"thinking":{"content":"more","signature":"sig"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"let me check"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"reasoning":"second thought"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"ls","arguments":"{}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	)
+
+	// 隐藏 thinking 不会留下未关闭的 block：每个 block 恰好一对 start / stop，索引连续
+	require.Equal(t, []string{"text", "tool_use"}, blockStartTypes(events))
+	var open []float64
+	var stopped []float64
+	for _, ev := range events {
+		switch ev.Event {
+		case "content_block_start":
+			require.Empty(t, open)
+			idx, _ := ev.Data["index"].(float64)
+			open = append(open, idx)
+		case "content_block_stop":
+			idx, _ := ev.Data["index"].(float64)
+			require.Equal(t, []float64{idx}, open)
+			open = nil
+			stopped = append(stopped, idx)
+		case "content_block_delta":
+			delta, _ := ev.Data["delta"].(map[string]any)
+			require.NotEqual(t, "thinking_delta", delta["type"])
+			require.NotEqual(t, "signature_delta", delta["type"])
+		}
+	}
+	require.Empty(t, open)
+	require.Equal(t, []float64{0, 1}, stopped)
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+}
+
+func TestStreamingProcessor_HideThinkingOnlyReasoning(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"secret plan"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":30,"total_tokens":35}}`,
+		`data: [DONE]`,
+	}
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{HideThinking: true}), lines...)
+
+	// 仅有推理且被隐藏时补一个空文本块，与非流式响应一致
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+	require.Empty(t, textDeltas(events))
+	require.Equal(t, 30, messageDeltaOutputTokens(t, events))
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+
+	body := []byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","reasoning_content":"secret plan"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":30,"total_tokens":35}}`)
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{HideThinking: true})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "text", resp.Content[0].Type)
+}
+
+// blockSignatures 返回各 content block index 收到的 signature_delta
+func blockSignatures(events []sseEvent) map[int][]string {
+	sigs := make(map[int][]string)
+	for _, ev := range events {
+		if ev.Event != "content_block_delta" {
+			continue
+		}
+		delta, _ := ev.Data["delta"].(map[string]any)
+		if delta["type"] != "signature_delta" {
+			continue
+		}
+		idx, _ := ev.Data["index"].(float64)
+		sig, _ := delta["signature"].(string)
+		sigs[int(idx)] = append(sigs[int(idx)], sig)
+	}
+	return sigs
+}
+
+func TestStreamingProcessor_InterleavedReasoningBlocks(t *testing.T) {
+	tests := map[string]struct {
+		firstThinking  string
+		secondThinking string
+		firstSig       string // 期望的第一个 thinking block 真实签名，空表示注入假签名
+		secondSig      string // 期望的第二个 thinking block 真实签名，空表示注入假签名
+	}{
+		"fake signatures":                {firstThinking: `{"reasoning_content":"plan A"}`},
+		"real signature on first block":  {firstThinking: `{"thinking":{"content":"plan A","signature":"sig-real"}}`, firstSig: "sig-real"},
+		"real signature on second block": {firstThinking: `{"reasoning_content":"plan A"}`, secondThinking: `{"thinking":{"content":"plan B","signature":"sig-second"}}`, secondSig: "sig-second"},
+		"real signatures on both blocks": {
+			firstThinking:  `{"thinking":{"content":"plan A","signature":"sig-one"}}`,
+			secondThinking: `{"thinking":{"content":"plan B","signature":"sig-two"}}`,
+			firstSig:       "sig-one",
+			secondSig:      "sig-two",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			secondThinking := tt.secondThinking
+			if secondThinking == "" {
+				secondThinking = `{"reasoning_content":"plan B"}`
+			}
+			events := runStream(t, NewStreamingProcessor("claude-model"),
+				`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+				`data: {"id":"c1","choices":[{"index":0,"delta":`+tt.firstThinking+`}]}`,
+				`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"step one"}}]}`,
+				`data: {"id":"c1","choices":[{"index":0,"delta":`+secondThinking+`}]}`,
+				`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"step two"}}]}`,
+				`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+				`data: [DONE]`,
+			)
+			require.Equal(t, []string{"thinking", "text", "thinking", "text"}, blockStartTypes(events))
+
+			// 每个 block 的 start / stop index 依次递增且成对出现
+			var starts, stops []int
+			for _, ev := range events {
+				idx, _ := ev.Data["index"].(float64)
+				switch ev.Event {
+				case "content_block_start":
+					starts = append(starts, int(idx))
+				case "content_block_stop":
+					stops = append(stops, int(idx))
+				}
+			}
+			require.Equal(t, []int{0, 1, 2, 3}, starts)
+			require.Equal(t, []int{0, 1, 2, 3}, stops)
+
+			// 每个 thinking block 恰好一个 signature，且互不相同；text block 没有 signature
+			sigs := blockSignatures(events)
+			require.Len(t, sigs, 2)
+			require.Len(t, sigs[0], 1)
+			require.Len(t, sigs[2], 1)
+			require.NotEqual(t, sigs[0][0], sigs[2][0])
+			require.NotEmpty(t, sigs[0][0])
+			require.NotEmpty(t, sigs[2][0])
+			if tt.firstSig != "" {
+				require.Equal(t, tt.firstSig, sigs[0][0])
+			}
+			if tt.secondSig != "" {
+				require.Equal(t, tt.secondSig, sigs[2][0])
+			}
+			require.Equal(t, "step onestep two", textDeltas(events))
+		})
+	}
+}
+
+func TestStreamingProcessor_FragmentedSignature(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","thinking":{"content":"first "}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"thinking":{"signature":"EqQB"}}}]}`,
+		// signature 之后仍有 thinking 内容，不应提前关闭 block
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"thinking":{"content":"second","signature":"Ck8I"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"thinking":{"signature":"AhgC=="}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"answer"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"thinking", "text"}, blockStartTypes(events))
+	require.Equal(t, map[int][]string{0: {"EqQBCk8IAhgC=="}}, blockSignatures(events))
+
+	var thinking strings.Builder
+	for _, ev := range events {
+		if delta, _ := ev.Data["delta"].(map[string]any); delta["type"] == "thinking_delta" {
+			thinking.WriteString(delta["thinking"].(string))
+		}
+	}
+	require.Equal(t, "first second", thinking.String())
+}
+
+func TestStreamingProcessor_SignatureClosedOnFinish(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","thinking":{"content":"only thinking","signature":"sig-"}}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"thinking":{"signature":"tail"}},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"thinking"}, blockStartTypes(events))
+	require.Equal(t, map[int][]string{0: {"sig-tail"}}, blockSignatures(events))
+}
+
+func TestStreamingProcessor_UsageKeepsMostComplete(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"a"}}],"usage":{"prompt_tokens":12,"completion_tokens":1,"total_tokens":13}}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"b"}}],"usage":{"prompt_tokens":12,"completion_tokens":2,"total_tokens":14}}`,
+		// 部分上游在中途返回不完整的 usage，不应覆盖更大的累计值
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"c"}}],"usage":{"prompt_tokens":0,"completion_tokens":1,"total_tokens":1}}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15,"prompt_tokens_details":{"cached_tokens":4}}}`,
+		`data: [DONE]`,
+	}
+
+	p := NewStreamingProcessor("claude-model")
+	var raw strings.Builder
+	for _, line := range lines {
+		raw.Write(p.ProcessLine(line))
+	}
+	final, usage := p.Finish()
+	raw.Write(final)
+
+	require.Equal(t, 3, messageDeltaOutputTokens(t, parseSSEEvents(t, raw.String())))
+	require.Equal(t, 3, usage.OutputTokens)
+	require.Equal(t, 8, usage.InputTokens)
+	require.Equal(t, 4, usage.CacheReadInputTokens)
+}
+
+func TestStreamingProcessor_SystemFingerprint(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","system_fingerprint":"fp_abc123","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, "message_start", events[0].Event)
+	message, _ := events[0].Data["message"].(map[string]any)
+	require.Equal(t, "fp_abc123", message["system_fingerprint"])
+
+	// 上游未返回指纹时不输出该字段
+	events = runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`,
+		`data: [DONE]`,
+	)
+	message, _ = events[0].Data["message"].(map[string]any)
+	require.NotContains(t, message, "system_fingerprint")
+}
+
+func TestTransformClaudeToOpenAI_Seed(t *testing.T) {
+	req := transformRequest(t, `{"model": "m", "metadata": {"seed": 42}, "messages": [{"role": "user", "content": "hi"}]}`)
+	require.NotNil(t, req.Seed)
+	require.EqualValues(t, 42, *req.Seed)
+
+	req = transformRequest(t, `{"model": "m", "messages": [{"role": "user", "content": "hi"}]}`)
+	require.Nil(t, req.Seed)
+}
+
+func TestStreamingProcessor_ToolArgumentsTooLarge(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"write","arguments":"{\"path\":\"/tmp/a\","}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"data\":\"0123456789abcdef\"}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`,
+		`data: [DONE]`,
+	}
+
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{MaxToolArgumentBytes: 26})
+	var raw strings.Builder
+	for _, line := range lines {
+		raw.Write(p.ProcessLine(line))
+	}
+	final, usage := p.Finish()
+	raw.Write(final)
+
+	// 超限后以 error 事件结束，不再输出后续事件，usage 仍照常收集
+	events := parseSSEEvents(t, raw.String())
+	last := events[len(events)-1]
+	require.Equal(t, "error", last.Event)
+	errObj, _ := last.Data["error"].(map[string]any)
+	require.Equal(t, "api_error", errObj["type"])
+	require.Contains(t, errObj["message"], "write")
+	require.Equal(t, `{"path":"/tmp/a",`, toolInputJSON(events, 0))
+	require.Equal(t, 20, usage.OutputTokens)
+
+	events = runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{MaxToolArgumentBytes: 26, ToolArgumentOverflowMode: ToolArgumentOverflowTruncate}), lines...)
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+	require.JSONEq(t, `{"path":"/tmp/a","data":"0"}`, toolInputJSON(events, 0))
+}
+
+func TestStreamingProcessor_MaxToolCalls(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"a","arguments":"{\"x\":"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"b","arguments":"{\"y\":"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"2}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":2,"id":"call_3","type":"function","function":{"name":"c","arguments":"{}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`,
+		`data: [DONE]`,
+	}
+
+	// 默认丢弃超出部分，流正常结束
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{MaxToolCalls: 1}), lines...)
+	require.Equal(t, []string{"tool_use"}, blockStartTypes(events))
+	require.JSONEq(t, `{"x":1}`, toolInputJSON(events, 0))
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+
+	events = runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{MaxToolCalls: 2}), lines...)
+	require.Equal(t, []string{"tool_use", "tool_use"}, blockStartTypes(events))
+	require.JSONEq(t, `{"y":2}`, toolInputJSON(events, 1))
+
+	// error 模式以 error 事件结束，usage 仍照常收集
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{MaxToolCalls: 2, ToolCallOverflowMode: ToolCallOverflowError})
+	var raw strings.Builder
+	for _, line := range lines {
+		raw.Write(p.ProcessLine(line))
+	}
+	final, usage := p.Finish()
+	raw.Write(final)
+	events = parseSSEEvents(t, raw.String())
+	last := events[len(events)-1]
+	require.Equal(t, "error", last.Event)
+	errObj, _ := last.Data["error"].(map[string]any)
+	require.Contains(t, errObj["message"], "too many tool calls")
+	require.Equal(t, []string{"tool_use", "tool_use"}, blockStartTypes(events))
+	require.Equal(t, 20, usage.OutputTokens)
+}
+
+func TestStreamingProcessor_DuplicateToolCallIndex(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[` +
+			`{"index":0,"id":"call_1","type":"function","function":{"name":"a","arguments":"{\"x\":"}},` +
+			`{"index":0,"function":{"arguments":"1}"}},` +
+			`{"index":0,"id":"call_2","type":"function","function":{"name":"b","arguments":"{\"y\":"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"2}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	}
+
+	// 默认重新分配 index：两个 tool call 的 arguments 互不混淆，后续增量归属新 tool call
+	events := runStream(t, NewStreamingProcessor("claude-model"), lines...)
+	require.Equal(t, []string{"tool_use", "tool_use"}, blockStartTypes(events))
+	require.JSONEq(t, `{"x":1}`, toolInputJSON(events, 0))
+	require.JSONEq(t, `{"y":2}`, toolInputJSON(events, 1))
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+
+	// error 模式以 error 事件结束
+	events = runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{DuplicateToolIndexMode: DuplicateToolIndexError}), lines...)
+	require.Equal(t, []string{"tool_use"}, blockStartTypes(events))
+	last := events[len(events)-1]
+	require.Equal(t, "error", last.Event)
+	errObj, _ := last.Data["error"].(map[string]any)
+	require.Contains(t, errObj["message"], "duplicate tool call index 0")
+}
+
+func TestStreamingProcessor_WhitespaceDeltas(t *testing.T) {
+	// 前导空 / 空白增量后紧跟 tool call：不产生 text 块
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"\n"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"\n"},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"tool_use"}, blockStartTypes(events))
+
+	// 空白后有正文：空白随正文一并输出
+	events = runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"\n"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+	require.Equal(t, "\nHello", textDeltas(events))
+
+	// 整个响应只有空白：原样输出
+	events = runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":" "},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+	require.Equal(t, " ", textDeltas(events))
+}
+
+func TestStreamingProcessor_UpstreamErrorEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		wantType string
+		wantMsg  string
+	}{
+		{
+			name:     "event error with json payload",
+			lines:    []string{"event: error", `data: {"error":{"message":"model overloaded","type":"server_error","code":503}}`},
+			wantType: "overloaded_error",
+			wantMsg:  "model overloaded",
+		},
+		{
+			name:     "event error with message payload",
+			lines:    []string{"event: error", `data: {"message":"stream aborted"}`},
+			wantType: "api_error",
+			wantMsg:  "stream aborted",
+		},
+		{
+			name:     "event error with plain text",
+			lines:    []string{"event: error", `data: upstream crashed`},
+			wantType: "api_error",
+			wantMsg:  "upstream crashed",
+		},
+		{
+			name:     "data with error object",
+			lines:    []string{`data: {"error":{"message":"rate limited","code":429}}`},
+			wantType: "rate_limit_error",
+			wantMsg:  "rate limited",
+		},
+		{
+			name:     "data with error object and string code",
+			lines:    []string{`data: {"error":{"message":"quota exhausted","type":"requests","code":"rate_limit_exceeded"}}`},
+			wantType: "rate_limit_error",
+			wantMsg:  "quota exhausted",
+		},
+		{
+			name:     "data with error object and numeric string code",
+			lines:    []string{`data: {"error":{"message":"bad gateway","code":"502"}}`},
+			wantType: "api_error",
+			wantMsg:  "bad gateway",
+		},
+		{
+			name:     "data with error type only",
+			lines:    []string{`data: {"error":{"message":"prompt too long","type":"invalid_request_error"}}`},
+			wantType: "invalid_request_error",
+			wantMsg:  "prompt too long",
+		},
+		{
+			name:     "data with error string",
+			lines:    []string{`data: {"error":"connection reset by provider"}`},
+			wantType: "api_error",
+			wantMsg:  "connection reset by provider",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append([]string{
+				": keepalive",
+				"id: 1",
+				`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"partial"}}]}`,
+				"",
+			}, tt.lines...)
+			lines = append(lines, `data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`, "data: [DONE]")
+
+			p := NewStreamingProcessor("claude-model")
+			var raw strings.Builder
+			for _, line := range lines {
+				raw.Write(p.ProcessLine(line))
+			}
+			final, usage := p.Finish()
+			raw.Write(final)
+
+			events := parseSSEEvents(t, raw.String())
+			require.Equal(t, "partial", textDeltas(events))
+			last := events[len(events)-1]
+			require.Equal(t, "error", last.Event)
+			errObj, _ := last.Data["error"].(map[string]any)
+			require.Equal(t, tt.wantType, errObj["type"])
+			require.Equal(t, tt.wantMsg, errObj["message"])
+			require.Equal(t, 2, usage.OutputTokens)
+
+			// 错误之后不再输出 message_delta / message_stop，客户端不会误认为本轮正常完成
+			for _, ev := range events {
+				require.NotEqual(t, "message_stop", ev.Event)
+			}
+		})
+	}
+}
+
+func TestStreamingProcessor_IgnoresNonErrorEvents(t *testing.T) {
+	// 非 error 的 event 行、注释与正文中出现的 "error" 字样均不影响正常输出
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		": OPENROUTER PROCESSING",
+		"event: message",
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"no \"error\" here"}}]}`,
+		"",
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		"data: [DONE]",
+	)
+	require.Equal(t, `no "error" here`, textDeltas(events))
+	require.Equal(t, "message_stop", events[len(events)-1].Event)
+}
+
+func TestStreamingProcessor_SnapshotDeltas(t *testing.T) {
+	lines := []string{
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"Let me"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"reasoning_content":"Let me think"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hel"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hello, world"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hello, world"},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}
+
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{SnapshotDeltas: true}), lines...)
+	require.Equal(t, []string{"thinking", "text"}, blockStartTypes(events))
+	require.Equal(t, "Hello, world", textDeltas(events))
+
+	var thinking strings.Builder
+	for _, ev := range events {
+		delta, _ := ev.Data["delta"].(map[string]any)
+		if chunk, ok := delta["thinking"].(string); ok {
+			thinking.WriteString(chunk)
+		}
+	}
+	require.Equal(t, "Let me think", thinking.String())
+
+	// 未开启时按增量处理，快照内容会重复输出
+	events = runStream(t, NewStreamingProcessor("claude-model"), lines...)
+	require.Equal(t, "HelHelloHello, worldHello, world", textDeltas(events))
+}
+
+func TestStreamingProcessor_SnapshotDeltasIncrementalFallback(t *testing.T) {
+	// 开启快照模式但上游实际发送增量：不以已累计内容为前缀的 delta 原样输出
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", ResponseOptions{SnapshotDeltas: true}),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":", world"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Hello, world!"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, "Hello, world!", textDeltas(events))
+}
+
+func TestStreamingProcessor_ConcurrentUsageReads(t *testing.T) {
+	p := NewStreamingProcessor("claude-test")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// 累计 usage 只增不减，并发读取到的快照也应单调不减
+				usage := p.Usage()
+				if usage.OutputTokens < last {
+					t.Errorf("usage went backwards: %d < %d", usage.OutputTokens, last)
+					return
+				}
+				last = usage.OutputTokens
+			}
+		}()
+	}
+
+	for i := 1; i <= 200; i++ {
+		p.ProcessLine(fmt.Sprintf(`data: {"id":"c","choices":[{"index":0,"delta":{"content":"x"}}],"usage":{"prompt_tokens":10,"completion_tokens":%d},"service_tier":"default"}`, i))
+	}
+	p.ProcessLine("data: [DONE]")
+	close(stop)
+	wg.Wait()
+
+	_, final := p.Finish()
+	usage := p.Usage()
+	require.Equal(t, 10, usage.InputTokens)
+	require.Equal(t, 200, usage.OutputTokens)
+	require.Equal(t, "default", usage.ServiceTier)
+	require.Equal(t, *final, usage)
+}
@@ -0,0 +1,178 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// 文本工具调用协议：用于不支持原生 function calling 的上游
+// 请求侧将工具定义写入 system prompt，并把历史中的 tool_use / tool_result 改写为文本；
+// 响应侧从模型输出文本中解析 <tool_call>{...}</tool_call> 片段，还原为 Claude tool_use 块
+
+const (
+	textToolCallOpenTag  = "<tool_call>"
+	textToolCallCloseTag = "</tool_call>"
+)
+
+// textToolCall 文本协议中单个工具调用的 JSON 结构
+type textToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// textToolSegment 解析后的文本片段，Call 非 nil 表示该片段是一个工具调用
+type textToolSegment struct {
+	Text string
+	Call *textToolCall
+}
+
+// buildTextToolProtocolPrompt 生成描述工具与调用格式的 system 指令
+func buildTextToolProtocolPrompt(tools []antigravity.ClaudeTool, toolChoice json.RawMessage) string {
+	var sb strings.Builder
+	sb.WriteString("You have access to the following tools. To call a tool, output exactly one line per call in this format and nothing else on that line:\n")
+	sb.WriteString(textToolCallOpenTag)
+	sb.WriteString(`{"name": "<tool name>", "arguments": {<JSON arguments>}}`)
+	sb.WriteString(textToolCallCloseTag)
+	sb.WriteString("\nAfter emitting tool calls, stop and wait for the results, which will be provided in <tool_result> tags.\n\nTools:\n")
+
+	for _, tool := range convertTools(tools) {
+		schema, err := json.Marshal(tool.Function.Parameters)
+		if err != nil {
+			schema = []byte("{}")
+		}
+		fmt.Fprintf(&sb, "- %s: %s\n  parameters: %s\n", tool.Function.Name, tool.Function.Description, schema)
+	}
+
+	var tc struct {
+		Type string `json:"type"`
+		Name string `json:"name,omitempty"`
+	}
+	if len(toolChoice) > 0 && json.Unmarshal(toolChoice, &tc) == nil {
+		switch tc.Type {
+		case "any":
+			sb.WriteString("\nYou must call at least one tool in your response.")
+		case "tool":
+			fmt.Fprintf(&sb, "\nYou must call the tool %q in your response.", tc.Name)
+		}
+	}
+
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// formatTextToolCall 将 tool_use 渲染为文本协议格式
+func formatTextToolCall(name string, input any) string {
+	args, err := json.Marshal(input)
+	if err != nil || string(args) == "null" {
+		args = []byte("{}")
+	}
+	call, _ := json.Marshal(textToolCall{Name: name, Arguments: args})
+	return textToolCallOpenTag + string(call) + textToolCallCloseTag
+}
+
+// rewriteToolBlocksAsText 将消息中的 tool_use / tool_result 块改写为文本块，供不支持工具的上游理解历史
+func rewriteToolBlocksAsText(msg antigravity.ClaudeMessage) antigravity.ClaudeMessage {
+	var blocks []antigravity.ContentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return msg
+	}
+
+	changed := false
+	for i, block := range blocks {
+		switch block.Type {
+		case "tool_use":
+			blocks[i] = antigravity.ContentBlock{Type: "text", Text: formatTextToolCall(block.Name, block.Input)}
+			changed = true
+		case "tool_result":
+			text := fmt.Sprintf("<tool_result tool_use_id=%q>\n%s\n</tool_result>", block.ToolUseID, extractToolResultText(block))
+			blocks[i] = antigravity.ContentBlock{Type: "text", Text: text}
+			changed = true
+		}
+	}
+	if !changed {
+		return msg
+	}
+
+	content, err := json.Marshal(blocks)
+	if err != nil {
+		return msg
+	}
+	return antigravity.ClaudeMessage{Role: msg.Role, Content: content}
+}
+
+// parseTextToolCall 解析 <tool_call> 标签内的 JSON，失败返回 nil
+func parseTextToolCall(raw string) *textToolCall {
+	var call textToolCall
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &call); err != nil {
+		return nil
+	}
+	if strings.TrimSpace(call.Name) == "" {
+		return nil
+	}
+	if len(call.Arguments) == 0 || string(call.Arguments) == "null" {
+		call.Arguments = json.RawMessage("{}")
+	}
+	return &call
+}
+
+// splitTextToolCalls 将完整文本拆分为普通文本与工具调用片段
+// 无法解析的 <tool_call> 片段按原文保留为文本
+func splitTextToolCalls(text string) []textToolSegment {
+	var segments []textToolSegment
+	rest := text
+	for {
+		start := strings.Index(rest, textToolCallOpenTag)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], textToolCallCloseTag)
+		if end < 0 {
+			break
+		}
+		end += start
+
+		call := parseTextToolCall(rest[start+len(textToolCallOpenTag) : end])
+		if call == nil {
+			segments = append(segments, textToolSegment{Text: rest[:end+len(textToolCallCloseTag)]})
+		} else {
+			if before := rest[:start]; strings.TrimSpace(before) != "" {
+				segments = append(segments, textToolSegment{Text: before})
+			}
+			segments = append(segments, textToolSegment{Call: call})
+		}
+		rest = rest[end+len(textToolCallCloseTag):]
+	}
+	if strings.TrimSpace(rest) != "" {
+		segments = append(segments, textToolSegment{Text: rest})
+	}
+	return mergeTextSegments(segments)
+}
+
+// mergeTextSegments 合并相邻的文本片段
+func mergeTextSegments(segments []textToolSegment) []textToolSegment {
+	var merged []textToolSegment
+	for _, seg := range segments {
+		if seg.Call == nil && len(merged) > 0 && merged[len(merged)-1].Call == nil {
+			merged[len(merged)-1].Text += seg.Text
+			continue
+		}
+		merged = append(merged, seg)
+	}
+	return merged
+}
+
+// partialTagSuffixLen 返回 s 末尾与 tag 前缀重合的最大长度，用于流式时暂缓输出可能属于标签的字符
+func partialTagSuffixLen(s, tag string) int {
+	maxLen := len(tag) - 1
+	if maxLen > len(s) {
+		maxLen = len(s)
+	}
+	for n := maxLen; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
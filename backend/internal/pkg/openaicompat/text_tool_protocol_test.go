@@ -0,0 +1,142 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_TextToolProtocolRequest(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "m",
+		"system": "be brief",
+		"tools": [{"name": "get_weather", "description": "Get weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"tool_choice": {"type": "any"},
+		"messages": [
+			{"role": "user", "content": "weather in Paris?"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"}]}
+		]
+	}`), &claudeReq))
+
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{TextToolProtocol: true})
+	require.NoError(t, err)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+
+	require.Empty(t, req.Tools)
+	require.Nil(t, req.ToolChoice)
+	require.Len(t, req.Messages, 4)
+
+	var system string
+	require.NoError(t, json.Unmarshal(req.Messages[0].Content, &system))
+	require.True(t, strings.HasPrefix(system, "be brief\n\n"))
+	require.Contains(t, system, "get_weather")
+	require.Contains(t, system, "You must call at least one tool")
+
+	var assistant string
+	require.Equal(t, "assistant", req.Messages[2].Role)
+	require.Empty(t, req.Messages[2].ToolCalls)
+	require.NoError(t, json.Unmarshal(req.Messages[2].Content, &assistant))
+	require.Equal(t, `<tool_call>{"name":"get_weather","arguments":{"city":"Paris"}}</tool_call>`, assistant)
+
+	var toolResult string
+	require.Equal(t, "user", req.Messages[3].Role)
+	require.NoError(t, json.Unmarshal(req.Messages[3].Content, &toolResult))
+	require.Contains(t, toolResult, `<tool_result tool_use_id="toolu_1">`)
+	require.Contains(t, toolResult, "sunny")
+}
+
+func TestTransformOpenAIToClaude_TextToolProtocol(t *testing.T) {
+	text := "Let me check.\n<tool_call>{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}</tool_call>"
+	content, _ := json.Marshal(text)
+	body := []byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":` + string(content) + `},"finish_reason":"stop"}]}`)
+
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", ResponseOptions{TextToolProtocol: true})
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+
+	require.Equal(t, "tool_use", resp.StopReason)
+	require.Len(t, resp.Content, 2)
+	require.Equal(t, "text", resp.Content[0].Type)
+	require.Equal(t, "Let me check.\n", resp.Content[0].Text)
+	require.Equal(t, "tool_use", resp.Content[1].Type)
+	require.Equal(t, "get_weather", resp.Content[1].Name)
+	require.True(t, strings.HasPrefix(resp.Content[1].ID, "toolu_"))
+	require.Equal(t, map[string]any{"city": "Paris"}, resp.Content[1].Input)
+
+	// 未开启时原样作为文本返回
+	out, _, err = TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "end_turn", resp.StopReason)
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, text, resp.Content[0].Text)
+}
+
+func TestStreamingProcessor_TextToolProtocol(t *testing.T) {
+	chunk := func(text string) string {
+		content, _ := json.Marshal(text)
+		return `data: {"id":"c1","choices":[{"index":0,"delta":{"content":` + string(content) + `}}]}`
+	}
+
+	// 标签与参数被拆散到多个 chunk 中
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{TextToolProtocol: true})
+	events := runStream(t, p,
+		chunk("Checking <"),
+		chunk("tool_"),
+		chunk(`call>{"name":"get_weather",`),
+		chunk(`"arguments":{"city":"Paris"}}</tool`),
+		chunk("_call>"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+
+	require.Equal(t, []string{"text", "tool_use"}, blockStartTypes(events))
+
+	var text, partialJSON, stopReason string
+	for _, ev := range events {
+		switch ev.Event {
+		case "content_block_start":
+			block := ev.Data["content_block"].(map[string]any)
+			if block["type"] == "tool_use" {
+				require.Equal(t, "get_weather", block["name"])
+			}
+		case "content_block_delta":
+			delta := ev.Data["delta"].(map[string]any)
+			switch delta["type"] {
+			case "text_delta":
+				text += delta["text"].(string)
+			case "input_json_delta":
+				partialJSON += delta["partial_json"].(string)
+			}
+		case "message_delta":
+			stopReason = ev.Data["delta"].(map[string]any)["stop_reason"].(string)
+		}
+	}
+	require.Equal(t, "Checking ", text)
+	require.JSONEq(t, `{"city":"Paris"}`, partialJSON)
+	require.Equal(t, "tool_use", stopReason)
+}
+
+func TestStreamingProcessor_TextToolProtocolUnclosedTag(t *testing.T) {
+	p := NewStreamingProcessorWithOptions("claude-model", ResponseOptions{TextToolProtocol: true})
+	events := runStream(t, p,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"a <tool_call>{\"name\""}}]}`,
+		`data: [DONE]`,
+	)
+
+	var text string
+	for _, ev := range events {
+		if ev.Event == "content_block_delta" {
+			text += ev.Data["delta"].(map[string]any)["text"].(string)
+		}
+	}
+	require.Equal(t, `a <tool_call>{"name"`, text)
+	require.Equal(t, []string{"text"}, blockStartTypes(events))
+}
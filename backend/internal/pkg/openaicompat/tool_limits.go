@@ -0,0 +1,78 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 发送给上游的 tools 超过 MaxTools / MaxToolSchemaBytes 时的处理方式
+const (
+	ToolOverflowTruncate = "truncate" // 按顺序保留不超限的工具，丢弃其余并记录被丢弃的工具名（默认）
+	ToolOverflowError    = "error"    // 返回 TooManyToolsError / ToolSchemasTooLargeError，不请求上游
+)
+
+// TooManyToolsError 请求中的工具数量超过上限（ToolOverflowError 模式下返回）
+type TooManyToolsError struct {
+	Count int
+	Limit int
+}
+
+func (e *TooManyToolsError) Error() string {
+	return fmt.Sprintf("request has %d tools, exceeds limit of %d", e.Count, e.Limit)
+}
+
+// ToolSchemasTooLargeError 请求中工具定义序列化后的总大小超过上限（ToolOverflowError 模式下返回）
+type ToolSchemasTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *ToolSchemasTooLargeError) Error() string {
+	return fmt.Sprintf("tool definitions total %d bytes, exceeds limit of %d bytes", e.Size, e.Limit)
+}
+
+// limitTools 按 opts.MaxTools（数量）与 opts.MaxToolSchemaBytes（序列化后的总字节数）限制发送给上游的工具
+// truncate 模式下按顺序保留能放下的工具（超过大小预算的工具跳过，后续较小的工具仍可保留）；error 模式下超限即返回错误
+func limitTools(tools []Tool, opts RequestOptions) ([]Tool, error) {
+	maxTools, maxBytes := opts.MaxTools, opts.MaxToolSchemaBytes
+	if maxTools <= 0 && maxBytes <= 0 {
+		return tools, nil
+	}
+	reject := strings.ToLower(strings.TrimSpace(opts.ToolOverflowMode)) == ToolOverflowError
+
+	sizes := make([]int, len(tools))
+	total := 0
+	for i, tool := range tools {
+		encoded, _ := json.Marshal(tool)
+		sizes[i] = len(encoded)
+		total += sizes[i]
+	}
+	if reject {
+		if maxTools > 0 && len(tools) > maxTools {
+			return nil, &TooManyToolsError{Count: len(tools), Limit: maxTools}
+		}
+		if maxBytes > 0 && total > maxBytes {
+			return nil, &ToolSchemasTooLargeError{Size: total, Limit: maxBytes}
+		}
+		return tools, nil
+	}
+
+	kept := make([]Tool, 0, len(tools))
+	var dropped []string
+	used := 0
+	for i, tool := range tools {
+		if (maxTools > 0 && len(kept) >= maxTools) || (maxBytes > 0 && used+sizes[i] > maxBytes) {
+			dropped = append(dropped, tool.Function.Name)
+			continue
+		}
+		kept = append(kept, tool)
+		used += sizes[i]
+	}
+	if len(dropped) > 0 {
+		log.Printf("[OpenAICompat] dropped %d of %d tools exceeding limits (max_tools=%d, max_tool_schema_bytes=%d): %s",
+			len(dropped), len(tools), maxTools, maxBytes, strings.Join(dropped, ", "))
+	}
+	return kept, nil
+}
@@ -0,0 +1,79 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+// transformWithTools 构造带指定工具的请求并按 opts 转换
+func transformWithTools(t *testing.T, tools []antigravity.ClaudeTool, opts RequestOptions) (ChatRequest, error) {
+	t.Helper()
+	claudeReq := antigravity.ClaudeRequest{
+		Model:    "m",
+		Messages: []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
+		Tools:    tools,
+	}
+	body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, opts)
+	if err != nil {
+		return ChatRequest{}, err
+	}
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	return req, nil
+}
+
+func testTools(descriptions ...string) []antigravity.ClaudeTool {
+	tools := make([]antigravity.ClaudeTool, 0, len(descriptions))
+	for i, description := range descriptions {
+		tools = append(tools, antigravity.ClaudeTool{Name: fmt.Sprintf("tool_%d", i), Description: description})
+	}
+	return tools
+}
+
+func toolNames(tools []Tool) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	return names
+}
+
+func TestLimitTools_MaxTools(t *testing.T) {
+	tools := testTools("a", "b", "c", "d")
+
+	req, err := transformWithTools(t, tools, RequestOptions{MaxTools: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"tool_0", "tool_1"}, toolNames(req.Tools))
+
+	_, err = transformWithTools(t, tools, RequestOptions{MaxTools: 2, ToolOverflowMode: ToolOverflowError})
+	var tooMany *TooManyToolsError
+	require.ErrorAs(t, err, &tooMany)
+	require.Equal(t, 4, tooMany.Count)
+	require.Equal(t, 2, tooMany.Limit)
+
+	req, err = transformWithTools(t, tools, RequestOptions{MaxTools: 4, ToolOverflowMode: ToolOverflowError})
+	require.NoError(t, err)
+	require.Len(t, req.Tools, 4)
+}
+
+func TestLimitTools_MaxToolSchemaBytes(t *testing.T) {
+	tools := testTools("short", strings.Repeat("x", 500), "short")
+	small, _ := json.Marshal(convertTools(tools[:1])[0])
+	budget := 2*len(small) + 10
+
+	// 超过预算的大工具被跳过，之后的小工具仍保留
+	req, err := transformWithTools(t, tools, RequestOptions{MaxToolSchemaBytes: budget})
+	require.NoError(t, err)
+	require.Equal(t, []string{"tool_0", "tool_2"}, toolNames(req.Tools))
+
+	_, err = transformWithTools(t, tools, RequestOptions{MaxToolSchemaBytes: budget, ToolOverflowMode: ToolOverflowError})
+	var tooLarge *ToolSchemasTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, budget, tooLarge.Limit)
+	require.Greater(t, tooLarge.Size, budget)
+}
@@ -0,0 +1,104 @@
+package openaicompat
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// maxToolNameLength OpenAI 函数名的最大长度（函数名须匹配 ^[a-zA-Z0-9_-]{1,64}$）
+const maxToolNameLength = 64
+
+// sanitizeToolName 将不符合 OpenAI 函数名规则的字符替换为下划线并截断至 64 个字符，空名称返回 "_"
+func sanitizeToolName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if sb.Len() >= maxToolNameLength {
+			break
+		}
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+// toolNameMapping 为 Claude 工具名生成符合 OpenAI 规则的上游名称
+// 返回 toUpstream（原名 → 上游名）与 toClaude（上游名 → 原名），仅包含需要改名的工具；
+// 改名后与其他工具重名时追加 "_2"、"_3" 等后缀保证唯一
+func toolNameMapping(tools []antigravity.ClaudeTool) (toUpstream, toClaude map[string]string) {
+	used := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		name := strings.TrimSpace(tool.Name)
+		if sanitizeToolName(name) == name {
+			used[name] = true
+		}
+	}
+	for _, tool := range tools {
+		name := strings.TrimSpace(tool.Name)
+		base := sanitizeToolName(name)
+		if name == "" || base == name {
+			continue
+		}
+		if _, done := toUpstream[name]; done {
+			continue
+		}
+		upstream := base
+		for i := 2; used[upstream]; i++ {
+			suffix := "_" + strconv.Itoa(i)
+			upstream = base[:min(len(base), maxToolNameLength-len(suffix))] + suffix
+		}
+		used[upstream] = true
+		if toUpstream == nil {
+			toUpstream, toClaude = make(map[string]string), make(map[string]string)
+		}
+		toUpstream[name] = upstream
+		toClaude[upstream] = name
+	}
+	return toUpstream, toClaude
+}
+
+// ToolNameMapping 返回请求中改名后的上游工具名 → Claude 原工具名映射，用于 ResponseOptions.ToolNames；
+// 所有工具名均符合 OpenAI 规则时返回 nil
+func ToolNameMapping(tools []antigravity.ClaudeTool) map[string]string {
+	_, toClaude := toolNameMapping(tools)
+	return toClaude
+}
+
+// applyUpstreamToolNames 将请求中工具定义、历史 tool call 与 tool_choice 的函数名替换为上游名称
+// 不在工具列表中的历史工具名直接按规则清洗
+func applyUpstreamToolNames(req *ChatRequest, toUpstream map[string]string) {
+	rename := func(name string) string {
+		if upstream, ok := toUpstream[name]; ok {
+			return upstream
+		}
+		return sanitizeToolName(name)
+	}
+	for i := range req.Tools {
+		req.Tools[i].Function.Name = rename(req.Tools[i].Function.Name)
+	}
+	for i := range req.Messages {
+		for j := range req.Messages[i].ToolCalls {
+			req.Messages[i].ToolCalls[j].Function.Name = rename(req.Messages[i].ToolCalls[j].Function.Name)
+		}
+	}
+	if choice, ok := req.ToolChoice.(map[string]any); ok {
+		if fn, ok := choice["function"].(map[string]string); ok {
+			fn["name"] = rename(fn["name"])
+		}
+	}
+}
+
+// claudeToolName 将上游返回的函数名还原为 Claude 原工具名
+func claudeToolName(name string, toClaude map[string]string) string {
+	if original, ok := toClaude[name]; ok {
+		return original
+	}
+	return name
+}
@@ -0,0 +1,75 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeToolName(t *testing.T) {
+	require.Equal(t, "get_weather", sanitizeToolName("get_weather"))
+	require.Equal(t, "mcp_github_create-issue", sanitizeToolName("mcp.github/create-issue"))
+	require.Equal(t, "read_file", sanitizeToolName("read file"))
+	require.Equal(t, "_", sanitizeToolName(""))
+	require.Equal(t, strings.Repeat("a", 64), sanitizeToolName(strings.Repeat("a", 80)))
+}
+
+func TestToolNameMapping_Collisions(t *testing.T) {
+	tools := []antigravity.ClaudeTool{{Name: "a_b"}, {Name: "a.b"}, {Name: "a b"}, {Name: "ok"}}
+	toUpstream, toClaude := toolNameMapping(tools)
+	require.Equal(t, map[string]string{"a.b": "a_b_2", "a b": "a_b_3"}, toUpstream)
+	require.Equal(t, map[string]string{"a_b_2": "a.b", "a_b_3": "a b"}, toClaude)
+
+	long := strings.Repeat("x", 70)
+	toUpstream, _ = toolNameMapping([]antigravity.ClaudeTool{{Name: long + "1"}, {Name: long + "2"}})
+	require.Len(t, toUpstream[long+"1"], 64)
+	require.Len(t, toUpstream[long+"2"], 64)
+	require.NotEqual(t, toUpstream[long+"1"], toUpstream[long+"2"])
+
+	require.Nil(t, ToolNameMapping([]antigravity.ClaudeTool{{Name: "valid_name"}}))
+}
+
+func TestTransformClaudeToOpenAI_SanitizesToolNames(t *testing.T) {
+	req := transformRequest(t, `{
+		"model": "m",
+		"tools": [{"name": "mcp.fs/read file", "input_schema": {"type": "object"}}, {"name": "plain"}],
+		"tool_choice": {"type": "tool", "name": "mcp.fs/read file"},
+		"messages": [
+			{"role": "user", "content": "read it"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "mcp.fs/read file", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"}]}
+		]
+	}`)
+	require.Equal(t, []string{"mcp_fs_read_file", "plain"}, toolNames(req.Tools))
+	require.Equal(t, "mcp_fs_read_file", req.Messages[1].ToolCalls[0].Function.Name)
+	require.Equal(t, map[string]any{"type": "function", "function": map[string]any{"name": "mcp_fs_read_file"}}, req.ToolChoice)
+}
+
+func TestToolNames_RestoredInResponses(t *testing.T) {
+	toolNames := ToolNameMapping([]antigravity.ClaudeTool{{Name: "mcp.fs/read file"}})
+	opts := ResponseOptions{ToolNames: toolNames}
+
+	body := []byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"mcp_fs_read_file","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`)
+	out, _, err := TransformOpenAIToClaudeWithOptions(body, "claude-model", opts)
+	require.NoError(t, err)
+	var resp antigravity.ClaudeResponse
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Equal(t, "mcp.fs/read file", resp.Content[0].Name)
+
+	events := runStream(t, NewStreamingProcessorWithOptions("claude-model", opts),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"mcp_fs_read_file","arguments":"{}"}}]}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	)
+	var names []string
+	for _, ev := range events {
+		if ev.Event == "content_block_start" {
+			block, _ := ev.Data["content_block"].(map[string]any)
+			names = append(names, block["name"].(string))
+		}
+	}
+	require.Equal(t, []string{"mcp.fs/read file"}, names)
+}
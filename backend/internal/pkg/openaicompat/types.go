@@ -1,22 +1,61 @@
 package openaicompat
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+)
 
 // OpenAI Chat Completions 请求/响应类型定义
 // 适用于所有 OpenAI Chat Completions 兼容 API（OpenRouter、LiteLLM、One API、vLLM 等）
 
 // ChatRequest OpenAI Chat Completions 请求
 type ChatRequest struct {
-	Model         string           `json:"model"`
-	Messages      []ChatMessage    `json:"messages"`
-	MaxTokens     int              `json:"max_tokens,omitempty"`
-	Temperature   *float64         `json:"temperature,omitempty"`
-	TopP          *float64         `json:"top_p,omitempty"`
-	Stream        bool             `json:"stream,omitempty"`
-	Tools         []Tool           `json:"tools,omitempty"`
-	ToolChoice    any              `json:"tool_choice,omitempty"` // string ("auto"/"none"/"required") 或 object
-	StreamOptions *StreamOpts      `json:"stream_options,omitempty"`
-	Reasoning     *ReasoningConfig `json:"reasoning,omitempty"`
+	Model             string           `json:"model"`
+	Messages          []ChatMessage    `json:"messages"`
+	MaxTokens         int              `json:"max_tokens,omitempty"`
+	Temperature       *float64         `json:"temperature,omitempty"`
+	TopP              *float64         `json:"top_p,omitempty"`
+	TopK              *int             `json:"top_k,omitempty"`
+	Seed              *int64           `json:"seed,omitempty"`
+	ServiceTier       string           `json:"service_tier,omitempty"` // "auto" / "default" / "flex" / "priority"
+	Logprobs          *bool            `json:"logprobs,omitempty"`
+	TopLogprobs       *int             `json:"top_logprobs,omitempty"`
+	Stream            bool             `json:"stream,omitempty"`
+	Tools             []Tool           `json:"tools,omitempty"`
+	ToolChoice        any              `json:"tool_choice,omitempty"` // string ("auto"/"none"/"required") 或 object
+	ParallelToolCalls *bool            `json:"parallel_tool_calls,omitempty"`
+	StreamOptions     *StreamOpts      `json:"stream_options,omitempty"`
+	Reasoning         *ReasoningConfig `json:"reasoning,omitempty"`
+	Plugins           []Plugin         `json:"plugins,omitempty"`    // OpenRouter 插件（如 web 搜索）
+	Modalities        []string         `json:"modalities,omitempty"` // 输出模态，如 ["text","audio"]
+	Audio             *AudioConfig     `json:"audio,omitempty"`      // modalities 含 audio 时的音频输出参数
+	// ExtraBody / ChatTemplateKwargs 按 TopKPlacement 承载嵌套的采样参数（如 top_k）
+	ExtraBody          map[string]any `json:"extra_body,omitempty"`
+	ChatTemplateKwargs map[string]any `json:"chat_template_kwargs,omitempty"`
+	// PromptCacheRetention 提示缓存保留时长（OpenAI："in_memory" / "24h"），由 cache_control.ttl 映射
+	PromptCacheRetention string `json:"prompt_cache_retention,omitempty"`
+	// Prediction predicted outputs（{"type":"content","content":...}），仅下发给支持的上游
+	Prediction any `json:"prediction,omitempty"`
+}
+
+// AudioConfig 音频输出参数
+type AudioConfig struct {
+	Voice  string `json:"voice"`  // "alloy" 等
+	Format string `json:"format"` // "wav" / "mp3" / "flac" / "opus" / "pcm16"
+}
+
+// ChatAudio 上游返回的音频输出（流式时 data 与 transcript 为增量）；
+// 在历史 assistant 消息中仅携带 id 以引用之前的音频回复
+type ChatAudio struct {
+	ID         string `json:"id,omitempty"`
+	Data       string `json:"data,omitempty"` // base64 音频数据
+	ExpiresAt  int64  `json:"expires_at,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+}
+
+// Plugin OpenRouter 插件配置
+type Plugin struct {
+	ID string `json:"id"` // "web"
 }
 
 // StreamOpts 流式选项
@@ -26,7 +65,8 @@ type StreamOpts struct {
 
 // ReasoningConfig reasoning 配置 (对应 Claude 的 thinking)
 type ReasoningConfig struct {
-	Effort string `json:"effort,omitempty"` // "high", "medium", "low"
+	Effort    string `json:"effort,omitempty"`     // "high", "medium", "low", "minimal"（仅 gpt-5 系列）
+	MaxTokens int    `json:"max_tokens,omitempty"` // 推理 token 预算（与 effort 二选一）
 }
 
 // ThinkingField 用于在历史消息中传递 thinking+signature
@@ -41,17 +81,37 @@ type ChatMessage struct {
 	Content          json.RawMessage   `json:"content,omitempty"`
 	Reasoning        string            `json:"reasoning,omitempty"`
 	ReasoningContent string            `json:"reasoning_content,omitempty"` // 部分模型使用此字段
+	Refusal          string            `json:"refusal,omitempty"`           // 模型拒绝回答时的说明（OpenAI structured refusal）
 	ReasoningDetails []ReasoningDetail `json:"reasoning_details,omitempty"`
 	ThinkingField    *ThinkingField    `json:"thinking,omitempty"` // 带 signature 的 thinking 传递
 	ToolCalls        []ToolCall        `json:"tool_calls,omitempty"`
+	FunctionCall     *FunctionCall     `json:"function_call,omitempty"` // 已废弃的单函数调用格式（旧版上游）
 	ToolCallID       string            `json:"tool_call_id,omitempty"`
 	Name             string            `json:"name,omitempty"`
+	Annotations      []Annotation      `json:"annotations,omitempty"` // 上游 web 搜索返回的引用
+	Audio            *ChatAudio        `json:"audio,omitempty"`       // 请求音频输出时返回的音频
+}
+
+// Annotation 消息注解（OpenRouter / OpenAI web 搜索引用）
+type Annotation struct {
+	Type        string       `json:"type"` // "url_citation"
+	URLCitation *URLCitation `json:"url_citation,omitempty"`
+}
+
+// URLCitation 网页引用
+type URLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content,omitempty"`
+	StartIndex int    `json:"start_index,omitempty"`
+	EndIndex   int    `json:"end_index,omitempty"`
 }
 
 // ReasoningDetail reasoning 详情
 type ReasoningDetail struct {
-	Type string `json:"type,omitempty"` // "reasoning.text", "reasoning.signature" 等
-	Text string `json:"text,omitempty"`
+	Type    string `json:"type,omitempty"` // "reasoning.text", "reasoning.summary", "reasoning.signature" 等
+	Text    string `json:"text,omitempty"`
+	Summary string `json:"summary,omitempty"` // type 为 reasoning.summary 时的摘要文本
 }
 
 // ToolCall 工具调用
@@ -68,6 +128,36 @@ type FunctionCall struct {
 	Arguments string `json:"arguments"` // JSON string
 }
 
+// UnmarshalJSON 兼容部分上游将 arguments 直接返回为 JSON 对象（而非字符串）的情况，
+// 统一规整为 JSON 字符串；null 视为空
+func (f *FunctionCall) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	f.Name = raw.Name
+	f.Arguments = ""
+
+	args := bytes.TrimSpace(raw.Arguments)
+	switch {
+	case len(args) == 0 || bytes.Equal(args, []byte("null")):
+	case args[0] == '"':
+		if err := json.Unmarshal(args, &f.Arguments); err != nil {
+			return err
+		}
+	default:
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, args); err != nil {
+			return err
+		}
+		f.Arguments = compacted.String()
+	}
+	return nil
+}
+
 // Tool 工具定义
 type Tool struct {
 	Type     string      `json:"type"` // "function"
@@ -83,44 +173,60 @@ type FunctionDef struct {
 
 // ChatResponse OpenAI 非流式响应
 type ChatResponse struct {
-	ID      string       `json:"id"`
-	Object  string       `json:"object"`
-	Model   string       `json:"model"`
-	Choices []ChatChoice `json:"choices"`
-	Usage   *Usage       `json:"usage,omitempty"`
+	ID                string       `json:"id"`
+	Object            string       `json:"object"`
+	Model             string       `json:"model"`
+	SystemFingerprint string       `json:"system_fingerprint,omitempty"`
+	ServiceTier       string       `json:"service_tier,omitempty"` // 上游实际使用的服务等级
+	Choices           []ChatChoice `json:"choices"`
+	Usage             *Usage       `json:"usage,omitempty"`
 }
 
 // ChatChoice 选择项
 type ChatChoice struct {
-	Index        int         `json:"index"`
-	Message      ChatMessage `json:"message"`
-	FinishReason string      `json:"finish_reason"` // stop, tool_calls, length
+	Index        int             `json:"index"`
+	Message      ChatMessage     `json:"message"`
+	FinishReason string          `json:"finish_reason"`      // stop, tool_calls, function_call, length
+	Logprobs     json.RawMessage `json:"logprobs,omitempty"` // {"content":[...]}，请求 logprobs 时返回
 }
 
 // StreamChunk OpenAI 流式 chunk
 type StreamChunk struct {
-	ID      string              `json:"id"`
-	Object  string              `json:"object"`
-	Model   string              `json:"model"`
-	Choices []StreamChunkChoice `json:"choices"`
-	Usage   *Usage              `json:"usage,omitempty"`
+	ID                string              `json:"id"`
+	Object            string              `json:"object"`
+	Model             string              `json:"model"`
+	SystemFingerprint string              `json:"system_fingerprint,omitempty"` // 通常仅在首个 chunk 中返回
+	ServiceTier       string              `json:"service_tier,omitempty"`       // 上游实际使用的服务等级
+	Choices           []StreamChunkChoice `json:"choices"`
+	Usage             *Usage              `json:"usage,omitempty"`
 }
 
 // StreamChunkChoice 流式选择项
 type StreamChunkChoice struct {
 	Index        int              `json:"index"`
 	Delta        StreamChunkDelta `json:"delta"`
-	FinishReason *string          `json:"finish_reason"` // nil or "stop", "tool_calls", "length"
+	FinishReason *string          `json:"finish_reason"`      // nil or "stop", "tool_calls", "function_call", "length"
+	Logprobs     *Logprobs        `json:"logprobs,omitempty"` // 本 chunk 输出 token 的 logprobs
+}
+
+// Logprobs token logprobs（仅解析 content，其余字段不透传）
+type Logprobs struct {
+	Content []json.RawMessage `json:"content"`
 }
 
 // StreamChunkDelta 流式增量
 type StreamChunkDelta struct {
-	Role             string         `json:"role,omitempty"`
-	Content          string         `json:"content,omitempty"`
-	Thinking         *ThinkingDelta `json:"thinking,omitempty"`
-	ReasoningContent string         `json:"reasoning_content,omitempty"` // 部分模型使用此字段
-	Reasoning        string         `json:"reasoning,omitempty"`         // 部分模型使用此字段
-	ToolCalls        []ToolCall     `json:"tool_calls,omitempty"`
+	Role             string            `json:"role,omitempty"`
+	Content          string            `json:"content,omitempty"`
+	Thinking         *ThinkingDelta    `json:"thinking,omitempty"`
+	ReasoningContent string            `json:"reasoning_content,omitempty"` // 部分模型使用此字段
+	Reasoning        string            `json:"reasoning,omitempty"`         // 部分模型使用此字段
+	ReasoningDetails []ReasoningDetail `json:"reasoning_details,omitempty"` // 区分推理摘要与完整推理（OpenRouter 等）
+	Refusal          string            `json:"refusal,omitempty"`
+	ToolCalls        []ToolCall        `json:"tool_calls,omitempty"`
+	FunctionCall     *FunctionCall     `json:"function_call,omitempty"` // 已废弃的单函数调用格式（旧版上游）
+	Annotations      []Annotation      `json:"annotations,omitempty"`
+	Audio            *ChatAudio        `json:"audio,omitempty"`
 }
 
 // ThinkingDelta reasoning/thinking 流式增量
@@ -131,15 +237,34 @@ type ThinkingDelta struct {
 
 // Usage 用量
 type Usage struct {
-	PromptTokens        int                  `json:"prompt_tokens"`
-	CompletionTokens    int                  `json:"completion_tokens"`
-	TotalTokens         int                  `json:"total_tokens"`
-	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+	ServerToolUse           *ServerToolUse           `json:"server_tool_use,omitempty"`    // OpenRouter 等返回的服务端工具用量
+	NumSearchQueries        int                      `json:"num_search_queries,omitempty"` // Perplexity 返回的搜索次数
+	// CacheCreationInputTokens 缓存写入 token 数（LiteLLM 等 Anthropic 兼容代理在 usage 顶层返回），已包含在 prompt_tokens 中
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+// ServerToolUse 服务端工具用量
+type ServerToolUse struct {
+	WebSearchRequests int `json:"web_search_requests,omitempty"`
 }
 
 // PromptTokensDetails prompt token 详情
 type PromptTokensDetails struct {
-	CachedTokens int `json:"cached_tokens,omitempty"`
+	CachedTokens     int `json:"cached_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"` // OpenRouter 返回的缓存写入 token 数
+}
+
+// CompletionTokensDetails completion token 详情
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens,omitempty"` // 已包含在 completion_tokens 中
+	// predicted outputs 中被采纳 / 未被采纳的 token 数（未被采纳的部分同样计入 completion_tokens）
+	AcceptedPredictionTokens int `json:"accepted_prediction_tokens,omitempty"`
+	RejectedPredictionTokens int `json:"rejected_prediction_tokens,omitempty"`
 }
 
 // ContentPart OpenAI 多模态内容块
@@ -0,0 +1,162 @@
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SchemaValidationError 转换后的请求或上游响应不符合 Chat Completions 结构（调试校验开启时返回）
+type SchemaValidationError struct {
+	Kind     string   // "request" 或 "response"
+	Problems []string // 每项为 "<JSON 路径>: <问题>"
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("openai %s schema validation failed: %s", e.Kind, strings.Join(e.Problems, "; "))
+}
+
+// validChatRoles Chat Completions 请求允许的消息角色
+var validChatRoles = map[string]bool{"system": true, "developer": true, "user": true, "assistant": true, "tool": true}
+
+// ValidateChatRequest 校验转换后的请求体是否符合 ChatRequest 结构：
+// model / messages 必填，消息角色合法，tool 消息带 tool_call_id，tool call 与工具定义带名称，content 为字符串、内容块数组或 null
+// 仅用于调试（捕获转换器缺陷），不做完整的 JSON Schema 校验
+func ValidateChatRequest(body []byte) error {
+	v := &schemaValidator{kind: "request"}
+	var req ChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		v.addf("$", "not a valid chat request: %v", err)
+		return v.err()
+	}
+
+	if strings.TrimSpace(req.Model) == "" {
+		v.addf("model", "required")
+	}
+	if len(req.Messages) == 0 {
+		v.addf("messages", "must contain at least one message")
+	}
+	if req.MaxTokens < 0 {
+		v.addf("max_tokens", "must be non-negative, got %d", req.MaxTokens)
+	}
+	for i, msg := range req.Messages {
+		path := fmt.Sprintf("messages[%d]", i)
+		if !validChatRoles[msg.Role] {
+			v.addf(path+".role", "invalid role %q", msg.Role)
+		}
+		if msg.Role == "tool" && msg.ToolCallID == "" {
+			v.addf(path+".tool_call_id", "required for tool messages")
+		}
+		if len(msg.ToolCalls) > 0 && msg.Role != "assistant" {
+			v.addf(path+".tool_calls", "only allowed on assistant messages")
+		}
+		v.validateContent(path+".content", msg.Content)
+		v.validateToolCalls(path+".tool_calls", msg.ToolCalls, true)
+	}
+	for i, tool := range req.Tools {
+		path := fmt.Sprintf("tools[%d]", i)
+		if tool.Type != "function" {
+			v.addf(path+".type", "must be \"function\", got %q", tool.Type)
+		}
+		if strings.TrimSpace(tool.Function.Name) == "" {
+			v.addf(path+".function.name", "required")
+		}
+	}
+	return v.err()
+}
+
+// ValidateChatResponse 校验上游非流式响应体是否符合 ChatResponse 结构：
+// choices 必须存在，每个 choice 的 message 角色为 assistant（或省略），tool call 带 id 与名称
+func ValidateChatResponse(body []byte) error {
+	v := &schemaValidator{kind: "response"}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		v.addf("$", "not a JSON object: %v", err)
+		return v.err()
+	}
+	var resp ChatResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		v.addf("$", "not a valid chat response: %v", err)
+		return v.err()
+	}
+
+	if choices, ok := raw["choices"]; !ok || bytes.Equal(bytes.TrimSpace(choices), []byte("null")) {
+		v.addf("choices", "required")
+	}
+	for i, choice := range resp.Choices {
+		path := fmt.Sprintf("choices[%d].message", i)
+		if choice.Message.Role != "" && choice.Message.Role != "assistant" {
+			v.addf(path+".role", "must be \"assistant\", got %q", choice.Message.Role)
+		}
+		v.validateContent(path+".content", choice.Message.Content)
+		v.validateToolCalls(path+".tool_calls", choice.Message.ToolCalls, false)
+	}
+	if resp.Usage != nil && (resp.Usage.PromptTokens < 0 || resp.Usage.CompletionTokens < 0) {
+		v.addf("usage", "token counts must be non-negative")
+	}
+	return v.err()
+}
+
+// schemaValidator 收集校验问题
+type schemaValidator struct {
+	kind     string
+	problems []string
+}
+
+func (v *schemaValidator) addf(path, format string, args ...any) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &SchemaValidationError{Kind: v.kind, Problems: v.problems}
+}
+
+// validateContent 校验 content：省略、null、字符串或内容块数组（每块带 type，文本块带 text，图片块带 image_url）
+func (v *schemaValidator) validateContent(path string, content json.RawMessage) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) || trimmed[0] == '"' {
+		return
+	}
+	var parts []map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &parts); err != nil {
+		v.addf(path, "must be a string, an array of content parts or null")
+		return
+	}
+	for i, part := range parts {
+		partPath := fmt.Sprintf("%s[%d]", path, i)
+		var partType string
+		_ = json.Unmarshal(part["type"], &partType)
+		switch partType {
+		case "":
+			v.addf(partPath+".type", "required")
+		case contentPartText, "input_text":
+			if _, ok := part["text"]; !ok {
+				v.addf(partPath+".text", "required for %s parts", partType)
+			}
+		case contentPartImage, "input_image":
+			if _, ok := part["image_url"]; !ok {
+				v.addf(partPath+".image_url", "required for %s parts", partType)
+			}
+		}
+	}
+}
+
+// validateToolCalls 校验 tool call 带 id 与函数名，type 为 function（requireType 为 false 时允许省略，部分上游响应不返回 type）
+func (v *schemaValidator) validateToolCalls(path string, toolCalls []ToolCall, requireType bool) {
+	for i, tc := range toolCalls {
+		callPath := fmt.Sprintf("%s[%d]", path, i)
+		if tc.ID == "" {
+			v.addf(callPath+".id", "required")
+		}
+		if tc.Type != "function" && (requireType || tc.Type != "") {
+			v.addf(callPath+".type", "must be \"function\", got %q", tc.Type)
+		}
+		if strings.TrimSpace(tc.Function.Name) == "" {
+			v.addf(callPath+".function.name", "required")
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChatRequest_TransformedRequestPasses(t *testing.T) {
+	claudeJSON := `{
+		"model": "m",
+		"system": "be brief",
+		"tools": [{"name": "read", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "read it"}, {"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "read", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"}]}
+		]
+	}`
+	for _, provider := range []string{ProviderGeneric, ProviderOpenAIResponses} {
+		var claudeReq antigravity.ClaudeRequest
+		require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+		body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: provider})
+		require.NoError(t, err)
+		require.NoError(t, ValidateChatRequest(body), provider)
+	}
+}
+
+func TestValidateChatRequest_Malformed(t *testing.T) {
+	// 模拟有缺陷的转换结果：缺少 model、非法角色、tool 消息缺少 tool_call_id、tool call 缺少 id / 名称、内容块缺少 text
+	body := []byte(`{
+		"messages": [
+			{"role": "human", "content": "hi"},
+			{"role": "assistant", "tool_calls": [{"type": "function", "function": {"name": "", "arguments": "{}"}}]},
+			{"role": "tool", "content": "result"},
+			{"role": "user", "content": [{"type": "text"}, {"text": "no type"}]},
+			{"role": "user", "content": 42}
+		],
+		"tools": [{"type": "retrieval", "function": {"name": "x"}}]
+	}`)
+
+	err := ValidateChatRequest(body)
+	var validationErr *SchemaValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, "request", validationErr.Kind)
+	require.ElementsMatch(t, []string{
+		"model: required",
+		`messages[0].role: invalid role "human"`,
+		"messages[1].tool_calls[0].id: required",
+		"messages[1].tool_calls[0].function.name: required",
+		"messages[2].tool_call_id: required for tool messages",
+		"messages[3].content[0].text: required for text parts",
+		"messages[3].content[1].type: required",
+		"messages[4].content: must be a string, an array of content parts or null",
+		`tools[0].type: must be "function", got "retrieval"`,
+	}, validationErr.Problems)
+
+	require.Error(t, ValidateChatRequest([]byte(`[]`)))
+}
+
+func TestValidateChatResponse(t *testing.T) {
+	valid := `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","function":{"name":"read","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":1,"completion_tokens":1}}`
+	require.NoError(t, ValidateChatResponse([]byte(valid)))
+
+	err := ValidateChatResponse([]byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"user","tool_calls":[{"type":"function","function":{"name":"read"}}]}}]}`))
+	var validationErr *SchemaValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, "response", validationErr.Kind)
+	require.ElementsMatch(t, []string{
+		`choices[0].message.role: must be "assistant", got "user"`,
+		"choices[0].message.tool_calls[0].id: required",
+	}, validationErr.Problems)
+
+	require.ErrorContains(t, ValidateChatResponse([]byte(`{"id":"c1"}`)), "choices: required")
+	require.ErrorContains(t, ValidateChatResponse([]byte(`not json`)), "not a JSON object")
+}
@@ -0,0 +1,118 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// 请求转换中静默调整请求内容时产生的警告代码（TransformWarning.Code）
+const (
+	WarningWebSearchToolDropped = "web_search_tool_dropped" // web_search 工具被丢弃（未配置 WebSearchMode）
+	WarningUnnamedToolSkipped   = "unnamed_tool_skipped"    // 名称为空的工具被跳过
+	WarningToolsDropped         = "tools_dropped"           // 上游接口不支持工具调用，工具定义未下发（旧版 Completions）
+	WarningEmptySystemSkipped   = "empty_system_skipped"    // 空白的 system 块或附加系统指令被跳过
+	WarningSamplingParamDropped = "sampling_param_dropped"  // 采样参数不在上游白名单内被丢弃
+	WarningPredictionDropped    = "prediction_dropped"      // 上游不支持 predicted outputs，prediction 未下发
+)
+
+// TransformWarning 请求转换时对客户端请求的静默调整（丢弃工具、跳过空块、丢弃参数等），
+// 供调用方统一记录日志或回传给客户端，便于排查输出与请求不一致的原因
+type TransformWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// String 返回 "code: message" 形式的警告描述
+func (w TransformWarning) String() string {
+	return w.Code + ": " + w.Message
+}
+
+// WarningCodes 返回警告代码列表（去重并保持首次出现顺序），用于响应头等紧凑输出
+func WarningCodes(warnings []TransformWarning) []string {
+	seen := make(map[string]bool, len(warnings))
+	var codes []string
+	for _, w := range warnings {
+		if !seen[w.Code] {
+			seen[w.Code] = true
+			codes = append(codes, w.Code)
+		}
+	}
+	return codes
+}
+
+// transformWarnings 转换过程中累积的警告
+type transformWarnings []TransformWarning
+
+func (w *transformWarnings) add(code, format string, args ...any) {
+	*w = append(*w, TransformWarning{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// collectRequestWarnings 对比 Claude 请求与转换后的 Chat Completions 请求，记录被静默丢弃或跳过的内容
+func collectRequestWarnings(claudeReq *antigravity.ClaudeRequest, req *ChatRequest, opts RequestOptions) []TransformWarning {
+	var warnings transformWarnings
+
+	// 采样参数：请求中指定但未下发（不在白名单内）
+	sampling := []struct {
+		name      string
+		requested bool
+		sent      bool
+	}{
+		{SamplingParamTemperature, claudeReq.Temperature != nil, req.Temperature != nil},
+		{SamplingParamTopP, claudeReq.TopP != nil, req.TopP != nil},
+		{SamplingParamTopK, claudeReq.TopK != nil, req.TopK != nil || req.ExtraBody[SamplingParamTopK] != nil || req.ChatTemplateKwargs[SamplingParamTopK] != nil},
+	}
+	for _, p := range sampling {
+		if p.requested && !p.sent {
+			warnings.add(WarningSamplingParamDropped, "%s is not supported by the upstream and was dropped", p.name)
+		}
+	}
+
+	if len(claudeReq.Prediction) > 0 && string(claudeReq.Prediction) != "null" && req.Prediction == nil {
+		warnings.add(WarningPredictionDropped, "prediction is not enabled for this upstream and was dropped")
+	}
+
+	// system：空白块与空白附加指令
+	if n := countEmptySystemBlocks(claudeReq.System); n > 0 {
+		warnings.add(WarningEmptySystemSkipped, "%d empty system block(s) skipped", n)
+	}
+	if claudeReq.Metadata != nil {
+		for _, directive := range claudeReq.Metadata.SystemDirectives {
+			if strings.TrimSpace(directive) == "" {
+				warnings.add(WarningEmptySystemSkipped, "empty system directive skipped")
+				break
+			}
+		}
+	}
+
+	// tools：文本工具协议下工具定义写入 system prompt，不涉及以下丢弃
+	if opts.TextToolProtocol {
+		return warnings
+	}
+	for _, tool := range claudeReq.Tools {
+		switch {
+		case isWebSearchTool(tool) && opts.WebSearchMode == WebSearchModeDrop:
+			warnings.add(WarningWebSearchToolDropped, "web_search tool %q dropped: upstream web search is not enabled", tool.Name)
+		case strings.TrimSpace(tool.Name) == "":
+			warnings.add(WarningUnnamedToolSkipped, "tool of type %q without a name skipped", tool.Type)
+		}
+	}
+	return warnings
+}
+
+// countEmptySystemBlocks 统计 system 块数组中内容为空白的 text 块数量（字符串形式的 system 不计）
+func countEmptySystemBlocks(system json.RawMessage) int {
+	var blocks []antigravity.SystemBlock
+	if len(system) == 0 || json.Unmarshal(system, &blocks) != nil {
+		return 0
+	}
+	n := 0
+	for _, block := range blocks {
+		if block.Type == "text" && strings.TrimSpace(block.Text) == "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_Warnings(t *testing.T) {
+	claudeJSON := `{
+		"model": "gpt-4o",
+		"temperature": 0.3,
+		"top_k": 40,
+		"system": [{"type": "text", "text": "be brief"}, {"type": "text", "text": "  "}],
+		"messages": [{"role": "user", "content": "latest news?"}],
+		"tools": [
+			{"type": "web_search_20250305", "name": "web_search"},
+			{"name": "", "input_schema": {"type": "object"}},
+			{"name": "get_weather", "input_schema": {"type": "object"}}
+		]
+	}`
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+
+	_, warnings, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderOpenAI})
+	require.NoError(t, err)
+	require.Equal(t, []string{WarningSamplingParamDropped, WarningEmptySystemSkipped, WarningWebSearchToolDropped, WarningUnnamedToolSkipped}, WarningCodes(warnings))
+	require.Contains(t, warnings[0].Message, "top_k")
+
+	// 开启上游原生搜索、允许 top_k 后对应警告消失
+	_, warnings, err = TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{Provider: ProviderOpenRouter, WebSearchMode: WebSearchModePlugins})
+	require.NoError(t, err)
+	require.Equal(t, []string{WarningEmptySystemSkipped, WarningUnnamedToolSkipped}, WarningCodes(warnings))
+}
+
+func TestTransformClaudeToOpenAI_NoWarnings(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"gpt-4o","system":"be brief","temperature":0.3,"messages":[{"role":"user","content":"hi"}]}`), &claudeReq))
+	_, warnings, err := TransformClaudeToOpenAI(&claudeReq)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+}
+
+func TestTransformClaudeToCompletions_ToolsDroppedWarning(t *testing.T) {
+	var claudeReq antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"llama","messages":[{"role":"user","content":"hi"}],"tools":[{"name":"ls","input_schema":{"type":"object"}}]}`), &claudeReq))
+	_, warnings, err := TransformClaudeToCompletionsWithOptions(&claudeReq, RequestOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{WarningToolsDropped}, WarningCodes(warnings))
+}
@@ -0,0 +1,92 @@
+package openaicompat
+
+import (
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// web_search 工具的上游原生搜索模式
+const (
+	// WebSearchModeDrop 丢弃 web_search 工具（默认，上游不支持搜索时使用）
+	WebSearchModeDrop = ""
+	// WebSearchModeOnlineSuffix 在模型名后追加 ":online"（OpenRouter）
+	WebSearchModeOnlineSuffix = "online_suffix"
+	// WebSearchModePlugins 在请求中添加 plugins: [{"id": "web"}]（OpenRouter）
+	WebSearchModePlugins = "plugins"
+)
+
+// openRouterOnlineSuffix OpenRouter 联网模型后缀
+const openRouterOnlineSuffix = ":online"
+
+// isWebSearchTool 判断 Claude 工具是否为 web_search 服务端工具
+func isWebSearchTool(tool antigravity.ClaudeTool) bool {
+	return tool.Type == "web_search_20250305" || tool.Type == "web_search"
+}
+
+// hasWebSearchTool 判断请求中是否包含 web_search 工具
+func hasWebSearchTool(tools []antigravity.ClaudeTool) bool {
+	for _, tool := range tools {
+		if isWebSearchTool(tool) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyWebSearchMode 按搜索模式为请求开启上游原生搜索
+func applyWebSearchMode(req *ChatRequest, mode string) {
+	switch mode {
+	case WebSearchModeOnlineSuffix:
+		if !strings.HasSuffix(req.Model, openRouterOnlineSuffix) {
+			req.Model += openRouterOnlineSuffix
+		}
+	case WebSearchModePlugins:
+		for _, plugin := range req.Plugins {
+			if plugin.ID == "web" {
+				return
+			}
+		}
+		req.Plugins = append(req.Plugins, Plugin{ID: "web"})
+	}
+}
+
+// buildWebSearchBlocks 将上游返回的 url_citation 注解转换为 Claude 的
+// server_tool_use + web_search_tool_result 块，无引用时返回 nil
+func buildWebSearchBlocks(annotations []Annotation, seen map[string]struct{}) []antigravity.ClaudeContentItem {
+	var results []map[string]any
+	for _, ann := range annotations {
+		if ann.Type != "url_citation" || ann.URLCitation == nil || ann.URLCitation.URL == "" {
+			continue
+		}
+		if _, ok := seen[ann.URLCitation.URL]; ok {
+			continue
+		}
+		seen[ann.URLCitation.URL] = struct{}{}
+		results = append(results, map[string]any{
+			"type":              "web_search_result",
+			"url":               ann.URLCitation.URL,
+			"title":             ann.URLCitation.Title,
+			"encrypted_content": "",
+			"page_age":          nil,
+		})
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	toolUseID := "srvtoolu_" + randomID()
+	return []antigravity.ClaudeContentItem{
+		{
+			Type:  "server_tool_use",
+			ID:    toolUseID,
+			Name:  "web_search",
+			Input: map[string]any{},
+		},
+		{
+			Type:      "web_search_tool_result",
+			ToolUseID: toolUseID,
+			Content:   results,
+		},
+	}
+}
@@ -0,0 +1,87 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformClaudeToOpenAI_WebSearchMode(t *testing.T) {
+	claudeJSON := `{
+		"model": "openai/gpt-4o",
+		"messages": [{"role": "user", "content": "latest news?"}],
+		"tools": [
+			{"type": "web_search_20250305", "name": "web_search"},
+			{"name": "get_weather", "input_schema": {"type": "object"}}
+		]
+	}`
+
+	tests := []struct {
+		mode        string
+		wantModel   string
+		wantPlugins []Plugin
+	}{
+		{mode: WebSearchModeDrop, wantModel: "openai/gpt-4o"},
+		{mode: WebSearchModeOnlineSuffix, wantModel: "openai/gpt-4o:online"},
+		{mode: WebSearchModePlugins, wantModel: "openai/gpt-4o", wantPlugins: []Plugin{{ID: "web"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var claudeReq antigravity.ClaudeRequest
+			require.NoError(t, json.Unmarshal([]byte(claudeJSON), &claudeReq))
+			body, _, err := TransformClaudeToOpenAIWithOptions(&claudeReq, RequestOptions{WebSearchMode: tt.mode})
+			require.NoError(t, err)
+
+			var req ChatRequest
+			require.NoError(t, json.Unmarshal(body, &req))
+			require.Equal(t, tt.wantModel, req.Model)
+			require.Equal(t, tt.wantPlugins, req.Plugins)
+			// web_search 工具本身始终不作为 function 下发
+			require.Len(t, req.Tools, 1)
+			require.Equal(t, "get_weather", req.Tools[0].Function.Name)
+		})
+	}
+
+	// 请求中没有 web_search 工具时不开启搜索
+	req := transformRequest(t, `{"model": "m", "messages": [{"role": "user", "content": "hi"}]}`)
+	require.Equal(t, "m", req.Model)
+	require.Nil(t, req.Plugins)
+}
+
+func TestTransformOpenAIToClaude_WebSearchAnnotations(t *testing.T) {
+	body := []byte(`{"id":"c1","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"Answer [1].","annotations":[
+		{"type":"url_citation","url_citation":{"url":"https://a.example","title":"A","start_index":7,"end_index":10}},
+		{"type":"url_citation","url_citation":{"url":"https://a.example","title":"A"}},
+		{"type":"url_citation","url_citation":{"url":"https://b.example","title":"B"}}
+	]}}]}`)
+
+	out, _, err := TransformOpenAIToClaude(body, "claude-model")
+	require.NoError(t, err)
+
+	var resp struct {
+		Content []map[string]any `json:"content"`
+	}
+	require.NoError(t, json.Unmarshal(out, &resp))
+	require.Len(t, resp.Content, 3)
+	require.Equal(t, "server_tool_use", resp.Content[0]["type"])
+	require.Equal(t, "web_search", resp.Content[0]["name"])
+	require.Equal(t, "web_search_tool_result", resp.Content[1]["type"])
+	require.Equal(t, resp.Content[0]["id"], resp.Content[1]["tool_use_id"])
+	results := resp.Content[1]["content"].([]any)
+	require.Len(t, results, 2)
+	require.Equal(t, "https://a.example", results[0].(map[string]any)["url"])
+	require.Equal(t, "https://b.example", results[1].(map[string]any)["url"])
+	require.Equal(t, "text", resp.Content[2]["type"])
+}
+
+func TestStreamingProcessor_WebSearchAnnotations(t *testing.T) {
+	events := runStream(t, NewStreamingProcessor("claude-model"),
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Answer"}}]}`,
+		`data: {"id":"c1","choices":[{"index":0,"delta":{"annotations":[{"type":"url_citation","url_citation":{"url":"https://a.example","title":"A"}}]},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	)
+	require.Equal(t, []string{"text", "server_tool_use", "web_search_tool_result"}, blockStartTypes(events))
+}
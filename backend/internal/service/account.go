@@ -863,6 +863,401 @@ func (a *Account) GetCurrentWindowStartTime() time.Time {
 	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
 }
 
+// getExtraBool 读取 extra 中的布尔开关，未设置或类型不符时返回 false
+func (a *Account) getExtraBool(key string) bool {
+	if a.Extra == nil {
+		return false
+	}
+	if v, ok := a.Extra[key]; ok {
+		if enabled, ok := v.(bool); ok {
+			return enabled
+		}
+	}
+	return false
+}
+
+// IsRequestGzipEnabled 检查是否对上游请求体启用 gzip 压缩
+// 仅适用于 openai_compat 平台，需确认上游支持 Content-Encoding: gzip 后再开启
+func (a *Account) IsRequestGzipEnabled() bool {
+	return a.getExtraBool("request_gzip_enabled")
+}
+
+// IsStreamUsageDisabled 检查是否在流式请求中省略 stream_options.include_usage
+// 仅适用于 openai_compat 平台：用于拒绝 stream_options 字段的上游，此时按请求与输出文本估算 usage
+func (a *Account) IsStreamUsageDisabled() bool {
+	return a.getExtraBool("stream_usage_disabled")
+}
+
+// GetHideThinkingFromClientOverride 获取账号是否对客户端隐藏 thinking 内容的显式配置（extra.hide_thinking_from_client）
+// 仅适用于 openai_compat 平台：上游仍进行推理且 reasoning tokens 正常计费，但响应中不返回 thinking 块；
+// 未配置时返回 nil（使用网关配置 gateway.hide_thinking_from_client）
+func (a *Account) GetHideThinkingFromClientOverride() *bool {
+	if a.Extra == nil {
+		return nil
+	}
+	if v, ok := a.Extra["hide_thinking_from_client"].(bool); ok {
+		return &v
+	}
+	return nil
+}
+
+// IsStreamDeltaSnapshotEnabled 检查上游流式 delta 是否为累计快照（每个 chunk 携带完整文本而非增量）
+// 仅适用于 openai_compat 平台：开启后只向客户端输出新增部分，避免文本重复
+func (a *Account) IsStreamDeltaSnapshotEnabled() bool {
+	return a.getExtraBool("stream_delta_snapshot")
+}
+
+// IsOmitToolChoiceNoneEnabled 检查 tool_choice 为 none 时是否省略 tools 与 tool_choice（extra.omit_tool_choice_none）
+// 仅适用于 openai_compat 平台，用于不接受 tool_choice "none" 的上游；vLLM 等已知不支持的提供方默认即省略
+func (a *Account) IsOmitToolChoiceNoneEnabled() bool {
+	return a.getExtraBool("omit_tool_choice_none")
+}
+
+// IsTraceContextEnabled 检查是否向上游注入 W3C Trace Context 请求头（extra.trace_context_enabled）
+// 仅适用于 openai_compat 平台，开启后透传客户端的 traceparent / tracestate（缺失时生成新的 trace），日志附带 trace_id
+func (a *Account) IsTraceContextEnabled() bool {
+	return a.getExtraBool("trace_context_enabled")
+}
+
+// IsModelOverrideEnabled 检查是否允许客户端通过 x-model-override 请求头指定模型（extra.model_override_enabled）
+// 仅适用于 openai_compat 平台，覆盖的模型仍须在账号 model_mapping 允许的范围内（未配置映射时允许所有模型）
+func (a *Account) IsModelOverrideEnabled() bool {
+	return a.getExtraBool("model_override_enabled")
+}
+
+// IsEffectiveParamsDebugEnabled 检查是否在响应中回显实际发送给上游的请求参数（extra.debug_effective_params）
+// 仅适用于 openai_compat 平台：开启后响应带 effective_params 字段（model / temperature / max_tokens / reasoning / tool_choice）
+func (a *Account) IsEffectiveParamsDebugEnabled() bool {
+	return a.getExtraBool("debug_effective_params")
+}
+
+// IsUnauthorizedFailoverEnabled 检查上游 401 时是否切换账号并按原因处理（extra.unauthorized_failover），仅适用于 openai_compat 平台：
+// 开启后按响应体区分密钥无效（停用账号）与令牌过期（临时停止调度等待凭证刷新）；默认关闭，401 原样返回客户端
+func (a *Account) IsUnauthorizedFailoverEnabled() bool {
+	return a.getExtraBool("unauthorized_failover")
+}
+
+// IsTransformWarningsHeaderEnabled 检查是否通过响应头 anthropic-transform-warnings 返回请求转换警告代码（extra.debug_transform_warnings）
+// 仅适用于 openai_compat 平台：开启后客户端可得知被丢弃的工具、采样参数等静默调整；警告始终记录日志
+func (a *Account) IsTransformWarningsHeaderEnabled() bool {
+	return a.getExtraBool("debug_transform_warnings")
+}
+
+// IsSchemaValidationEnabled 检查是否校验转换后的请求与上游非流式响应的 Chat Completions 结构（extra.debug_schema_validation）
+// 仅适用于 openai_compat 平台，用于排查转换器缺陷，校验失败时直接向客户端返回错误；默认关闭以避免生产环境开销
+func (a *Account) IsSchemaValidationEnabled() bool {
+	return a.getExtraBool("debug_schema_validation")
+}
+
+// IsTextToolProtocolEnabled 检查是否对不支持原生 function calling 的上游启用文本工具调用协议
+// 仅适用于 openai_compat 平台：工具定义写入 system prompt，并从模型输出文本中解析工具调用
+func (a *Account) IsTextToolProtocolEnabled() bool {
+	return a.getExtraBool("text_tool_protocol_enabled")
+}
+
+// GetWebSearchMode 获取 web_search 工具的上游原生搜索模式
+// 仅适用于 openai_compat 平台："online_suffix"（模型名追加 :online）、"plugins"（OpenRouter web 插件），
+// 为空表示上游不支持搜索，丢弃 web_search 工具
+func (a *Account) GetWebSearchMode() string {
+	return strings.TrimSpace(a.GetExtraString("web_search_mode"))
+}
+
+// IsPreserveUserThinkingEnabled 检查是否将 user 消息中的 thinking 块作为 reasoning 历史传给上游
+// 仅适用于 openai_compat 平台，默认丢弃 user 消息中的 thinking 块
+func (a *Account) IsPreserveUserThinkingEnabled() bool {
+	return a.getExtraBool("preserve_user_thinking")
+}
+
+// GetSystemDirectiveOrder 获取 metadata.system_directives 相对基础 system prompt 的合并顺序
+// 仅适用于 openai_compat 平台："prepend"（置于之前）或 "append"（追加在后，默认）
+func (a *Account) GetSystemDirectiveOrder() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("system_directive_order")))
+}
+
+// GetOutputModalities 获取账号配置的输出模态（extra.modalities，如 ["text","audio"]）
+// 仅适用于 openai_compat 平台，未配置时返回 nil（不发送 modalities，纯文本请求保持不变）
+func (a *Account) GetOutputModalities() []string {
+	modalities := a.getExtraStringList("modalities")
+	for i, m := range modalities {
+		modalities[i] = strings.ToLower(m)
+	}
+	return modalities
+}
+
+// GetAudioOutputConfig 获取音频输出的 voice / format（extra.audio_voice / extra.audio_format）
+// 仅在 modalities 含 audio 时生效，未配置时返回空字符串，使用默认值
+func (a *Account) GetAudioOutputConfig() (voice, format string) {
+	return strings.TrimSpace(a.GetExtraString("audio_voice")), strings.ToLower(strings.TrimSpace(a.GetExtraString("audio_format")))
+}
+
+// GetSystemPrefix 获取账号配置的固定 system 前缀（extra.system_prefix），置于合并后 system prompt 的最前
+// 仅适用于 openai_compat 平台，未配置时返回空字符串
+func (a *Account) GetSystemPrefix() string {
+	return a.GetExtraString("system_prefix")
+}
+
+// GetSystemSuffix 获取账号配置的固定 system 后缀（extra.system_suffix），置于合并后 system prompt 的最后
+// 仅适用于 openai_compat 平台，未配置时返回空字符串
+func (a *Account) GetSystemSuffix() string {
+	return a.GetExtraString("system_suffix")
+}
+
+// GetDefaultMaxTokens 获取客户端未指定（或指定为 0）max_tokens 时发送给上游的值（credentials.default_max_tokens）
+// 仅适用于 openai_compat 平台，未配置或非正数时返回 0，使用上游提供方的默认值
+func (a *Account) GetDefaultMaxTokens() int {
+	if n := int(a.GetCredentialAsInt64("default_max_tokens")); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// GetEmptyChoicesMode 获取上游非流式响应 choices 为空时的处理方式
+// 仅适用于 openai_compat 平台："error"（返回错误）或 "text"（返回空文本块，默认）
+func (a *Account) GetEmptyChoicesMode() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("empty_choices_mode")))
+}
+
+// GetErrorBodyMode 获取上游错误响应体不是 OpenAI 错误格式时的处理方式（extra.error_body_mode）
+// 仅适用于 openai_compat 平台："status_text"（JSON 响应体以状态文本代替，避免回显请求内容，默认）或 "raw"（截断后原样返回）
+func (a *Account) GetErrorBodyMode() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("error_body_mode")))
+}
+
+// GetEmptyStopMode 获取上游非流式响应 finish_reason 为 stop 但内容为空时的处理方式
+// 仅适用于 openai_compat 平台："error"（返回错误）、"retry"（按临时性错误重试）或 "text"（返回空文本块，默认）
+func (a *Account) GetEmptyStopMode() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("empty_stop_mode")))
+}
+
+// GetReasoningOutput 获取上游 reasoning 返回给客户端的方式（extra.reasoning_output）
+// 仅适用于 openai_compat 平台："full"（摘要与完整推理均返回，默认）或 "suppress_summary"（丢弃推理摘要，仅计费）；
+// 完全不返回 thinking 块使用 extra.hide_thinking_from_client
+func (a *Account) GetReasoningOutput() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("reasoning_output")))
+}
+
+// GetFakeSignatureFormat 获取上游未返回 signature 时 thinking 块假签名的格式（extra.fake_signature_format）
+// 仅适用于 openai_compat 平台："timestamp"（时间戳 + 随机后缀，默认）、"base64" 或 "hex"（随机字节编码）
+func (a *Account) GetFakeSignatureFormat() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("fake_signature_format")))
+}
+
+// GetFakeSignatureBytes 获取 base64 / hex 格式假签名的随机字节数（extra.fake_signature_bytes），返回 0 表示使用默认长度
+func (a *Account) GetFakeSignatureBytes() int {
+	if a.Extra == nil {
+		return 0
+	}
+	if v, ok := a.Extra["fake_signature_bytes"]; ok {
+		return parseExtraInt(v)
+	}
+	return 0
+}
+
+// GetRefusalMode 获取上游仅返回 refusal 时的处理方式
+// 仅适用于 openai_compat 平台："error"（返回错误）或 "end_turn"（文本块 + end_turn，默认）
+func (a *Account) GetRefusalMode() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("refusal_mode")))
+}
+
+// GetSupportsTopK 获取凭证 supports_top_k 的取值（布尔或 "true"/"false" 字符串）
+// 未配置或无法解析时返回 nil，表示按采样参数白名单决定是否透传 top_k
+func (a *Account) GetSupportsTopK() *bool {
+	if a.Credentials == nil {
+		return nil
+	}
+	switch v := a.Credentials["supports_top_k"].(type) {
+	case bool:
+		return &v
+	case string:
+		if supported, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return &supported
+		}
+	}
+	return nil
+}
+
+// GetTopKPlacement 获取凭证 top_k_placement：top_k 在上游请求体中的位置
+// "top_level"（默认）、"extra_body" 或 "chat_template_kwargs"
+func (a *Account) GetTopKPlacement() string {
+	return strings.ToLower(strings.TrimSpace(a.GetCredential("top_k_placement")))
+}
+
+// IsPredictionPassthroughEnabled 检查是否向上游透传客户端的 prediction（predicted outputs）（extra.prediction_passthrough）
+// 仅适用于 openai_compat 平台：需确认上游支持 prediction 字段后再开启；upstream_provider 为 openai 时无需开启
+func (a *Account) IsPredictionPassthroughEnabled() bool {
+	return a.getExtraBool("prediction_passthrough")
+}
+
+// GetLogprobsConfig 获取凭证中默认的 logprobs 请求参数（logprobs 为布尔或 "true"/"false" 字符串，top_logprobs 为正整数）
+// 未配置时返回 nil，请求中显式指定的 logprobs / top_logprobs 优先
+func (a *Account) GetLogprobsConfig() (logprobs *bool, topLogprobs *int) {
+	if a.Credentials == nil {
+		return nil, nil
+	}
+	switch v := a.Credentials["logprobs"].(type) {
+	case bool:
+		logprobs = &v
+	case string:
+		if enabled, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			logprobs = &enabled
+		}
+	}
+	if n := int(a.GetCredentialAsInt64("top_logprobs")); n > 0 {
+		topLogprobs = &n
+	}
+	return logprobs, topLogprobs
+}
+
+// GetAuthScheme 获取 openai_compat 账号的上游鉴权方式（credentials.auth_scheme）
+// "bearer"（默认，Bearer <api_key>）、"basic"（api_key 为 "user:password"，按 Basic 编码）或 "raw"（原样发送 api_key）
+func (a *Account) GetAuthScheme() string {
+	switch scheme := strings.ToLower(strings.TrimSpace(a.GetCredential("auth_scheme"))); scheme {
+	case "basic", "raw":
+		return scheme
+	default:
+		return "bearer"
+	}
+}
+
+// GetAuthHeaderName 获取 openai_compat 账号发送鉴权信息的请求头名（credentials.auth_header_name），未配置时为 Authorization
+func (a *Account) GetAuthHeaderName() string {
+	if name := strings.TrimSpace(a.GetCredential("auth_header_name")); name != "" {
+		return name
+	}
+	return "Authorization"
+}
+
+// GetReasoningMode 获取 openai_compat 账号的 thinking budget 转换方式（extra.reasoning_mode）
+// "effort" 始终映射为 reasoning.effort，"budget" 始终传递 reasoning.max_tokens，为空时按上游提供方自动选择
+func (a *Account) GetReasoningMode() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("reasoning_mode")))
+}
+
+// GetReasoningEffortThresholds 获取 budget → effort 映射阈值
+// （extra.reasoning_effort_minimal_max / reasoning_effort_low_max / reasoning_effort_medium_max），未配置时返回 0 表示使用默认阈值
+func (a *Account) GetReasoningEffortThresholds() (minimalMax, lowMax, mediumMax int) {
+	if a.Extra == nil {
+		return 0, 0, 0
+	}
+	if v, ok := a.Extra["reasoning_effort_minimal_max"]; ok {
+		minimalMax = parseExtraInt(v)
+	}
+	if v, ok := a.Extra["reasoning_effort_low_max"]; ok {
+		lowMax = parseExtraInt(v)
+	}
+	if v, ok := a.Extra["reasoning_effort_medium_max"]; ok {
+		mediumMax = parseExtraInt(v)
+	}
+	return minimalMax, lowMax, mediumMax
+}
+
+// GetReasoningOnlyOverride 获取账号对推理专用模型的显式声明（extra.reasoning_only）
+// true 时 thinking 关闭也发送最低 effort，false 时从不发送 reasoning，未配置时返回 nil（按模型名判断）
+func (a *Account) GetReasoningOnlyOverride() *bool {
+	if a.Extra == nil {
+		return nil
+	}
+	if v, ok := a.Extra["reasoning_only"].(bool); ok {
+		return &v
+	}
+	return nil
+}
+
+// GetFinishReasonMap 获取账号自定义的 finish_reason → stop_reason 映射（extra.finish_reason_map）
+// 用于使用非标准 finish_reason 的上游，如 {"complete": "end_turn"}；未配置时返回 nil
+func (a *Account) GetFinishReasonMap() map[string]string {
+	if a.Extra == nil {
+		return nil
+	}
+	raw, ok := a.Extra["finish_reason_map"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	mapping := make(map[string]string, len(raw))
+	for finishReason, v := range raw {
+		if stopReason, ok := v.(string); ok && strings.TrimSpace(stopReason) != "" {
+			mapping[strings.TrimSpace(finishReason)] = strings.TrimSpace(stopReason)
+		}
+	}
+	return mapping
+}
+
+// GetResponseModelAliases 获取账号配置的响应模型别名（extra.response_model_aliases）
+// 如 {"gpt-4o": "claude-3-5-sonnet"}，仅改写返回给客户端的 model 字段；未配置时返回 nil
+func (a *Account) GetResponseModelAliases() map[string]string {
+	if a.Extra == nil {
+		return nil
+	}
+	raw, ok := a.Extra["response_model_aliases"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	aliases := make(map[string]string, len(raw))
+	for model, v := range raw {
+		if alias, ok := v.(string); ok && strings.TrimSpace(alias) != "" {
+			aliases[strings.TrimSpace(model)] = strings.TrimSpace(alias)
+		}
+	}
+	return aliases
+}
+
+// GetUpstreamProvider 获取 openai_compat 账号的上游提供方（如 "openai"、"openrouter"、"vllm"）
+// 用于选择默认的采样参数白名单、多模态内容块命名与鉴权头（"anthropic-auth" 仍发送 OpenAI 请求体，仅鉴权改用 x-api-key），
+// 为空表示通用 OpenAI 兼容上游
+func (a *Account) GetUpstreamProvider() string {
+	return strings.ToLower(strings.TrimSpace(a.GetExtraString("upstream_provider")))
+}
+
+// GetUpstreamAPI 获取上游接口类型（extra.api）：responses 表示使用 OpenAI Responses API（/responses），
+// completions 表示使用旧版文本补全 API（/completions，仅支持纯文本），
+// 未配置或其他取值使用 Chat Completions（/chat/completions）；仅适用于 openai_compat 平台。
+// 未配置 extra.api 时 upstream_provider=completions 等同于 api=completions
+func (a *Account) GetUpstreamAPI() string {
+	if api := strings.ToLower(strings.TrimSpace(a.GetExtraString("api"))); api != "" {
+		return api
+	}
+	if a.GetUpstreamProvider() == "completions" {
+		return "completions"
+	}
+	return ""
+}
+
+// GetSamplingParamAllowlist 获取账号自定义的采样参数白名单（extra.sampling_param_allowlist）
+// 未配置时返回 nil，表示使用上游提供方的默认白名单；配置为空数组表示不透传任何采样参数
+func (a *Account) GetSamplingParamAllowlist() []string {
+	return a.getExtraStringList("sampling_param_allowlist")
+}
+
+// GetAnthropicBetaAllowlist 获取 anthropic-auth 上游允许透传的 anthropic-beta 标志（extra.anthropic_beta_allowlist）
+// 未配置时返回 nil，表示透传客户端的全部标志；配置为空数组表示不透传 anthropic-beta
+func (a *Account) GetAnthropicBetaAllowlist() []string {
+	return a.getExtraStringList("anthropic_beta_allowlist")
+}
+
+// GetAnthropicVersionAllowlist 获取 anthropic-auth 上游允许透传的 anthropic-version 取值（extra.anthropic_version_allowlist）
+// 未配置时返回 nil，表示透传客户端的版本；客户端版本不在白名单中时使用账号默认版本
+func (a *Account) GetAnthropicVersionAllowlist() []string {
+	return a.getExtraStringList("anthropic_version_allowlist")
+}
+
+// getExtraStringList 从 extra 读取字符串数组（忽略空白项），未配置或类型不符时返回 nil
+func (a *Account) getExtraStringList(key string) []string {
+	if a.Extra == nil {
+		return nil
+	}
+	raw, ok := a.Extra[key].([]any)
+	if !ok {
+		return nil
+	}
+	list := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if name, ok := item.(string); ok && strings.TrimSpace(name) != "" {
+			list = append(list, strings.TrimSpace(name))
+		}
+	}
+	return list
+}
+
 // parseExtraFloat64 从 extra 字段解析 float64 值
 func parseExtraFloat64(value any) float64 {
 	switch v := value.(type) {
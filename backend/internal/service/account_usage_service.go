@@ -147,6 +147,9 @@ type UsageInfo struct {
 
 	// Antigravity 多模型配额
 	AntigravityQuota map[string]*AntigravityModelQuota `json:"antigravity_quota,omitempty"`
+
+	// 账号套餐等级（GLM 等平台返回，如 pro），未知时为空
+	Level string `json:"level,omitempty"`
 }
 
 // ClaudeUsageResponse Anthropic API返回的usage结构
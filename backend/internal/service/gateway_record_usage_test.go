@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingUsageLogRepo 仅记录 Create 写入的使用日志，其余方法未实现
+type recordingUsageLogRepo struct {
+	UsageLogRepository
+	logs []*UsageLog
+}
+
+func (r *recordingUsageLogRepo) Create(_ context.Context, log *UsageLog) (bool, error) {
+	r.logs = append(r.logs, log)
+	return true, nil
+}
+
+// recordUsageForTest 以简单模式（不扣费）执行 RecordUsage，返回写入的使用日志
+func recordUsageForTest(t *testing.T, result *ForwardResult) *UsageLog {
+	t.Helper()
+	cfg := &config.Config{RunMode: config.RunModeSimple}
+	cfg.Default.RateMultiplier = 1
+	repo := &recordingUsageLogRepo{}
+	svc := &GatewayService{
+		cfg:             cfg,
+		usageLogRepo:    repo,
+		billingService:  NewBillingService(cfg, nil),
+		deferredService: &DeferredService{},
+	}
+	err := svc.RecordUsage(context.Background(), &RecordUsageInput{
+		Result:  result,
+		APIKey:  &APIKey{ID: 1},
+		User:    &User{ID: 1},
+		Account: &Account{ID: 1, Platform: PlatformOpenAICompat},
+	})
+	require.NoError(t, err)
+	require.Len(t, repo.logs, 1)
+	return repo.logs[0]
+}
+
+func TestRecordUsage_WebSearchRequests(t *testing.T) {
+	log := recordUsageForTest(t, &ForwardResult{
+		RequestID: "req_1",
+		Model:     "gpt-4o",
+		Usage:     ClaudeUsage{InputTokens: 10, OutputTokens: 20, WebSearchRequests: 2},
+	})
+	require.Equal(t, 2, log.WebSearchRequests)
+	require.Equal(t, 10, log.InputTokens)
+	require.Equal(t, 20, log.OutputTokens)
+}
+
+func TestRecordUsage_ServiceTier(t *testing.T) {
+	log := recordUsageForTest(t, &ForwardResult{
+		RequestID: "req_1",
+		Model:     "gpt-4o",
+		Usage:     ClaudeUsage{InputTokens: 10, OutputTokens: 20, ServiceTier: "flex"},
+	})
+	require.NotNil(t, log.ServiceTier)
+	require.Equal(t, "flex", *log.ServiceTier)
+
+	// 上游未返回服务等级时不记录
+	log = recordUsageForTest(t, &ForwardResult{RequestID: "req_2", Model: "gpt-4o"})
+	require.Nil(t, log.ServiceTier)
+}
+
+func TestRecordUsage_UsageEstimated(t *testing.T) {
+	log := recordUsageForTest(t, &ForwardResult{
+		RequestID:      "req_1",
+		Model:          "gpt-4o",
+		Usage:          ClaudeUsage{InputTokens: 10, OutputTokens: 20},
+		UsageEstimated: true,
+	})
+	require.True(t, log.UsageEstimated)
+
+	log = recordUsageForTest(t, &ForwardResult{RequestID: "req_2", Model: "gpt-4o"})
+	require.False(t, log.UsageEstimated)
+}
+
+func TestRecordUsage_ReasoningTokens(t *testing.T) {
+	log := recordUsageForTest(t, &ForwardResult{
+		RequestID: "req_1",
+		Model:     "gpt-4o",
+		Usage:     ClaudeUsage{InputTokens: 10, OutputTokens: 20, ReasoningTokens: 15},
+	})
+	// 推理 token 已包含在 OutputTokens 中，单独记录但不重复计费
+	require.Equal(t, 15, log.ReasoningTokens)
+	require.Equal(t, 20, log.OutputTokens)
+}
@@ -20,10 +20,12 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Wei-Shaw/sub2api/internal/config"
 	"github.com/Wei-Shaw/sub2api/internal/pkg/claude"
 	"github.com/Wei-Shaw/sub2api/internal/pkg/ctxkey"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/openaicompat"
 	"github.com/Wei-Shaw/sub2api/internal/util/responseheaders"
 	"github.com/Wei-Shaw/sub2api/internal/util/urlvalidator"
 	"github.com/cespare/xxhash/v2"
@@ -345,12 +347,15 @@ type AccountSelectionResult struct {
 
 // ClaudeUsage 表示Claude API返回的usage信息
 type ClaudeUsage struct {
-	InputTokens              int `json:"input_tokens"`
-	OutputTokens             int `json:"output_tokens"`
-	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
-	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
-	CacheCreation5mTokens    int // 5分钟缓存创建token（来自嵌套 cache_creation 对象）
-	CacheCreation1hTokens    int // 1小时缓存创建token（来自嵌套 cache_creation 对象）
+	InputTokens              int    `json:"input_tokens"`
+	OutputTokens             int    `json:"output_tokens"`
+	CacheCreationInputTokens int    `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int    `json:"cache_read_input_tokens"`
+	CacheCreation5mTokens    int    // 5分钟缓存创建token（来自嵌套 cache_creation 对象）
+	CacheCreation1hTokens    int    // 1小时缓存创建token（来自嵌套 cache_creation 对象）
+	WebSearchRequests        int    // 服务端 web 搜索次数（来自 server_tool_use，OpenAI 兼容上游）
+	ServiceTier              string // 上游实际使用的服务等级（OpenAI 兼容上游，如 flex / priority）
+	ReasoningTokens          int    // OutputTokens 中的推理 token 数（来自 completion_tokens_details，OpenAI 兼容上游）
 }
 
 // ForwardResult 转发结果
@@ -362,6 +367,7 @@ type ForwardResult struct {
 	Duration         time.Duration
 	FirstTokenMs     *int // 首字时间（流式请求）
 	ClientDisconnect bool // 客户端是否在流式传输过程中断开
+	UsageEstimated   bool // Usage 为本地估算值（上游未返回 usage）
 
 	// 图片生成计费字段（仅 gemini-3-pro-image 使用）
 	ImageCount int    // 生成的图片数量
@@ -371,15 +377,56 @@ type ForwardResult struct {
 // UpstreamFailoverError indicates an upstream error that should trigger account failover.
 type UpstreamFailoverError struct {
 	StatusCode             int
-	ResponseBody           []byte // 上游响应体，用于错误透传规则匹配
-	ForceCacheBilling      bool   // Antigravity 粘性会话切换时设为 true
-	RetryableOnSameAccount bool   // 临时性错误（如 Google 间歇性 400、空响应），应在同一账号上重试 N 次再切换
+	ResponseBody           []byte     // 上游响应体，用于错误透传规则匹配
+	ForceCacheBilling      bool       // Antigravity 粘性会话切换时设为 true
+	RetryableOnSameAccount bool       // 临时性错误（如 Google 间歇性 400、空响应），应在同一账号上重试 N 次再切换
+	RateLimitResetAt       *time.Time // 从上游 Retry-After / x-ratelimit-reset-* 响应头解析出的限流重置时间，nil 表示未知
+	Overloaded             bool       // 上游过载（529/503），失败兜底时按 overloaded_error 返回给客户端
+	AuthError              string     // 上游 401 的分类（openaicompat.AuthErrorInvalidKey / AuthErrorExpired），空表示未分类
 }
 
 func (e *UpstreamFailoverError) Error() string {
 	return fmt.Sprintf("upstream error: %d (failover)", e.StatusCode)
 }
 
+// failoverReasonMaxLen 日志中记录的上游响应体摘要最大长度
+const failoverReasonMaxLen = 256
+
+// Classification 返回 failover 原因分类，用于日志与排障
+func (e *UpstreamFailoverError) Classification() string {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return "rate_limited"
+	case e.Overloaded:
+		return "overloaded"
+	case e.RetryableOnSameAccount:
+		return "transient"
+	case e.StatusCode == http.StatusUnauthorized && e.AuthError != "":
+		return "auth_" + e.AuthError
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return "auth_error"
+	case e.StatusCode >= 500:
+		return "upstream_error"
+	case e.StatusCode >= 400:
+		return "client_error"
+	default:
+		return "unknown"
+	}
+}
+
+// Reason 返回上游响应体的截断摘要（折叠空白），响应体为空时返回空字符串
+func (e *UpstreamFailoverError) Reason() string {
+	reason := strings.Join(strings.Fields(string(e.ResponseBody)), " ")
+	if len(reason) > failoverReasonMaxLen {
+		cut := failoverReasonMaxLen
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut] + "..."
+	}
+	return reason
+}
+
 // TempUnscheduleRetryableError 对 RetryableOnSameAccount 类型的 failover 错误触发临时封禁。
 // 由 handler 层在同账号重试全部用尽、切换账号时调用。
 func (s *GatewayService) TempUnscheduleRetryableError(ctx context.Context, accountID int64, failoverErr *UpstreamFailoverError) {
@@ -395,6 +442,55 @@ func (s *GatewayService) TempUnscheduleRetryableError(ctx context.Context, accou
 	}
 }
 
+// unauthorizedExpiredCooldown 上游令牌过期时临时停止调度的时长，期间等待凭证刷新或轮换
+const unauthorizedExpiredCooldown = 10 * time.Minute
+
+// HandleUnauthorizedAccount 按 failover 错误中的 401 分类处理账号：
+// 密钥无效（永久性）时停用账号并记录错误；令牌过期（可恢复）时临时停止调度，等待凭证刷新后自动恢复。
+// 由 handler 层在切换账号时调用，未分类的 401 不做处理。
+func (s *GatewayService) HandleUnauthorizedAccount(ctx context.Context, accountID int64, failoverErr *UpstreamFailoverError) {
+	if failoverErr == nil || failoverErr.StatusCode != http.StatusUnauthorized {
+		return
+	}
+	switch failoverErr.AuthError {
+	case openaicompat.AuthErrorInvalidKey:
+		msg := "Authentication failed (401): invalid API key"
+		if reason := failoverErr.Reason(); reason != "" {
+			msg += ": " + reason
+		}
+		if err := s.accountRepo.SetError(ctx, accountID, msg); err != nil {
+			log.Printf("[handler] set_error_failed account=%d error=%v", accountID, err)
+			return
+		}
+		log.Printf("[handler] account_disabled_invalid_key account=%d", accountID)
+	case openaicompat.AuthErrorExpired:
+		until := time.Now().Add(unauthorizedExpiredCooldown)
+		reason := "upstream credentials expired (401), waiting for refresh"
+		if err := s.accountRepo.SetTempUnschedulable(ctx, accountID, until, reason); err != nil {
+			log.Printf("[handler] temp_unschedule_failed account=%d error=%v", accountID, err)
+			return
+		}
+		log.Printf("[handler] temp_unscheduled account=%d until=%v reason=%q", accountID, until.Format("15:04:05"), reason)
+	}
+}
+
+// CooldownRateLimitedAccount 按 failover 错误中携带的限流重置时间冷却账号。
+// 由 handler 层在切换账号时调用，避免重置前再次选中同一账号。
+func (s *GatewayService) CooldownRateLimitedAccount(ctx context.Context, accountID int64, failoverErr *UpstreamFailoverError) {
+	if failoverErr == nil || failoverErr.RateLimitResetAt == nil {
+		return
+	}
+	resetAt := *failoverErr.RateLimitResetAt
+	if !resetAt.After(time.Now()) {
+		return
+	}
+	if err := s.accountRepo.SetRateLimited(ctx, accountID, resetAt); err != nil {
+		log.Printf("[handler] rate_limit_set_failed account=%d error=%v", accountID, err)
+		return
+	}
+	log.Printf("[handler] rate_limited account=%d reset_at=%v", accountID, resetAt.Format(time.RFC3339))
+}
+
 // GatewayService handles API gateway operations
 type GatewayService struct {
 	accountRepo         AccountRepository
@@ -4568,6 +4664,10 @@ func (s *GatewayService) RecordUsage(ctx context.Context, input *RecordUsageInpu
 		result.Usage.CacheReadInputTokens += result.Usage.InputTokens
 		result.Usage.InputTokens = 0
 	}
+	if result.UsageEstimated {
+		log.Printf("usage_estimated: upstream returned no usage, recording local estimate (account=%d, model=%s, input=%d, output=%d)",
+			account.ID, result.Model, result.Usage.InputTokens, result.Usage.OutputTokens)
+	}
 
 	// 获取费率倍数（优先级：用户专属 > 分组默认 > 系统默认）
 	multiplier := s.cfg.Default.RateMultiplier
@@ -4627,6 +4727,10 @@ func (s *GatewayService) RecordUsage(ctx context.Context, input *RecordUsageInpu
 	if result.ImageSize != "" {
 		imageSize = &result.ImageSize
 	}
+	var serviceTier *string
+	if result.Usage.ServiceTier != "" {
+		serviceTier = &result.Usage.ServiceTier
+	}
 	accountRateMultiplier := account.BillingRateMultiplier()
 	usageLog := &UsageLog{
 		UserID:                user.ID,
@@ -4654,6 +4758,10 @@ func (s *GatewayService) RecordUsage(ctx context.Context, input *RecordUsageInpu
 		FirstTokenMs:          result.FirstTokenMs,
 		ImageCount:            result.ImageCount,
 		ImageSize:             imageSize,
+		WebSearchRequests:     result.Usage.WebSearchRequests,
+		ServiceTier:           serviceTier,
+		UsageEstimated:        result.UsageEstimated,
+		ReasoningTokens:       result.Usage.ReasoningTokens,
 		CreatedAt:             time.Now(),
 	}
 
@@ -4753,6 +4861,10 @@ func (s *GatewayService) RecordUsageWithLongContext(ctx context.Context, input *
 		result.Usage.CacheReadInputTokens += result.Usage.InputTokens
 		result.Usage.InputTokens = 0
 	}
+	if result.UsageEstimated {
+		log.Printf("usage_estimated: upstream returned no usage, recording local estimate (account=%d, model=%s, input=%d, output=%d)",
+			account.ID, result.Model, result.Usage.InputTokens, result.Usage.OutputTokens)
+	}
 
 	// 获取费率倍数（优先级：用户专属 > 分组默认 > 系统默认）
 	multiplier := s.cfg.Default.RateMultiplier
@@ -4812,6 +4924,10 @@ func (s *GatewayService) RecordUsageWithLongContext(ctx context.Context, input *
 	if result.ImageSize != "" {
 		imageSize = &result.ImageSize
 	}
+	var serviceTier *string
+	if result.Usage.ServiceTier != "" {
+		serviceTier = &result.Usage.ServiceTier
+	}
 	accountRateMultiplier := account.BillingRateMultiplier()
 	usageLog := &UsageLog{
 		UserID:                user.ID,
@@ -4839,6 +4955,10 @@ func (s *GatewayService) RecordUsageWithLongContext(ctx context.Context, input *
 		FirstTokenMs:          result.FirstTokenMs,
 		ImageCount:            result.ImageCount,
 		ImageSize:             imageSize,
+		WebSearchRequests:     result.Usage.WebSearchRequests,
+		ServiceTier:           serviceTier,
+		UsageEstimated:        result.UsageEstimated,
+		ReasoningTokens:       result.Usage.ReasoningTokens,
 		CreatedAt:             time.Now(),
 	}
 
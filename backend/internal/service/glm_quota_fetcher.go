@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -103,6 +104,7 @@ func (f *GLMQuotaFetcher) buildUsageInfo(data *GLMQuotaLimitData) *UsageInfo {
 	if data == nil {
 		return info
 	}
+	info.Level = strings.ToLower(strings.TrimSpace(data.Level))
 
 	for _, limit := range data.Limits {
 		progress := &UsageProgress{
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGLMQuotaFetcher_BuildUsageInfoLevel(t *testing.T) {
+	f := &GLMQuotaFetcher{}
+
+	var resp GLMQuotaLimitResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"data":{"level":"Pro","limits":[
+		{"type":"TOKENS_LIMIT","unit":3,"number":5,"percentage":42}
+	]}}`), &resp))
+	info := f.buildUsageInfo(resp.Data)
+	require.Equal(t, "pro", info.Level)
+	require.NotNil(t, info.FiveHour)
+	require.Equal(t, 42.0, info.FiveHour.Utilization)
+
+	// 响应未返回 level 或无 data 时等级为空，JSON 中省略
+	var noLevel GLMQuotaLimitResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"data":{"limits":[]}}`), &noLevel))
+	require.Empty(t, f.buildUsageInfo(noLevel.Data).Level)
+	info = f.buildUsageInfo(nil)
+	require.Empty(t, info.Level)
+	encoded, err := json.Marshal(info)
+	require.NoError(t, err)
+	require.NotContains(t, string(encoded), `"level"`)
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/openaicompat"
+	"github.com/gin-gonic/gin"
+)
+
+// 辅助接口（模型列表 / count_tokens）默认超时，避免继承主请求较长的超时时间
+const (
+	defaultOpenAICompatModelsTimeout      = 10 * time.Second
+	defaultOpenAICompatCountTokensTimeout = 10 * time.Second
+)
+
+// modelsCacheTTL 上游模型列表缓存时间，0 表示不缓存
+func (s *OpenAICompatGatewayService) modelsCacheTTL() time.Duration {
+	if s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.AuxModelsCacheTTL > 0 {
+		return time.Duration(s.settingService.cfg.Gateway.AuxModelsCacheTTL) * time.Second
+	}
+	return 0
+}
+
+// modelsTimeout 上游模型列表请求超时
+func (s *OpenAICompatGatewayService) modelsTimeout() time.Duration {
+	if s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.AuxModelsTimeout > 0 {
+		return time.Duration(s.settingService.cfg.Gateway.AuxModelsTimeout) * time.Second
+	}
+	return defaultOpenAICompatModelsTimeout
+}
+
+// countTokensTimeout 上游 count_tokens 单次请求超时
+func (s *OpenAICompatGatewayService) countTokensTimeout() time.Duration {
+	if s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.AuxCountTokensTimeout > 0 {
+		return time.Duration(s.settingService.cfg.Gateway.AuxCountTokensTimeout) * time.Second
+	}
+	return defaultOpenAICompatCountTokensTimeout
+}
+
+// countTokensMaxRetries 上游 count_tokens 最大重试次数
+func (s *OpenAICompatGatewayService) countTokensMaxRetries() int {
+	if s.settingService != nil && s.settingService.cfg != nil {
+		return s.settingService.cfg.Gateway.AuxCountTokensMaxRetries
+	}
+	return 1
+}
+
+// ListModels 获取上游 /models 返回的模型 ID 列表
+// 按账号缓存 gateway.aux_models_cache_ttl 秒（0 表示不缓存），账号 base_url 变化或缓存过期时重新请求；
+// 失败结果不缓存，调用方应回退到默认列表
+func (s *OpenAICompatGatewayService) ListModels(ctx context.Context, account *Account) ([]string, error) {
+	ttl := s.modelsCacheTTL()
+	baseURL := strings.TrimSpace(account.GetCredential("base_url"))
+	if ttl > 0 {
+		if models, ok := s.modelsCache.get(account.ID, baseURL, ttl); ok {
+			return models, nil
+		}
+	}
+	return s.RefreshModels(ctx, account)
+}
+
+// RefreshModels 忽略缓存重新获取上游模型列表，成功时更新该账号的缓存
+// 供运维在上游模型变化后手动刷新，无需重启服务
+func (s *OpenAICompatGatewayService) RefreshModels(ctx context.Context, account *Account) ([]string, error) {
+	models, err := s.fetchModels(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	if s.modelsCacheTTL() > 0 {
+		s.modelsCache.set(account.ID, strings.TrimSpace(account.GetCredential("base_url")), models)
+	}
+	return models, nil
+}
+
+// InvalidateModels 清除账号的模型列表缓存，下次 ListModels 时重新请求上游
+func (s *OpenAICompatGatewayService) InvalidateModels(accountID int64) {
+	s.modelsCache.delete(accountID)
+}
+
+// fetchModels 请求上游 /models
+// 使用独立的短超时，且不重试
+func (s *OpenAICompatGatewayService) fetchModels(ctx context.Context, account *Account) ([]string, error) {
+	baseURL, apiKey, err := openAICompatUpstreamCredentials(account)
+	if err != nil {
+		return nil, err
+	}
+	upstreamURL := baseURL + "/models"
+
+	ctx, cancel := context.WithTimeout(ctx, s.modelsTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create models request: %w", err)
+	}
+	setOpenAICompatAuthHeader(req.Header, account, apiKey)
+
+	resp, err := s.httpUpstream.Do(req, openAICompatProxyURL(account), account.ID, account.Concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("models request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read models response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("models API returned %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &list); err != nil {
+		return nil, fmt.Errorf("parse models response: %w", err)
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		if id := strings.TrimSpace(m.ID); id != "" {
+			models = append(models, id)
+		}
+	}
+	return models, nil
+}
+
+// CountTokens 通过上游 tokenize 接口统计 Claude 请求的 input tokens
+// 仅当账号配置了 count_tokens_url 凭据（如 vLLM 的 /tokenize）时才请求上游；
+// 每次请求使用独立的短超时，网络错误或 5xx 时按配置重试
+func (s *OpenAICompatGatewayService) CountTokens(ctx context.Context, account *Account, body []byte) (int, error) {
+	countURL := strings.TrimSpace(account.GetCredential("count_tokens_url"))
+	if countURL == "" {
+		return 0, fmt.Errorf("openai-compat account has no count_tokens_url")
+	}
+	apiKey := strings.TrimSpace(account.GetCredential("api_key"))
+
+	var claudeReq antigravity.ClaudeRequest
+	if err := json.Unmarshal(body, &claudeReq); err != nil {
+		return 0, fmt.Errorf("parse claude request: %w", err)
+	}
+	if mapped := account.GetMappedModel(claudeReq.Model); mapped != "" {
+		claudeReq.Model = mapped
+	}
+	openaiBody, _, err := openaicompat.TransformClaudeToOpenAIWithOptions(&claudeReq, s.requestOptions(account))
+	if err != nil {
+		return 0, fmt.Errorf("transform request: %w", err)
+	}
+
+	maxRetries := s.countTokensMaxRetries()
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		count, retryable, err := s.doCountTokens(ctx, account, countURL, apiKey, openaiBody)
+		if err == nil {
+			return count, nil
+		}
+		lastErr = err
+		if !retryable || ctx.Err() != nil {
+			break
+		}
+		if attempt < maxRetries {
+			log.Printf("[OpenAICompat] count_tokens attempt %d/%d failed: %v", attempt+1, maxRetries+1, err)
+		}
+	}
+	return 0, lastErr
+}
+
+// doCountTokens 发送单次 tokenize 请求，返回 token 数以及失败时是否可重试
+func (s *OpenAICompatGatewayService) doCountTokens(ctx context.Context, account *Account, countURL, apiKey string, body []byte) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.countTokensTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, countURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, fmt.Errorf("create count_tokens request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		setOpenAICompatAuthHeader(req.Header, account, apiKey)
+	}
+
+	resp, err := s.httpUpstream.Do(req, openAICompatProxyURL(account), account.ID, account.Concurrency)
+	if err != nil {
+		return 0, true, fmt.Errorf("count_tokens request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+	if err != nil {
+		return 0, true, fmt.Errorf("read count_tokens response: %w", err)
+	}
+	if resp.StatusCode >= 500 {
+		return 0, true, fmt.Errorf("count_tokens API returned %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 400 {
+		return 0, false, fmt.Errorf("count_tokens API returned %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	// 兼容 vLLM（count）以及 Anthropic 风格（input_tokens）的返回格式
+	var result struct {
+		Count       *int `json:"count"`
+		InputTokens *int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return 0, false, fmt.Errorf("parse count_tokens response: %w", err)
+	}
+	switch {
+	case result.Count != nil:
+		return *result.Count, false, nil
+	case result.InputTokens != nil:
+		return *result.InputTokens, false, nil
+	default:
+		return 0, false, fmt.Errorf("count_tokens response has no token count")
+	}
+}
+
+// ForwardCountTokens 处理 openai_compat 账号的 count_tokens 请求
+// 上游不支持或统计失败时在本地按内容估算；请求带 verbose=true 查询参数时，
+// 本地估算结果附带按 system / messages / tools / images 分项的 breakdown
+func (s *OpenAICompatGatewayService) ForwardCountTokens(ctx context.Context, c *gin.Context, account *Account, body []byte) error {
+	count, err := s.CountTokens(ctx, account, body)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"input_tokens": count})
+		return nil
+	}
+	log.Printf("[OpenAICompat] count_tokens unavailable for account %d, estimating locally: %v", account.ID, err)
+
+	var claudeReq antigravity.ClaudeRequest
+	if json.Unmarshal(body, &claudeReq) != nil {
+		c.JSON(http.StatusOK, gin.H{"input_tokens": 0})
+		return nil
+	}
+	breakdown := estimateOpenAICompatCountTokens(&claudeReq)
+	resp := gin.H{"input_tokens": breakdown.Total()}
+	if verbose, _ := strconv.ParseBool(c.Query("verbose")); verbose {
+		resp["estimated"] = true
+		resp["breakdown"] = breakdown
+	}
+	c.JSON(http.StatusOK, resp)
+	return nil
+}
+
+// openAICompatModelsCache 按账号缓存的上游模型列表
+type openAICompatModelsCache struct {
+	mu      sync.Mutex
+	entries map[int64]openAICompatModelsCacheEntry
+}
+
+type openAICompatModelsCacheEntry struct {
+	baseURL   string
+	models    []string
+	fetchedAt time.Time
+}
+
+func newOpenAICompatModelsCache() *openAICompatModelsCache {
+	return &openAICompatModelsCache{entries: make(map[int64]openAICompatModelsCacheEntry)}
+}
+
+// get 返回未过期且 base_url 未变化的缓存，返回副本避免调用方修改缓存
+func (c *openAICompatModelsCache) get(accountID int64, baseURL string, ttl time.Duration) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[accountID]
+	if !ok || entry.baseURL != baseURL || time.Since(entry.fetchedAt) >= ttl {
+		return nil, false
+	}
+	return append([]string(nil), entry.models...), true
+}
+
+func (c *openAICompatModelsCache) set(accountID int64, baseURL string, models []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[accountID] = openAICompatModelsCacheEntry{
+		baseURL:   baseURL,
+		models:    append([]string(nil), models...),
+		fetchedAt: time.Now(),
+	}
+}
+
+func (c *openAICompatModelsCache) delete(accountID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, accountID)
+}
+
+// openAICompatProxyURL 返回账号配置的代理 URL，未配置时为空
+func openAICompatProxyURL(account *Account) string {
+	if account.ProxyID != nil && account.Proxy != nil {
+		return account.Proxy.URL()
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Wei-Shaw/sub2api/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newOpenAICompatAuxTestService(gateway config.GatewayConfig) *OpenAICompatGatewayService {
+	cfg := &config.Config{Gateway: gateway}
+	return NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+}
+
+// blockingHandler 阻塞直到客户端取消请求，用于验证超时
+func blockingHandler(hits *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func TestOpenAICompatListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/models", r.URL.Path)
+		require.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"model-a"},{"id":"model-b"}]}`))
+	}))
+	defer server.Close()
+
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{AuxModelsTimeout: 1})
+	models, err := svc.ListModels(context.Background(), newOpenAICompatTestAccount(server.URL+"/v1", nil))
+	require.NoError(t, err)
+	require.Equal(t, []string{"model-a", "model-b"}, models)
+}
+
+func TestOpenAICompatListModels_TimeoutWithoutRetry(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(blockingHandler(&hits))
+	defer server.Close()
+
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{AuxModelsTimeout: 1, AuxCountTokensMaxRetries: 3})
+	start := time.Now()
+	_, err := svc.ListModels(context.Background(), newOpenAICompatTestAccount(server.URL, nil))
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 3*time.Second)
+	require.EqualValues(t, 1, atomic.LoadInt32(&hits))
+}
+
+func TestOpenAICompatCountTokens_RetriesOnServerError(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"count":42,"max_model_len":8192}`))
+	}))
+	defer server.Close()
+
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{AuxCountTokensTimeout: 1, AuxCountTokensMaxRetries: 1})
+	account := newOpenAICompatTestAccount(server.URL, nil)
+	account.Credentials["count_tokens_url"] = server.URL + "/tokenize"
+
+	count, err := svc.CountTokens(context.Background(), account, []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, 42, count)
+	require.EqualValues(t, 2, atomic.LoadInt32(&hits))
+}
+
+func TestOpenAICompatCountTokens_TimeoutPerAttempt(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(blockingHandler(&hits))
+	defer server.Close()
+
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{AuxCountTokensTimeout: 1, AuxCountTokensMaxRetries: 1})
+	account := newOpenAICompatTestAccount(server.URL, nil)
+	account.Credentials["count_tokens_url"] = server.URL + "/tokenize"
+
+	start := time.Now()
+	_, err := svc.CountTokens(context.Background(), account, []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 4*time.Second)
+	require.EqualValues(t, 2, atomic.LoadInt32(&hits))
+}
+
+func TestOpenAICompatCountTokens_NotConfigured(t *testing.T) {
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{})
+	_, err := svc.CountTokens(context.Background(), newOpenAICompatTestAccount("http://127.0.0.1:1", nil), []byte(`{"model":"m","messages":[]}`))
+	require.Error(t, err)
+}
+
+func TestOpenAICompatListModels_Cache(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			_, _ = w.Write([]byte(`{"data":[{"id":"model-a"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[{"id":"model-a"},{"id":"model-b"}]}`))
+	}))
+	defer server.Close()
+
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{AuxModelsTimeout: 1, AuxModelsCacheTTL: 60})
+	account := newOpenAICompatTestAccount(server.URL, nil)
+
+	// 缓存命中：第二次不请求上游
+	models, err := svc.ListModels(context.Background(), account)
+	require.NoError(t, err)
+	require.Equal(t, []string{"model-a"}, models)
+	models, err = svc.ListModels(context.Background(), account)
+	require.NoError(t, err)
+	require.Equal(t, []string{"model-a"}, models)
+	require.EqualValues(t, 1, atomic.LoadInt32(&hits))
+
+	// 手动刷新：忽略缓存并更新缓存
+	models, err = svc.RefreshModels(context.Background(), account)
+	require.NoError(t, err)
+	require.Equal(t, []string{"model-a", "model-b"}, models)
+	models, err = svc.ListModels(context.Background(), account)
+	require.NoError(t, err)
+	require.Equal(t, []string{"model-a", "model-b"}, models)
+	require.EqualValues(t, 2, atomic.LoadInt32(&hits))
+
+	// 过期后重新请求
+	svc.modelsCache.mu.Lock()
+	entry := svc.modelsCache.entries[account.ID]
+	entry.fetchedAt = time.Now().Add(-61 * time.Second)
+	svc.modelsCache.entries[account.ID] = entry
+	svc.modelsCache.mu.Unlock()
+	_, err = svc.ListModels(context.Background(), account)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, atomic.LoadInt32(&hits))
+
+	// 清除缓存后重新请求
+	svc.InvalidateModels(account.ID)
+	_, err = svc.ListModels(context.Background(), account)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, atomic.LoadInt32(&hits))
+}
+
+func TestOpenAICompatListModels_CacheDisabled(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		_, _ = w.Write([]byte(`{"data":[{"id":"model-a"}]}`))
+	}))
+	defer server.Close()
+
+	svc := newOpenAICompatAuxTestService(config.GatewayConfig{AuxModelsTimeout: 1})
+	account := newOpenAICompatTestAccount(server.URL, nil)
+	for i := 0; i < 2; i++ {
+		_, err := svc.ListModels(context.Background(), account)
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 2, atomic.LoadInt32(&hits))
+}
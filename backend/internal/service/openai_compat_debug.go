@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/Wei-Shaw/sub2api/internal/util/logredact"
+)
+
+// defaultOpenAICompatDebugLogMaxBytes 调试日志请求/响应体默认记录上限
+const defaultOpenAICompatDebugLogMaxBytes = 8192
+
+// debugLogEnabled 是否开启 openai_compat 调试日志（gateway.debug_log）
+func (s *OpenAICompatGatewayService) debugLogEnabled() bool {
+	return s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.DebugLog
+}
+
+// debugLogMaxBytes 调试日志请求/响应体记录上限
+func (s *OpenAICompatGatewayService) debugLogMaxBytes() int {
+	if s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.DebugLogMaxBytes > 0 {
+		return s.settingService.cfg.Gateway.DebugLogMaxBytes
+	}
+	return defaultOpenAICompatDebugLogMaxBytes
+}
+
+// logDebugRequest 记录发往上游的请求（脱敏头部与 base64 图片，截断请求体）
+func (s *OpenAICompatGatewayService) logDebugRequest(account *Account, req *http.Request, body []byte) {
+	log.Printf("[OpenAICompat][Debug] account=%d request url=%s headers=%s body=%s",
+		account.ID, req.URL.String(), formatDebugHeaders(req.Header, account.GetAuthHeaderName()), redactDebugBody(body, s.debugLogMaxBytes()))
+}
+
+// wrapDebugResponse 包装上游响应体：读取时旁路保存前 N 字节，关闭时记录响应
+// 流式与非流式响应统一在 Body.Close 时输出，不影响正常读取
+func (s *OpenAICompatGatewayService) wrapDebugResponse(account *Account, resp *http.Response) *http.Response {
+	resp.Body = &debugResponseBody{
+		ReadCloser: resp.Body,
+		accountID:  account.ID,
+		statusCode: resp.StatusCode,
+		headers:    resp.Header.Clone(),
+		maxBytes:   s.debugLogMaxBytes(),
+	}
+	return resp
+}
+
+// debugResponseBody 记录上游响应体前 maxBytes 字节，在 Close 时输出调试日志
+type debugResponseBody struct {
+	io.ReadCloser
+	accountID  int64
+	statusCode int
+	headers    http.Header
+	maxBytes   int
+	captured   bytes.Buffer
+	total      int
+	once       sync.Once
+}
+
+func (b *debugResponseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.total += n
+		if remaining := b.maxBytes - b.captured.Len(); remaining > 0 {
+			b.captured.Write(p[:min(n, remaining)])
+		}
+	}
+	return n, err
+}
+
+func (b *debugResponseBody) Close() error {
+	b.once.Do(func() {
+		body := redactDebugBody(b.captured.Bytes(), b.maxBytes)
+		if b.total > b.captured.Len() {
+			body += "...(truncated)"
+		}
+		log.Printf("[OpenAICompat][Debug] account=%d response status=%d headers=%s body=%s",
+			b.accountID, b.statusCode, formatDebugHeaders(b.headers), body)
+	})
+	return b.ReadCloser.Close()
+}
+
+// formatDebugHeaders 将脱敏后的头部格式化为 JSON
+func formatDebugHeaders(h http.Header, extraSensitive ...string) string {
+	encoded, err := json.Marshal(logredact.RedactHeaders(h, extraSensitive...))
+	if err != nil {
+		return "{}"
+	}
+	return string(encoded)
+}
+
+// redactDebugBody 脱敏 base64 图片数据后截断为单行
+func redactDebugBody(body []byte, maxBytes int) string {
+	redacted := logredact.RedactBase64DataURLs(string(body))
+	out := truncateForLog([]byte(redacted), maxBytes)
+	if len(redacted) > maxBytes {
+		out += "...(truncated)"
+	}
+	return out
+}
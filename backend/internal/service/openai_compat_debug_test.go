@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdLog 将标准 log 输出重定向到缓冲区
+func captureStdLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func forwardOpenAICompatWithDebugLog(t *testing.T, debugLog bool, maxBytes int, body string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{DebugLog: debugLog, DebugLogMaxBytes: maxBytes}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	buf := captureStdLog(t)
+	_, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), []byte(body))
+	require.NoError(t, err)
+	require.Contains(t, rec.Body.String(), `"ok"`)
+	return buf.String()
+}
+
+func TestOpenAICompatDebugLog_RedactsSecretsAndImages(t *testing.T) {
+	image := strings.Repeat("QUJD", 64)
+	body := `{"model":"m","messages":[{"role":"user","content":[` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + image + `"}},` +
+		`{"type":"text","text":"describe"}]}]}`
+
+	logs := forwardOpenAICompatWithDebugLog(t, true, 0, body)
+
+	require.Contains(t, logs, "[OpenAICompat][Debug] account=1 request")
+	require.Contains(t, logs, "[OpenAICompat][Debug] account=1 response status=200")
+	require.Contains(t, logs, `"Authorization":"***"`)
+	require.Contains(t, logs, `"Set-Cookie":"***"`)
+	require.NotContains(t, logs, "sk-test")
+	require.NotContains(t, logs, "secret-cookie")
+	require.NotContains(t, logs, image)
+	require.Contains(t, logs, "data:image/png;base64,<redacted 256 bytes>")
+	require.Contains(t, logs, "describe")
+	require.Contains(t, logs, `chatcmpl-1`)
+}
+
+func TestOpenAICompatDebugLog_TruncatesBody(t *testing.T) {
+	body := `{"model":"m","messages":[{"role":"user","content":"` + strings.Repeat("x", 500) + `"}]}`
+
+	logs := forwardOpenAICompatWithDebugLog(t, true, 64, body)
+
+	require.NotContains(t, logs, strings.Repeat("x", 100))
+	require.Equal(t, 2, strings.Count(logs, "...(truncated)"))
+}
+
+func TestOpenAICompatDebugLog_DisabledByDefault(t *testing.T) {
+	logs := forwardOpenAICompatWithDebugLog(t, false, 0, `{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	require.NotContains(t, logs, "[OpenAICompat][Debug]")
+}
+
+func TestFormatDebugHeaders_RedactsCustomAuthHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Gateway-Token", "sk-custom")
+	h.Set("Content-Type", "application/json")
+
+	out := formatDebugHeaders(h, "x-gateway-token")
+	require.Contains(t, out, `"X-Gateway-Token":"***"`)
+	require.Contains(t, out, `"Content-Type":"application/json"`)
+	require.NotContains(t, out, "sk-custom")
+}
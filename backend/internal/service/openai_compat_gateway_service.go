@@ -3,17 +3,24 @@ package service
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/ctxkey"
 	"github.com/Wei-Shaw/sub2api/internal/pkg/openaicompat"
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +31,8 @@ import (
 type OpenAICompatGatewayService struct {
 	httpUpstream   HTTPUpstream
 	settingService *SettingService
+	metricsHook    OpenAICompatMetricsHook
+	modelsCache    *openAICompatModelsCache
 }
 
 // NewOpenAICompatGatewayService 创建 OpenAICompatGatewayService
@@ -34,22 +43,85 @@ func NewOpenAICompatGatewayService(
 	return &OpenAICompatGatewayService{
 		httpUpstream:   httpUpstream,
 		settingService: settingService,
+		metricsHook:    noopOpenAICompatMetricsHook{},
+		modelsCache:    newOpenAICompatModelsCache(),
 	}
 }
 
 // Forward 转发请求到 OpenAI 兼容上游
-// 接收 Claude Messages API 格式请求，转换为 OpenAI Chat Completions 格式后发送
+// 接收 Claude Messages API 格式请求，转换为 OpenAI Chat Completions 格式后发送，结束后上报请求指标
+// 账号并发槽位由 handler 获取（并发已满时排队，超时返回 429），排队时间经 ctxkey.AccountSlotWait 传入并随指标上报
 func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context, account *Account, body []byte) (*ForwardResult, error) {
 	startTime := time.Now()
+	slotWait, _ := ctx.Value(ctxkey.AccountSlotWait).(time.Duration)
+	result, err := s.forward(ctx, c, account, body, startTime)
+	s.observeForward(c, account, body, startTime, slotWait, result, err)
+	return result, err
+}
+
+// transformWarningsHeader 返回请求转换警告代码（逗号分隔）的响应头
+const transformWarningsHeader = "anthropic-transform-warnings"
+
+// reportTransformWarnings 记录请求转换中对请求的静默调整，账号开启 debug_transform_warnings 时同时写入响应头
+func (s *OpenAICompatGatewayService) reportTransformWarnings(c *gin.Context, account *Account, warnings []openaicompat.TransformWarning) {
+	messages := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		messages = append(messages, w.String())
+	}
+	log.Printf("[OpenAICompat] account %d transform warnings: %s", account.ID, strings.Join(messages, "; "))
+	if account.IsTransformWarningsHeaderEnabled() {
+		c.Header(transformWarningsHeader, strings.Join(openaicompat.WarningCodes(warnings), ","))
+	}
+}
+
+// writeOpenAICompatClaudeError 以 Claude 错误格式向客户端返回网关自身产生的错误（不请求上游或上游响应无法使用时）
+func writeOpenAICompatClaudeError(c *gin.Context, status int, msg string) {
+	errBody, _ := json.Marshal(map[string]any{"error": map[string]any{"message": msg}})
+	c.Header("Content-Type", "application/json")
+	c.Status(status)
+	_, _ = c.Writer.Write(openaicompat.TransformOpenAIErrorToClaude(errBody, status))
+}
+
+// openAICompatUpstreamCredentials 获取账号的上游 base_url（经 openaicompat.NormalizeBaseURL 校验与规范化，
+// 可直接拼接 /chat/completions 等接口路径）与 api_key
+func openAICompatUpstreamCredentials(account *Account) (baseURL, apiKey string, err error) {
+	rawBaseURL := strings.TrimSpace(account.GetCredential("base_url"))
+	apiKey = strings.TrimSpace(account.GetCredential("api_key"))
+	if rawBaseURL == "" || apiKey == "" {
+		return "", "", fmt.Errorf("openai-compat account missing base_url or api_key")
+	}
+	baseURL, err = openaicompat.NormalizeBaseURL(rawBaseURL)
+	if err != nil {
+		return "", "", fmt.Errorf("openai-compat account %d: %w", account.ID, err)
+	}
+	return baseURL, apiKey, nil
+}
+
+// openAICompatUpstreamEndpoint 按账号的上游接口类型（extra.api）返回请求地址
+func openAICompatUpstreamEndpoint(account *Account, baseURL string) string {
+	switch account.GetUpstreamAPI() {
+	case openaicompat.UpstreamAPIResponses:
+		return baseURL + "/responses"
+	case openaicompat.UpstreamAPICompletions:
+		return baseURL + "/completions"
+	default:
+		return baseURL + "/chat/completions"
+	}
+}
+
+// openAICompatModelOverrideHeader 请求级模型覆盖请求头（需账号开启 extra.model_override_enabled）
+const openAICompatModelOverrideHeader = "x-model-override"
 
+// forward 执行实际的请求转换与转发
+func (s *OpenAICompatGatewayService) forward(ctx context.Context, c *gin.Context, account *Account, body []byte, startTime time.Time) (*ForwardResult, error) {
 	// 获取上游配置
-	baseURL := strings.TrimSpace(account.GetCredential("base_url"))
-	apiKey := strings.TrimSpace(account.GetCredential("api_key"))
-	if baseURL == "" || apiKey == "" {
-		return nil, fmt.Errorf("openai-compat account missing base_url or api_key")
+	baseURL, apiKey, err := openAICompatUpstreamCredentials(account)
+	if err != nil {
+		return nil, err
 	}
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	upstreamURL := baseURL + "/chat/completions"
+	upstreamURL := openAICompatUpstreamEndpoint(account, baseURL)
+	useResponsesAPI := account.GetUpstreamAPI() == openaicompat.UpstreamAPIResponses
+	useCompletionsAPI := account.GetUpstreamAPI() == openaicompat.UpstreamAPICompletions
 
 	// 解析 Claude 请求
 	var claudeReq antigravity.ClaudeRequest
@@ -59,6 +131,31 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 	if strings.TrimSpace(claudeReq.Model) == "" {
 		return nil, fmt.Errorf("missing model")
 	}
+
+	var clientHeader http.Header
+	if c.Request != nil {
+		clientHeader = c.Request.Header
+	}
+
+	// 账号开启 trace_context_enabled 时向上游注入 W3C Trace Context，日志附带 trace_id 便于端到端追踪
+	var trace openAICompatTraceContext
+	if account.IsTraceContextEnabled() {
+		trace = newOpenAICompatTraceContext(clientHeader)
+		log.Printf("[OpenAICompat] account %d trace_id=%s", account.ID, trace.traceID)
+	}
+
+	// 请求级模型覆盖：账号开启后客户端可通过请求头指定模型（不修改请求体），须在账号允许的模型范围内，计费按覆盖后的模型
+	if override := strings.TrimSpace(clientHeader.Get(openAICompatModelOverrideHeader)); override != "" && override != claudeReq.Model {
+		if !account.IsModelOverrideEnabled() {
+			log.Printf("[OpenAICompat] account %d ignored %s header: model override disabled", account.ID, openAICompatModelOverrideHeader)
+		} else if !account.IsModelSupported(override) {
+			writeOpenAICompatClaudeError(c, http.StatusBadRequest, fmt.Sprintf("model %q from %s header is not permitted for this account", override, openAICompatModelOverrideHeader))
+			return &ForwardResult{Model: claudeReq.Model}, nil
+		} else {
+			log.Printf("[OpenAICompat] account %d model overridden by header: %s -> %s", account.ID, claudeReq.Model, override)
+			claudeReq.Model = override
+		}
+	}
 	originalModel := claudeReq.Model
 	billingModel := originalModel
 
@@ -68,33 +165,56 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 		billingModel = mappedModel
 	}
 
-	// 转换为 OpenAI Chat Completions 格式
-	openaiBody, err := openaicompat.TransformClaudeToOpenAI(&claudeReq)
-	if err != nil {
-		return nil, fmt.Errorf("transform request: %w", err)
+	// 客户端声明的 anthropic-beta 标志交给转换层，供按 beta 特性调整行为
+	anthropicBetas := openaicompat.ParseAnthropicBetas(clientHeader.Values("anthropic-beta")...)
+	if len(anthropicBetas) > 0 {
+		log.Printf("[OpenAICompat] account %d anthropic-beta: %s", account.ID, strings.Join(anthropicBetas, ","))
 	}
 
-	// 创建请求
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstreamURL, bytes.NewReader(openaiBody))
+	// 转换为 OpenAI Chat Completions 格式（账号配置 api=responses / completions 时转换为 Responses API / 旧版 Completions 格式）
+	reqOpts := s.requestOptions(account)
+	reqOpts.AnthropicBetas = anthropicBetas
+	transformRequest := openaicompat.TransformClaudeToOpenAIWithOptions
+	if useResponsesAPI {
+		transformRequest = openaicompat.TransformClaudeToResponsesWithOptions
+	} else if useCompletionsAPI {
+		transformRequest = openaicompat.TransformClaudeToCompletionsWithOptions
+	}
+	openaiBody, warnings, err := transformRequest(&claudeReq, reqOpts)
+	var tooManyToolsErr *openaicompat.TooManyToolsError
+	var schemasTooLargeErr *openaicompat.ToolSchemasTooLargeError
+	if errors.As(err, &tooManyToolsErr) || errors.As(err, &schemasTooLargeErr) {
+		// 工具数量或定义大小超限：不请求上游，直接返回明确的 400
+		log.Printf("[OpenAICompat] account %d rejected request: %v", account.ID, err)
+		writeOpenAICompatClaudeError(c, http.StatusBadRequest, err.Error())
+		return &ForwardResult{Model: billingModel}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("create upstream request: %w", err)
+		return nil, fmt.Errorf("transform request: %w", err)
 	}
-
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	// 代理 URL
-	proxyURL := ""
-	if account.ProxyID != nil && account.Proxy != nil {
-		proxyURL = account.Proxy.URL()
+	if len(warnings) > 0 {
+		s.reportTransformWarnings(c, account, warnings)
+	}
+	validateSchema := account.IsSchemaValidationEnabled()
+	// 请求校验仅适用于 Chat Completions 结构；Responses API / 旧版 Completions 的响应改写后仍按 Chat Completions 结构校验
+	if validateSchema && !useResponsesAPI && !useCompletionsAPI {
+		if err := openaicompat.ValidateChatRequest(openaiBody); err != nil {
+			// 转换后的请求不符合 Chat Completions 结构（转换器缺陷）：不请求上游，返回 500 并记录问题详情
+			log.Printf("[OpenAICompat][Debug] account=%d %v", account.ID, err)
+			writeOpenAICompatClaudeError(c, http.StatusInternalServerError, err.Error())
+			return &ForwardResult{Model: billingModel}, nil
+		}
 	}
 
-	// 发送请求
-	resp, err := s.httpUpstream.Do(req, proxyURL, account.ID, account.Concurrency)
+	// 上游请求使用独立可取消的 context，流式响应中客户端断开时可按配置立即取消
+	upstreamCtx, cancelUpstream := context.WithCancel(ctx)
+	defer cancelUpstream()
+
+	// 发送请求（按账号配置对大请求体进行 gzip 压缩）
+	resp, err := s.sendChatRequest(upstreamCtx, account, upstreamURL, apiKey, openaiBody, clientHeader, trace)
 	if err != nil {
-		log.Printf("[OpenAICompat] upstream request failed: %v", err)
-		return nil, fmt.Errorf("upstream request failed: %w", err)
+		log.Printf("[OpenAICompat] upstream request failed%s: %v", trace.logSuffix(), err)
+		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -104,14 +224,28 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 
 		// 429 时返回 UpstreamFailoverError 以触发账号切换
 		if resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &UpstreamFailoverError{
+				StatusCode:       resp.StatusCode,
+				ResponseBody:     respBody,
+				RateLimitResetAt: parseOpenAICompatRateLimitResetAt(resp.Header, time.Now(), s.maxRateLimitCooldown()),
+			}
+		}
+		// 529/503 过载同样触发账号切换，并标记为过载
+		if isOpenAICompatOverloadStatus(resp.StatusCode) {
 			return nil, &UpstreamFailoverError{
 				StatusCode:   resp.StatusCode,
 				ResponseBody: respBody,
+				Overloaded:   true,
 			}
 		}
+		// 401 按响应体区分密钥无效 / 令牌过期后切换账号（需开启 extra.unauthorized_failover）
+		if failoverErr := openAICompatUnauthorizedError(account, resp.StatusCode, respBody); failoverErr != nil {
+			return nil, failoverErr
+		}
 
 		// 转换错误格式：OpenAI → Claude
-		claudeErrBody := openaicompat.TransformOpenAIErrorToClaude(respBody, resp.StatusCode)
+		s.forwardResponseHeaders(c, resp.Header)
+		claudeErrBody := openaicompat.TransformOpenAIErrorToClaudeWithMode(respBody, resp.StatusCode, account.GetErrorBodyMode())
 		c.Header("Content-Type", "application/json")
 		c.Status(resp.StatusCode)
 		_, _ = c.Writer.Write(claudeErrBody)
@@ -125,18 +259,68 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 	var usage *ClaudeUsage
 	var firstTokenMs *int
 	var clientDisconnect bool
+	var usageEstimated bool
 
+	respOpts := s.responseOptions(account)
+	respOpts.AnthropicBetas = anthropicBetas
+	respOpts.ResponsesAPI = useResponsesAPI
+	respOpts.CompletionsAPI = useCompletionsAPI
+	if account.IsEffectiveParamsDebugEnabled() {
+		respOpts.EffectiveParams = openaicompat.EffectiveRequestParams(openaiBody)
+	}
+	if !reqOpts.TextToolProtocol {
+		// 请求中改写过的工具名在响应中还原为 Claude 原名
+		respOpts.ToolNames = openaicompat.ToolNameMapping(claudeReq.Tools)
+	}
 	if claudeReq.Stream {
-		streamRes := s.streamResponse(c, resp, startTime, originalModel)
+		s.forwardResponseHeaders(c, resp.Header)
+		// 未请求 include_usage 时记录输出文本，上游未返回 usage 时用于估算
+		respOpts.RecordOutputText = reqOpts.DisableStreamUsage
+		var onDisconnect context.CancelFunc
+		if s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.CancelUpstreamOnDisconnect {
+			onDisconnect = cancelUpstream
+		}
+		streamRes := s.streamResponse(c, resp, startTime, account.ID, billingModel, originalModel, respOpts, onDisconnect)
 		usage = streamRes.usage
+		if reqOpts.DisableStreamUsage && usage.InputTokens == 0 && usage.OutputTokens == 0 {
+			usage = estimateOpenAICompatUsage(openaiBody, streamRes.outputText)
+			usage.ServiceTier = streamRes.usage.ServiceTier
+			usageEstimated = true
+		}
 		firstTokenMs = streamRes.firstTokenMs
 		clientDisconnect = streamRes.clientDisconnect
 	} else {
-		respBody, err := io.ReadAll(resp.Body)
+		respBody, err := readOpenAICompatResponseBody(resp.Body, s.maxResponseBytes())
+		var bodyTooLargeErr *openAICompatResponseTooLargeError
+		if errors.As(err, &bodyTooLargeErr) {
+			// 响应体超过上限：不再继续读取，返回明确错误，避免异常上游耗尽内存
+			log.Printf("[OpenAICompat] account %d %v", account.ID, bodyTooLargeErr)
+			writeOpenAICompatClaudeError(c, http.StatusBadGateway, bodyTooLargeErr.Error())
+			return &ForwardResult{Model: billingModel}, nil
+		}
+		partialResponse := false
+		if err != nil && isOpenAICompatReadTimeout(err) && len(respBody) > 0 {
+			// 上游返回部分内容后停滞至超时：尽量修复已收到的响应体并返回部分内容（stop_reason 为 max_tokens）
+			if salvaged, ok := openaicompat.SalvagePartialChatResponse(respBody); ok {
+				log.Printf("[OpenAICompat] account %d upstream response interrupted after %d bytes (%v), returning partial content", account.ID, len(respBody), err)
+				respBody, err, partialResponse = salvaged, nil, true
+			}
+		}
 		if err != nil {
 			return nil, fmt.Errorf("read upstream response: %w", err)
 		}
 
+		// Responses API / 旧版 Completions 响应改写为 Chat Completions 结构（失败的响应改写为 error 对象），后续按统一流程处理
+		if useResponsesAPI {
+			if converted, err := openaicompat.ResponsesToChatResponse(respBody); err == nil {
+				respBody = converted
+			}
+		} else if useCompletionsAPI {
+			if converted, err := openaicompat.CompletionsToChatResponse(respBody); err == nil {
+				respBody = converted
+			}
+		}
+
 		// 某些上游可能用 HTTP 200 包装错误（错误码在 JSON body 内部）
 		var errResp openaicompat.ErrorResponse
 		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != nil {
@@ -145,21 +329,73 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 				statusCode = int(code)
 			}
 			if statusCode == http.StatusTooManyRequests {
+				return nil, &UpstreamFailoverError{
+					StatusCode:       statusCode,
+					ResponseBody:     respBody,
+					RateLimitResetAt: parseOpenAICompatRateLimitResetAt(resp.Header, time.Now(), s.maxRateLimitCooldown()),
+				}
+			}
+			if isOpenAICompatOverloadStatus(statusCode) {
 				return nil, &UpstreamFailoverError{
 					StatusCode:   statusCode,
 					ResponseBody: respBody,
+					Overloaded:   true,
 				}
 			}
-			claudeErrBody := openaicompat.TransformOpenAIErrorToClaude(respBody, statusCode)
+			if failoverErr := openAICompatUnauthorizedError(account, statusCode, respBody); failoverErr != nil {
+				return nil, failoverErr
+			}
+			s.forwardResponseHeaders(c, resp.Header)
+			claudeErrBody := openaicompat.TransformOpenAIErrorToClaudeWithMode(respBody, statusCode, account.GetErrorBodyMode())
 			c.Header("Content-Type", "application/json")
 			c.Status(statusCode)
 			_, _ = c.Writer.Write(claudeErrBody)
 			return &ForwardResult{Model: billingModel}, nil
 		}
+		if validateSchema {
+			if err := openaicompat.ValidateChatResponse(respBody); err != nil {
+				// 上游响应不符合 Chat Completions 结构：返回 502 并记录问题详情
+				log.Printf("[OpenAICompat][Debug] account=%d %v", account.ID, err)
+				writeOpenAICompatClaudeError(c, http.StatusBadGateway, err.Error())
+				return &ForwardResult{Model: billingModel}, nil
+			}
+		}
+		s.forwardResponseHeaders(c, resp.Header)
 
 		// 转换响应：OpenAI → Claude
-		claudeRespBody, respUsage, err := openaicompat.TransformOpenAIToClaude(respBody, originalModel)
-		if err != nil {
+		claudeRespBody, respUsage, err := openaicompat.TransformOpenAIToClaudeWithOptions(respBody, originalModel, respOpts)
+		var refusalErr *openaicompat.RefusalError
+		var tooLargeErr *openaicompat.ToolArgumentsTooLargeError
+		var tooManyErr *openaicompat.TooManyToolCallsError
+		if errors.Is(err, openaicompat.ErrEmptyCompletion) && respOpts.EmptyStopMode == openaicompat.EmptyStopModeRetry {
+			// 空的 end_turn 视为失败的生成：按临时性错误先在同一账号上重试，再切换账号
+			log.Printf("[OpenAICompat] account %d %v, retrying", account.ID, err)
+			return nil, &UpstreamFailoverError{
+				StatusCode:             http.StatusBadGateway,
+				ResponseBody:           respBody,
+				RetryableOnSameAccount: true,
+			}
+		}
+		if errors.Is(err, openaicompat.ErrEmptyChoices) || errors.Is(err, openaicompat.ErrMissingChoices) || errors.Is(err, openaicompat.ErrEmptyCompletion) {
+			// choices 为空或缺失、内容为空：返回明确错误，仍按上游报告的 usage 计费
+			log.Printf("[OpenAICompat] account %d %v", account.ID, err)
+			writeOpenAICompatClaudeError(c, http.StatusBadGateway, err.Error())
+			usage = openAICompatClaudeUsage(respUsage)
+		} else if errors.As(err, &refusalErr) {
+			// 上游拒绝回答：以 invalid_request_error 返回拒绝原因，仍按 usage 计费
+			writeOpenAICompatClaudeError(c, http.StatusBadRequest, refusalErr.Message)
+			usage = openAICompatClaudeUsage(respUsage)
+		} else if errors.As(err, &tooLargeErr) {
+			// tool call arguments 超限：不下发异常巨大的工具输入，返回 502，仍按 usage 计费
+			log.Printf("[OpenAICompat] account %d %v", account.ID, tooLargeErr)
+			writeOpenAICompatClaudeError(c, http.StatusBadGateway, tooLargeErr.Error())
+			usage = openAICompatClaudeUsage(respUsage)
+		} else if errors.As(err, &tooManyErr) {
+			// tool call 数量超限：返回 502，仍按 usage 计费
+			log.Printf("[OpenAICompat] account %d %v", account.ID, tooManyErr)
+			writeOpenAICompatClaudeError(c, http.StatusBadGateway, tooManyErr.Error())
+			usage = openAICompatClaudeUsage(respUsage)
+		} else if err != nil {
 			// 转换失败，透传原始响应
 			log.Printf("[OpenAICompat] transform response failed: %v, passing through", err)
 			c.Header("Content-Type", resp.Header.Get("Content-Type"))
@@ -170,16 +406,20 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 			c.Header("Content-Type", "application/json")
 			c.Status(http.StatusOK)
 			_, _ = c.Writer.Write(claudeRespBody)
-			usage = &ClaudeUsage{
-				InputTokens:          respUsage.InputTokens,
-				OutputTokens:         respUsage.OutputTokens,
-				CacheReadInputTokens: respUsage.CacheReadInputTokens,
+			usage = openAICompatClaudeUsage(respUsage)
+			if usage.InputTokens == 0 && usage.OutputTokens == 0 && (s.estimateMissingUsage() || partialResponse) {
+				// 上游未返回 usage：按请求与输出文本估算，避免按 0 计费（部分响应通常缺少末尾的 usage，始终估算）
+				serviceTier := usage.ServiceTier
+				usage = estimateOpenAICompatUsage(openaiBody, openAICompatResponseOutputText(respBody))
+				usage.ServiceTier = serviceTier
+				usageEstimated = true
+				log.Printf("[OpenAICompat] account %d response has no usage, estimated input=%d output=%d", account.ID, usage.InputTokens, usage.OutputTokens)
 			}
 		}
 	}
 
 	duration := time.Since(startTime)
-	log.Printf("[OpenAICompat] status=success model=%s duration_ms=%d", billingModel, duration.Milliseconds())
+	log.Printf("[OpenAICompat] status=success model=%s duration_ms=%d%s", billingModel, duration.Milliseconds(), trace.logSuffix())
 
 	return &ForwardResult{
 		Model:            billingModel,
@@ -187,31 +427,448 @@ func (s *OpenAICompatGatewayService) Forward(ctx context.Context, c *gin.Context
 		Duration:         duration,
 		FirstTokenMs:     firstTokenMs,
 		ClientDisconnect: clientDisconnect,
-		Usage: ClaudeUsage{
-			InputTokens:          usage.InputTokens,
-			OutputTokens:         usage.OutputTokens,
-			CacheReadInputTokens: usage.CacheReadInputTokens,
-		},
+		UsageEstimated:   usageEstimated,
+		Usage:            *usage,
 	}, nil
 }
 
+// estimateMissingUsage 是否在非流式响应缺少 usage 时本地估算用量（gateway.estimate_missing_usage）
+func (s *OpenAICompatGatewayService) estimateMissingUsage() bool {
+	return s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.EstimateMissingUsage
+}
+
+// hideThinkingFromClient 是否对客户端隐藏 thinking 块：账号 extra.hide_thinking_from_client 优先，
+// 未配置时使用 gateway.hide_thinking_from_client
+func (s *OpenAICompatGatewayService) hideThinkingFromClient(account *Account) bool {
+	if override := account.GetHideThinkingFromClientOverride(); override != nil {
+		return *override
+	}
+	return s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.HideThinkingFromClient
+}
+
+// openAICompatResponseOutputText 提取非流式响应中的输出文本（content、reasoning、refusal 与 tool call），用于估算 output tokens
+func openAICompatResponseOutputText(respBody []byte) string {
+	var resp openaicompat.ChatResponse
+	if json.Unmarshal(respBody, &resp) != nil {
+		return ""
+	}
+	var out strings.Builder
+	for _, choice := range resp.Choices {
+		msg := choice.Message
+		var text string
+		if json.Unmarshal(msg.Content, &text) == nil {
+			out.WriteString(text)
+		}
+		out.WriteString(msg.ReasoningContent)
+		out.WriteString(msg.Reasoning)
+		out.WriteString(msg.Refusal)
+		for _, tc := range msg.ToolCalls {
+			out.WriteString(tc.Function.Name)
+			out.WriteString(tc.Function.Arguments)
+		}
+		if msg.FunctionCall != nil {
+			out.WriteString(msg.FunctionCall.Name)
+			out.WriteString(msg.FunctionCall.Arguments)
+		}
+	}
+	return out.String()
+}
+
+// openAICompatResponseTooLargeError 非流式上游响应体超过 gateway.max_response_bytes
+type openAICompatResponseTooLargeError struct {
+	Limit int
+}
+
+func (e *openAICompatResponseTooLargeError) Error() string {
+	return fmt.Sprintf("upstream response exceeds max response size of %d bytes", e.Limit)
+}
+
+// maxResponseBytes 返回非流式上游响应体的最大字节数，0 表示不限制
+func (s *OpenAICompatGatewayService) maxResponseBytes() int {
+	if s.settingService == nil || s.settingService.cfg == nil {
+		return 0
+	}
+	return s.settingService.cfg.Gateway.MaxResponseBytes
+}
+
+// readOpenAICompatResponseBody 读取上游响应体，超过 limit 字节时返回 openAICompatResponseTooLargeError（limit<=0 不限制）
+// 读取出错时同时返回已读取的部分内容
+func readOpenAICompatResponseBody(r io.Reader, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	body, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return body, err
+	}
+	if len(body) > limit {
+		return nil, &openAICompatResponseTooLargeError{Limit: limit}
+	}
+	return body, nil
+}
+
+// openAICompatDefaultAnthropicVersion anthropic-auth 上游未指定版本时发送的 anthropic-version
+const openAICompatDefaultAnthropicVersion = "2023-06-01"
+
+// setOpenAICompatAuthHeader 按账号配置的鉴权方式与请求头名设置上游鉴权信息
+// anthropic-auth 上游改用 x-api-key 与 anthropic-version（credentials.anthropic_version，默认 2023-06-01），请求体与接口不变
+func setOpenAICompatAuthHeader(h http.Header, account *Account, apiKey string) {
+	if account.GetUpstreamProvider() == openaicompat.ProviderAnthropicAuth {
+		h.Set("x-api-key", apiKey)
+		version := strings.TrimSpace(account.GetCredential("anthropic_version"))
+		if version == "" {
+			version = openAICompatDefaultAnthropicVersion
+		}
+		h.Set("anthropic-version", version)
+		return
+	}
+	var value string
+	switch account.GetAuthScheme() {
+	case "basic":
+		value = "Basic " + base64.StdEncoding.EncodeToString([]byte(apiKey))
+	case "raw":
+		value = apiKey
+	default:
+		value = "Bearer " + apiKey
+	}
+	h.Set(account.GetAuthHeaderName(), value)
+}
+
+// setOpenAICompatAnthropicClientHeaders anthropic-auth 上游透传客户端的 anthropic-version / anthropic-beta 请求头
+// 账号配置白名单时仅透传白名单内的取值
+func setOpenAICompatAnthropicClientHeaders(h http.Header, account *Account, client http.Header) {
+	if account.GetUpstreamProvider() != openaicompat.ProviderAnthropicAuth || client == nil {
+		return
+	}
+	// anthropic-version 不在白名单中时保留账号默认版本
+	if v := strings.TrimSpace(client.Get("anthropic-version")); v != "" {
+		if allowlist := account.GetAnthropicVersionAllowlist(); allowlist == nil || slices.Contains(allowlist, v) {
+			h.Set("anthropic-version", v)
+		}
+	}
+	betas := openaicompat.ParseAnthropicBetas(client.Values("anthropic-beta")...)
+	if betas = openaicompat.FilterAnthropicBetas(betas, account.GetAnthropicBetaAllowlist()); len(betas) > 0 {
+		h.Set("anthropic-beta", strings.Join(betas, ","))
+	}
+}
+
+// openAICompatClaudeUsage 将转换层的 Claude usage 转为计费使用的 ClaudeUsage
+func openAICompatClaudeUsage(u *antigravity.ClaudeUsage) *ClaudeUsage {
+	usage := &ClaudeUsage{
+		InputTokens:              u.InputTokens,
+		OutputTokens:             u.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens,
+		ServiceTier:              u.ServiceTier,
+		ReasoningTokens:          u.ReasoningTokens,
+	}
+	if u.ServerToolUse != nil {
+		usage.WebSearchRequests = u.ServerToolUse.WebSearchRequests
+	}
+	return usage
+}
+
+// requestOptions 根据账号及网关配置构建请求转换选项
+func (s *OpenAICompatGatewayService) requestOptions(account *Account) openaicompat.RequestOptions {
+	opts := openaicompat.DefaultRequestOptions()
+	if s.settingService != nil && s.settingService.cfg != nil {
+		opts.EmptyAssistantMode = strings.ToLower(strings.TrimSpace(s.settingService.cfg.Gateway.EmptyAssistantMode))
+		opts.OrphanToolResultMode = strings.ToLower(strings.TrimSpace(s.settingService.cfg.Gateway.OrphanToolResultMode))
+		opts.ToolResultJSON = s.settingService.cfg.Gateway.ToolResultJSONMode
+		opts.MaxImageDimension = s.settingService.cfg.Gateway.MaxImageDimension
+		opts.MaxTools = s.settingService.cfg.Gateway.MaxTools
+		opts.MaxToolSchemaBytes = s.settingService.cfg.Gateway.MaxToolSchemaBytes
+		opts.ToolOverflowMode = strings.ToLower(strings.TrimSpace(s.settingService.cfg.Gateway.ToolOverflowMode))
+		opts.AdaptiveReasoningEffort = s.settingService.cfg.Gateway.AdaptiveReasoningEffort
+	}
+	opts.TextToolProtocol = account.IsTextToolProtocolEnabled()
+	opts.WebSearchMode = account.GetWebSearchMode()
+	opts.Provider = account.GetUpstreamProvider()
+	opts.DefaultMaxTokens = account.GetDefaultMaxTokens()
+	opts.OmitToolChoiceNone = account.IsOmitToolChoiceNoneEnabled()
+	opts.SamplingAllowlist = account.GetSamplingParamAllowlist()
+	opts.SupportsTopK = account.GetSupportsTopK()
+	opts.TopKPlacement = account.GetTopKPlacement()
+	opts.Logprobs, opts.TopLogprobs = account.GetLogprobsConfig()
+	opts.PredictionPassthrough = account.IsPredictionPassthroughEnabled()
+	opts.DisableStreamUsage = account.IsStreamUsageDisabled()
+	opts.ReasoningMode = account.GetReasoningMode()
+	opts.ReasoningOnly = account.GetReasoningOnlyOverride()
+	thresholds := &opts.ReasoningEffortThresholds
+	thresholds.MinimalMax, thresholds.LowMax, thresholds.MediumMax = account.GetReasoningEffortThresholds()
+	opts.PreserveUserThinking = account.IsPreserveUserThinkingEnabled()
+	opts.SystemDirectiveOrder = account.GetSystemDirectiveOrder()
+	opts.SystemPrefix = account.GetSystemPrefix()
+	opts.SystemSuffix = account.GetSystemSuffix()
+	opts.Modalities = account.GetOutputModalities()
+	if voice, format := account.GetAudioOutputConfig(); voice != "" || format != "" {
+		opts.Audio = &openaicompat.AudioConfig{Voice: voice, Format: format}
+	}
+	return opts
+}
+
+// responseOptions 根据账号及网关配置构建响应转换选项
+func (s *OpenAICompatGatewayService) responseOptions(account *Account) openaicompat.ResponseOptions {
+	opts := openaicompat.DefaultResponseOptions()
+	if s.settingService != nil && s.settingService.cfg != nil {
+		opts.MaxDeltaBytes = s.settingService.cfg.Gateway.MaxDeltaBytes
+		opts.TextDeltaBatchWindow = time.Duration(s.settingService.cfg.Gateway.TextDeltaBatchWindowMs) * time.Millisecond
+		opts.MaxToolArgumentBytes = s.settingService.cfg.Gateway.MaxToolArgumentBytes
+		opts.ToolArgumentOverflowMode = strings.ToLower(strings.TrimSpace(s.settingService.cfg.Gateway.ToolArgumentOverflowMode))
+		opts.MaxToolCalls = s.settingService.cfg.Gateway.MaxToolCalls
+		opts.ToolCallOverflowMode = strings.ToLower(strings.TrimSpace(s.settingService.cfg.Gateway.ToolCallOverflowMode))
+		opts.DuplicateToolIndexMode = strings.ToLower(strings.TrimSpace(s.settingService.cfg.Gateway.DuplicateToolCallIndexMode))
+	}
+	opts.HideThinking = s.hideThinkingFromClient(account)
+	opts.ReasoningOutput = account.GetReasoningOutput()
+	opts.FakeSignatureFormat = account.GetFakeSignatureFormat()
+	opts.FakeSignatureBytes = account.GetFakeSignatureBytes()
+	opts.TextToolProtocol = account.IsTextToolProtocolEnabled()
+	opts.EmptyChoicesMode = account.GetEmptyChoicesMode()
+	opts.EmptyStopMode = account.GetEmptyStopMode()
+	opts.RefusalMode = account.GetRefusalMode()
+	opts.FinishReasonMap = account.GetFinishReasonMap()
+	opts.ResponseModelAliases = account.GetResponseModelAliases()
+	opts.SnapshotDeltas = account.IsStreamDeltaSnapshotEnabled()
+	_, opts.AudioFormat = account.GetAudioOutputConfig()
+	return opts
+}
+
+// openAICompatProtectedResponseHeaders 不允许从上游透传的响应头：
+// hop-by-hop 头，以及由网关根据转换后的响应自行设置的内容 / SSE 头
+var openAICompatProtectedResponseHeaders = map[string]struct{}{
+	"connection":          {},
+	"keep-alive":          {},
+	"proxy-authenticate":  {},
+	"proxy-authorization": {},
+	"proxy-connection":    {},
+	"te":                  {},
+	"trailer":             {},
+	"transfer-encoding":   {},
+	"upgrade":             {},
+	"content-length":      {},
+	"content-type":        {},
+	"content-encoding":    {},
+	"cache-control":       {},
+	"x-accel-buffering":   {},
+}
+
+// forwardResponseHeaders 按 gateway.forward_response_headers 将上游响应头复制到客户端响应，
+// 规则为 "头名" 或 "上游头名:客户端头名"；须在写入响应状态码之前调用
+func (s *OpenAICompatGatewayService) forwardResponseHeaders(c *gin.Context, upstream http.Header) {
+	if s.settingService == nil || s.settingService.cfg == nil {
+		return
+	}
+	copyOpenAICompatResponseHeaders(c.Writer.Header(), upstream, s.settingService.cfg.Gateway.ForwardResponseHeaders)
+}
+
+// copyOpenAICompatResponseHeaders 按规则复制响应头，跳过受保护的头及上游 Connection 头中声明的逐跳头
+func copyOpenAICompatResponseHeaders(dst, src http.Header, rules []string) {
+	if len(rules) == 0 || len(src) == 0 {
+		return
+	}
+	connectionTokens := make(map[string]struct{})
+	for _, value := range src.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			connectionTokens[strings.ToLower(strings.TrimSpace(token))] = struct{}{}
+		}
+	}
+	isProtected := func(name string) bool {
+		lower := strings.ToLower(name)
+		if _, ok := openAICompatProtectedResponseHeaders[lower]; ok {
+			return true
+		}
+		_, ok := connectionTokens[lower]
+		return ok
+	}
+
+	for _, rule := range rules {
+		from, to, _ := strings.Cut(rule, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if to == "" {
+			to = from
+		}
+		if from == "" || isProtected(from) || isProtected(to) {
+			continue
+		}
+		values := src.Values(from)
+		if len(values) == 0 {
+			continue
+		}
+		dst.Del(to)
+		for _, value := range values {
+			dst.Add(to, value)
+		}
+	}
+}
+
+// isOpenAICompatReadTimeout 判断读取响应体的错误是否为超时（context 截止或网络读超时），客户端取消不算
+func isOpenAICompatReadTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// openAICompatGzipMinBytes 请求体超过该大小时才进行 gzip 压缩，小请求压缩收益不明显
+const openAICompatGzipMinBytes = 32 << 10
+
+// sendChatRequest 构建并发送 Chat Completions 请求
+// 账号开启 request_gzip_enabled 且请求体足够大时使用 gzip 压缩并设置 Content-Encoding；
+// 若上游以 415 拒绝压缩请求体，则自动回退为未压缩请求重发一次；clientHeader 为客户端原始请求头（可为 nil），
+// trace 为需注入的 W3C Trace Context（零值时不注入）
+func (s *OpenAICompatGatewayService) sendChatRequest(ctx context.Context, account *Account, upstreamURL, apiKey string, body []byte, clientHeader http.Header, trace openAICompatTraceContext) (*http.Response, error) {
+	proxyURL := openAICompatProxyURL(account)
+
+	useGzip := account.IsRequestGzipEnabled() && len(body) >= openAICompatGzipMinBytes
+	for {
+		reqBody := body
+		if useGzip {
+			compressed, err := gzipRequestBody(body)
+			if err != nil {
+				log.Printf("[OpenAICompat] gzip request body failed, sending uncompressed: %v", err)
+				useGzip = false
+			} else {
+				reqBody = compressed
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstreamURL, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, fmt.Errorf("create upstream request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		setOpenAICompatAuthHeader(req.Header, account, apiKey)
+		setOpenAICompatAnthropicClientHeaders(req.Header, account, clientHeader)
+		trace.apply(req.Header)
+		if useGzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		debugLog := s.debugLogEnabled()
+		if debugLog {
+			s.logDebugRequest(account, req, body)
+		}
+
+		resp, err := s.httpUpstream.Do(req, proxyURL, account.ID, account.Concurrency)
+		if err != nil {
+			return nil, fmt.Errorf("upstream request failed: %w", err)
+		}
+		if useGzip && resp.StatusCode == http.StatusUnsupportedMediaType {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+			log.Printf("[OpenAICompat] account %d upstream rejected gzip request body, retrying uncompressed", account.ID)
+			useGzip = false
+			continue
+		}
+		if debugLog {
+			resp = s.wrapDebugResponse(account, resp)
+		}
+		return resp, nil
+	}
+}
+
+// gzipRequestBody 使用 gzip 压缩请求体
+func gzipRequestBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // openaiCompatStreamResult 流式响应结果
 type openaiCompatStreamResult struct {
 	usage            *ClaudeUsage
 	firstTokenMs     *int
 	clientDisconnect bool
+	outputText       string // RecordOutputText 开启时记录的上游输出文本
 }
 
-// streamResponse 处理流式响应：将 OpenAI SSE 转换为 Claude SSE 后写回客户端
-func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.Response, startTime time.Time, originalModel string) *openaiCompatStreamResult {
-	processor := openaicompat.NewStreamingProcessor(originalModel)
+// estimateOpenAICompatUsage 上游未返回 usage 时按请求消息与输出文本估算 token 用量
+func estimateOpenAICompatUsage(openaiBody []byte, outputText string) *ClaudeUsage {
+	usage := &ClaudeUsage{OutputTokens: estimateTokensForText(outputText)}
+	var req openaicompat.ChatRequest
+	if err := json.Unmarshal(openaiBody, &req); err != nil {
+		return usage
+	}
+	for _, msg := range req.Messages {
+		var text string
+		if err := json.Unmarshal(msg.Content, &text); err == nil {
+			usage.InputTokens += estimateTokensForText(text)
+		} else {
+			var parts []openaicompat.ContentPart
+			if err := json.Unmarshal(msg.Content, &parts); err == nil {
+				for _, part := range parts {
+					usage.InputTokens += estimateTokensForText(part.Text)
+				}
+			}
+		}
+		for _, tc := range msg.ToolCalls {
+			usage.InputTokens += estimateTokensForText(tc.Function.Arguments)
+		}
+	}
+	return usage
+}
+
+// openAICompatSSELineTooLongError 上游单行 SSE 数据超过 gateway.max_line_size
+type openAICompatSSELineTooLongError struct {
+	Limit int
+}
 
-	scanner := bufio.NewScanner(resp.Body)
-	maxLineSize := defaultMaxLineSize
-	if s.settingService.cfg != nil && s.settingService.cfg.Gateway.MaxLineSize > 0 {
-		maxLineSize = s.settingService.cfg.Gateway.MaxLineSize
+func (e *openAICompatSSELineTooLongError) Error() string {
+	return fmt.Sprintf("upstream SSE line exceeds max line size of %d bytes", e.Limit)
+}
+
+// maxSSELineSize 返回上游单行 SSE 数据的最大字节数，未配置时使用 defaultMaxLineSize
+func (s *OpenAICompatGatewayService) maxSSELineSize() int {
+	if s.settingService != nil && s.settingService.cfg != nil && s.settingService.cfg.Gateway.MaxLineSize > 0 {
+		return s.settingService.cfg.Gateway.MaxLineSize
 	}
-	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return defaultMaxLineSize
+}
+
+// readSSELine 读取一行 SSE 数据（去除行尾 \r\n），行长度不受读缓冲区大小限制，
+// 超过 maxLineSize 字节（>0 时）返回 openAICompatSSELineTooLongError
+// 最后一行没有换行符时照常返回，随后的调用返回 io.EOF
+func readSSELine(r *bufio.Reader, maxLineSize int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if maxLineSize > 0 && len(line)+len(chunk) > maxLineSize {
+			return "", &openAICompatSSELineTooLongError{Limit: maxLineSize}
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// streamResponse 处理流式响应：将 OpenAI SSE 转换为 Claude SSE 后写回客户端
+// cancelUpstream 非 nil 时，客户端断开后立即取消上游请求并返回已收集的 usage，而不是继续读取上游直至结束；
+// 上游长时间无输出（如推理模型静默思考）时同样通过请求 context 及时感知断开
+func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.Response, startTime time.Time, accountID int64, billingModel, originalModel string, opts openaicompat.ResponseOptions, cancelUpstream context.CancelFunc) (result *openaiCompatStreamResult) {
+	processor := openaicompat.NewStreamingProcessorWithOptions(originalModel, opts)
+	defer func() {
+		if result != nil {
+			result.outputText = processor.OutputText()
+		}
+	}()
+
+	// 使用 bufio.Reader 逐行读取：单行长度不受缓冲区限制，避免超长 data 行（如上游一次性返回整段内容）
+	// 触发 bufio.Scanner 的 ErrTooLong 导致整个流中断
+	// 单行仍受 gateway.max_line_size 上限约束，防止异常上游耗尽内存
+	reader := bufio.NewReaderSize(resp.Body, 64*1024)
+	maxLineSize := s.maxSSELineSize()
 
 	type scanEvent struct {
 		line string
@@ -231,22 +888,27 @@ func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.R
 	atomic.StoreInt64(&lastReadAt, time.Now().UnixNano())
 	go func() {
 		defer close(events)
-		for scanner.Scan() {
+		for {
+			line, err := readSSELine(reader, maxLineSize)
+			if err != nil {
+				if err != io.EOF {
+					_ = sendEvent(scanEvent{err: err})
+				}
+				return
+			}
 			atomic.StoreInt64(&lastReadAt, time.Now().UnixNano())
-			if !sendEvent(scanEvent{line: scanner.Text()}) {
+			if !sendEvent(scanEvent{line: line}) {
 				return
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			_ = sendEvent(scanEvent{err: err})
-		}
 	}()
 	defer close(done)
 
-	streamInterval := time.Duration(0)
-	if s.settingService.cfg != nil && s.settingService.cfg.Gateway.StreamDataIntervalTimeout > 0 {
-		streamInterval = time.Duration(s.settingService.cfg.Gateway.StreamDataIntervalTimeout) * time.Second
+	configuredInterval := 0
+	if s.settingService.cfg != nil {
+		configuredInterval = s.settingService.cfg.Gateway.StreamDataIntervalTimeout
 	}
+	streamInterval := openAICompatStreamIdleTimeout(c.GetHeader(openAICompatStreamIdleTimeoutHeader), configuredInterval)
 	var intervalTicker *time.Ticker
 	if streamInterval > 0 {
 		intervalTicker = time.NewTicker(streamInterval)
@@ -257,6 +919,14 @@ func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.R
 		intervalCh = intervalTicker.C
 	}
 
+	// 文本增量合并开启时按窗口周期输出暂存文本，避免上游停顿时文本滞留
+	var batchCh <-chan time.Time
+	if opts.TextDeltaBatchWindow > 0 {
+		batchTicker := time.NewTicker(opts.TextDeltaBatchWindow)
+		defer batchTicker.Stop()
+		batchCh = batchTicker.C
+	}
+
 	// 设置 SSE 响应头
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -269,6 +939,11 @@ func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.R
 
 	var firstTokenMs *int
 
+	var clientDone <-chan struct{}
+	if cancelUpstream != nil && c.Request != nil {
+		clientDone = c.Request.Context().Done()
+	}
+
 	for {
 		select {
 		case ev, ok := <-events:
@@ -278,47 +953,63 @@ func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.R
 				if len(finalData) > 0 {
 					cw.Write(finalData)
 				}
-				usage := &ClaudeUsage{
-					InputTokens:          finalUsage.InputTokens,
-					OutputTokens:         finalUsage.OutputTokens,
-					CacheReadInputTokens: finalUsage.CacheReadInputTokens,
-				}
+				usage := openAICompatClaudeUsage(finalUsage)
 				return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs, clientDisconnect: cw.Disconnected()}
 			}
 			if ev.err != nil {
 				if disconnect, handled := handleStreamReadError(ev.err, cw.Disconnected(), "openaicompat"); handled {
 					_, finalUsage := processor.Finish()
-					usage := &ClaudeUsage{
-						InputTokens:          finalUsage.InputTokens,
-						OutputTokens:         finalUsage.OutputTokens,
-						CacheReadInputTokens: finalUsage.CacheReadInputTokens,
-					}
+					usage := openAICompatClaudeUsage(finalUsage)
 					return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs, clientDisconnect: disconnect}
 				}
 				log.Printf("[OpenAICompat] Stream read error: %v", ev.err)
-				_, finalUsage := processor.Finish()
-				usage := &ClaudeUsage{
-					InputTokens:          finalUsage.InputTokens,
-					OutputTokens:         finalUsage.OutputTokens,
-					CacheReadInputTokens: finalUsage.CacheReadInputTokens,
+				var lineTooLongErr *openAICompatSSELineTooLongError
+				if errors.As(ev.err, &lineTooLongErr) {
+					// 超长行无法转换，以 error 事件明确结束流，而不是静默截断
+					if errData := processor.Abort(lineTooLongErr.Error()); len(errData) > 0 {
+						cw.Write(errData)
+					}
 				}
+				_, finalUsage := processor.Finish()
+				usage := openAICompatClaudeUsage(finalUsage)
 				return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs}
 			}
 
 			line := ev.line
 
-			// 记录首 token 时间
-			if firstTokenMs == nil && len(line) > 0 {
-				ms := int(time.Since(startTime).Milliseconds())
-				firstTokenMs = &ms
-			}
-
 			// 转换 OpenAI SSE → Claude SSE
+			elapsedMs := int(time.Since(startTime).Milliseconds())
 			claudeEvents := processor.ProcessLine(line)
+
+			// 记录首 token 时间：仅在首个文本 / thinking / tool call 增量到达时记录，仅含 role 的首个 chunk 不计
+			if firstTokenMs == nil && processor.ContentSeen() {
+				firstTokenMs = &elapsedMs
+				s.metrics().ObserveFirstToken(accountID, billingModel, elapsedMs)
+			}
 			if len(claudeEvents) > 0 {
 				cw.Write(claudeEvents)
 			}
 
+			if cancelUpstream != nil && cw.Disconnected() {
+				log.Printf("[OpenAICompat] Client disconnected, cancelling upstream request")
+				cancelUpstream()
+				_, finalUsage := processor.Finish()
+				usage := openAICompatClaudeUsage(finalUsage)
+				return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs, clientDisconnect: true}
+			}
+
+		case <-clientDone:
+			log.Printf("[OpenAICompat] Client disconnected while waiting for upstream, cancelling upstream request")
+			cancelUpstream()
+			_, finalUsage := processor.Finish()
+			usage := openAICompatClaudeUsage(finalUsage)
+			return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs, clientDisconnect: true}
+
+		case <-batchCh:
+			if pending := processor.FlushTextBatch(); len(pending) > 0 {
+				cw.Write(pending)
+			}
+
 		case <-intervalCh:
 			lastRead := time.Unix(0, atomic.LoadInt64(&lastReadAt))
 			if time.Since(lastRead) < streamInterval {
@@ -327,35 +1018,170 @@ func (s *OpenAICompatGatewayService) streamResponse(c *gin.Context, resp *http.R
 			if cw.Disconnected() {
 				log.Printf("[OpenAICompat] Upstream timeout after client disconnect, returning collected usage")
 				_, finalUsage := processor.Finish()
-				usage := &ClaudeUsage{
-					InputTokens:          finalUsage.InputTokens,
-					OutputTokens:         finalUsage.OutputTokens,
-					CacheReadInputTokens: finalUsage.CacheReadInputTokens,
-				}
+				usage := openAICompatClaudeUsage(finalUsage)
 				return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs, clientDisconnect: true}
 			}
 			log.Printf("[OpenAICompat] Stream data interval timeout")
 			_, finalUsage := processor.Finish()
-			usage := &ClaudeUsage{
-				InputTokens:          finalUsage.InputTokens,
-				OutputTokens:         finalUsage.OutputTokens,
-				CacheReadInputTokens: finalUsage.CacheReadInputTokens,
-			}
+			usage := openAICompatClaudeUsage(finalUsage)
 			return &openaiCompatStreamResult{usage: usage, firstTokenMs: firstTokenMs}
 		}
 	}
 }
 
+// openAICompatStreamIdleTimeoutHeader 客户端按请求覆盖流数据间隔超时（秒）的请求头
+const openAICompatStreamIdleTimeoutHeader = "X-Stream-Idle-Timeout"
+
+// openAICompatMaxStreamIdleTimeout 全局未启用流数据间隔超时时，请求头可设置的最大值（秒）
+const openAICompatMaxStreamIdleTimeout = 300
+
+// openAICompatStreamIdleTimeout 计算本次请求的流数据间隔超时：
+// 请求头只能收紧超时，取值不超过全局配置（全局禁用时不超过 openAICompatMaxStreamIdleTimeout）；
+// 请求头缺失或非法时使用全局配置，返回 0 表示不启用
+func openAICompatStreamIdleTimeout(header string, configuredSeconds int) time.Duration {
+	seconds := configuredSeconds
+	if requested, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && requested > 0 {
+		limit := configuredSeconds
+		if limit <= 0 {
+			limit = openAICompatMaxStreamIdleTimeout
+		}
+		seconds = min(requested, limit)
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// isOpenAICompatOverloadStatus 判断上游状态码是否表示过载（Anthropic 风格 529 或 503）
+func isOpenAICompatOverloadStatus(statusCode int) bool {
+	return statusCode == 529 || statusCode == http.StatusServiceUnavailable
+}
+
+// openAICompatUnauthorizedError 账号开启 unauthorized_failover 时将上游 401 转为携带分类（密钥无效 / 令牌过期）的
+// UpstreamFailoverError，由 handler 层据此停用账号或临时停止调度；未开启或非 401 时返回 nil（错误原样返回客户端）
+func openAICompatUnauthorizedError(account *Account, statusCode int, respBody []byte) *UpstreamFailoverError {
+	if statusCode != http.StatusUnauthorized || !account.IsUnauthorizedFailoverEnabled() {
+		return nil
+	}
+	return &UpstreamFailoverError{
+		StatusCode:   statusCode,
+		ResponseBody: respBody,
+		AuthError:    openaicompat.ClassifyUnauthorized(respBody),
+	}
+}
+
+// maxRateLimitCooldown 上游 429 按响应头限流账号的最长时间，0 表示不限制
+func (s *OpenAICompatGatewayService) maxRateLimitCooldown() time.Duration {
+	if s.settingService == nil || s.settingService.cfg == nil {
+		return 0
+	}
+	return time.Duration(s.settingService.cfg.Gateway.MaxRateLimitCooldown) * time.Second
+}
+
+// parseOpenAICompatRateLimitResetAt 从上游 429 响应头解析限流重置时间
+// 优先使用 Retry-After（秒数或 HTTP 日期）/ retry-after-ms，其次取已耗尽配额（对应 x-ratelimit-remaining-* 为 0）的
+// x-ratelimit-reset-* 中最晚的时间，未耗尽的配额不会导致限流。
+// x-ratelimit-reset-* 的取值可能是 Go duration（OpenAI: "6m0s"）、秒数、Unix 秒或毫秒时间戳（OpenRouter）。
+// maxCooldown > 0 时结果不超过 now+maxCooldown，避免异常的响应头长时间停用账号。
+// 返回 nil 表示响应头中没有可用的重置信息
+func parseOpenAICompatRateLimitResetAt(headers http.Header, now time.Time, maxCooldown time.Duration) *time.Time {
+	resetAt := parseOpenAICompatRateLimitHeaders(headers, now)
+	if resetAt != nil && maxCooldown > 0 {
+		if limit := now.Add(maxCooldown); resetAt.After(limit) {
+			resetAt = &limit
+		}
+	}
+	return resetAt
+}
+
+// parseOpenAICompatRateLimitHeaders 解析未经上限约束的限流重置时间（规则见 parseOpenAICompatRateLimitResetAt）
+func parseOpenAICompatRateLimitHeaders(headers http.Header, now time.Time) *time.Time {
+	if headers == nil {
+		return nil
+	}
+
+	if v := strings.TrimSpace(headers.Get("retry-after-ms")); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			resetAt := now.Add(time.Duration(ms * float64(time.Millisecond)))
+			return &resetAt
+		}
+	}
+	if v := strings.TrimSpace(headers.Get("Retry-After")); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			resetAt := now.Add(time.Duration(secs * float64(time.Second)))
+			return &resetAt
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return &t
+		}
+	}
+
+	var latest *time.Time
+	for key, values := range headers {
+		lower := strings.ToLower(key)
+		if !strings.HasPrefix(lower, "x-ratelimit-reset") || len(values) == 0 {
+			continue
+		}
+		// x-ratelimit-reset[-requests|-tokens] 对应 x-ratelimit-remaining[-requests|-tokens]
+		remaining := strings.TrimSpace(headers.Get("x-ratelimit-remaining" + strings.TrimPrefix(lower, "x-ratelimit-reset")))
+		if n, err := strconv.ParseFloat(remaining, 64); err != nil || n > 0 {
+			continue
+		}
+		resetAt := parseRateLimitResetValue(values[0], now)
+		if resetAt == nil {
+			continue
+		}
+		if latest == nil || resetAt.After(*latest) {
+			latest = resetAt
+		}
+	}
+	return latest
+}
+
+// parseRateLimitResetValue 解析单个 x-ratelimit-reset-* 头的值
+func parseRateLimitResetValue(value string, now time.Time) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return nil
+		}
+		resetAt := now.Add(d)
+		return &resetAt
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	var resetAt time.Time
+	switch {
+	case n >= 1e12:
+		// Unix 毫秒时间戳
+		resetAt = time.UnixMilli(int64(n))
+	case n >= 1e9:
+		// Unix 秒时间戳
+		resetAt = time.Unix(int64(n), 0)
+	default:
+		// 相对秒数
+		resetAt = now.Add(time.Duration(n * float64(time.Second)))
+	}
+	if !resetAt.After(now) {
+		return nil
+	}
+	return &resetAt
+}
+
 // TestConnection 测试 OpenAI 兼容账号连接（非流式）
 func (s *OpenAICompatGatewayService) TestConnection(ctx context.Context, account *Account, modelID string) (*TestConnectionResult, error) {
 	// 获取凭据
-	baseURL := strings.TrimSpace(account.GetCredential("base_url"))
-	apiKey := strings.TrimSpace(account.GetCredential("api_key"))
-	if baseURL == "" || apiKey == "" {
-		return nil, fmt.Errorf("openai-compat account missing base_url or api_key")
+	baseURL, apiKey, err := openAICompatUpstreamCredentials(account)
+	if err != nil {
+		return nil, err
 	}
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	upstreamURL := baseURL + "/chat/completions"
+	upstreamURL := openAICompatUpstreamEndpoint(account, baseURL)
 
 	// 模型映射
 	mappedModel := modelID
@@ -363,18 +1189,25 @@ func (s *OpenAICompatGatewayService) TestConnection(ctx context.Context, account
 		mappedModel = m
 	}
 
-	// 构建 OpenAI Chat Completions 请求
-	chatReq := openaicompat.ChatRequest{
-		Model: mappedModel,
-		Messages: []openaicompat.ChatMessage{
-			{Role: "user", Content: json.RawMessage(`"hi"`)},
-		},
+	// 构建测试请求：与 forward 一致，按账号的上游接口类型（extra.api）选择请求格式，响应统一改写为 Chat Completions 结构
+	claudeReq := antigravity.ClaudeRequest{
+		Model:     mappedModel,
+		Messages:  []antigravity.ClaudeMessage{{Role: "user", Content: json.RawMessage(`"hi"`)}},
 		MaxTokens: 16,
-		Stream:    false,
 	}
-	reqBody, err := json.Marshal(chatReq)
+	transformRequest := openaicompat.TransformClaudeToOpenAIWithOptions
+	var toChatResponse func([]byte) ([]byte, error)
+	switch account.GetUpstreamAPI() {
+	case openaicompat.UpstreamAPIResponses:
+		transformRequest = openaicompat.TransformClaudeToResponsesWithOptions
+		toChatResponse = openaicompat.ResponsesToChatResponse
+	case openaicompat.UpstreamAPICompletions:
+		transformRequest = openaicompat.TransformClaudeToCompletionsWithOptions
+		toChatResponse = openaicompat.CompletionsToChatResponse
+	}
+	reqBody, _, err := transformRequest(&claudeReq, s.requestOptions(account))
 	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
+		return nil, fmt.Errorf("transform request: %w", err)
 	}
 
 	// 创建 HTTP 请求
@@ -383,7 +1216,7 @@ func (s *OpenAICompatGatewayService) TestConnection(ctx context.Context, account
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	setOpenAICompatAuthHeader(req.Header, account, apiKey)
 
 	// 代理 URL
 	proxyURL := ""
@@ -409,6 +1242,12 @@ func (s *OpenAICompatGatewayService) TestConnection(ctx context.Context, account
 
 	log.Printf("[OpenAICompat] TestConnection raw response: %s", string(respBody))
 
+	if toChatResponse != nil {
+		if converted, err := toChatResponse(respBody); err == nil {
+			respBody = converted
+		}
+	}
+
 	// 某些上游可能用 HTTP 200 包装错误（错误码在 JSON body 内部）
 	var errResp openaicompat.ErrorResponse
 	if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != nil {
@@ -0,0 +1,1387 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wei-Shaw/sub2api/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// openAICompatPassthroughUpstream 直接通过 http.Client 发送请求，用于对接 httptest 上游
+type openAICompatPassthroughUpstream struct{}
+
+func (openAICompatPassthroughUpstream) Do(req *http.Request, _ string, _ int64, _ int) (*http.Response, error) {
+	return http.DefaultClient.Do(req)
+}
+
+func (openAICompatPassthroughUpstream) DoWithTLS(req *http.Request, _ string, _ int64, _ int, _ bool) (*http.Response, error) {
+	return http.DefaultClient.Do(req)
+}
+
+// newOpenAICompatTestAccount 创建指向测试上游的 openai_compat 账号
+func newOpenAICompatTestAccount(baseURL string, extra map[string]any) *Account {
+	return &Account{
+		ID:          1,
+		Platform:    PlatformOpenAICompat,
+		Type:        AccountTypeAPIKey,
+		Concurrency: 1,
+		Credentials: map[string]any{"base_url": baseURL, "api_key": "sk-test"},
+		Extra:       extra,
+	}
+}
+
+// forwardOpenAICompat 使用测试上游执行一次 Forward，返回客户端收到的响应
+func forwardOpenAICompat(t *testing.T, account *Account, body []byte) (*httptest.ResponseRecorder, *ForwardResult, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+	result, err := svc.Forward(context.Background(), c, account, body)
+	return rec, result, err
+}
+
+const openAICompatTestChatResponse = `{"id":"chatcmpl-1","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`
+
+func TestOpenAICompatForward_GzipRequestBody(t *testing.T) {
+	longText := strings.Repeat("hello world ", 8<<10)
+
+	var gotEncoding string
+	var gotPrompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		var reader io.Reader = r.Body
+		if gotEncoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			reader = zr
+		}
+		raw, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		require.NoError(t, json.Unmarshal(raw, &req))
+		require.Len(t, req.Messages, 1)
+		gotPrompt = req.Messages[0].Content
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	body, err := json.Marshal(map[string]any{
+		"model":      "m",
+		"max_tokens": 16,
+		"messages":   []map[string]any{{"role": "user", "content": longText}},
+	})
+	require.NoError(t, err)
+
+	account := newOpenAICompatTestAccount(server.URL, map[string]any{"request_gzip_enabled": true})
+	rec, result, err := forwardOpenAICompat(t, account, body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "gzip", gotEncoding)
+	require.Equal(t, longText, gotPrompt)
+	require.Equal(t, 2, result.Usage.OutputTokens)
+
+	// 未开启时不压缩
+	account = newOpenAICompatTestAccount(server.URL, nil)
+	_, _, err = forwardOpenAICompat(t, account, body)
+	require.NoError(t, err)
+	require.Empty(t, gotEncoding)
+	require.Equal(t, longText, gotPrompt)
+}
+
+func TestOpenAICompatForward_GzipRejectedFallsBack(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	body, err := json.Marshal(map[string]any{
+		"model":      "m",
+		"max_tokens": 16,
+		"messages":   []map[string]any{{"role": "user", "content": strings.Repeat("x", 64<<10)}},
+	})
+	require.NoError(t, err)
+
+	account := newOpenAICompatTestAccount(server.URL, map[string]any{"request_gzip_enabled": true})
+	rec, _, err := forwardOpenAICompat(t, account, body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []string{"gzip", ""}, encodings)
+}
+
+func TestParseOpenAICompatRateLimitResetAt(t *testing.T) {
+	now := time.Unix(1770000000, 0)
+
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    *time.Time
+	}{
+		{
+			name:    "no headers",
+			headers: http.Header{},
+			want:    nil,
+		},
+		{
+			name:    "retry-after seconds",
+			headers: http.Header{"Retry-After": []string{"30"}},
+			want:    ptrTime(now.Add(30 * time.Second)),
+		},
+		{
+			name:    "retry-after http date",
+			headers: http.Header{"Retry-After": []string{now.Add(2 * time.Minute).UTC().Format(http.TimeFormat)}},
+			want:    ptrTime(now.Add(2 * time.Minute)),
+		},
+		{
+			name:    "retry-after-ms takes precedence",
+			headers: http.Header{"Retry-After-Ms": []string{"1500"}, "Retry-After": []string{"30"}},
+			want:    ptrTime(now.Add(1500 * time.Millisecond)),
+		},
+		{
+			name: "openai duration headers pick latest exhausted",
+			headers: http.Header{
+				"X-Ratelimit-Remaining-Requests": []string{"0"},
+				"X-Ratelimit-Reset-Requests":     []string{"1s"},
+				"X-Ratelimit-Remaining-Tokens":   []string{"0"},
+				"X-Ratelimit-Reset-Tokens":       []string{"6m0s"},
+			},
+			want: ptrTime(now.Add(6 * time.Minute)),
+		},
+		{
+			name: "reset of remaining quota is ignored",
+			headers: http.Header{
+				"X-Ratelimit-Remaining-Requests": []string{"0"},
+				"X-Ratelimit-Reset-Requests":     []string{"1s"},
+				"X-Ratelimit-Remaining-Tokens":   []string{"5000"},
+				"X-Ratelimit-Reset-Tokens":       []string{"6m0s"},
+			},
+			want: ptrTime(now.Add(time.Second)),
+		},
+		{
+			name:    "reset without remaining header is ignored",
+			headers: http.Header{"X-Ratelimit-Reset-Tokens": []string{"6m0s"}},
+			want:    nil,
+		},
+		{
+			name:    "openrouter millisecond timestamp",
+			headers: http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"1770000060000"}},
+			want:    ptrTime(now.Add(time.Minute)),
+		},
+		{
+			name:    "unix seconds timestamp",
+			headers: http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"1770000120"}},
+			want:    ptrTime(now.Add(2 * time.Minute)),
+		},
+		{
+			name:    "reset in the past is ignored",
+			headers: http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"1769999000"}},
+			want:    nil,
+		},
+		{
+			name:    "unparseable values are ignored",
+			headers: http.Header{"Retry-After": []string{"soon"}, "X-Ratelimit-Remaining-Tokens": []string{"0"}, "X-Ratelimit-Reset-Tokens": []string{"n/a"}},
+			want:    nil,
+		},
+		{
+			name:    "retry-after clamped to max cooldown",
+			headers: http.Header{"Retry-After": []string{"864000"}},
+			want:    ptrTime(now.Add(time.Hour)),
+		},
+		{
+			name:    "far-future timestamp clamped to max cooldown",
+			headers: http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"1780000000"}},
+			want:    ptrTime(now.Add(time.Hour)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseOpenAICompatRateLimitResetAt(tt.headers, now, time.Hour)
+			if tt.want == nil {
+				require.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			require.True(t, tt.want.Equal(*got), "want %v, got %v", *tt.want, *got)
+		})
+	}
+}
+
+func TestParseOpenAICompatRateLimitResetAt_NoMaxCooldown(t *testing.T) {
+	now := time.Unix(1770000000, 0)
+	got := parseOpenAICompatRateLimitResetAt(http.Header{"Retry-After": []string{"864000"}}, now, 0)
+	require.NotNil(t, got)
+	require.True(t, now.Add(240*time.Hour).Equal(*got))
+}
+
+func ptrTime(t time.Time) *time.Time {
+	return &t
+}
+
+func TestOpenAICompatForward_OverloadTriggersFailover(t *testing.T) {
+	for _, status := range []int{529, http.StatusServiceUnavailable} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error":{"message":"overloaded","type":"overloaded_error"}}`))
+		}))
+
+		body := []byte(`{"model":"m","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`)
+		rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+		server.Close()
+
+		var failoverErr *UpstreamFailoverError
+		require.ErrorAs(t, err, &failoverErr, "status %d", status)
+		require.Equal(t, status, failoverErr.StatusCode)
+		require.True(t, failoverErr.Overloaded)
+		require.Zero(t, rec.Body.Len(), "overload should not be written to client before failover")
+	}
+}
+
+func TestOpenAICompatForward_StreamHandlesVeryLongLine(t *testing.T) {
+	longText := strings.Repeat("a", 2<<20)
+	content, err := json.Marshal(longText)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":` + string(content) + `}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+	// 单行 2MB 远超 64KB 读缓冲区，未超过 max_line_size 时仍应完整处理
+	cfg := &config.Config{Gateway: config.GatewayConfig{MaxLineSize: 4 << 20}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Equal(t, 5, result.Usage.OutputTokens)
+
+	out := rec.Body.String()
+	require.Contains(t, out, longText)
+	require.Contains(t, out, "message_stop")
+}
+
+func TestOpenAICompatForward_StreamLineExceedsMaxLineSize(t *testing.T) {
+	longText := strings.Repeat("a", 2<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"` + longText + `"}}]}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+	// 超过 max_line_size 的行以 error 事件结束流，而不是截断或丢弃
+	cfg := &config.Config{Gateway: config.GatewayConfig{MaxLineSize: 1 << 20}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+
+	out := rec.Body.String()
+	require.Contains(t, out, "event: error")
+	require.Contains(t, out, "upstream SSE line exceeds max line size of 1048576 bytes")
+	require.NotContains(t, out, longText)
+	require.NotContains(t, out, "message_stop")
+}
+
+func TestOpenAICompatForward_EmptyChoicesErrorMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"m","choices":[],"usage":{"prompt_tokens":30,"completion_tokens":0,"total_tokens":30}}`))
+	}))
+	defer server.Close()
+
+	account := newOpenAICompatTestAccount(server.URL, map[string]any{"empty_choices_mode": "error"})
+	rec, result, err := forwardOpenAICompat(t, account, []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "upstream returned no choices")
+	require.Equal(t, 30, result.Usage.InputTokens)
+}
+
+func TestOpenAICompatForward_EchoedRequestErrorBody(t *testing.T) {
+	// 异常代理出错时回显请求体（含账号注入的 system_prefix）
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echoed, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(echoed)
+	}))
+	defer server.Close()
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"what does this error message mean?"}]}`)
+
+	rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"system_prefix": "secret house rules"}), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.NotContains(t, rec.Body.String(), "secret house rules")
+	var claudeErr map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &claudeErr))
+	errObj, _ := claudeErr["error"].(map[string]any)
+	require.Equal(t, "invalid_request_error", errObj["type"])
+	require.Equal(t, "Bad Request", errObj["message"])
+
+	// error_body_mode=raw 时按原样（截断）返回
+	rec, _, err = forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"system_prefix": "secret house rules", "error_body_mode": "raw"}), body)
+	require.NoError(t, err)
+	require.Contains(t, rec.Body.String(), "secret house rules")
+}
+
+func TestOpenAICompatForward_EmptyStopMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}],"usage":{"prompt_tokens":30,"completion_tokens":0,"total_tokens":30}}`))
+	}))
+	defer server.Close()
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+
+	rec, result, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"empty_stop_mode": "error"}), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "upstream returned an empty completion")
+	require.Equal(t, 30, result.Usage.InputTokens)
+
+	// retry 模式：返回可在同一账号重试的 failover 错误，不向客户端写入响应
+	rec, _, err = forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"empty_stop_mode": "retry"}), body)
+	var failoverErr *UpstreamFailoverError
+	require.ErrorAs(t, err, &failoverErr)
+	require.Equal(t, http.StatusBadGateway, failoverErr.StatusCode)
+	require.True(t, failoverErr.RetryableOnSameAccount)
+	require.Empty(t, rec.Body.String())
+}
+
+func TestOpenAICompatForward_RefusalErrorMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"refusal":"Not allowed."},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":3,"total_tokens":11}}`))
+	}))
+	defer server.Close()
+
+	account := newOpenAICompatTestAccount(server.URL, map[string]any{"refusal_mode": "error"})
+	rec, result, err := forwardOpenAICompat(t, account, []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "invalid_request_error")
+	require.Contains(t, rec.Body.String(), "Not allowed.")
+	require.Equal(t, 3, result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_ToolArgumentsTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"write","arguments":"{\"data\":\"0123456789abcdef\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":8,"completion_tokens":12,"total_tokens":20}}`))
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{MaxToolArgumentBytes: 16, ToolArgumentOverflowMode: "error"}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "arguments too large")
+	require.NotContains(t, rec.Body.String(), "0123456789abcdef")
+	require.Equal(t, 12, result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_SupportsTopK(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		wantTopK bool
+	}{
+		{name: "unset", value: nil, wantTopK: false},
+		{name: "bool true", value: true, wantTopK: true},
+		{name: "string true", value: "true", wantTopK: true},
+		{name: "false", value: false, wantTopK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamReq map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&upstreamReq))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(openAICompatTestChatResponse))
+			}))
+			defer server.Close()
+
+			account := newOpenAICompatTestAccount(server.URL, nil)
+			if tt.value != nil {
+				account.Credentials["supports_top_k"] = tt.value
+			}
+			rec, _, err := forwardOpenAICompat(t, account, []byte(`{"model":"m","top_k":40,"messages":[{"role":"user","content":"hi"}]}`))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			if tt.wantTopK {
+				require.EqualValues(t, 40, upstreamReq["top_k"])
+			} else {
+				require.NotContains(t, upstreamReq, "top_k")
+			}
+		})
+	}
+}
+
+func TestOpenAICompatForward_StreamUsageDisabled(t *testing.T) {
+	newServer := func(withUsage bool, gotStreamOptions *bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			_, *gotStreamOptions = req["stream_options"]
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello there, how can I help?"}}]}` + "\n\n"))
+			_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n"))
+			if withUsage {
+				_, _ = w.Write([]byte(`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}` + "\n\n"))
+			}
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+		}))
+	}
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"Please say hello to me in a friendly way."}]}`)
+
+	// 默认请求 include_usage，使用上游报告的 usage
+	var gotStreamOptions bool
+	server := newServer(true, &gotStreamOptions)
+	_, result, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+	server.Close()
+	require.NoError(t, err)
+	require.True(t, gotStreamOptions)
+	require.Equal(t, 10, result.Usage.InputTokens)
+	require.Equal(t, 5, result.Usage.OutputTokens)
+
+	// 关闭后不发送 stream_options，按请求与输出文本估算 usage
+	server = newServer(false, &gotStreamOptions)
+	rec, result, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"stream_usage_disabled": true}), body)
+	server.Close()
+	require.NoError(t, err)
+	require.False(t, gotStreamOptions)
+	require.Contains(t, rec.Body.String(), "Hello there")
+	require.Equal(t, estimateTokensForText("Please say hello to me in a friendly way."), result.Usage.InputTokens)
+	require.Equal(t, estimateTokensForText("Hello there, how can I help?"), result.Usage.OutputTokens)
+	require.Positive(t, result.Usage.OutputTokens)
+
+	// 关闭后上游仍返回 usage 时以上游为准
+	server = newServer(true, &gotStreamOptions)
+	_, result, err = forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"stream_usage_disabled": true}), body)
+	server.Close()
+	require.NoError(t, err)
+	require.Equal(t, 5, result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_CancelUpstreamOnDisconnect(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":10,"completion_tokens":1,"total_tokens":11}}` + "\n\n"))
+		flusher.Flush()
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"content":" world"}}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}` + "\n\n"))
+		flusher.Flush()
+		// 模拟长时间推理：直到请求被取消才结束
+		select {
+		case <-r.Context().Done():
+			close(upstreamCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Writer = &failingGinWriter{ResponseWriter: c.Writer, failAfter: 1}
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{CancelUpstreamOnDisconnect: true}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+	start := time.Now()
+	result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 3*time.Second)
+	require.True(t, result.ClientDisconnect)
+	require.Equal(t, 10, result.Usage.InputTokens)
+	require.Equal(t, 2, result.Usage.OutputTokens)
+
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestOpenAICompatForward_CancelUpstreamOnDisconnectWhileStalled(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":10,"completion_tokens":1,"total_tokens":11}}` + "\n\n"))
+		flusher.Flush()
+		// 模拟推理模型静默思考：不再输出任何数据，直到请求被取消
+		select {
+		case <-r.Context().Done():
+			close(upstreamCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	clientCtx, disconnect := context.WithCancel(context.Background())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil).WithContext(clientCtx)
+	time.AfterFunc(100*time.Millisecond, disconnect)
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{CancelUpstreamOnDisconnect: true}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+	start := time.Now()
+	result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 3*time.Second)
+	require.True(t, result.ClientDisconnect)
+	require.Equal(t, 10, result.Usage.InputTokens)
+	require.Equal(t, 1, result.Usage.OutputTokens)
+
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestCopyOpenAICompatResponseHeaders(t *testing.T) {
+	src := http.Header{}
+	src.Set("X-Request-Id", "req_123")
+	src.Set("Openai-Processing-Ms", "42")
+	src.Set("Content-Type", "application/json")
+	src.Set("Transfer-Encoding", "chunked")
+	src.Set("Connection", "X-Internal")
+	src.Set("X-Internal", "secret")
+	src.Set("X-Other", "ignored")
+
+	dst := http.Header{}
+	dst.Set("Content-Type", "text/event-stream")
+	copyOpenAICompatResponseHeaders(dst, src, []string{
+		"x-request-id:request-id",
+		"openai-processing-ms",
+		"content-type",
+		"transfer-encoding",
+		"x-internal",
+		"x-missing:x-renamed",
+		"x-other:content-type",
+	})
+
+	require.Equal(t, "req_123", dst.Get("Request-Id"))
+	require.Empty(t, dst.Get("X-Request-Id"))
+	require.Equal(t, "42", dst.Get("Openai-Processing-Ms"))
+	require.Equal(t, "text/event-stream", dst.Get("Content-Type"))
+	require.Empty(t, dst.Get("Transfer-Encoding"))
+	require.Empty(t, dst.Get("X-Internal"))
+	require.Empty(t, dst.Get("X-Renamed"))
+}
+
+func TestOpenAICompatForward_ForwardsResponseHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Request-Id", "req_abc")
+		w.Header().Set("X-Ratelimit-Remaining-Requests", "99")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{ForwardResponseHeaders: []string{
+		"x-request-id:request-id",
+		"x-ratelimit-remaining-requests:anthropic-ratelimit-requests-remaining",
+	}}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	_, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+
+	require.Equal(t, "req_abc", rec.Header().Get("Request-Id"))
+	require.Equal(t, "99", rec.Header().Get("Anthropic-Ratelimit-Requests-Remaining"))
+	require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	require.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+}
+
+func TestOpenAICompatForward_ServerToolUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"c1","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12,"server_tool_use":{"web_search_requests":3}}}`))
+	}))
+	defer server.Close()
+
+	_, result, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, 10, result.Usage.InputTokens)
+	require.Equal(t, 3, result.Usage.WebSearchRequests)
+}
+
+func TestOpenAICompatStreamIdleTimeout(t *testing.T) {
+	tests := []struct {
+		header     string
+		configured int
+		want       time.Duration
+	}{
+		{"", 180, 180 * time.Second},
+		{"30", 180, 30 * time.Second},
+		{"600", 180, 180 * time.Second}, // 不超过全局配置
+		{"abc", 180, 180 * time.Second},
+		{"-5", 180, 180 * time.Second},
+		{"", 0, 0},
+		{"60", 0, 60 * time.Second},
+		{"900", 0, openAICompatMaxStreamIdleTimeout * time.Second},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, openAICompatStreamIdleTimeout(tt.header, tt.configured), "header=%q configured=%d", tt.header, tt.configured)
+	}
+}
+
+func TestOpenAICompatForward_StreamIdleTimeoutHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		// 模拟上游卡住
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Request.Header.Set("X-Stream-Idle-Timeout", "1")
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{StreamDataIntervalTimeout: 180}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	body := []byte(`{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+	start := time.Now()
+	_, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 4*time.Second)
+	require.Contains(t, rec.Body.String(), "Hello")
+}
+
+func TestOpenAICompatForward_RecordsServiceTier(t *testing.T) {
+	var gotTier string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		gotTier, _ = req["service_tier"].(string)
+		if stream, _ := req["stream"].(bool); stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"id":"c1","service_tier":"flex","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}` + "\n\n"))
+			_, _ = w.Write([]byte(`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}` + "\n\n"))
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"c1","object":"chat.completion","model":"m","service_tier":"flex","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`))
+	}))
+	defer server.Close()
+
+	for _, stream := range []bool{false, true} {
+		body := []byte(`{"model":"m","stream":` + strconv.FormatBool(stream) + `,"service_tier":"flex","messages":[{"role":"user","content":"hi"}]}`)
+		rec, result, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+		require.NoError(t, err)
+		require.Equal(t, "flex", gotTier)
+		require.Equal(t, "flex", result.Usage.ServiceTier, "stream=%v", stream)
+		require.Equal(t, 10, result.Usage.InputTokens)
+		if !stream {
+			require.Contains(t, rec.Body.String(), `"service_tier":"flex"`)
+		}
+	}
+}
+
+func TestOpenAICompatForward_MissingChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"c1","object":"chat.completion","model":"m","usage":{"prompt_tokens":10,"completion_tokens":0,"total_tokens":10}}`))
+	}))
+	defer server.Close()
+
+	rec, result, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "upstream response missing choices")
+	require.Equal(t, 10, result.Usage.InputTokens)
+}
+
+func TestOpenAICompatForward_MaxResponseBytes(t *testing.T) {
+	payload := `{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"` + strings.Repeat("x", 256) + `"},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":12,"total_tokens":20}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	forward := func(limit int) (*httptest.ResponseRecorder, *ForwardResult) {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+		cfg := &config.Config{Gateway: config.GatewayConfig{MaxResponseBytes: limit}}
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+		result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+		require.NoError(t, err)
+		return rec, result
+	}
+
+	rec, _ := forward(128)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "exceeds max response size of 128 bytes")
+	require.NotContains(t, rec.Body.String(), "xxxx")
+
+	// 恰好等于上限时正常返回
+	rec, result := forward(len(payload))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 12, result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_EstimateMissingUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"The capital of France is Paris."},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	forward := func(estimate bool) *ForwardResult {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		body := []byte(`{"model":"m","messages":[{"role":"user","content":"What is the capital of France?"}]}`)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+		cfg := &config.Config{Gateway: config.GatewayConfig{EstimateMissingUsage: estimate}}
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+		result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return result
+	}
+
+	// 默认不估算，保持上游返回的 0
+	result := forward(false)
+	require.False(t, result.UsageEstimated)
+	require.Zero(t, result.Usage.InputTokens)
+	require.Zero(t, result.Usage.OutputTokens)
+
+	result = forward(true)
+	require.True(t, result.UsageEstimated)
+	require.Positive(t, result.Usage.InputTokens)
+	require.Equal(t, estimateTokensForText("The capital of France is Paris."), result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_ToolLimitRejected(t *testing.T) {
+	var upstreamCalled bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalled = true
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"tools":[{"name":"a"},{"name":"b"},{"name":"c"}]}`)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+	cfg := &config.Config{Gateway: config.GatewayConfig{MaxTools: 2, ToolOverflowMode: "error"}}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+	_, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.False(t, upstreamCalled)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "invalid_request_error")
+	require.Contains(t, rec.Body.String(), "request has 3 tools, exceeds limit of 2")
+}
+
+func TestOpenAICompatForward_SchemaValidation(t *testing.T) {
+	upstreamBody := `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"type":"function","function":{"name":"read","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(upstreamBody))
+	}))
+	defer server.Close()
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+
+	// 默认不校验：缺少 id 的 tool call 照常转换
+	rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec, _, err = forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"debug_schema_validation": true}), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "openai response schema validation failed")
+	require.Contains(t, rec.Body.String(), "choices[0].message.tool_calls[0].id: required")
+}
+
+func TestOpenAICompatForward_NormalizesBaseURL(t *testing.T) {
+	var upstreamPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+
+	for _, baseURL := range []string{server.URL, server.URL + "/v1/", server.URL + "/v1/v1", server.URL + "/v1/chat/completions"} {
+		upstreamPath = ""
+		rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(baseURL, nil), body)
+		require.NoError(t, err, "base_url=%s", baseURL)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "/v1/chat/completions", upstreamPath, "base_url=%s", baseURL)
+	}
+
+	_, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount("api.example.com/v1", nil), body)
+	require.ErrorContains(t, err, "invalid base_url")
+}
+
+func TestOpenAICompatForward_EffectiveParams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if stream, _ := req["stream"].(bool); stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}` + "\n\n"))
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+	// 未指定 max_tokens（使用账号默认值），thinking budget 超过 max_tokens（钳制为 max_tokens-1），模型经映射
+	body := []byte(`{"model":"claude-sonnet","temperature":0.5,"thinking":{"type":"enabled","budget_tokens":20000},"tool_choice":{"type":"auto"},"tools":[{"name":"ls","input_schema":{"type":"object"}}],"messages":[{"role":"user","content":"hi"}]}`)
+	newAccount := func(extra map[string]any) *Account {
+		account := newOpenAICompatTestAccount(server.URL, extra)
+		account.Credentials["default_max_tokens"] = 8000
+		account.Credentials["model_mapping"] = map[string]any{"claude-sonnet": "upstream-model"}
+		return account
+	}
+	extra := map[string]any{"debug_effective_params": true, "upstream_provider": "openrouter"}
+
+	// 默认不回显
+	rec, _, err := forwardOpenAICompat(t, newAccount(map[string]any{"upstream_provider": "openrouter"}), body)
+	require.NoError(t, err)
+	require.NotContains(t, rec.Body.String(), "effective_params")
+
+	rec, _, err = forwardOpenAICompat(t, newAccount(extra), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		Model           string         `json:"model"`
+		EffectiveParams map[string]any `json:"effective_params"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "claude-sonnet", resp.Model)
+	require.Equal(t, map[string]any{
+		"model":       "upstream-model",
+		"temperature": 0.5,
+		"max_tokens":  float64(8000),
+		"reasoning":   map[string]any{"max_tokens": float64(7999)},
+		"tool_choice": "auto",
+	}, resp.EffectiveParams)
+
+	// 流式：写入 message_start.message
+	streamBody := bytes.Replace(body, []byte(`"model":"claude-sonnet",`), []byte(`"model":"claude-sonnet","stream":true,`), 1)
+	rec, _, err = forwardOpenAICompat(t, newAccount(extra), streamBody)
+	require.NoError(t, err)
+	require.Contains(t, rec.Body.String(), `"effective_params":{"max_tokens":8000,"model":"upstream-model"`)
+}
+
+func TestOpenAICompatForward_SanitizedToolNamesRestored(t *testing.T) {
+	var upstreamTools []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Tools []struct {
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			} `json:"tools"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		for _, tool := range req.Tools {
+			upstreamTools = append(upstreamTools, tool.Function.Name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"mcp_fs_read","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`))
+	}))
+	defer server.Close()
+
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"tools":[{"name":"mcp.fs.read"}]}`)
+	rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mcp_fs_read"}, upstreamTools)
+
+	var resp struct {
+		Content []struct {
+			Name string `json:"name"`
+		} `json:"content"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "mcp.fs.read", resp.Content[0].Name)
+}
+
+func TestOpenAICompatForward_AuthScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		creds      map[string]any
+		header     string
+		wantValue  string
+		wantNoAuth bool
+	}{
+		{name: "default bearer", creds: map[string]any{}, header: "Authorization", wantValue: "Bearer sk-test"},
+		{name: "basic", creds: map[string]any{"auth_scheme": "basic", "api_key": "user:pass"}, header: "Authorization", wantValue: "Basic dXNlcjpwYXNz"},
+		{name: "raw custom header", creds: map[string]any{"auth_scheme": "raw", "auth_header_name": "x-api-key"}, header: "X-Api-Key", wantValue: "sk-test", wantNoAuth: true},
+		{name: "unknown scheme falls back to bearer", creds: map[string]any{"auth_scheme": "digest"}, header: "Authorization", wantValue: "Bearer sk-test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(openAICompatTestChatResponse))
+			}))
+			defer server.Close()
+
+			account := newOpenAICompatTestAccount(server.URL, nil)
+			for k, v := range tt.creds {
+				account.Credentials[k] = v
+			}
+			rec, _, err := forwardOpenAICompat(t, account, []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, tt.wantValue, got.Get(tt.header))
+			if tt.wantNoAuth {
+				require.Empty(t, got.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestOpenAICompatForward_ModelOverrideHeader(t *testing.T) {
+	var upstreamModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		upstreamModel = req.Model
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	body := []byte(`{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	forward := func(account *Account, override string) (*httptest.ResponseRecorder, *ForwardResult) {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+		c.Request.Header.Set("x-model-override", override)
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+		result, err := svc.Forward(context.Background(), c, account, body)
+		require.NoError(t, err)
+		return rec, result
+	}
+	newAccount := func(extra map[string]any) *Account {
+		account := newOpenAICompatTestAccount(server.URL, extra)
+		account.Credentials["model_mapping"] = map[string]any{"claude-sonnet": "model-a", "claude-opus": "model-b"}
+		return account
+	}
+
+	// 覆盖后的模型参与映射，计费按覆盖后的模型
+	rec, result := forward(newAccount(map[string]any{"model_override_enabled": true}), "claude-opus")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "model-b", upstreamModel)
+	require.Equal(t, "model-b", result.Model)
+
+	// 不在账号允许范围内的模型返回 400，不请求上游
+	upstreamModel = ""
+	rec, _ = forward(newAccount(map[string]any{"model_override_enabled": true}), "gpt-unknown")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "not permitted")
+	require.Empty(t, upstreamModel)
+
+	// 账号未开启时忽略请求头
+	rec, result = forward(newAccount(nil), "claude-opus")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "model-a", upstreamModel)
+	require.Equal(t, "model-a", result.Model)
+}
+
+func TestOpenAICompatForward_AnthropicAuth(t *testing.T) {
+	var got http.Header
+	var gotPath string
+	var gotBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		gotPath = r.URL.Path
+		gotBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	forward := func(account *Account, clientHeaders map[string]string) {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+		for k, v := range clientHeaders {
+			c.Request.Header.Set(k, v)
+		}
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+		_, err := svc.Forward(context.Background(), c, account, body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	account := newOpenAICompatTestAccount(server.URL, map[string]any{"upstream_provider": "anthropic-auth"})
+	forward(account, nil)
+	// 仅鉴权头改为 Anthropic 风格，接口与请求体仍为 OpenAI Chat Completions
+	require.Equal(t, "/v1/chat/completions", gotPath)
+	require.Contains(t, gotBody, "messages")
+	require.NotContains(t, gotBody, "system")
+	require.Equal(t, float64(4096), gotBody["max_tokens"])
+	require.Equal(t, "sk-test", got.Get("x-api-key"))
+	require.Equal(t, "2023-06-01", got.Get("anthropic-version"))
+	require.Empty(t, got.Get("Authorization"))
+	require.Empty(t, got.Get("anthropic-beta"))
+
+	// 客户端的 anthropic-version / anthropic-beta 透传给上游
+	forward(account, map[string]string{"anthropic-version": "2024-01-01", "anthropic-beta": "tools-2024-04-04"})
+	require.Equal(t, "2024-01-01", got.Get("anthropic-version"))
+	require.Equal(t, "tools-2024-04-04", got.Get("anthropic-beta"))
+
+	// 配置白名单后仅透传白名单内的 beta 标志，版本不在白名单中时使用账号默认版本
+	restricted := newOpenAICompatTestAccount(server.URL, map[string]any{
+		"upstream_provider":           "anthropic-auth",
+		"anthropic_beta_allowlist":    []any{"interleaved-thinking-2025-05-14"},
+		"anthropic_version_allowlist": []any{"2023-06-01"},
+	})
+	forward(restricted, map[string]string{"anthropic-version": "2024-01-01", "anthropic-beta": "tools-2024-04-04, interleaved-thinking-2025-05-14"})
+	require.Equal(t, "2023-06-01", got.Get("anthropic-version"))
+	require.Equal(t, "interleaved-thinking-2025-05-14", got.Get("anthropic-beta"))
+	forward(restricted, map[string]string{"anthropic-beta": "tools-2024-04-04"})
+	require.Empty(t, got.Get("anthropic-beta"))
+
+	// 其他上游不透传 Anthropic 请求头
+	forward(newOpenAICompatTestAccount(server.URL, nil), map[string]string{"anthropic-beta": "tools-2024-04-04"})
+	require.Equal(t, "Bearer sk-test", got.Get("Authorization"))
+	require.Empty(t, got.Get("x-api-key"))
+	require.Empty(t, got.Get("anthropic-beta"))
+}
+
+func TestOpenAICompatForward_ResponsesAPI(t *testing.T) {
+	var upstreamPath string
+	var upstreamReq struct {
+		Model string           `json:"model"`
+		Input []map[string]any `json:"input"`
+		Store *bool            `json:"store"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&upstreamReq)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"resp_1","model":"gpt-5","status":"completed","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"hello"}]}],"usage":{"input_tokens":10,"output_tokens":2,"total_tokens":12}}`))
+	}))
+	defer server.Close()
+
+	account := newOpenAICompatTestAccount(server.URL+"/v1", map[string]any{"api": "responses"})
+	rec, result, err := forwardOpenAICompat(t, account, []byte(`{"model":"gpt-5","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "/v1/responses", upstreamPath)
+	require.Equal(t, "gpt-5", upstreamReq.Model)
+	require.Len(t, upstreamReq.Input, 1)
+	require.NotNil(t, upstreamReq.Store)
+	require.False(t, *upstreamReq.Store)
+
+	var resp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "hello", resp.Content[0].Text)
+	require.Equal(t, "end_turn", resp.StopReason)
+	require.Equal(t, 10, result.Usage.InputTokens)
+	require.Equal(t, 2, result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_CompletionsAPI(t *testing.T) {
+	var upstreamPath, upstreamPrompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		var req map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		upstreamPrompt, _ = req["prompt"].(string)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"cmpl-1","object":"text_completion","model":"llama","choices":[{"index":0,"text":" hello","finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`))
+	}))
+	defer server.Close()
+
+	account := newOpenAICompatTestAccount(server.URL+"/v1", map[string]any{"api": "completions"})
+	rec, result, err := forwardOpenAICompat(t, account, []byte(`{"model":"llama","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "/v1/completions", upstreamPath)
+	require.Equal(t, "User: hi\n\nAssistant:", upstreamPrompt)
+
+	var resp struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "hello", resp.Content[0].Text)
+	require.Equal(t, "end_turn", resp.StopReason)
+	require.Equal(t, 10, result.Usage.InputTokens)
+}
+
+func TestOpenAICompatForward_PartialResponseOnTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"The answer is`))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // 上游停滞直至客户端超时
+	}))
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"question"}]}`)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+	result, err := svc.Forward(ctx, c, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Content, 1)
+	require.Equal(t, "The answer is", resp.Content[0].Text)
+	require.Equal(t, "max_tokens", resp.StopReason)
+	// 部分响应缺少 usage，按请求与已收到的文本估算
+	require.Positive(t, result.Usage.InputTokens)
+	require.Positive(t, result.Usage.OutputTokens)
+}
+
+func TestOpenAICompatForward_HideThinkingFromClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"m","choices":[{"index":0,"message":{"role":"assistant","reasoning_content":"secret plan","content":"answer"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":30,"total_tokens":35}}`))
+	}))
+	defer server.Close()
+
+	forward := func(hide bool, extra map[string]any) ([]string, *ForwardResult) {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+
+		cfg := &config.Config{Gateway: config.GatewayConfig{HideThinkingFromClient: hide}}
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: cfg})
+		result, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, extra), body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp struct {
+			Content []struct {
+				Type string `json:"type"`
+			} `json:"content"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		var types []string
+		for _, block := range resp.Content {
+			types = append(types, block.Type)
+		}
+		return types, result
+	}
+
+	types, _ := forward(false, nil)
+	require.Equal(t, []string{"thinking", "text"}, types)
+
+	// 网关开启时省略 thinking 块，reasoning tokens 仍计费
+	types, result := forward(true, nil)
+	require.Equal(t, []string{"text"}, types)
+	require.Equal(t, 30, result.Usage.OutputTokens)
+
+	// 账号配置覆盖网关配置
+	types, _ = forward(true, map[string]any{"hide_thinking_from_client": false})
+	require.Equal(t, []string{"thinking", "text"}, types)
+	types, _ = forward(false, map[string]any{"hide_thinking_from_client": true})
+	require.Equal(t, []string{"text"}, types)
+}
+
+func TestOpenAICompatForward_UnauthorizedFailover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`))
+	}))
+	defer server.Close()
+	body := []byte(`{"model":"gpt-4o","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`)
+
+	// 默认：401 原样返回客户端
+	rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// 开启 unauthorized_failover：返回携带分类的 failover 错误
+	_, _, err = forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"unauthorized_failover": true}), body)
+	var failoverErr *UpstreamFailoverError
+	require.ErrorAs(t, err, &failoverErr)
+	require.Equal(t, http.StatusUnauthorized, failoverErr.StatusCode)
+	require.Equal(t, "invalid_key", failoverErr.AuthError)
+	require.Equal(t, "auth_invalid_key", failoverErr.Classification())
+}
+
+func TestOpenAICompatForward_TransformWarningsHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+	body := []byte(`{"model":"gpt-4o","max_tokens":64,"tools":[{"type":"web_search_20250305","name":"web_search"}],"messages":[{"role":"user","content":"hi"}]}`)
+
+	// 默认仅记录日志
+	rec, _, err := forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, nil), body)
+	require.NoError(t, err)
+	require.Empty(t, rec.Header().Get("anthropic-transform-warnings"))
+
+	rec, _, err = forwardOpenAICompat(t, newOpenAICompatTestAccount(server.URL, map[string]any{"debug_transform_warnings": true}), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "web_search_tool_dropped", rec.Header().Get("anthropic-transform-warnings"))
+}
+
+func TestOpenAICompatTestConnection_ResponsesAPI(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBody))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"resp_1","model":"gpt-5","status":"completed","output":[{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"pong"}]}],"usage":{"input_tokens":5,"output_tokens":1,"total_tokens":6}}`))
+	}))
+	defer server.Close()
+
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+	account := newOpenAICompatTestAccount(server.URL, map[string]any{"api": "responses"})
+	result, err := svc.TestConnection(context.Background(), account, "gpt-5")
+	require.NoError(t, err)
+	require.Equal(t, "/v1/responses", gotPath)
+	require.Contains(t, gotBody, "input")
+	require.NotContains(t, gotBody, "messages")
+	require.Equal(t, "pong", result.Text)
+}
+
+func TestOpenAICompatTestConnection_CompletionsAPI(t *testing.T) {
+	for _, extra := range []map[string]any{{"api": "completions"}, {"upstream_provider": "completions"}} {
+		var gotPath string
+		var gotBody map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBody))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"cmpl-1","object":"text_completion","model":"llama","choices":[{"index":0,"text":"pong","finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`))
+		}))
+
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+		result, err := svc.TestConnection(context.Background(), newOpenAICompatTestAccount(server.URL, extra), "llama")
+		server.Close()
+		require.NoError(t, err)
+		require.Equal(t, "/v1/completions", gotPath)
+		require.Contains(t, gotBody, "prompt")
+		require.NotContains(t, gotBody, "messages")
+		require.Equal(t, "pong", result.Text)
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// OpenAICompat 请求结果分类
+const (
+	OpenAICompatStatusSuccess       = "success"        // 上游成功响应
+	OpenAICompatStatusUpstreamError = "upstream_error" // 上游返回错误，已转换后透传给客户端
+	OpenAICompatStatusFailover      = "failover"       // 上游错误触发账号切换
+	OpenAICompatStatusError         = "error"          // 网关内部或网络错误
+)
+
+// OpenAICompatRequestMetrics 单次 openai_compat 请求的指标
+type OpenAICompatRequestMetrics struct {
+	AccountID    int64
+	Model        string // 计费模型（映射后）
+	Stream       bool
+	Status       string // OpenAICompatStatus* 常量
+	StatusCode   int    // 返回给客户端（或触发 failover）的 HTTP 状态码，网络错误时为 0
+	Duration     time.Duration
+	FirstTokenMs *int          // 首字时间（仅流式请求）
+	QueueWait    time.Duration // handler 等待账号并发槽位的时间，未排队时为 0
+	Usage        ClaudeUsage
+}
+
+// OpenAICompatMetricsHook openai_compat 请求指标回调，用于导出到 Prometheus / OpenTelemetry 等
+// 实现需保证并发安全且不阻塞（在请求 goroutine 中同步调用）
+type OpenAICompatMetricsHook interface {
+	// ObserveFirstToken 流式响应收到首个数据行时调用
+	ObserveFirstToken(accountID int64, model string, firstTokenMs int)
+	// ObserveRequest 请求结束时调用（含 failover 与错误场景）
+	ObserveRequest(metrics OpenAICompatRequestMetrics)
+}
+
+// noopOpenAICompatMetricsHook 默认的空实现
+type noopOpenAICompatMetricsHook struct{}
+
+func (noopOpenAICompatMetricsHook) ObserveFirstToken(int64, string, int)      {}
+func (noopOpenAICompatMetricsHook) ObserveRequest(OpenAICompatRequestMetrics) {}
+
+// SetMetricsHook 设置指标回调，传入 nil 时恢复为空实现
+func (s *OpenAICompatGatewayService) SetMetricsHook(hook OpenAICompatMetricsHook) {
+	if hook == nil {
+		hook = noopOpenAICompatMetricsHook{}
+	}
+	s.metricsHook = hook
+}
+
+// metrics 返回当前指标回调，未设置时返回空实现
+func (s *OpenAICompatGatewayService) metrics() OpenAICompatMetricsHook {
+	if s.metricsHook == nil {
+		return noopOpenAICompatMetricsHook{}
+	}
+	return s.metricsHook
+}
+
+// observeForward 根据 Forward 的返回结果上报请求指标
+func (s *OpenAICompatGatewayService) observeForward(c *gin.Context, account *Account, body []byte, startTime time.Time, queueWait time.Duration, result *ForwardResult, err error) {
+	m := OpenAICompatRequestMetrics{
+		AccountID: account.ID,
+		Duration:  time.Since(startTime),
+		QueueWait: queueWait,
+	}
+	if result != nil {
+		m.Model = result.Model
+		m.Stream = result.Stream
+		m.FirstTokenMs = result.FirstTokenMs
+		m.Usage = result.Usage
+	} else {
+		m.Model, m.Stream = openAICompatRequestModel(account, body)
+	}
+
+	var failoverErr *UpstreamFailoverError
+	switch {
+	case errors.As(err, &failoverErr):
+		m.Status = OpenAICompatStatusFailover
+		m.StatusCode = failoverErr.StatusCode
+	case err != nil:
+		m.Status = OpenAICompatStatusError
+	default:
+		m.StatusCode = c.Writer.Status()
+		if m.StatusCode >= http.StatusBadRequest {
+			m.Status = OpenAICompatStatusUpstreamError
+		} else {
+			m.Status = OpenAICompatStatusSuccess
+		}
+	}
+	s.metrics().ObserveRequest(m)
+}
+
+// openAICompatRequestModel 从原始请求体解析计费模型（映射后）与是否流式
+func openAICompatRequestModel(account *Account, body []byte) (string, bool) {
+	model := gjson.GetBytes(body, "model").String()
+	if mapped := account.GetMappedModel(model); mapped != "" {
+		model = mapped
+	}
+	return model, gjson.GetBytes(body, "stream").Bool()
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Wei-Shaw/sub2api/internal/config"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/ctxkey"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingOpenAICompatMetricsHook 记录所有回调，便于断言
+type recordingOpenAICompatMetricsHook struct {
+	mu          sync.Mutex
+	firstTokens []int
+	requests    []OpenAICompatRequestMetrics
+}
+
+func (h *recordingOpenAICompatMetricsHook) ObserveFirstToken(_ int64, _ string, firstTokenMs int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.firstTokens = append(h.firstTokens, firstTokenMs)
+}
+
+func (h *recordingOpenAICompatMetricsHook) ObserveRequest(m OpenAICompatRequestMetrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, m)
+}
+
+func forwardOpenAICompatWithHook(t *testing.T, handler http.HandlerFunc, body string) (*recordingOpenAICompatMetricsHook, error) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+
+	hook := &recordingOpenAICompatMetricsHook{}
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+	svc.SetMetricsHook(hook)
+	account := newOpenAICompatTestAccount(server.URL, nil)
+	account.ID = 99
+	_, err := svc.Forward(context.Background(), c, account, []byte(body))
+	return hook, err
+}
+
+func TestOpenAICompatMetricsHook_NonStreamSuccess(t *testing.T) {
+	hook, err := forwardOpenAICompatWithHook(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}, `{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	require.NoError(t, err)
+
+	require.Empty(t, hook.firstTokens)
+	require.Len(t, hook.requests, 1)
+	m := hook.requests[0]
+	require.EqualValues(t, 99, m.AccountID)
+	require.Equal(t, "m", m.Model)
+	require.False(t, m.Stream)
+	require.Equal(t, OpenAICompatStatusSuccess, m.Status)
+	require.Equal(t, http.StatusOK, m.StatusCode)
+	require.Positive(t, m.Duration)
+	require.Equal(t, 10, m.Usage.InputTokens)
+	require.Equal(t, 2, m.Usage.OutputTokens)
+}
+
+func TestOpenAICompatMetricsHook_StreamReportsFirstToken(t *testing.T) {
+	hook, err := forwardOpenAICompatWithHook(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}, `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	require.NoError(t, err)
+
+	require.Len(t, hook.firstTokens, 1)
+	require.Len(t, hook.requests, 1)
+	m := hook.requests[0]
+	require.True(t, m.Stream)
+	require.Equal(t, OpenAICompatStatusSuccess, m.Status)
+	require.NotNil(t, m.FirstTokenMs)
+	require.Equal(t, hook.firstTokens[0], *m.FirstTokenMs)
+	require.Equal(t, 3, m.Usage.OutputTokens)
+}
+
+func TestOpenAICompatMetricsHook_FirstTokenIgnoresRoleOnlyChunk(t *testing.T) {
+	const contentDelay = 80 * time.Millisecond
+	hook, err := forwardOpenAICompatWithHook(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(contentDelay)
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"hi"}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}, `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	require.NoError(t, err)
+
+	require.Len(t, hook.firstTokens, 1)
+	require.GreaterOrEqual(t, hook.firstTokens[0], int(contentDelay.Milliseconds()))
+}
+
+func TestOpenAICompatMetricsHook_NoFirstTokenWithoutContent(t *testing.T) {
+	hook, err := forwardOpenAICompatWithHook(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant"}}]}` + "\n\n"))
+		_, _ = w.Write([]byte(`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n"))
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}, `{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	require.NoError(t, err)
+
+	require.Empty(t, hook.firstTokens)
+	require.Len(t, hook.requests, 1)
+	require.Nil(t, hook.requests[0].FirstTokenMs)
+}
+
+func TestOpenAICompatMetricsHook_Failover(t *testing.T) {
+	hook, err := forwardOpenAICompatWithHook(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"message":"slow down"}}`))
+	}, `{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	require.Error(t, err)
+
+	require.Len(t, hook.requests, 1)
+	m := hook.requests[0]
+	require.Equal(t, "m", m.Model)
+	require.Equal(t, OpenAICompatStatusFailover, m.Status)
+	require.Equal(t, http.StatusTooManyRequests, m.StatusCode)
+}
+
+func TestOpenAICompatMetricsHook_UpstreamError(t *testing.T) {
+	hook, err := forwardOpenAICompatWithHook(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"message":"bad"}}`))
+	}, `{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	require.NoError(t, err)
+
+	require.Len(t, hook.requests, 1)
+	require.Equal(t, OpenAICompatStatusUpstreamError, hook.requests[0].Status)
+	require.Equal(t, http.StatusBadRequest, hook.requests[0].StatusCode)
+}
+
+func TestOpenAICompatMetricsHook_QueueWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{cfg: &config.Config{}})
+	hook := &recordingOpenAICompatMetricsHook{}
+	svc.SetMetricsHook(hook)
+	account := newOpenAICompatTestAccount(server.URL, nil)
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	forward := func(ctx context.Context) {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+		_, err := svc.Forward(ctx, c, account, body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// handler 排队获取账号槽位后经 context 传入排队时间，随请求指标上报
+	forward(context.WithValue(context.Background(), ctxkey.AccountSlotWait, 1500*time.Millisecond))
+	// 未排队
+	forward(context.Background())
+
+	require.Len(t, hook.requests, 2)
+	require.Equal(t, OpenAICompatStatusSuccess, hook.requests[0].Status)
+	require.Equal(t, 1500*time.Millisecond, hook.requests[0].QueueWait)
+	require.Zero(t, hook.requests[1].QueueWait)
+}
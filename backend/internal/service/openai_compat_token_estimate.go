@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"  // 注册 GIF 解码器，用于读取图片尺寸
+	_ "image/jpeg" // 注册 JPEG 解码器
+	_ "image/png"  // 注册 PNG 解码器
+	"math"
+	"strings"
+
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+)
+
+// OpenAI 图片 token 计算参数（high detail）：图片先缩放至 2048x2048 以内，再将短边缩放至 768，
+// 按 512x512 切片，每片 170 token，另加 85 token 基础开销
+const (
+	openAICompatImageMaxSide       = 2048
+	openAICompatImageShortSide     = 768
+	openAICompatImageTileSize      = 512
+	openAICompatImageTileTokens    = 170
+	openAICompatImageBaseTokens    = 85
+	openAICompatImageDefaultSide   = 1024 // 无法读取图片尺寸时按 1024x1024 估算
+	openAICompatMaxToolResultDepth = 8    // 嵌套 tool_result 的最大展开深度
+)
+
+// openAICompatTokenBreakdown count_tokens 本地估算的分项 token 数
+type openAICompatTokenBreakdown struct {
+	System   int `json:"system"`
+	Messages int `json:"messages"`
+	Tools    int `json:"tools"`
+	Images   int `json:"images"`
+}
+
+// Total 返回各分项之和
+func (b openAICompatTokenBreakdown) Total() int {
+	return b.System + b.Messages + b.Tools + b.Images
+}
+
+// estimateOpenAICompatCountTokens 在本地按内容类型估算 Claude 请求的 input tokens
+// 文本按 estimateTokensForText 估算，图片按 OpenAI 的切片公式估算
+func estimateOpenAICompatCountTokens(req *antigravity.ClaudeRequest) openAICompatTokenBreakdown {
+	var b openAICompatTokenBreakdown
+
+	var system string
+	if json.Unmarshal(req.System, &system) == nil {
+		b.System += estimateTokensForText(system)
+	} else {
+		var blocks []antigravity.SystemBlock
+		if json.Unmarshal(req.System, &blocks) == nil {
+			for _, block := range blocks {
+				b.System += estimateTokensForText(block.Text)
+			}
+		}
+	}
+	if req.Metadata != nil {
+		for _, directive := range req.Metadata.SystemDirectives {
+			b.System += estimateTokensForText(directive)
+		}
+	}
+
+	for _, tool := range req.Tools {
+		encoded, _ := json.Marshal(tool)
+		b.Tools += estimateTokensForText(string(encoded))
+	}
+
+	for _, msg := range req.Messages {
+		var text string
+		if json.Unmarshal(msg.Content, &text) == nil {
+			b.Messages += estimateTokensForText(text)
+			continue
+		}
+		var blocks []antigravity.ContentBlock
+		if json.Unmarshal(msg.Content, &blocks) == nil {
+			estimateOpenAICompatBlocks(blocks, &b, 0)
+		}
+	}
+	return b
+}
+
+// estimateOpenAICompatBlocks 累加内容块的估算值，图片计入 Images，其余计入 Messages
+func estimateOpenAICompatBlocks(blocks []antigravity.ContentBlock, b *openAICompatTokenBreakdown, depth int) {
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			b.Messages += estimateTokensForText(block.Text)
+		case "thinking":
+			b.Messages += estimateTokensForText(block.Thinking)
+		case "tool_use":
+			input, _ := json.Marshal(block.Input)
+			b.Messages += estimateTokensForText(block.Name) + estimateTokensForText(string(input))
+		case "tool_result":
+			var text string
+			var nested []antigravity.ContentBlock
+			switch {
+			case json.Unmarshal(block.Content, &text) == nil:
+				b.Messages += estimateTokensForText(text)
+			case depth < openAICompatMaxToolResultDepth && json.Unmarshal(block.Content, &nested) == nil:
+				estimateOpenAICompatBlocks(nested, b, depth+1)
+			default:
+				b.Messages += estimateTokensForText(string(block.Content))
+			}
+		case "image":
+			b.Images += estimateOpenAICompatImageTokens(block.Source)
+		}
+	}
+}
+
+// estimateOpenAICompatImageTokens 按 OpenAI high detail 公式估算图片 token，无法解析尺寸时按默认尺寸估算
+func estimateOpenAICompatImageTokens(source *antigravity.ImageSource) int {
+	width, height := openAICompatImageDefaultSide, openAICompatImageDefaultSide
+	if source != nil && source.Type == "base64" && source.Data != "" {
+		decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(source.Data))
+		if cfg, _, err := image.DecodeConfig(decoder); err == nil && cfg.Width > 0 && cfg.Height > 0 {
+			width, height = cfg.Width, cfg.Height
+		}
+	}
+	return openAICompatImageTokens(width, height)
+}
+
+// openAICompatImageTokens 计算指定尺寸图片的 token 数
+func openAICompatImageTokens(width, height int) int {
+	w, h := float64(width), float64(height)
+	if longest := math.Max(w, h); longest > openAICompatImageMaxSide {
+		scale := openAICompatImageMaxSide / longest
+		w, h = w*scale, h*scale
+	}
+	if shortest := math.Min(w, h); shortest > openAICompatImageShortSide {
+		scale := openAICompatImageShortSide / shortest
+		w, h = w*scale, h*scale
+	}
+	tiles := int(math.Ceil(w/openAICompatImageTileSize)) * int(math.Ceil(h/openAICompatImageTileSize))
+	return openAICompatImageBaseTokens + openAICompatImageTileTokens*tiles
+}
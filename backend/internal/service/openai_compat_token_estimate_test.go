@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Wei-Shaw/sub2api/internal/config"
+	"github.com/Wei-Shaw/sub2api/internal/pkg/antigravity"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// testPNGBase64 生成指定尺寸的 PNG 图片（base64）
+func testPNGBase64(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestOpenAICompatImageTokens(t *testing.T) {
+	require.Equal(t, 85+170*1, openAICompatImageTokens(512, 512))
+	require.Equal(t, 85+170*4, openAICompatImageTokens(1024, 1024))  // 缩放至 768x768
+	require.Equal(t, 85+170*6, openAICompatImageTokens(2048, 4096))  // 缩放至 1024x2048 再至 768x1536
+	require.Equal(t, 85+170*2, openAICompatImageTokens(1000, 300))   // 短边未超过 768，不缩放
+	require.Equal(t, 85+170*4, estimateOpenAICompatImageTokens(nil)) // 无法解析时按 1024x1024
+}
+
+func TestEstimateOpenAICompatCountTokens_BreakdownSumsToTotal(t *testing.T) {
+	body := `{
+		"model": "m",
+		"system": [{"type": "text", "text": "You are a careful assistant."}],
+		"tools": [{"name": "lookup", "description": "Look up a record", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in this picture?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "` + testPNGBase64(t, 1024, 512) + `"}}
+			]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "lookup", "input": {"id": 42}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": [{"type": "text", "text": "record 42"}]}]}
+		]
+	}`
+	var req antigravity.ClaudeRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &req))
+
+	b := estimateOpenAICompatCountTokens(&req)
+	require.Positive(t, b.System)
+	require.Positive(t, b.Tools)
+	require.Positive(t, b.Messages)
+	require.Equal(t, 85+170*2, b.Images) // 1024x512 不缩放，2 个切片
+	require.Equal(t, b.System+b.Messages+b.Tools+b.Images, b.Total())
+}
+
+func TestOpenAICompatForwardCountTokens_VerboseBreakdown(t *testing.T) {
+	body := []byte(`{"model":"m","system":"Be brief.","messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"hello there"},` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + testPNGBase64(t, 256, 256) + `"}}]}]}`)
+
+	countTokens := func(target string) map[string]any {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+
+		svc := newOpenAICompatAuxTestService(config.GatewayConfig{})
+		require.NoError(t, svc.ForwardCountTokens(context.Background(), c, newOpenAICompatTestAccount("http://127.0.0.1:1", nil), body))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// 未配置 count_tokens_url：本地估算，默认不返回 breakdown
+	plain := countTokens("/v1/messages/count_tokens")
+	require.NotContains(t, plain, "breakdown")
+	total := plain["input_tokens"].(float64)
+	require.Positive(t, total)
+
+	verbose := countTokens("/v1/messages/count_tokens?verbose=true")
+	require.Equal(t, true, verbose["estimated"])
+	breakdown := verbose["breakdown"].(map[string]any)
+	require.EqualValues(t, 85+170, breakdown["images"])
+	sum := breakdown["system"].(float64) + breakdown["messages"].(float64) + breakdown["tools"].(float64) + breakdown["images"].(float64)
+	require.Equal(t, total, sum)
+	require.Equal(t, total, verbose["input_tokens"])
+}
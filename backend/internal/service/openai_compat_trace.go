@@ -0,0 +1,102 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// W3C Trace Context 请求头
+const (
+	openAICompatTraceparentHeader = "traceparent"
+	openAICompatTracestateHeader  = "tracestate"
+)
+
+// openAICompatTraceContext 注入到上游请求的 W3C Trace Context（账号开启 extra.trace_context_enabled 时使用）
+type openAICompatTraceContext struct {
+	traceID     string
+	traceparent string
+	tracestate  string
+}
+
+// newOpenAICompatTraceContext 根据客户端请求头构建上游 trace context：
+// 客户端携带合法 traceparent 时沿用其 trace-id 与 trace-flags 并透传 tracestate，否则生成新的 trace-id；
+// 网关作为新的一跳，parent-id 总是重新生成
+func newOpenAICompatTraceContext(client http.Header) openAICompatTraceContext {
+	spanID := randomTraceHex(8)
+	if client != nil {
+		if traceID, flags, ok := parseTraceparent(client.Get(openAICompatTraceparentHeader)); ok {
+			return openAICompatTraceContext{
+				traceID:     traceID,
+				traceparent: "00-" + traceID + "-" + spanID + "-" + flags,
+				tracestate:  strings.TrimSpace(client.Get(openAICompatTracestateHeader)),
+			}
+		}
+	}
+	traceID := randomTraceHex(16)
+	return openAICompatTraceContext{
+		traceID:     traceID,
+		traceparent: "00-" + traceID + "-" + spanID + "-01",
+	}
+}
+
+// apply 设置上游请求的 traceparent / tracestate
+func (t openAICompatTraceContext) apply(h http.Header) {
+	if t.traceparent == "" {
+		return
+	}
+	h.Set(openAICompatTraceparentHeader, t.traceparent)
+	if t.tracestate != "" {
+		h.Set(openAICompatTracestateHeader, t.tracestate)
+	}
+}
+
+// logSuffix 返回附加在日志行末尾的 trace_id 字段，未启用时为空
+func (t openAICompatTraceContext) logSuffix() string {
+	if t.traceID == "" {
+		return ""
+	}
+	return " trace_id=" + t.traceID
+}
+
+// parseTraceparent 解析 traceparent（version-traceid-parentid-flags），返回 trace-id 与 trace-flags
+// 格式不合法、version 为 ff 或 trace-id / parent-id 全零时返回 false
+func parseTraceparent(value string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	// version 00 必须恰好 4 段，更高版本允许追加字段
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", "", false
+	}
+	if !isLowerHex(traceID, 32) || !isLowerHex(parentID, 16) || !isLowerHex(flags, 2) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, flags, true
+}
+
+// isLowerHex 判断 s 是否为指定长度的小写十六进制字符串
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, ch := range s {
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomTraceHex 生成 n 字节的随机十六进制 id
+func randomTraceHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, flags, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	require.Equal(t, "01", flags)
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",          // 缺少 flags
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",       // 大写
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",       // trace-id 全零
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",       // parent-id 全零
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",       // 非法 version
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", // version 00 不允许追加字段
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",        // trace-id 长度错误
+	}
+	for _, value := range invalid {
+		_, _, ok := parseTraceparent(value)
+		require.False(t, ok, value)
+	}
+
+	// 更高版本允许追加字段
+	_, _, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	require.True(t, ok)
+}
+
+func TestOpenAICompatForward_TraceContext(t *testing.T) {
+	var upstreamHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAICompatTestChatResponse))
+	}))
+	defer server.Close()
+
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	forward := func(extra map[string]any, clientHeader map[string]string) {
+		gin.SetMode(gin.TestMode)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+		for k, v := range clientHeader {
+			c.Request.Header.Set(k, v)
+		}
+		svc := NewOpenAICompatGatewayService(openAICompatPassthroughUpstream{}, &SettingService{})
+		_, err := svc.Forward(context.Background(), c, newOpenAICompatTestAccount(server.URL, extra), body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	enabled := map[string]any{"trace_context_enabled": true}
+
+	// 透传客户端 trace：沿用 trace-id 与 flags，parent-id 重新生成，tracestate 原样透传
+	forward(enabled, map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		"tracestate":  "vendor=abc",
+	})
+	parts := strings.Split(upstreamHeader.Get("traceparent"), "-")
+	require.Len(t, parts, 4)
+	require.Equal(t, []string{"00", "4bf92f3577b34da6a3ce929d0e0e4736"}, parts[:2])
+	require.NotEqual(t, "00f067aa0ba902b7", parts[2])
+	require.Equal(t, "00", parts[3])
+	require.Equal(t, "vendor=abc", upstreamHeader.Get("tracestate"))
+
+	// 客户端未携带（或携带非法）traceparent 时生成新的 trace，不透传 tracestate
+	forward(enabled, map[string]string{"traceparent": "invalid", "tracestate": "vendor=abc"})
+	traceID, flags, ok := parseTraceparent(upstreamHeader.Get("traceparent"))
+	require.True(t, ok)
+	require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	require.Equal(t, "01", flags)
+	require.Empty(t, upstreamHeader.Get("tracestate"))
+
+	// 账号未开启时不注入
+	forward(nil, map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})
+	require.Empty(t, upstreamHeader.Get("traceparent"))
+}
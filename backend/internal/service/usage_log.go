@@ -50,6 +50,18 @@ type UsageLog struct {
 	ImageCount int
 	ImageSize  *string
 
+	// WebSearchRequests 上游服务端 web 搜索次数（OpenAI 兼容上游）
+	WebSearchRequests int
+
+	// ServiceTier 上游实际使用的服务等级（OpenAI 兼容上游，如 flex / priority），nil 表示上游未返回
+	ServiceTier *string
+
+	// UsageEstimated token 用量为本地估算值（上游未返回 usage）
+	UsageEstimated bool
+
+	// ReasoningTokens OutputTokens 中的推理 token 数（OpenAI 兼容上游），已按输出 token 计费
+	ReasoningTokens int
+
 	CreatedAt time.Time
 
 	User         *User
@@ -2,6 +2,9 @@ package logredact
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
 	"strings"
 )
 
@@ -98,3 +101,48 @@ func isSensitiveKey(key string, keys map[string]struct{}) bool {
 func normalizeKey(key string) string {
 	return strings.ToLower(strings.TrimSpace(key))
 }
+
+// base64DataURLPattern 匹配 data URL 中的 base64 负载（如 data:image/png;base64,....）
+var base64DataURLPattern = regexp.MustCompile(`(data:[\w.+-]+/[\w.+-]+;base64,)[A-Za-z0-9+/=]+`)
+
+// RedactBase64DataURLs 将文本中 data URL 的 base64 负载替换为占位符，保留 MIME 类型便于排查
+func RedactBase64DataURLs(s string) string {
+	return base64DataURLPattern.ReplaceAllStringFunc(s, func(match string) string {
+		prefix := base64DataURLPattern.FindStringSubmatch(match)[1]
+		return fmt.Sprintf("%s<redacted %d bytes>", prefix, len(match)-len(prefix))
+	})
+}
+
+// sensitiveHeaders 日志中需要脱敏的 HTTP 头（小写）
+var sensitiveHeaders = map[string]struct{}{
+	"authorization":       {},
+	"proxy-authorization": {},
+	"x-api-key":           {},
+	"api-key":             {},
+	"x-goog-api-key":      {},
+	"cookie":              {},
+	"set-cookie":          {},
+}
+
+// RedactHeaders 返回脱敏后的 HTTP 头副本，敏感头（含 extraSensitive 中额外指定的头）的值替换为 ***
+func RedactHeaders(h http.Header, extraSensitive ...string) map[string]string {
+	out := make(map[string]string, len(h))
+	for key, values := range h {
+		if _, ok := sensitiveHeaders[normalizeKey(key)]; ok || containsHeaderKey(extraSensitive, key) {
+			out[key] = "***"
+			continue
+		}
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// containsHeaderKey 判断 keys 中是否包含 key（忽略大小写与首尾空白）
+func containsHeaderKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if normalizeKey(k) == normalizeKey(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,3 @@
+-- Add web_search_requests field to usage_logs.
+-- This stores the number of server-side web searches reported by the upstream (OpenAI-compatible accounts).
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS web_search_requests INT NOT NULL DEFAULT 0;
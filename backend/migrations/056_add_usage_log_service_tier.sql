@@ -0,0 +1,3 @@
+-- Add service_tier field to usage_logs.
+-- This stores the service tier actually used by the upstream (e.g. flex/priority for OpenAI-compatible accounts).
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS service_tier VARCHAR(20);
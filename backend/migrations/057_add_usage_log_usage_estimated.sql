@@ -0,0 +1,3 @@
+-- Add usage_estimated field to usage_logs.
+-- TRUE means the token counts were estimated locally because the upstream returned no usage.
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS usage_estimated BOOLEAN NOT NULL DEFAULT FALSE;
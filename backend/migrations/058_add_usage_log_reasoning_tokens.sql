@@ -0,0 +1,3 @@
+-- Add reasoning_tokens field to usage_logs.
+-- This stores the reasoning tokens included in output_tokens (completion_tokens_details of OpenAI-compatible upstreams).
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS reasoning_tokens INT NOT NULL DEFAULT 0;
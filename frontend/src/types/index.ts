@@ -655,6 +655,7 @@ export interface AccountUsageInfo {
   gemini_pro_minute?: UsageProgress | null
   gemini_flash_minute?: UsageProgress | null
   antigravity_quota?: Record<string, AntigravityModelQuota> | null
+  level?: string // 账号套餐等级（如 GLM 的 pro）
 }
 
 // OpenAI Codex usage snapshot (from response headers)